@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"gguan/cwgcf_db/models"
+)
+
+func TestAlbumPrivacy(t *testing.T) {
+	ta := newTestApp(t, nil)
+	ctx := context.Background()
+	ownerID, owner := ta.user("owner", models.RoleMember)
+	otherID, other := ta.user("other", models.RoleMember)
+	_, moderator := ta.user("moderator", models.RoleModerator)
+	_, admin := ta.user("admin", models.RoleAdmin)
+
+	insertAlbum := func(ownerID string, visibility string) string {
+		id, err := ta.app.Store.Albums.InsertAlbum(ctx, models.Album{Title: "a", OwnerID: ownerID, Visibility: visibility})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	private := insertAlbum(ownerID, models.AlbumPrivate)
+	public := insertAlbum(otherID, models.AlbumPublic)
+	// The photo is only in the private album, so it is as private as the album
+	photoID, err := ta.app.Store.Photos.InsertPhoto(ctx, models.Photo{URL: "https://example.com/a.jpg", UserID: ownerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ta.app.Store.Albums.AddAlbumPhoto(ctx, models.AlbumPhoto{AlbumID: private, PhotoID: photoID}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"owner sees their private album", owner, http.MethodGet, "/mongo/v1/albums/" + private, "", http.StatusOK},
+		{"admin sees a private album", admin, http.MethodGet, "/mongo/v1/albums/" + private, "", http.StatusOK},
+		{"another user does not find a private album", other, http.MethodGet, "/mongo/v1/albums/" + private, "", http.StatusNotFound},
+		{"a moderator does not find a private album", moderator, http.MethodGet, "/mongo/v1/albums/" + private, "", http.StatusNotFound},
+		{"anonymous users do not find a private album", "", http.MethodGet, "/mongo/v1/albums/" + private, "", http.StatusNotFound},
+		{"another user does not find the photos of a private album", other, http.MethodGet, "/mongo/v1/albums/" + private + "/photos", "", http.StatusNotFound},
+		{"another user does not find a private album to change", other, http.MethodPatch, "/mongo/v1/albums/" + private, `{"title": "b"}`, http.StatusNotFound},
+		{"owner changes their private album", owner, http.MethodPatch, "/mongo/v1/albums/" + private, `{"title": "b"}`, http.StatusOK},
+		{"admin changes a private album", admin, http.MethodPatch, "/mongo/v1/albums/" + private, `{"title": "c"}`, http.StatusOK},
+		{"anyone sees a public album", "", http.MethodGet, "/mongo/v1/albums/" + public, "", http.StatusOK},
+		{"another user may not change a public album", owner, http.MethodPatch, "/mongo/v1/albums/" + public, `{"title": "b"}`, http.StatusForbidden},
+		{"a moderator may not change a public album", moderator, http.MethodPatch, "/mongo/v1/albums/" + public, `{"title": "b"}`, http.StatusForbidden},
+		{"another user may not add photos to a public album", owner, http.MethodPost, "/mongo/v1/albums/" + public + "/photos", `{"photoId": "` + photoID + `"}`, http.StatusForbidden},
+		{"a private photo is not added to a public album", other, http.MethodPost, "/mongo/v1/albums/" + public + "/photos", `{"photoId": "` + photoID + `"}`, http.StatusBadRequest},
+		{"admin deletes a public album", admin, http.MethodDelete, "/mongo/v1/albums/" + public, "", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := ta.do(test.token, test.method, test.path, test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: status %d, want %d: %s", test.name, w.Code, test.code, w.Body.String())
+			continue
+		}
+		if w.Code == http.StatusForbidden && !ta.forbidden(w, "owner or admin role required") {
+			t.Errorf("%s: 403 body %s", test.name, w.Body.String())
+		}
+	}
+
+	// The private album and its photo are left out of the listings of other users
+	listings := []struct {
+		token string
+		// sees tells whether the private album and photo are listed
+		sees bool
+	}{
+		{owner, true},
+		{admin, true},
+		{other, false},
+		{"", false},
+	}
+	for _, listing := range listings {
+		var albums []models.Album
+		ta.decode(ta.do(listing.token, http.MethodGet, "/mongo/v1/albums", ""), &albums)
+		var photos []models.Photo
+		ta.decode(ta.do(listing.token, http.MethodGet, "/mongo/v1/album", ""), &photos)
+		if seen := len(albums) == 1 && albums[0].ID == private; seen != listing.sees {
+			t.Errorf("listing albums with token %q: %+v", listing.token, albums)
+		}
+		if seen := len(photos) == 1 && photos[0].ID == photoID; seen != listing.sees {
+			t.Errorf("listing photos with token %q: %+v", listing.token, photos)
+		}
+	}
+}
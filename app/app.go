@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/background"
+	"gguan/cwgcf_db/blob"
+	"gguan/cwgcf_db/clients"
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/models"
+	"gguan/cwgcf_db/storage/memory"
+	"gguan/cwgcf_db/storage/mongodb"
+	"log"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// App owns the shared dependencies of the API and the servers built on top of them
+type App struct {
+	Config *config.Config
+	// Client is the single mongo pool of the process, nil for in-memory storage
+	Client *mongo.Client
+	Store  *models.Store
+	// Blobs keeps uploaded files
+	Blobs blob.Store
+	// PhotoWorker generates the variants of uploaded photos once StartJobs runs it
+	PhotoWorker *models.PhotoWorker
+	// Background tracks work started by handlers that must finish before the client is closed
+	Background *background.Group
+	// stopJobs cancels the jobs started by StartJobs
+	stopJobs   context.CancelFunc
+	Tokens     *auth.Tokens
+	Authorizer *models.Authorizer
+
+	ProfileServer    *models.ProfileServer
+	ModerationServer *models.ModerationServer
+	AlbumServer      *models.AlbumServer
+	SubForumServer   *models.SubForumServer
+	EditServer       *models.EditServer
+	ForumServer      *models.ForumServer
+	ForumServerV2    *clients.ForumServer
+}
+
+// New connects to the configured storage and wires every server to it
+func New(ctx context.Context, cfg *config.Config) (*App, error) {
+	a := &App{Config: cfg, Background: &background.Group{}, stopJobs: func() {}}
+	if cfg.Storage == config.StorageMemory {
+		log.Print("Using in-memory storage")
+		a.Store = memory.NewStore()
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, cfg.Mongo.ConnectTimeout.Duration)
+		defer cancel()
+		client, err := mongodb.Connect(ctx, cfg.Mongo.URI)
+		if err != nil {
+			return nil, err
+		}
+		log.Print("Connected to MongoDB")
+		a.Client = client
+		db := client.Database(cfg.Mongo.Database)
+		if err := mongodb.EnsureIndexes(ctx, db, cfg.Mongo.Collections); err != nil {
+			client.Disconnect(context.Background())
+			return nil, err
+		}
+		a.Store = mongodb.NewStore(db, cfg.Mongo.Collections)
+	}
+	// Posts naming no sub go to the default one, so it has to exist before serving
+	if err := models.EnsureDefaultSubForum(ctx, a.Store.SubForums); err != nil {
+		if a.Client != nil {
+			a.Client.Disconnect(context.Background())
+		}
+		return nil, fmt.Errorf("creating the default sub: %v", err)
+	}
+
+	blobs, err := blob.NewLocal(cfg.Blobs.Dir)
+	if err != nil {
+		if a.Client != nil {
+			a.Client.Disconnect(context.Background())
+		}
+		return nil, err
+	}
+	a.Blobs = blobs
+
+	a.Tokens = auth.NewTokens(cfg.Auth.Secret, cfg.Auth.TokenTTL.Duration, cfg.Auth.MaxTokenLifetime.Duration)
+	a.Authorizer = models.NewAuthorizer(a.Store, cfg)
+	a.ProfileServer = models.NewProfileServer(a.Store, cfg, a.Tokens, a.Authorizer)
+	a.ModerationServer = models.NewModerationServer(a.Store, cfg, a.Authorizer)
+	a.PhotoWorker = models.NewPhotoWorker(a.Store, cfg, a.Blobs)
+	a.AlbumServer = models.NewAlbumServer(a.Store, cfg, a.Blobs, a.PhotoWorker, a.Authorizer)
+	a.SubForumServer = models.NewSubForumServer(a.Store, cfg, a.Authorizer)
+	a.EditServer = models.NewEditServer(a.Store, cfg, a.Authorizer)
+	a.ForumServer = models.NewForumServer(a.Store, cfg, a.Background, a.Authorizer)
+	a.ForumServerV2 = clients.NewForumServer(a.Store, cfg, a.Authorizer)
+	return a, nil
+}
+
+// StartJobs starts the scheduled jobs of the server, they run in Background until Close
+func (a *App) StartJobs() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.stopJobs = cancel
+	a.Background.Go(func() { a.PhotoWorker.Run(ctx) })
+	if interval := a.Config.Reconciliation.Interval.Duration; interval > 0 {
+		log.Printf("Reconciling votes every %v", interval)
+		a.Background.Every(ctx, interval, a.reconcileVotes)
+	}
+}
+
+func (a *App) reconcileVotes(ctx context.Context) {
+	report, err := models.ReconcileVotes(ctx, a.Store, a.Config.Reconciliation.Fix)
+	for _, d := range report.Discrepancies {
+		log.Printf("Vote reconciliation: %v", d)
+	}
+	if err != nil {
+		log.Printf("Vote reconciliation failed: %v", err)
+		return
+	}
+	log.Printf("Vote reconciliation checked %d counts, %d wrong, %d fixed", report.Checked, len(report.Discrepancies), report.Fixed())
+}
+
+// Close stops the jobs, waits for background work and then releases the storage connection
+// The connection is released even when ctx expires before the background work is done
+func (a *App) Close(ctx context.Context) error {
+	a.stopJobs()
+	if err := a.Background.Wait(ctx); err != nil {
+		log.Printf("Background work did not finish: %v", err)
+	}
+	if a.Client == nil {
+		return nil
+	}
+	if err := a.Client.Disconnect(ctx); err != nil {
+		return err
+	}
+	log.Print("Disconnected from MongoDB")
+	return nil
+}
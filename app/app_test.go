@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/models"
+)
+
+// testApp serves the API from an in-memory store
+type testApp struct {
+	t      *testing.T
+	app    *App
+	router http.Handler
+}
+
+// newTestApp creates a testApp, configure changes the defaults before the app is created
+func newTestApp(t *testing.T, configure func(cfg *config.Config)) *testApp {
+	cfg := config.Default()
+	cfg.Storage = config.StorageMemory
+	cfg.Auth.Secret = "secret"
+	cfg.Blobs.Dir = t.TempDir()
+	if configure != nil {
+		configure(cfg)
+	}
+	a, err := New(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close(context.Background()) })
+	return &testApp{t: t, app: a, router: a.Router()}
+}
+
+// user creates a profile with role and returns its id and a token for it
+func (ta *testApp) user(name string, role string) (string, string) {
+	ctx := context.Background()
+	id, err := ta.app.Store.Profiles.InsertProfile(ctx, models.Profile{Name: name, Role: role})
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	token, _, err := ta.app.Tokens.Issue(id)
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	return id, token
+}
+
+// do sends a request with an optional token and JSON body
+func (ta *testApp) do(token string, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, req)
+	return w
+}
+
+// decode reads the JSON response of w into v
+func (ta *testApp) decode(w *httptest.ResponseRecorder, v interface{}) {
+	ta.t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		ta.t.Fatalf("decoding %s: %v", w.Body.String(), err)
+	}
+}
+
+// forbidden reports whether w is a 403 with the given reason in the shape every 403 has
+func (ta *testApp) forbidden(w *httptest.ResponseRecorder, reason string) bool {
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/json" {
+		return false
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		return false
+	}
+	return len(res) == 2 && res["error"] == "Forbidden" && res["reason"] == reason
+}
@@ -0,0 +1,27 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+
+	"gguan/cwgcf_db/models"
+)
+
+func TestMalformedLimit(t *testing.T) {
+	ta := newTestApp(t, nil)
+	_, token := ta.user("moderator", models.RoleModerator)
+
+	paths := []string{
+		"/mongo/v1/forum/post?limit=abc",
+		"/mongo/v1/forum/v2/post?limit=abc",
+		"/mongo/v1/moderation/votes/any/events?limit=abc",
+		"/mongo/v1/album?sort=takenAt&limit=abc",
+		"/mongo/v1/albums/any/photos?limit=abc",
+	}
+	for _, path := range paths {
+		w := ta.do(token, http.MethodGet, path, "")
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error": "limit must be an integer"}` {
+			t.Errorf("%s: got status %d and body %s, want %d", path, w.Code, w.Body.String(), http.StatusBadRequest)
+		}
+	}
+}
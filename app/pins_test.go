@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"gguan/cwgcf_db/models"
+)
+
+// feedPage is a page of a feed, pinned lists which posts carry their pin
+type feedPage struct {
+	ids    []string
+	pinned []bool
+	next   string
+}
+
+func TestPinnedPostsComeFirst(t *testing.T) {
+	ta := newTestApp(t, nil)
+	ctx := context.Background()
+	authorID, _ := ta.user("author", models.RoleMember)
+	_, moderator := ta.user("moderator", models.RoleModerator)
+
+	feeds := []struct {
+		name string
+		// insert stores a post that was last active at updatedAt
+		insert func(updatedAt int64) (string, error)
+		get    func(cursor string) feedPage
+	}{
+		{
+			name: "v1",
+			insert: func(updatedAt int64) (string, error) {
+				return ta.app.Store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", UserID: authorID, CreatedAt: updatedAt, UpdatedAt: updatedAt})
+			},
+			get: func(cursor string) feedPage {
+				w := ta.do("", http.MethodGet, "/mongo/v1/forum/post?sort=new&limit=2&cursor="+url.QueryEscape(cursor), "")
+				var posts []models.ForumPost
+				ta.decode(w, &posts)
+				page := feedPage{next: w.Header().Get("X-Next-Cursor")}
+				for _, post := range posts {
+					page.ids = append(page.ids, post.ID)
+					page.pinned = append(page.pinned, post.Pin != nil)
+				}
+				return page
+			},
+		},
+		{
+			name: "v2",
+			insert: func(updatedAt int64) (string, error) {
+				voteID, err := ta.app.Store.Votes.InsertVote(ctx, models.Metadata{})
+				if err != nil {
+					return "", err
+				}
+				return ta.app.Store.Posts.InsertDBPost(ctx, models.DBForumPost{Title: "t", UserID: authorID, VoteID: voteID, Metadata: models.Metadata{CreatedAt: updatedAt, UpdatedAt: updatedAt}})
+			},
+			get: func(cursor string) feedPage {
+				w := ta.do("", http.MethodGet, "/mongo/v1/forum/v2/post?sort=new&limit=2&cursor="+url.QueryEscape(cursor), "")
+				var res models.GetForumPostsResponse
+				ta.decode(w, &res)
+				page := feedPage{next: res.NextCursor}
+				for _, post := range res.ForumPosts {
+					page.ids = append(page.ids, post.ID)
+					page.pinned = append(page.pinned, post.Pin != nil)
+				}
+				return page
+			},
+		},
+	}
+	for _, feed := range feeds {
+		// Newest last
+		posts := make([]string, 5)
+		for i := range posts {
+			id, err := feed.insert(1600000000 + int64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			posts[i] = id
+		}
+		moderate := func(action string, postID string, body string) {
+			if w := ta.do(moderator, http.MethodPost, "/mongo/v1/moderation/post/"+postID+"/"+action, body); w.Code != http.StatusOK {
+				t.Fatalf("%s: %s %s: status %d: %s", feed.name, action, postID, w.Code, w.Body.String())
+			}
+		}
+		moderate("pin", posts[1], `{"order": 1}`)
+		moderate("pin", posts[3], `{"order": 0}`)
+
+		// The pinned posts by order, then the first page of the others
+		first := feed.get("")
+		want := feedPage{
+			ids:    []string{posts[3], posts[1], posts[4], posts[2]},
+			pinned: []bool{true, true, false, false},
+			next:   first.next,
+		}
+		if !reflect.DeepEqual(first, want) || first.next == "" {
+			t.Errorf("%s: first page %+v, want %+v", feed.name, first, want)
+		}
+
+		// Pins changing between pages neither repeat nor skip the posts after the cursor
+		moderate("unpin", posts[1], "")
+		moderate("pin", posts[0], `{"order": 2}`)
+		second := feed.get(first.next)
+		want = feedPage{ids: []string{posts[1]}, pinned: []bool{false}}
+		if !reflect.DeepEqual(second, want) {
+			t.Errorf("%s: second page %+v, want %+v", feed.name, second, want)
+		}
+
+		// A new first page shows the pins as they are now
+		first = feed.get("")
+		if want := []string{posts[3], posts[0], posts[4], posts[2]}; !reflect.DeepEqual(first.ids, want) {
+			t.Errorf("%s: first page after changing pins %v, want %v", feed.name, first.ids, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"gguan/cwgcf_db/models"
+)
+
+func TestRoles(t *testing.T) {
+	ta := newTestApp(t, nil)
+	ownerID, owner := ta.user("owner", models.RoleMember)
+	_, member := ta.user("member", models.RoleMember)
+	_, moderator := ta.user("moderator", models.RoleModerator)
+	_, admin := ta.user("admin", models.RoleAdmin)
+	commentID, err := ta.app.Store.Comments.InsertComment(context.Background(), models.ForumComment{ParentID: "p", UserID: ownerID, CreatedAt: 1600000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type call struct {
+		method string
+		path   string
+		body   string
+	}
+	setRole := call{http.MethodPut, "/mongo/v1/profile/" + ownerID + "/role", `{"role": "member"}`}
+	patchProfile := call{http.MethodPatch, "/mongo/v1/profile/" + ownerID, `{"title": "t"}`}
+	hideComment := call{http.MethodPost, "/mongo/v1/moderation/comment/" + commentID + "/hide", ""}
+	voters := call{http.MethodGet, "/mongo/v1/moderation/votes/" + commentID, ""}
+	tests := []struct {
+		name  string
+		call  call
+		token string
+		code  int
+		// reason is the reason of a 403
+		reason string
+	}{
+		{"anonymous sets a role", setRole, "", http.StatusUnauthorized, ""},
+		{"member sets a role", setRole, member, http.StatusForbidden, "admin role required"},
+		{"owner sets their own role", setRole, owner, http.StatusForbidden, "admin role required"},
+		{"moderator sets a role", setRole, moderator, http.StatusForbidden, "admin role required"},
+		{"admin sets a role", setRole, admin, http.StatusOK, ""},
+
+		{"owner updates their profile", patchProfile, owner, http.StatusOK, ""},
+		{"member updates another profile", patchProfile, member, http.StatusForbidden, "owner or admin role required"},
+		{"moderator updates another profile", patchProfile, moderator, http.StatusForbidden, "owner or admin role required"},
+		{"admin updates another profile", patchProfile, admin, http.StatusOK, ""},
+
+		{"member hides a comment", hideComment, member, http.StatusForbidden, "moderator role required"},
+		{"owner hides their comment", hideComment, owner, http.StatusForbidden, "moderator role required"},
+		{"moderator hides a comment", hideComment, moderator, http.StatusOK, ""},
+		{"admin hides a comment", hideComment, admin, http.StatusOK, ""},
+
+		{"member lists voters", voters, member, http.StatusForbidden, "moderator role required"},
+		{"moderator lists voters", voters, moderator, http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		w := ta.do(test.token, test.call.method, test.call.path, test.call.body)
+		if w.Code != test.code {
+			t.Errorf("%s: status %d, want %d: %s", test.name, w.Code, test.code, w.Body.String())
+			continue
+		}
+		if test.code == http.StatusForbidden && !ta.forbidden(w, test.reason) {
+			t.Errorf("%s: 403 body %s, want the reason %q", test.name, w.Body.String(), test.reason)
+		}
+	}
+}
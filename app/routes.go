@@ -0,0 +1,108 @@
+package app
+
+import (
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/models"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Router registers every enabled endpoint
+func (a *App) Router() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(a.Tokens.Middleware)
+
+	mongoAPI := router.PathPrefix("/mongo/v1").Subrouter()
+	ps := a.ProfileServer
+	mongoAPI.HandleFunc("/auth/refresh", auth.Required(ps.Refresh)).Methods(http.MethodPost)
+	mongoAPI.HandleFunc("/profile", ps.GetAll).Methods(http.MethodGet)
+	mongoAPI.HandleFunc("/profile/{userID}", ps.Get).Methods(http.MethodGet)
+	mongoAPI.HandleFunc("/profile", ps.Post).Methods(http.MethodPost)
+	// PUT /profile creates too, for app builds from before POST existed
+	mongoAPI.HandleFunc("/profile", ps.Post).Methods(http.MethodPut)
+	mongoAPI.HandleFunc("/profile/{userID}", auth.Required(ps.Patch)).Methods(http.MethodPatch)
+	mongoAPI.HandleFunc("/profile/{userID}", auth.Required(ps.Delete)).Methods(http.MethodDelete)
+	mongoAPI.HandleFunc("/profile/{userID}/role", a.Authorizer.RequireRole(models.RoleAdmin, ps.SetRole)).Methods(http.MethodPut)
+
+	moderator := func(h http.HandlerFunc) http.HandlerFunc {
+		return a.Authorizer.RequireRole(models.RoleModerator, h)
+	}
+	ms := a.ModerationServer
+	// The moderators of a sub hide and pin its posts too
+	mongoAPI.HandleFunc("/moderation/post/{postID}/hide", ms.RequirePostModerator(ms.HidePost)).Methods(http.MethodPost)
+	mongoAPI.HandleFunc("/moderation/post/{postID}/unhide", ms.RequirePostModerator(ms.UnhidePost)).Methods(http.MethodPost)
+	mongoAPI.HandleFunc("/moderation/post/{postID}/pin", ms.RequirePostModerator(ms.PinPost)).Methods(http.MethodPost)
+	mongoAPI.HandleFunc("/moderation/post/{postID}/unpin", ms.RequirePostModerator(ms.UnpinPost)).Methods(http.MethodPost)
+	mongoAPI.HandleFunc("/moderation/post/{postID}", moderator(ms.DeletePost)).Methods(http.MethodDelete)
+	mongoAPI.HandleFunc("/moderation/comment/{commentID}/hide", moderator(ms.HideComment)).Methods(http.MethodPost)
+	mongoAPI.HandleFunc("/moderation/comment/{commentID}/unhide", moderator(ms.UnhideComment)).Methods(http.MethodPost)
+	mongoAPI.HandleFunc("/moderation/comment/{commentID}", moderator(ms.DeleteComment)).Methods(http.MethodDelete)
+	mongoAPI.HandleFunc("/moderation/votes/{targetID}", moderator(ms.GetVoters)).Methods(http.MethodGet)
+	mongoAPI.HandleFunc("/moderation/votes/{targetID}/events", moderator(ms.GetVoteEvents)).Methods(http.MethodGet)
+
+	if a.Config.Features.Album {
+		albumServer := a.AlbumServer
+		mongoAPI.HandleFunc("/album", albumServer.GetAll).Methods(http.MethodGet)
+		mongoAPI.HandleFunc("/album", albumServer.Put).Methods(http.MethodPut)
+		mongoAPI.HandleFunc("/album/photo", auth.Required(albumServer.Upload)).Methods(http.MethodPost)
+		mongoAPI.HandleFunc("/album/photo/{photoID}", albumServer.Serve).Methods(http.MethodGet, http.MethodHead)
+		mongoAPI.HandleFunc("/album/photo/{photoID}/{variant}", albumServer.ServeVariant).Methods(http.MethodGet, http.MethodHead)
+		mongoAPI.HandleFunc("/album/photo/{photoID}", auth.Required(albumServer.PatchPhoto)).Methods(http.MethodPatch)
+		mongoAPI.HandleFunc("/album/photo/{photoID}", auth.Required(albumServer.DeletePhoto)).Methods(http.MethodDelete)
+		mongoAPI.HandleFunc("/albums", albumServer.GetAlbums).Methods(http.MethodGet)
+		mongoAPI.HandleFunc("/albums", auth.Required(albumServer.PostAlbum)).Methods(http.MethodPost)
+		mongoAPI.HandleFunc("/albums/{albumID}", albumServer.GetAlbum).Methods(http.MethodGet)
+		mongoAPI.HandleFunc("/albums/{albumID}", auth.Required(albumServer.PatchAlbum)).Methods(http.MethodPatch)
+		mongoAPI.HandleFunc("/albums/{albumID}", auth.Required(albumServer.DeleteAlbum)).Methods(http.MethodDelete)
+		mongoAPI.HandleFunc("/albums/{albumID}/photos", albumServer.GetAlbumPhotos).Methods(http.MethodGet)
+		mongoAPI.HandleFunc("/albums/{albumID}/photos", auth.Required(albumServer.AddAlbumPhoto)).Methods(http.MethodPost)
+		mongoAPI.HandleFunc("/albums/{albumID}/photos/order", auth.Required(albumServer.OrderAlbumPhotos)).Methods(http.MethodPut)
+		mongoAPI.HandleFunc("/albums/{albumID}/photos/{photoID}/move", auth.Required(albumServer.MoveAlbumPhoto)).Methods(http.MethodPost)
+		mongoAPI.HandleFunc("/albums/{albumID}/photos/{photoID}", auth.Required(albumServer.RemoveAlbumPhoto)).Methods(http.MethodDelete)
+	}
+
+	edits := a.EditServer
+	if a.Config.Features.LegacyForum {
+		forumServer := a.ForumServer
+		mongoAPI.HandleFunc("/forum/post", forumServer.GetAllPosts).Methods(http.MethodGet)
+		mongoAPI.HandleFunc("/forum/post/{postID}", forumServer.GetPost).Methods(http.MethodGet)
+		mongoAPI.HandleFunc("/forum/commentsofpost/{postID}", forumServer.GetCommentsForPost).Methods(http.MethodGet)
+		mongoAPI.HandleFunc("/forum/post", auth.Required(forumServer.PutPost)).Methods(http.MethodPut)
+		mongoAPI.HandleFunc("/forum/comment/{parentID}", auth.Required(forumServer.AddCommentV2)).Methods(http.MethodPost)
+		mongoAPI.HandleFunc("/forum/vote/{id}", forumServer.GetUserVoteMap).Methods(http.MethodGet)
+		mongoAPI.HandleFunc("/forum/vote", auth.Required(forumServer.Vote)).Methods(http.MethodPost)
+		mongoAPI.HandleFunc("/forum/post/{postID}", auth.Required(edits.EditPost)).Methods(http.MethodPatch)
+		mongoAPI.HandleFunc("/forum/post/{postID}", auth.Required(edits.DeletePost)).Methods(http.MethodDelete)
+		mongoAPI.HandleFunc("/forum/post/{postID}/revisions", auth.Required(edits.GetPostRevisions)).Methods(http.MethodGet)
+		mongoAPI.HandleFunc("/forum/comment/{commentID}", auth.Required(edits.EditComment)).Methods(http.MethodPatch)
+		mongoAPI.HandleFunc("/forum/comment/{commentID}", auth.Required(edits.DeleteComment)).Methods(http.MethodDelete)
+		mongoAPI.HandleFunc("/forum/comment/{commentID}/revisions", auth.Required(edits.GetCommentRevisions)).Methods(http.MethodGet)
+	}
+
+	subs := a.SubForumServer
+	admin := func(h http.HandlerFunc) http.HandlerFunc {
+		return a.Authorizer.RequireRole(models.RoleAdmin, h)
+	}
+	mongoAPI.HandleFunc("/forum/sub", subs.GetAll).Methods(http.MethodGet)
+	mongoAPI.HandleFunc("/forum/sub/{slug}", subs.Get).Methods(http.MethodGet)
+	mongoAPI.HandleFunc("/forum/sub", admin(subs.Post)).Methods(http.MethodPost)
+	mongoAPI.HandleFunc("/forum/sub/{slug}", auth.Required(subs.Patch)).Methods(http.MethodPatch)
+	mongoAPI.HandleFunc("/forum/sub/{slug}", admin(subs.Delete)).Methods(http.MethodDelete)
+
+	forumServerV2 := a.ForumServerV2
+	mongoAPI.HandleFunc("/forum/v2/post", forumServerV2.GetForumPosts).Methods(http.MethodGet)
+	mongoAPI.HandleFunc("/forum/v2/post", auth.Required(forumServerV2.SaveForumPost)).Methods(http.MethodPut)
+	mongoAPI.HandleFunc("/forum/v2/post/{postID}", auth.Required(edits.EditDBPost)).Methods(http.MethodPatch)
+	mongoAPI.HandleFunc("/forum/v2/post/{postID}", auth.Required(edits.DeleteDBPost)).Methods(http.MethodDelete)
+	mongoAPI.HandleFunc("/forum/v2/post/{postID}/revisions", auth.Required(edits.GetDBPostRevisions)).Methods(http.MethodGet)
+	mongoAPI.HandleFunc("/forum/v2/post/{postID}/comments", forumServerV2.GetForumComments).Methods(http.MethodGet)
+	mongoAPI.HandleFunc("/forum/v2/comment", auth.Required(forumServerV2.SaveForumComment)).Methods(http.MethodPut)
+	mongoAPI.HandleFunc("/forum/v2/comment/{commentID}", auth.Required(edits.EditDBComment)).Methods(http.MethodPatch)
+	mongoAPI.HandleFunc("/forum/v2/comment/{commentID}", auth.Required(edits.DeleteDBComment)).Methods(http.MethodDelete)
+	mongoAPI.HandleFunc("/forum/v2/comment/{commentID}/revisions", auth.Required(edits.GetDBCommentRevisions)).Methods(http.MethodGet)
+	mongoAPI.HandleFunc("/forum/v2/vote", auth.Required(forumServerV2.HandleVoteEvent)).Methods(http.MethodPost)
+	mongoAPI.HandleFunc("/forum/v2/vote", forumServerV2.GetVoteMap).Methods(http.MethodGet)
+
+	return router
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gguan/cwgcf_db/models"
+)
+
+func TestSubPostingRules(t *testing.T) {
+	ta := newTestApp(t, nil)
+	ctx := context.Background()
+	_, member := ta.user("member", models.RoleMember)
+	subModeratorID, subModerator := ta.user("sub moderator", models.RoleMember)
+	_, moderator := ta.user("moderator", models.RoleModerator)
+	staff := models.SubForum{Slug: "staff", Name: "Staff", Moderators: []string{subModeratorID}, PostingRules: models.PostingRules{MinRole: models.RoleModerator}}
+	if _, err := ta.app.Store.SubForums.InsertSubForum(ctx, staff); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		sub   string
+		// v1 and v2 are the statuses of the v1 and v2 forums, v1 accepts new posts with 202
+		v1 int
+		v2 int
+	}{
+		{"member in the default sub", member, "", http.StatusAccepted, http.StatusOK},
+		{"member in a sub for moderators", member, "staff", http.StatusForbidden, http.StatusForbidden},
+		{"moderator of the sub", subModerator, "staff", http.StatusAccepted, http.StatusOK},
+		{"moderator", moderator, "staff", http.StatusAccepted, http.StatusOK},
+		{"unknown sub", moderator, "nowhere", http.StatusBadRequest, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		responses := []struct {
+			version string
+			code    int
+			w       *httptest.ResponseRecorder
+		}{
+			{"v1", test.v1, ta.do(test.token, http.MethodPut, "/mongo/v1/forum/post", fmt.Sprintf(`{"title": "t", "content": "c", "sub": %q}`, test.sub))},
+			{"v2", test.v2, ta.do(test.token, http.MethodPut, "/mongo/v1/forum/v2/post", fmt.Sprintf(`{"ForumPost": {"title": "t", "content": "c", "sub": %q}}`, test.sub))},
+		}
+		for _, res := range responses {
+			if res.w.Code != res.code {
+				t.Errorf("%s: %s status %d, want %d", test.name, res.version, res.w.Code, res.code)
+				continue
+			}
+			// Both forums answer 403 like every other endpoint
+			if res.code == http.StatusForbidden && !ta.forbidden(res.w, models.ErrPostingDenied.Error()) {
+				t.Errorf("%s: %s 403 body %s", test.name, res.version, res.w.Body.String())
+			}
+		}
+	}
+}
+
+func TestSubModeratorsModeratePosts(t *testing.T) {
+	ta := newTestApp(t, nil)
+	ctx := context.Background()
+	_, member := ta.user("member", models.RoleMember)
+	subModeratorID, subModerator := ta.user("sub moderator", models.RoleMember)
+	_, moderator := ta.user("moderator", models.RoleModerator)
+	if _, err := ta.app.Store.SubForums.InsertSubForum(ctx, models.SubForum{Slug: "open", Name: "Open", Moderators: []string{subModeratorID}}); err != nil {
+		t.Fatal(err)
+	}
+	inOpen, err := ta.app.Store.Posts.InsertDBPost(ctx, models.DBForumPost{Title: "t", Sub: "open", Metadata: models.Metadata{CreatedAt: 1600000000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inGeneral, err := ta.app.Store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", Sub: models.DefaultSubSlug, CreatedAt: 1600000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		postID string
+		code   int
+	}{
+		{"moderator of the sub", subModerator, inOpen, http.StatusOK},
+		{"moderator of another sub", subModerator, inGeneral, http.StatusForbidden},
+		{"moderator", moderator, inOpen, http.StatusOK},
+		{"moderator on a v1 post", moderator, inGeneral, http.StatusOK},
+		{"member", member, inOpen, http.StatusForbidden},
+		{"anonymous", "", inOpen, http.StatusUnauthorized},
+		{"missing post", moderator, "5e8f8f8f8f8f8f8f8f8f8f8f", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		for _, action := range []string{"hide", "unhide", "pin", "unpin"} {
+			w := ta.do(test.token, http.MethodPost, "/mongo/v1/moderation/post/"+test.postID+"/"+action, "")
+			if w.Code != test.code {
+				t.Errorf("%s: %s status %d, want %d: %s", test.name, action, w.Code, test.code, w.Body.String())
+				continue
+			}
+			if w.Code == http.StatusForbidden && !ta.forbidden(w, "moderator role or moderator of the sub required") {
+				t.Errorf("%s: %s 403 body %s", test.name, action, w.Body.String())
+			}
+		}
+	}
+
+	// The changes of the sub moderator took effect
+	if w := ta.do(subModerator, http.MethodPost, "/mongo/v1/moderation/post/"+inOpen+"/hide", ""); w.Code != http.StatusOK {
+		t.Fatalf("hiding: %d", w.Code)
+	}
+	post, err := ta.app.Store.Posts.GetDBPost(ctx, inOpen)
+	if err != nil || !post.Hidden {
+		t.Errorf("post hidden by the moderator of its sub: %+v, %v", post, err)
+	}
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/models"
+)
+
+// encodePNG returns a PNG of width by height pixels
+func encodePNG(t *testing.T, width int, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// upload posts data as the file in field of a multipart form
+func (ta *testApp) upload(token string, field string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, "upload")
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/mongo/v1/album/photo", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, req)
+	return w
+}
+
+// blobCount returns how many blobs are stored under dir
+func blobCount(t *testing.T, dir string) int {
+	count := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestUploadRejections(t *testing.T) {
+	var blobDir string
+	ta := newTestApp(t, func(cfg *config.Config) {
+		cfg.Photos.MaxUploadBytes = 4096
+		cfg.Photos.MaxPixels = 100 * 100
+		cfg.Photos.Variants = nil
+		blobDir = cfg.Blobs.Dir
+	})
+	_, token := ta.user("uploader", models.RoleMember)
+
+	tests := []struct {
+		name  string
+		token string
+		field string
+		data  []byte
+		code  int
+		body  string
+	}{
+		{"anonymous", "", models.PhotoFormField, encodePNG(t, 10, 10), http.StatusUnauthorized, ""},
+		{"too many bytes", token, models.PhotoFormField, bytes.Repeat([]byte{0}, 4097), http.StatusRequestEntityTooLarge, "at most 4096 bytes"},
+		{"too many pixels", token, models.PhotoFormField, encodePNG(t, 101, 100), http.StatusRequestEntityTooLarge, "at most 10000 pixels"},
+		{"text", token, models.PhotoFormField, []byte("not a photo"), http.StatusUnsupportedMediaType, "got text/plain; charset=utf-8"},
+		{"truncated png", token, models.PhotoFormField, encodePNG(t, 10, 10)[:40], http.StatusBadRequest, "is no readable image"},
+		{"other field", token, "file", encodePNG(t, 10, 10), http.StatusBadRequest, "is required"},
+	}
+	for _, test := range tests {
+		w := ta.upload(test.token, test.field, test.data)
+		if w.Code != test.code {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s: got body %s, want it to contain %q", test.name, w.Body.String(), test.body)
+		}
+	}
+
+	w := ta.do(token, http.MethodPost, "/mongo/v1/album/photo", `{"photo": "data"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("json body: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if count := blobCount(t, blobDir); count != 0 {
+		t.Errorf("got %d blobs after rejected uploads, want 0", count)
+	}
+	photos, err := ta.app.Store.Photos.GetAllPhotos(context.Background())
+	check(t, err)
+	if len(photos) != 0 {
+		t.Errorf("got %d photos after rejected uploads, want 0", len(photos))
+	}
+}
+
+func TestUploadStoresBlob(t *testing.T) {
+	var blobDir string
+	ta := newTestApp(t, func(cfg *config.Config) {
+		cfg.Photos.Variants = nil
+		blobDir = cfg.Blobs.Dir
+	})
+	userID, token := ta.user("uploader", models.RoleMember)
+	data := encodePNG(t, 30, 20)
+
+	w := ta.upload(token, models.PhotoFormField, data)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var photo models.Photo
+	ta.decode(w, &photo)
+	if photo.URL != models.PhotoURL(photo.ID) || photo.ContentType != "image/png" || photo.Width != 30 || photo.Height != 20 ||
+		photo.Size != int64(len(data)) || photo.UserID != userID {
+		t.Errorf("got photo %+v", photo)
+	}
+	stored, err := ta.app.Store.Photos.GetPhoto(context.Background(), photo.ID)
+	check(t, err)
+	if stored.BlobKey == "" {
+		t.Errorf("stored photo has no blob key")
+	}
+	if count := blobCount(t, blobDir); count != 1 {
+		t.Errorf("got %d blobs, want 1", count)
+	}
+
+	w = ta.do("", http.MethodGet, photo.URL, "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("serving the upload: got status %d, content type %q and %d bytes", w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+	}
+
+	// Sending the same bytes again answers with the photo stored before and writes no blob
+	w = ta.upload(token, models.PhotoFormField, data)
+	var again models.Photo
+	ta.decode(w, &again)
+	if w.Code != http.StatusOK || again.ID != photo.ID {
+		t.Errorf("upload of a copy: got status %d and photo %s, want %d and %s", w.Code, again.ID, http.StatusOK, photo.ID)
+	}
+	if count := blobCount(t, blobDir); count != 1 {
+		t.Errorf("got %d blobs after uploading a copy, want 1", count)
+	}
+}
+
+// check fails the test on err
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
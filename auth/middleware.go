@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type contextKey struct{}
+
+type claimsKey struct{}
+
+// WithUserID returns a context carrying the authenticated user id
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, userID)
+}
+
+// UserID returns the authenticated user id of a request context, empty for anonymous requests
+func UserID(ctx context.Context) string {
+	userID, _ := ctx.Value(contextKey{}).(string)
+	return userID
+}
+
+// TokenClaims returns the claims of the token that authenticated a request, zero for anonymous requests
+func TokenClaims(ctx context.Context) Claims {
+	claims, _ := ctx.Value(claimsKey{}).(Claims)
+	return claims
+}
+
+// Middleware authenticates requests carrying an "Authorization: Bearer <token>" header
+// Requests without the header continue anonymously, requests with a bad token are rejected with 401
+func (t *Tokens) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if authorization == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if token == authorization {
+			Unauthorized(w, "Authorization must be a Bearer token")
+			return
+		}
+		claims, err := t.Verify(token)
+		if err == ErrExpiredToken {
+			Unauthorized(w, "Token expired")
+			return
+		}
+		if err != nil {
+			log.Printf("Rejected token: %v", err)
+			Unauthorized(w, "Invalid token")
+			return
+		}
+		ctx := context.WithValue(WithUserID(r.Context(), claims.Subject), claimsKey{}, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Required rejects anonymous requests with 401
+func Required(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if UserID(r.Context()) == "" {
+			Unauthorized(w, "Authentication required")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// TokenResponse is the response definition of endpoints that issue tokens
+type TokenResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// NewTokenResponse issues a token for userID
+func (t *Tokens) NewTokenResponse(userID string) (TokenResponse, error) {
+	token, expiresAt, err := t.Issue(userID)
+	return TokenResponse{Token: token, ExpiresAt: expiresAt.Unix()}, err
+}
+
+// NewRenewedTokenResponse renews the token of claims, see Renew
+func (t *Tokens) NewRenewedTokenResponse(claims Claims) (TokenResponse, error) {
+	token, expiresAt, err := t.Renew(claims)
+	return TokenResponse{Token: token, ExpiresAt: expiresAt.Unix()}, err
+}
+
+// Unauthorized responds 401 with message and asks for a bearer token
+func Unauthorized(w http.ResponseWriter, message string) {
+	res, _ := json.Marshal(map[string]string{"error": message})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(res)
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tokens := newTestTokens(&now)
+	token := mustIssue(t, tokens, "u1")
+	handler := tokens.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserID(r.Context()) + " " + TokenClaims(r.Context()).Subject))
+	}))
+	required := tokens.Middleware(Required(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserID(r.Context())))
+	}))
+	tests := []struct {
+		name          string
+		handler       http.Handler
+		authorization string
+		// after is how long after issuing the token the request is made
+		after time.Duration
+		code  int
+		// body is the response of the handler, or the error of a 401
+		body string
+	}{
+		{"anonymous", handler, "", 0, http.StatusOK, " "},
+		{"authenticated", handler, "Bearer " + token, 0, http.StatusOK, "u1 u1"},
+		{"not a bearer token", handler, "Basic dTE6cHc=", 0, http.StatusUnauthorized, "Authorization must be a Bearer token"},
+		{"invalid token", handler, "Bearer " + token + "x", 0, http.StatusUnauthorized, "Invalid token"},
+		{"expired token", handler, "Bearer " + token, time.Hour, http.StatusUnauthorized, "Token expired"},
+		{"required and anonymous", required, "", 0, http.StatusUnauthorized, "Authentication required"},
+		{"required and authenticated", required, "Bearer " + token, 0, http.StatusOK, "u1"},
+	}
+	for _, test := range tests {
+		now = time.Unix(1600000000, 0).Add(test.after)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		test.handler.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.code)
+			continue
+		}
+		if w.Code == http.StatusOK {
+			if w.Body.String() != test.body {
+				t.Errorf("%s: body %q, want %q", test.name, w.Body.String(), test.body)
+			}
+			continue
+		}
+		var res map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 1 || res["error"] != test.body {
+			t.Errorf("%s: body %s, want the error %q", test.name, w.Body.String(), test.body)
+		}
+		if w.Header().Get("WWW-Authenticate") != "Bearer" || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: headers %v", test.name, w.Header())
+		}
+	}
+}
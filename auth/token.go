@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed or not signed with our secret
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned for correctly signed tokens past their expiry
+	ErrExpiredToken = errors.New("token expired")
+	// ErrLifetimeExceeded is returned by Renew for tokens whose first token was issued more than the maximum lifetime ago
+	ErrLifetimeExceeded = errors.New("token lifetime exceeded")
+)
+
+// header is the fixed JWT header of every token, tokens are HS256 JWTs so standard libraries can read them
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims is the payload of a token
+type Claims struct {
+	// Subject is the user id the token was issued to
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	// OriginalIssuedAt is when the first token of a chain of renewals was issued, tokens issued before it was added lack it
+	OriginalIssuedAt int64 `json:"orig_iat,omitempty"`
+}
+
+// Tokens issues and verifies signed bearer tokens
+type Tokens struct {
+	secret      []byte
+	ttl         time.Duration
+	maxLifetime time.Duration
+	now         func() time.Time
+}
+
+// NewTokens creates a Tokens signing with secret, issued tokens are valid for ttl
+// Renewals never extend a token past maxLifetime after the first token was issued
+func NewTokens(secret string, ttl time.Duration, maxLifetime time.Duration) *Tokens {
+	return &Tokens{secret: []byte(secret), ttl: ttl, maxLifetime: maxLifetime, now: time.Now}
+}
+
+// Issue signs a token for userID
+func (t *Tokens) Issue(userID string) (token string, expiresAt time.Time, err error) {
+	return t.issue(userID, t.now())
+}
+
+// Renew signs a new token for the user of claims, keeping the time the first token was issued
+// It returns ErrLifetimeExceeded once that is maxLifetime ago
+func (t *Tokens) Renew(claims Claims) (token string, expiresAt time.Time, err error) {
+	original := claims.OriginalIssuedAt
+	if original == 0 {
+		original = claims.IssuedAt
+	}
+	if !t.now().Before(time.Unix(original, 0).Add(t.maxLifetime)) {
+		return "", time.Time{}, ErrLifetimeExceeded
+	}
+	return t.issue(claims.Subject, time.Unix(original, 0))
+}
+
+func (t *Tokens) issue(userID string, original time.Time) (token string, expiresAt time.Time, err error) {
+	now := t.now()
+	expiresAt = now.Add(t.ttl)
+	if end := original.Add(t.maxLifetime); expiresAt.After(end) {
+		expiresAt = end
+	}
+	payload, err := json.Marshal(Claims{
+		Subject:          userID,
+		IssuedAt:         now.Unix(),
+		ExpiresAt:        expiresAt.Unix(),
+		OriginalIssuedAt: original.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + t.sign(unsigned), expiresAt, nil
+}
+
+// Verify checks the signature and expiry of token and returns its claims
+func (t *Tokens) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return Claims{}, ErrInvalidToken
+	}
+	unsigned := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(t.sign(unsigned))) {
+		return Claims{}, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return Claims{}, ErrInvalidToken
+	}
+	if t.now().Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+func (t *Tokens) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
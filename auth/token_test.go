@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestTokens returns Tokens whose clock is read from now
+func newTestTokens(now *time.Time) *Tokens {
+	t := NewTokens("secret", time.Hour, 24*time.Hour)
+	t.now = func() time.Time { return *now }
+	return t
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tokens := newTestTokens(&now)
+	token, expiresAt, err := tokens.Issue("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresAt != now.Add(time.Hour) {
+		t.Errorf("expires at %v, want an hour later", expiresAt)
+	}
+	parts := strings.Split(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","iat":1600000000,"exp":1700000000}`))
+	tests := []struct {
+		name  string
+		token string
+		// after is how long after issuing the token is verified
+		after time.Duration
+		err   error
+	}{
+		{"valid", token, 0, nil},
+		{"valid until expiry", token, time.Hour - time.Second, nil},
+		{"expired", token, time.Hour, ErrExpiredToken},
+		{"other secret", mustIssue(t, NewTokens("other", time.Hour, 24*time.Hour), "u1"), 0, ErrInvalidToken},
+		{"forged payload", parts[0] + "." + forged + "." + parts[2], 0, ErrInvalidToken},
+		{"bad signature", parts[0] + "." + parts[1] + ".x" + parts[2][1:], 0, ErrInvalidToken},
+		{"other algorithm", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "." + parts[2], 0, ErrInvalidToken},
+		{"missing signature", parts[0] + "." + parts[1], 0, ErrInvalidToken},
+		{"empty", "", 0, ErrInvalidToken},
+	}
+	for _, test := range tests {
+		now = time.Unix(1600000000, 0).Add(test.after)
+		claims, err := tokens.Verify(test.token)
+		if err != test.err {
+			t.Errorf("%s: %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && (claims.Subject != "u1" || claims.IssuedAt != 1600000000 || claims.OriginalIssuedAt != 1600000000) {
+			t.Errorf("%s: claims %+v", test.name, claims)
+		}
+	}
+}
+
+func TestRenew(t *testing.T) {
+	issued := time.Unix(1600000000, 0)
+	tests := []struct {
+		name   string
+		claims Claims
+		// after is how long after the first token the renewal happens
+		after time.Duration
+		// expiresAfter is how long after the first token the renewed token expires
+		expiresAfter time.Duration
+		err          error
+	}{
+		{"early", Claims{Subject: "u1", IssuedAt: issued.Unix(), OriginalIssuedAt: issued.Unix()}, time.Minute, time.Minute + time.Hour, nil},
+		{"capped at the maximum lifetime", Claims{Subject: "u1", IssuedAt: issued.Unix(), OriginalIssuedAt: issued.Unix()}, 23*time.Hour + 30*time.Minute, 24 * time.Hour, nil},
+		{"past the maximum lifetime", Claims{Subject: "u1", IssuedAt: issued.Add(23 * time.Hour).Unix(), OriginalIssuedAt: issued.Unix()}, 24 * time.Hour, 0, ErrLifetimeExceeded},
+		{"token without orig_iat", Claims{Subject: "u1", IssuedAt: issued.Unix()}, time.Minute, time.Minute + time.Hour, nil},
+		{"token without orig_iat past the maximum lifetime", Claims{Subject: "u1", IssuedAt: issued.Unix()}, 25 * time.Hour, 0, ErrLifetimeExceeded},
+	}
+	for _, test := range tests {
+		now := issued.Add(test.after)
+		tokens := newTestTokens(&now)
+		token, expiresAt, err := tokens.Renew(test.claims)
+		if err != test.err {
+			t.Errorf("%s: %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if want := issued.Add(test.expiresAfter); !expiresAt.Equal(want) {
+			t.Errorf("%s: expires at %v, want %v", test.name, expiresAt, want)
+		}
+		claims, err := tokens.Verify(token)
+		if err != nil || claims.Subject != "u1" || claims.OriginalIssuedAt != issued.Unix() || claims.IssuedAt != now.Unix() {
+			t.Errorf("%s: renewed claims %+v: %v", test.name, claims, err)
+		}
+	}
+}
+
+func mustIssue(t *testing.T, tokens *Tokens, userID string) string {
+	token, _, err := tokens.Issue(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
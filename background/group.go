@@ -0,0 +1,52 @@
+package background
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Group tracks work that outlives the request that started it so shutdown can wait for it
+type Group struct {
+	wg sync.WaitGroup
+}
+
+// Go runs fn in a goroutine tracked by the group
+func (g *Group) Go(fn func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn()
+	}()
+}
+
+// Every runs fn every interval in a goroutine tracked by the group until ctx is done
+func (g *Group) Every(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	g.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn(ctx)
+			}
+		}
+	})
+}
+
+// Wait blocks until every tracked goroutine returned or ctx is done
+func (g *Group) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
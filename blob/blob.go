@@ -0,0 +1,35 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned when no blob is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps binary objects under slash separated keys like "photos/<id>"
+type Store interface {
+	// Put stores the bytes of r under key, replacing what was there, readers never see a partly written blob
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns the blob stored under key, ErrNotFound when there is none
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the blob stored under key, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// validateKey rejects keys that could escape the root of a backend
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
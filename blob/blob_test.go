@@ -0,0 +1,29 @@
+package blob
+
+import "testing"
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"photos/abc", true},
+		{"photos/abc/thumbnail", true},
+		{"photo.jpg", true},
+		{"", false},
+		{"/photos/abc", false},
+		{"photos/../abc", false},
+		{"../abc", false},
+		{"photos/./abc", false},
+		{"photos//abc", false},
+		{"photos/", false},
+		{"photos\\abc", false},
+		{"..", false},
+	}
+	for _, test := range tests {
+		err := validateKey(test.key)
+		if (err == nil) != test.valid {
+			t.Errorf("validateKey(%q) = %v, want valid %v", test.key, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/models"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// GetForumComments returns the comments of a v2 post as nested trees
+// Siblings are sorted by vote count and then latest activity
+// Query parameters: userId, defaults to the authenticated user
+func (s *ForumServer) GetForumComments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	postID := mux.Vars(r)["postID"]
+	request := models.GetForumCommentsRequest{UserID: r.URL.Query().Get("userId")}
+	if request.UserID == "" {
+		request.UserID = auth.UserID(r.Context())
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	post, err := s.Posts.GetDBPost(ctx, postID)
+	if err == nil && (post.Hidden || post.Deleted) {
+		err = models.ErrNotFound
+	}
+	if err == models.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting post %s: %v", postID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	dbComments, err := s.Comments.GetDBCommentsByPost(ctx, postID)
+	if err != nil {
+		log.Printf("Error getting comments of post %s: %v", postID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	// The requesting user's own votes are filled into ForumVotesMap
+	var voteMap models.ForumVoteMap
+	if request.UserID != "" {
+		voteMap, err = s.VoteMaps.GetVoteMap(ctx, request.UserID)
+		if err != nil && err != models.ErrNotFound {
+			log.Printf("Error getting forum vote map: %v", err)
+		}
+	}
+	// Get user profiles and votes
+	loader := models.NewLoader(s.Profiles, s.Votes)
+	for i, dbComment := range dbComments {
+		if dbComment.Hidden {
+			dbComments[i].Content = models.HiddenContent
+		}
+		if dbComment.Deleted {
+			dbComments[i].Content = models.DeletedContent
+			dbComments[i].UserID = models.DeletedUserID
+		}
+		loader.QueueProfile(dbComments[i].UserID)
+		loader.QueueVote(dbComment.VoteID)
+	}
+	if err := loader.Load(ctx); err != nil {
+		log.Printf("Error getting profiles and votes: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	// Comments whose author is missing are dropped with their replies, like in v1
+	comments := []models.ForumCommentV2{}
+	votes := map[string]models.ForumVote{}
+	for _, dbComment := range dbComments {
+		profile, ok := loader.Profile(dbComment.UserID)
+		if !ok {
+			log.Printf("Error getting profile for ID %s: %v", dbComment.UserID, models.ErrNotFound)
+			continue
+		}
+		comments = append(comments, s.dbCommentToComment(dbComment, profile))
+
+		vote, ok := loader.Vote(dbComment.VoteID)
+		if !ok {
+			log.Printf("Error getting vote with id %s: %v", dbComment.VoteID, models.ErrNotFound)
+		}
+		vote.VoteStatus = voteMap.VoteMap[dbComment.VoteID].VoteStatus
+		votes[dbComment.VoteID] = vote
+	}
+	// dbComments come latest first, so equal counts keep that order
+	sort.SliceStable(comments, func(i, j int) bool {
+		return votes[comments[i].VoteID].Count > votes[comments[j].VoteID].Count
+	})
+	response := models.GetForumCommentsResponse{
+		ForumComments: models.NestCommentsV2(postID, comments),
+		ForumVotesMap: votes,
+	}
+	resBytes, _ := json.Marshal(response)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBytes)
+}
+
+// SaveForumComment saves a comment on a v2 post or a reply to a v2 comment, with a vote object of its own
+// The latest activity of the post and the comments above moves up to the createdAt of the comment
+func (s *ForumServer) SaveForumComment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var err error
+	var comment models.DBForumComment
+	defer func() {
+		res := models.SaveForumCommentResponse{Success: err == nil, ID: comment.ID, VoteID: comment.VoteID}
+		if err != nil {
+			res.ErrorMsg = err.Error()
+		}
+		resBytes, _ := json.Marshal(res)
+		w.Write(resBytes)
+	}()
+	// Parse request
+	var saveForumCommentRequest models.SaveForumCommentRequest
+	decoder := json.NewDecoder(r.Body)
+	err = decoder.Decode(&saveForumCommentRequest)
+	if err == nil && saveForumCommentRequest.ForumComment.ParentID == "" {
+		err = fmt.Errorf("parentId is required")
+	}
+	if err != nil {
+		log.Printf("Invalid comment request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dbComment := saveForumCommentRequest.ForumComment
+	dbComment.UserID = auth.UserID(r.Context())
+	dbComment.Metadata.CreatedBy = dbComment.UserID
+	dbComment.Metadata.UpdatedBy = dbComment.UserID
+	dbComment.Metadata.UpdatedAt = dbComment.Metadata.CreatedAt
+	dbComment.Hidden, dbComment.Deleted = false, false
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	dbComment.PostID, dbComment.Path, err = models.DBCommentAncestry(ctx, s.Comments, s.Posts, dbComment.ParentID)
+	if err == models.ErrNotFound {
+		err = fmt.Errorf("parent %s does not exist", dbComment.ParentID)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error finding the parent of a forum comment: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Create and get voteID, a comment without one could never be voted on
+	dbComment.VoteID, err = s.createAndGetVoteID(ctx, dbComment.Metadata)
+	if err != nil {
+		log.Printf("Error inserting the vote of a forum comment: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	dbComment.ID, err = s.Comments.InsertDBComment(ctx, dbComment)
+	if err != nil {
+		log.Printf("Error inserting forum comment: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	comment = dbComment
+	// The comment is saved, stale activity times only affect the order of the feed
+	if err := models.PropagateCommentActivity(ctx, s.Comments, s.Posts, dbComment); err != nil {
+		log.Printf("Error propagating the activity of comment %s: %v", dbComment.ID, err)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *ForumServer) dbCommentToComment(dbComment models.DBForumComment, profile models.Profile) models.ForumCommentV2 {
+	return models.ForumCommentV2{
+		ID:          dbComment.ID,
+		ParentID:    dbComment.ParentID,
+		PostID:      dbComment.PostID,
+		Content:     dbComment.Content,
+		UserProfile: profile,
+		VoteID:      dbComment.VoteID,
+		Metadata:    dbComment.Metadata,
+	}
+}
@@ -4,39 +4,45 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"gguan/cwgcf_db/auth"
 	"gguan/cwgcf_db/config"
 	"gguan/cwgcf_db/models"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // ForumServer is the definition of a REST API for forum
 type ForumServer struct {
-	Client        *mongo.Client
-	ProfileClient *models.ProfileServer
+	Posts        models.PostRepository
+	Comments     models.CommentRepository
+	Votes        models.VoteRepository
+	VoteMaps     models.VoteMapRepository
+	Profiles     models.ProfileRepository
+	SubForums    models.SubForumRepository
+	Photos       models.PhotoRepository
+	Authorizer   *models.Authorizer
+	Timeout      time.Duration
+	Limits       config.Pagination
+	BodyFallback bool
 }
 
 // NewForumServer creates a new Server instance
-func NewForumServer() *ForumServer {
-	s := &ForumServer{}
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	// "mongodb+srv://<username>:<password>@<cluster-address>/test?w=majority"
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(
-		config.MongoDBUrl,
-	))
-	if err != nil {
-		log.Fatal(err)
+func NewForumServer(store *models.Store, cfg *config.Config, authorizer *models.Authorizer) *ForumServer {
+	return &ForumServer{
+		Posts:        store.Posts,
+		Comments:     store.Comments,
+		Votes:        store.Votes,
+		VoteMaps:     store.VoteMaps,
+		Profiles:     store.Profiles,
+		SubForums:    store.SubForums,
+		Photos:       store.Photos,
+		Authorizer:   authorizer,
+		Timeout:      cfg.Server.RequestTimeout.Duration,
+		Limits:       cfg.Pagination,
+		BodyFallback: cfg.Features.GetBodyFallback,
 	}
-	log.Print("Connected to MongoDB")
-	s.ProfileClient = models.NewProfileServer()
-	s.Client = client
-	return s
 }
 
 /*
@@ -44,54 +50,116 @@ func NewForumServer() *ForumServer {
 */
 
 // GetForumPosts returns an array of forum posts
+// Query parameters: userId, limit, cursor, sort, window
+// userId defaults to the authenticated user, sort to models.SortNew
 func (s *ForumServer) GetForumPosts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	// Parse request
 	var getForumPostsRequest models.GetForumPostsRequest
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&getForumPostsRequest)
+	err := s.decodeReadRequest(w, r, &getForumPostsRequest, func(query url.Values) (err error) {
+		getForumPostsRequest.UserID = query.Get("userId")
+		getForumPostsRequest.Cursor = query.Get("cursor")
+		getForumPostsRequest.Sort = query.Get("sort")
+		getForumPostsRequest.Window = query.Get("window")
+		getForumPostsRequest.Sub = query.Get("sub")
+		getForumPostsRequest.Limit, err = models.QueryInt64(query, "limit")
+		return err
+	})
+	if getForumPostsRequest.UserID == "" {
+		getForumPostsRequest.UserID = auth.UserID(r.Context())
+	}
+	var sort models.PostSort
+	if err == nil {
+		sort, err = models.NewPostSort(getForumPostsRequest.Sort, getForumPostsRequest.Window, models.SortNew)
+	}
 	if err != nil {
-		log.Printf("Failed to decode request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	page, err := models.NewPageRequest(getForumPostsRequest.Limit, getForumPostsRequest.Cursor, s.Limits)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
 	}
 	// Fetch DBPosts
-	collection := s.Client.Database("cwgcf").Collection("forumPosts")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	opt := options.Find()
-	opt.SetSort(bson.D{{"metadata.updatedAt", -1}})
-	cur, err := collection.Find(ctx, bson.D{}, opt)
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if sort.Sub = getForumPostsRequest.Sub; sort.Sub != "" {
+		if _, err := s.SubForums.GetSubForum(ctx, sort.Sub); err != nil {
+			log.Printf("Error getting sub %s: %v", sort.Sub, err)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "Sub not found"}`))
+			return
+		}
+	}
+	dbPosts, next, err := s.Posts.GetDBPosts(ctx, sort, page)
+	if err == models.ErrInvalidCursor {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	// Pinned posts come before the first page, only they carry their pin
+	var pinned []models.DBForumPost
+	if err == nil && page.After == nil {
+		pinned, err = s.Posts.GetPinnedDBPosts(ctx, sort)
+	}
 	if err != nil {
 		log.Printf("Error getting forum posts: %v", err)
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
 		return
 	}
+	for i := range dbPosts {
+		dbPosts[i].Pin = nil
+	}
+	dbPosts = append(pinned, dbPosts...)
+	// The requesting user's own votes are filled into ForumVotesMap
+	var voteMap models.ForumVoteMap
+	if getForumPostsRequest.UserID != "" {
+		voteMap, err = s.VoteMaps.GetVoteMap(ctx, getForumPostsRequest.UserID)
+		if err != nil && err != models.ErrNotFound {
+			log.Printf("Error getting forum vote map: %v", err)
+		}
+	}
+	// Get user profiles and votes
+	loader := models.NewLoader(s.Profiles, s.Votes)
+	for _, dbPost := range dbPosts {
+		loader.QueueProfile(dbPost.UserID)
+		loader.QueueVote(dbPost.VoteID)
+	}
+	if err := loader.Load(ctx); err != nil {
+		log.Printf("Error getting profiles and votes: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
 	// Transform DBPosts to []ForumPostV2
-	defer cur.Close(ctx)
 	response := models.GetForumPostsResponse{}
 	posts := []models.ForumPostV2{}
 	votes := map[string]models.ForumVote{}
-	for cur.Next(ctx) {
-		var dbPost models.DBForumPost
-		err := cur.Decode(&dbPost)
-		if err != nil {
-			log.Printf("Error getting post: %v", err)
-			continue
-		}
-		// Get user profile
-		profile, err := s.ProfileClient.GetProfile(dbPost.UserID)
-		if err != nil {
-			log.Printf("Error getting profile for ID %s: %v", dbPost.UserID, err)
+	for _, dbPost := range dbPosts {
+		profile, ok := loader.Profile(dbPost.UserID)
+		if !ok {
+			log.Printf("Error getting profile for ID %s: %v", dbPost.UserID, models.ErrNotFound)
 			continue
 		}
 		post := s.dbPostToPost(dbPost, profile)
 		posts = append(posts, post)
 
 		// Get vote
-		vote := s.getVote(dbPost.VoteID)
+		vote, ok := loader.Vote(dbPost.VoteID)
+		if !ok {
+			log.Printf("Error getting vote with id %s: %v", dbPost.VoteID, models.ErrNotFound)
+		}
+		vote.VoteStatus = voteMap.VoteMap[dbPost.VoteID].VoteStatus
 		votes[dbPost.VoteID] = vote
 	}
 	response.ForumPosts = posts
 	response.ForumVotesMap = votes
+	response.NextCursor = next.Encode()
+	response.HasMore = next != nil
 	resBytes, _ := json.Marshal(response)
 
 	w.WriteHeader(http.StatusOK)
@@ -102,7 +170,12 @@ func (s *ForumServer) GetForumPosts(w http.ResponseWriter, r *http.Request) {
 func (s *ForumServer) SaveForumPost(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var err error
+	// responded is set by the branches that write their own body
+	responded := false
 	defer func() {
+		if responded {
+			return
+		}
 		res := s.generateBasicResponse(err)
 		resBytes, _ := json.Marshal(res)
 		w.Write(resBytes)
@@ -117,37 +190,71 @@ func (s *ForumServer) SaveForumPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	forumPost := saveForumPostsRequest.ForumPost
-	// Create and get voteID
-	voteID := s.createAndGetVoteID(forumPost.Metadata)
-	log.Printf("VoteID: %s", voteID)
+	forumPost.UserID = auth.UserID(r.Context())
+	forumPost.Metadata.CreatedBy = forumPost.UserID
+	forumPost.Metadata.UpdatedBy = forumPost.UserID
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	slug := forumPost.Sub
+	forumPost.Sub, err = models.PostingSub(ctx, s.SubForums, s.Authorizer, forumPost.UserID, slug)
+	if err == models.ErrNotFound {
+		err = fmt.Errorf("sub %s does not exist", slug)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err == models.ErrPostingDenied {
+		models.WriteForbidden(w, err.Error())
+		responded = true
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking the sub of a forum post: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if forumPost.ImageID != "" {
+		forumPost.Image, err = models.PostImage(ctx, s.Photos, forumPost.ImageID)
+		if err == models.ErrNotFound {
+			err = fmt.Errorf("imageId %s is no uploaded photo", forumPost.ImageID)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking the image of a forum post: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	// Create and get voteID, a post without one could never be voted on
+	forumPost.VoteID, err = s.createAndGetVoteID(ctx, forumPost.Metadata)
+	if err != nil {
+		log.Printf("Error inserting the vote of a forum post: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	forumPost.Ranking = models.NewRanking(models.VoteTally{}, time.Now().Unix())
+	log.Printf("VoteID: %s", forumPost.VoteID)
 	// Upsert Post
-	collection := s.Client.Database("cwgcf").Collection("forumPosts")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	doc := bson.M{
-		"title":    forumPost.Title,
-		"content":  forumPost.Content,
-		"image":    forumPost.Image,
-		"metadata": forumPost.Metadata,
-		"userId":   forumPost.UserID,
-		"voteId":   voteID,
-	}
-	dbRes, err := collection.InsertOne(ctx, doc)
+	insertID, err := s.Posts.InsertDBPost(ctx, forumPost)
 	if err != nil {
 		log.Printf("Error inserting forum post: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	objectID, _ := dbRes.InsertedID.(primitive.ObjectID)
-	log.Printf("Insert ID: %s", objectID.Hex())
+	log.Printf("Insert ID: %s", insertID)
 	w.WriteHeader(http.StatusOK)
 }
 
-// HandleVoteEvent updates vote object and userVoteMap
+// HandleVoteEvent sets the user's vote status and updates the vote count by the change, retrying the same status is harmless
 func (s *ForumServer) HandleVoteEvent(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var err error
+	var vote models.ForumVote
 	defer func() {
-		res := s.generateBasicResponse(err)
+		res := models.VoteResponse{Success: err == nil, Vote: vote}
+		if err != nil {
+			res.ErrorMsg = err.Error()
+		}
 		resBytes, _ := json.Marshal(res)
 		w.Write(resBytes)
 	}()
@@ -155,20 +262,37 @@ func (s *ForumServer) HandleVoteEvent(w http.ResponseWriter, r *http.Request) {
 	var forumVoteUpdateRequest models.ForumVoteUpdateRequest
 	decoder := json.NewDecoder(r.Body)
 	err = decoder.Decode(&forumVoteUpdateRequest)
+	if err == nil && forumVoteUpdateRequest.VoteID == "" {
+		err = fmt.Errorf("voteId is required")
+	}
+	if err == nil {
+		err = models.ValidateVoteStatus(forumVoteUpdateRequest.VoteStatus)
+	}
 	if err != nil {
-		log.Printf("Failed to decode request: %v", err)
+		log.Printf("Invalid vote request: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	// Update vote
-	s.updateVote(forumVoteUpdateRequest)
-	// Update userVoteMap
-	s.updateVoteMap(forumVoteUpdateRequest)
-
+	userID := auth.UserID(r.Context())
+	metadata := forumVoteUpdateRequest.Metadata
+	metadata.UpdatedBy = userID
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	vote, err = s.Votes.ApplyVote(ctx, userID, forumVoteUpdateRequest.VoteID, forumVoteUpdateRequest.VoteStatus, metadata)
+	if err == models.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error applying vote %s for user %s: %v", forumVoteUpdateRequest.VoteID, userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 // GetVoteMap gets votemap of given user
+// Query parameters: userId, defaults to the authenticated user
 func (s *ForumServer) GetVoteMap(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var err error
@@ -180,19 +304,25 @@ func (s *ForumServer) GetVoteMap(w http.ResponseWriter, r *http.Request) {
 	}()
 	// Parse request
 	var request models.GetForumVoteMapRequest
-	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&request)
+	err = s.decodeReadRequest(w, r, &request, func(query url.Values) error {
+		request.UserID = query.Get("userId")
+		return nil
+	})
+	if request.UserID == "" {
+		request.UserID = auth.UserID(r.Context())
+	}
+	if err == nil && request.UserID == "" {
+		err = fmt.Errorf("userId is required")
+	}
 	if err != nil {
-		log.Printf("Failed to decode request: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
+		res = []byte(fmt.Sprintf(`{"error": "%v"}`, err))
 		return
 	}
 	// Get votemap
-	collection := s.Client.Database("cwgcf").Collection("forumVoteMap")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	filter := bson.M{"userId": request.UserID}
-	var voteMap models.ForumVoteMap
-	err = collection.FindOne(ctx, filter).Decode(&voteMap)
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	voteMap, err := s.VoteMaps.GetVoteMap(ctx, request.UserID)
 	if err != nil {
 		log.Printf("Error getting forum vote map: %v", err)
 		w.WriteHeader(http.StatusNotFound)
@@ -212,9 +342,12 @@ func (s *ForumServer) dbPostToPost(dbPost models.DBForumPost, profile models.Pro
 		Title:       dbPost.Title,
 		Content:     dbPost.Content,
 		Image:       dbPost.Image,
+		ImageID:     dbPost.ImageID,
 		UserProfile: profile,
 		VoteID:      dbPost.VoteID,
+		Sub:         dbPost.Sub,
 		Metadata:    dbPost.Metadata,
+		Pin:         dbPost.Pin,
 	}
 	return post
 }
@@ -233,81 +366,6 @@ func (s *ForumServer) generateBasicResponse(err error) models.BasicResponse {
 }
 
 // createAndGetVoteID creates a new vote object and returns the id
-func (s *ForumServer) createAndGetVoteID(metadata models.Metadata) string {
-	collection := s.Client.Database("cwgcf").Collection("forumVotes")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	doc := bson.M{
-		"count":    0,
-		"metadata": metadata,
-	}
-	dbRes, err := collection.InsertOne(ctx, doc)
-	if err != nil {
-		log.Printf("Error inserting forum post: %v", err)
-		return ""
-	}
-	objectID, _ := dbRes.InsertedID.(primitive.ObjectID)
-	return objectID.Hex()
-}
-
-// getVote retrieves a vote object
-func (s *ForumServer) getVote(id string) models.ForumVote {
-	collection := s.Client.Database("cwgcf").Collection("forumVotes")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	objectID, _ := primitive.ObjectIDFromHex(id)
-	filter := bson.M{
-		"_id": objectID,
-	}
-	var vote models.ForumVote
-	err := collection.FindOne(ctx, filter).Decode(&vote)
-	if err != nil {
-		log.Printf("Error getting vote with id %s: %v", id, err)
-		return vote
-	}
-	return vote
-}
-
-// updateVote updates a vote object
-func (s *ForumServer) updateVote(request models.ForumVoteUpdateRequest) {
-	collection := s.Client.Database("cwgcf").Collection("forumVotes")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	objectID, _ := primitive.ObjectIDFromHex(request.VoteID)
-	filter := bson.M{
-		"_id": objectID,
-	}
-	update := bson.M{
-		"$inc": bson.M{
-			"count": request.Offset,
-		},
-		"$set": bson.M{
-			"metadata.updatedAt": request.Metadata.UpdatedAt,
-		},
-	}
-	opt := options.Update()
-	opt.SetUpsert(true)
-	_, err := collection.UpdateOne(ctx, filter, update, opt)
-	if err != nil {
-		log.Printf("Error updating vote with id %s: %v", request.VoteID, err)
-	}
-}
-
-// updateVoteMap updates a user's votemap
-func (s *ForumServer) updateVoteMap(request models.ForumVoteUpdateRequest) {
-	collection := s.Client.Database("cwgcf").Collection("forumVoteMap")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	filter := bson.M{
-		"userId": request.UserID,
-	}
-	entryPrefix := fmt.Sprintf("voteMap.%s.", request.VoteID)
-	update := bson.M{
-		"$set": bson.M{
-			entryPrefix + "voteStatus": request.VoteStatus,
-			entryPrefix + "metadata":   request.Metadata,
-		},
-	}
-	opt := options.Update()
-	opt.SetUpsert(true)
-	_, err := collection.UpdateOne(ctx, filter, update, opt)
-	if err != nil {
-		log.Printf("Error updating votemap with userID %s and voteID %s: %v", request.VoteID, request.VoteID, err)
-	}
+func (s *ForumServer) createAndGetVoteID(ctx context.Context, metadata models.Metadata) (string, error) {
+	return s.Votes.InsertVote(ctx, metadata)
 }
@@ -0,0 +1,29 @@
+package clients
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// deprecatedBodyWarning is sent to clients that still put GET parameters in a JSON body
+const deprecatedBodyWarning = `299 - "JSON bodies on GET are deprecated, send query parameters instead"`
+
+// decodeReadRequest fills request for a GET endpoint
+// Parameters come from the query string through fromQuery. Old app builds send a JSON body instead,
+// which is decoded into request while the fallback is enabled and answered with a Deprecation header
+func (s *ForumServer) decodeReadRequest(w http.ResponseWriter, r *http.Request, request interface{}, fromQuery func(url.Values) error) error {
+	query := r.URL.Query()
+	if len(query) > 0 || !s.BodyFallback || r.ContentLength == 0 {
+		return fromQuery(query)
+	}
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		log.Printf("Failed to decode request: %v", err)
+		return fmt.Errorf("invalid request body")
+	}
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Warning", deprecatedBodyWarning)
+	return nil
+}
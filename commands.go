@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gguan/cwgcf_db/app"
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/models"
+	"log"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// command is a maintenance task run against the configured storage instead of serving
+type command struct {
+	help string
+	run  func(ctx context.Context, a *app.App, args []string) error
+}
+
+var commands = map[string]command{
+	"migrate": {
+		help: "apply the pending data migrations in version order, -list shows which ran",
+		run:  migrate,
+	},
+	"reconcile-votes": {
+		help: "recompute vote counts from the users' vote maps and report wrong ones, -fix overwrites them",
+		run:  reconcileVotes,
+	},
+	"process-photos": {
+		help: "generate the missing variants of uploaded photos, -all regenerates every variant after their sizes changed",
+		run:  processPhotos,
+	},
+	"set-role": {
+		help: "set the role of a user to member, moderator or admin, use it to create the first admin",
+		run:  setRole,
+	},
+	"mint-token": {
+		help: "print a bearer token for a user id, for local development",
+		run:  mintToken,
+	},
+}
+
+func commandNames() []string {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runCommand runs cmd with its own app, a signal cancels the context
+func runCommand(cfg *config.Config, cmd command, args []string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	a, err := app.New(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close(context.Background())
+	return cmd.run(ctx, a, args)
+}
+
+func mintToken(ctx context.Context, a *app.App, args []string) error {
+	flags := flag.NewFlagSet("mint-token", flag.ExitOnError)
+	ttl := flags.Duration("ttl", a.Config.Auth.TokenTTL.Duration, "how long the token is valid")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: mint-token [-ttl duration] userID\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one user id, got %d", flags.NArg())
+	}
+	userID := flags.Arg(0)
+	if _, err := a.Store.Profiles.GetProfile(ctx, userID); err != nil {
+		log.Printf("Warning: no profile for %s: %v", userID, err)
+	}
+	token, expiresAt, err := auth.NewTokens(a.Config.Auth.Secret, *ttl, a.Config.Auth.MaxTokenLifetime.Duration).Issue(userID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Token for %s expires at %s", userID, expiresAt.Format(time.RFC3339))
+	fmt.Println(token)
+	return nil
+}
+
+func setRole(ctx context.Context, a *app.App, args []string) error {
+	flags := flag.NewFlagSet("set-role", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: set-role userID role\n")
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("expected a user id and a role, got %d arguments", flags.NArg())
+	}
+	userID, role := flags.Arg(0), flags.Arg(1)
+	if err := models.ValidateRole(role); err != nil {
+		return err
+	}
+	if _, err := a.Store.Profiles.SetProfileRole(ctx, userID, role); err != nil {
+		return err
+	}
+	log.Printf("Set the role of %s to %s", userID, role)
+	return nil
+}
+
+func reconcileVotes(ctx context.Context, a *app.App, args []string) error {
+	flags := flag.NewFlagSet("reconcile-votes", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "overwrite wrong counts, the default is a dry run")
+	flags.Parse(args)
+	report, err := models.ReconcileVotes(ctx, a.Store, *fix)
+	for _, d := range report.Discrepancies {
+		fmt.Println(d)
+	}
+	log.Printf("Checked %d counts, %d wrong, %d fixed", report.Checked, len(report.Discrepancies), report.Fixed())
+	return err
+}
+
+func processPhotos(ctx context.Context, a *app.App, args []string) error {
+	flags := flag.NewFlagSet("process-photos", flag.ExitOnError)
+	all := flags.Bool("all", false, "regenerate the variants of every uploaded photo, not only of those missing one")
+	flags.Parse(args)
+	processed, err := a.PhotoWorker.ProcessPhotos(ctx, *all)
+	log.Printf("Processed %d photos", processed)
+	return err
+}
+
+func migrate(ctx context.Context, a *app.App, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	list := flags.Bool("list", false, "list every migration and when it was applied, without applying any")
+	to := flags.Int("to", 0, "stop after this version, the default applies every pending migration")
+	flags.Parse(args)
+	if *list {
+		return listMigrations(ctx, a)
+	}
+	pending, err := models.PendingMigrations(ctx, a.Store.Migrations)
+	if err != nil {
+		return err
+	}
+	applied := 0
+	for _, migration := range pending {
+		if *to > 0 && migration.Version > *to {
+			break
+		}
+		// The check relies on the servers running with the same config as the command
+		if migration.RetiresV1 && a.Config.Features.LegacyForum {
+			return fmt.Errorf("migration %d %s converts the v1 forum, disable features.legacyForum first", migration.Version, migration.Name)
+		}
+		log.Printf("Applying migration %d %s", migration.Version, migration.Name)
+		res, err := models.ApplyMigration(ctx, a.Store, migration)
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied migration %d %s, %d documents changed", res.Version, res.Name, res.Changed)
+		applied++
+	}
+	log.Printf("Applied %d migrations", applied)
+	return nil
+}
+
+func listMigrations(ctx context.Context, a *app.App) error {
+	applied, err := a.Store.Migrations.GetAppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	done := map[int]models.AppliedMigration{}
+	for _, migration := range applied {
+		done[migration.Version] = migration
+	}
+	for _, migration := range models.Migrations {
+		status := "pending"
+		if res, ok := done[migration.Version]; ok {
+			status = fmt.Sprintf("applied %s, %d documents changed", time.Unix(res.AppliedAt, 0).Format(time.RFC3339), res.Changed)
+		}
+		fmt.Printf("%3d %-22s %s\n    %s\n", migration.Version, migration.Name, status, migration.Help)
+	}
+	return nil
+}
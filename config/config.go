@@ -1,6 +1,450 @@
 package config
 
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
 const (
-	// MongoDBUrl is the URL for mongodb
-	MongoDBUrl = "mongodb://localhost:27017"
+	// StorageMongo keeps data in MongoDB
+	StorageMongo = "mongo"
+	// StorageMemory keeps data in process memory, for local development
+	StorageMemory = "memory"
+
+	// DeletePolicyAnonymize keeps a deleted user's posts, comments and votes without linking them to the user
+	DeletePolicyAnonymize = "anonymize"
+	// DeletePolicyCascade deletes a deleted user's posts, comments and votes
+	DeletePolicyCascade = "cascade"
+
+	// BlobBackendLocal keeps uploaded files in a directory of the local filesystem
+	BlobBackendLocal = "local"
+
+	// DevelopmentSecret signs tokens when storage is memory and no secret is configured
+	DevelopmentSecret = "cwgcf-development-secret-never-use-in-production"
+	// minSecretLength is the shortest accepted token signing secret, in bytes
+	minSecretLength = 32
 )
+
+// variantName matches the names of photo variants, which appear in urls and blob keys
+var variantName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Config is the runtime configuration of the API
+type Config struct {
+	Storage    string     `json:"storage"`
+	Mongo      Mongo      `json:"mongo"`
+	Server     Server     `json:"server"`
+	Pagination Pagination `json:"pagination"`
+	Features   Features   `json:"features"`
+	Profiles   Profiles   `json:"profiles"`
+	Auth       Auth       `json:"auth"`
+	// Reconciliation schedules the reconcile-votes job inside the server
+	Reconciliation Reconciliation `json:"reconciliation"`
+	Blobs          Blobs          `json:"blobs"`
+	Photos         Photos         `json:"photos"`
+}
+
+// Mongo configures the MongoDB connection
+type Mongo struct {
+	// URI is the connection string, e.g. "mongodb+srv://<username>:<password>@<cluster-address>/test?w=majority"
+	URI            string      `json:"uri"`
+	Database       string      `json:"database"`
+	ConnectTimeout Duration    `json:"connectTimeout"`
+	Collections    Collections `json:"collections"`
+}
+
+// Collections names every collection the API uses
+type Collections struct {
+	ForumPosts     string `json:"forumPosts"`
+	ForumComments  string `json:"forumComments"`
+	ForumUserVotes string `json:"forumUserVotes"`
+	ForumVotes     string `json:"forumVotes"`
+	ForumVoteMap   string `json:"forumVoteMap"`
+	// ForumVoteEvents is the append-only log of vote changes
+	ForumVoteEvents string `json:"forumVoteEvents"`
+	Profiles        string `json:"profiles"`
+	// Album holds the photos, Albums the named albums and AlbumPhotos which photos they hold
+	Album       string `json:"album"`
+	Albums      string `json:"albums"`
+	AlbumPhotos string `json:"albumPhotos"`
+	SubForums   string `json:"subForums"`
+	// ForumRevisions keeps the former versions of edited and deleted posts and comments
+	ForumRevisions string `json:"forumRevisions"`
+	// Migrations records the data migrations applied by the migrate command
+	Migrations string `json:"migrations"`
+}
+
+// Server configures the HTTP server
+type Server struct {
+	Addr string `json:"addr"`
+	// RequestTimeout bounds the storage calls made while serving one request
+	RequestTimeout Duration `json:"requestTimeout"`
+	ReadTimeout    Duration `json:"readTimeout"`
+	WriteTimeout   Duration `json:"writeTimeout"`
+	IdleTimeout    Duration `json:"idleTimeout"`
+	// ShutdownTimeout bounds draining requests and background work on SIGINT/SIGTERM
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+}
+
+// Pagination bounds the page size of paginated listings
+type Pagination struct {
+	DefaultLimit int64 `json:"defaultLimit"`
+	MaxLimit     int64 `json:"maxLimit"`
+}
+
+// Features toggles optional parts of the API
+type Features struct {
+	LegacyForum bool `json:"legacyForum"`
+	Album       bool `json:"album"`
+	// GetBodyFallback accepts JSON bodies on v2 GET endpoints during the deprecation window
+	GetBodyFallback bool `json:"getBodyFallback"`
+}
+
+// Profiles configures profile management
+type Profiles struct {
+	// DeletePolicy decides what happens to the posts, comments and votes of a deleted profile
+	DeletePolicy string `json:"deletePolicy"`
+}
+
+// Auth configures bearer tokens
+type Auth struct {
+	// Secret signs tokens, it is required with mongo storage and must be shared by every instance
+	Secret   string   `json:"secret"`
+	TokenTTL Duration `json:"tokenTTL"`
+	// MaxTokenLifetime bounds how long refreshes renew a token, counted from when the first one was issued
+	MaxTokenLifetime Duration `json:"maxTokenLifetime"`
+}
+
+// Reconciliation configures the scheduled vote reconciliation
+type Reconciliation struct {
+	// Interval between runs, zero disables the schedule
+	Interval Duration `json:"interval"`
+	// Fix overwrites wrong counts, otherwise runs only report them
+	Fix bool `json:"fix"`
+}
+
+// Blobs configures where uploaded files are stored
+type Blobs struct {
+	Backend string `json:"backend"`
+	// Dir is the root directory of the local backend, every instance must share it
+	Dir string `json:"dir"`
+}
+
+// Photos configures photo uploads to the album
+type Photos struct {
+	// MaxUploadBytes bounds the size of one uploaded photo
+	MaxUploadBytes int64 `json:"maxUploadBytes"`
+	// MaxPixels bounds width times height of one uploaded photo, decoding takes memory in proportion to it
+	// and a small compressed file may declare a huge picture
+	MaxPixels int64 `json:"maxPixels"`
+	// CacheMaxAge is how long clients may cache served photos, the bytes behind a photo id never change
+	CacheMaxAge Duration `json:"cacheMaxAge"`
+	// Variants are the resized copies generated for every uploaded photo
+	Variants []PhotoVariant `json:"variants"`
+	// DuplicateDistance is how many of the 64 bits of their perceptual hashes an upload may differ in from a photo
+	// of the same user to be taken for a copy of it, negative only matches identical bytes
+	DuplicateDistance int `json:"duplicateDistance"`
+	// KeepGPS keeps the location in the EXIF data of uploaded JPEGs, by default it is removed before they are stored
+	KeepGPS bool `json:"keepGPS"`
+}
+
+// MaxDuplicateDistance bounds photos.duplicateDistance, similar photos are looked up by 8 parts of their hash
+// and only hashes differing in fewer bits than that are sure to share one
+const MaxDuplicateDistance = 7
+
+// PhotoVariant is a resized copy of uploaded photos, named in its url
+type PhotoVariant struct {
+	Name string `json:"name"`
+	// MaxSize bounds the longer edge in pixels, smaller photos keep their size
+	MaxSize int `json:"maxSize"`
+}
+
+// Duration is a time.Duration written as "10s" in config files
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	return &Config{
+		Storage: StorageMongo,
+		Mongo: Mongo{
+			URI:            "mongodb://localhost:27017",
+			Database:       "cwgcf",
+			ConnectTimeout: Duration{10 * time.Second},
+			Collections: Collections{
+				ForumPosts:      "forumPosts",
+				ForumComments:   "forumComments",
+				ForumUserVotes:  "forumUserVotes",
+				ForumVotes:      "forumVotes",
+				ForumVoteMap:    "forumVoteMap",
+				ForumVoteEvents: "forumVoteEvents",
+				Profiles:        "profiles",
+				Album:           "album",
+				Albums:          "albums",
+				AlbumPhotos:     "albumPhotos",
+				SubForums:       "subForums",
+				ForumRevisions:  "forumRevisions",
+				Migrations:      "migrations",
+			},
+		},
+		Server: Server{
+			Addr:            ":8080",
+			RequestTimeout:  Duration{10 * time.Second},
+			ReadTimeout:     Duration{15 * time.Second},
+			WriteTimeout:    Duration{30 * time.Second},
+			IdleTimeout:     Duration{60 * time.Second},
+			ShutdownTimeout: Duration{20 * time.Second},
+		},
+		Pagination: Pagination{
+			DefaultLimit: 20,
+			MaxLimit:     100,
+		},
+		Features: Features{
+			LegacyForum:     true,
+			Album:           true,
+			GetBodyFallback: true,
+		},
+		Profiles: Profiles{
+			DeletePolicy: DeletePolicyAnonymize,
+		},
+		Auth: Auth{
+			TokenTTL:         Duration{24 * time.Hour},
+			MaxTokenLifetime: Duration{30 * 24 * time.Hour},
+		},
+		Blobs: Blobs{
+			Backend: BlobBackendLocal,
+			Dir:     "blobs",
+		},
+		Photos: Photos{
+			MaxUploadBytes: 10 << 20,
+			MaxPixels:      40000000,
+			CacheMaxAge:    Duration{365 * 24 * time.Hour},
+			Variants: []PhotoVariant{
+				{Name: "thumbnail", MaxSize: 200},
+				{Name: "medium", MaxSize: 1024},
+			},
+			DuplicateDistance: 4,
+		},
+	}
+}
+
+// Load reads the config file at path on top of the defaults, applies environment overrides and validates the result
+// An empty path skips the file
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", path, err)
+		}
+	}
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	// Local runs without a mongod should not need a secret, the data is thrown away on exit anyway
+	if cfg.Storage == StorageMemory && cfg.Auth.Secret == "" {
+		cfg.Auth.Secret = DevelopmentSecret
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides fields with CWGCF_* environment variables
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	stringFields := map[string]*string{
+		"CWGCF_STORAGE":               &c.Storage,
+		"CWGCF_MONGO_URI":             &c.Mongo.URI,
+		"CWGCF_MONGO_DATABASE":        &c.Mongo.Database,
+		"CWGCF_LISTEN_ADDR":           &c.Server.Addr,
+		"CWGCF_PROFILE_DELETE_POLICY": &c.Profiles.DeletePolicy,
+		"CWGCF_AUTH_SECRET":           &c.Auth.Secret,
+		"CWGCF_BLOB_DIR":              &c.Blobs.Dir,
+	}
+	for key, field := range stringFields {
+		if value, ok := lookup(key); ok {
+			*field = value
+		}
+	}
+	durations := map[string]*Duration{
+		"CWGCF_MONGO_CONNECT_TIMEOUT":   &c.Mongo.ConnectTimeout,
+		"CWGCF_REQUEST_TIMEOUT":         &c.Server.RequestTimeout,
+		"CWGCF_SHUTDOWN_TIMEOUT":        &c.Server.ShutdownTimeout,
+		"CWGCF_AUTH_TOKEN_TTL":          &c.Auth.TokenTTL,
+		"CWGCF_AUTH_MAX_TOKEN_LIFETIME": &c.Auth.MaxTokenLifetime,
+		"CWGCF_RECONCILE_INTERVAL":      &c.Reconciliation.Interval,
+	}
+	for key, field := range durations {
+		if value, ok := lookup(key); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			field.Duration = parsed
+		}
+	}
+	bools := map[string]*bool{
+		"CWGCF_FEATURE_LEGACY_FORUM":      &c.Features.LegacyForum,
+		"CWGCF_FEATURE_ALBUM":             &c.Features.Album,
+		"CWGCF_FEATURE_GET_BODY_FALLBACK": &c.Features.GetBodyFallback,
+		"CWGCF_RECONCILE_FIX":             &c.Reconciliation.Fix,
+	}
+	for key, field := range bools {
+		if value, ok := lookup(key); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			*field = parsed
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid field at once
+func (c *Config) Validate() error {
+	var problems []string
+	switch c.Storage {
+	case StorageMongo:
+		if c.Mongo.URI == "" {
+			problems = append(problems, "mongo.uri is required")
+		}
+		if c.Mongo.Database == "" {
+			problems = append(problems, "mongo.database is required")
+		}
+		if c.Mongo.ConnectTimeout.Duration <= 0 {
+			problems = append(problems, "mongo.connectTimeout must be positive")
+		}
+		collections := map[string]string{
+			"forumPosts":      c.Mongo.Collections.ForumPosts,
+			"forumComments":   c.Mongo.Collections.ForumComments,
+			"forumUserVotes":  c.Mongo.Collections.ForumUserVotes,
+			"forumVotes":      c.Mongo.Collections.ForumVotes,
+			"forumVoteMap":    c.Mongo.Collections.ForumVoteMap,
+			"forumVoteEvents": c.Mongo.Collections.ForumVoteEvents,
+			"profiles":        c.Mongo.Collections.Profiles,
+			"album":           c.Mongo.Collections.Album,
+			"albums":          c.Mongo.Collections.Albums,
+			"albumPhotos":     c.Mongo.Collections.AlbumPhotos,
+			"subForums":       c.Mongo.Collections.SubForums,
+			"forumRevisions":  c.Mongo.Collections.ForumRevisions,
+			"migrations":      c.Mongo.Collections.Migrations,
+		}
+		for key, name := range collections {
+			if name == "" {
+				problems = append(problems, fmt.Sprintf("mongo.collections.%s is required", key))
+			}
+		}
+	case StorageMemory:
+	default:
+		problems = append(problems, fmt.Sprintf("storage must be %q or %q, got %q", StorageMongo, StorageMemory, c.Storage))
+	}
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr is required")
+	}
+	serverTimeouts := map[string]Duration{
+		"requestTimeout":  c.Server.RequestTimeout,
+		"readTimeout":     c.Server.ReadTimeout,
+		"writeTimeout":    c.Server.WriteTimeout,
+		"idleTimeout":     c.Server.IdleTimeout,
+		"shutdownTimeout": c.Server.ShutdownTimeout,
+	}
+	for key, timeout := range serverTimeouts {
+		if timeout.Duration <= 0 {
+			problems = append(problems, fmt.Sprintf("server.%s must be positive", key))
+		}
+	}
+	if c.Pagination.DefaultLimit <= 0 {
+		problems = append(problems, "pagination.defaultLimit must be positive")
+	}
+	if c.Pagination.MaxLimit < c.Pagination.DefaultLimit {
+		problems = append(problems, "pagination.maxLimit must not be less than pagination.defaultLimit")
+	}
+	if len(c.Auth.Secret) < minSecretLength {
+		problems = append(problems, fmt.Sprintf("auth.secret must be at least %d bytes", minSecretLength))
+	}
+	if c.Auth.TokenTTL.Duration <= 0 {
+		problems = append(problems, "auth.tokenTTL must be positive")
+	}
+	if c.Auth.MaxTokenLifetime.Duration < c.Auth.TokenTTL.Duration {
+		problems = append(problems, "auth.maxTokenLifetime must not be less than auth.tokenTTL")
+	}
+	if c.Reconciliation.Interval.Duration < 0 {
+		problems = append(problems, "reconciliation.interval must not be negative")
+	}
+	switch c.Blobs.Backend {
+	case BlobBackendLocal:
+		if c.Blobs.Dir == "" {
+			problems = append(problems, "blobs.dir is required")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("blobs.backend must be %q, got %q", BlobBackendLocal, c.Blobs.Backend))
+	}
+	if c.Photos.MaxUploadBytes <= 0 {
+		problems = append(problems, "photos.maxUploadBytes must be positive")
+	}
+	if c.Photos.MaxPixels <= 0 {
+		problems = append(problems, "photos.maxPixels must be positive")
+	}
+	if c.Photos.CacheMaxAge.Duration < 0 {
+		problems = append(problems, "photos.cacheMaxAge must not be negative")
+	}
+	if c.Photos.DuplicateDistance > MaxDuplicateDistance {
+		problems = append(problems, fmt.Sprintf("photos.duplicateDistance must be at most %d", MaxDuplicateDistance))
+	}
+	variants := map[string]bool{}
+	for i, variant := range c.Photos.Variants {
+		if !variantName.MatchString(variant.Name) {
+			problems = append(problems, fmt.Sprintf("photos.variants[%d].name must be lowercase letters, digits and dashes, got %q", i, variant.Name))
+		}
+		if variants[variant.Name] {
+			problems = append(problems, fmt.Sprintf("photos.variants[%d].name %q is used twice", i, variant.Name))
+		}
+		variants[variant.Name] = true
+		if variant.MaxSize <= 0 {
+			problems = append(problems, fmt.Sprintf("photos.variants[%d].maxSize must be positive", i))
+		}
+	}
+	switch c.Profiles.DeletePolicy {
+	case DeletePolicyAnonymize, DeletePolicyCascade:
+	default:
+		problems = append(problems, fmt.Sprintf("profiles.deletePolicy must be %q or %q, got %q", DeletePolicyAnonymize, DeletePolicyCascade, c.Profiles.DeletePolicy))
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+)
+
+// DHash is the difference hash of img: it is scaled to 9x8 gray pixels and every bit tells whether a pixel is brighter
+// than its right neighbour. Copies of a picture that were resized or recompressed get the same or a close hash
+func DHash(img image.Image) uint64 {
+	small := Resize(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luma(small, x, y) > luma(small, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// luma is the brightness of a pixel, transparent pixels count as black
+func luma(img *image.RGBA, x int, y int) uint32 {
+	i := img.PixOffset(x, y)
+	r, g, b := uint32(img.Pix[i]), uint32(img.Pix[i+1]), uint32(img.Pix[i+2])
+	// ITU-R 601 weights, scaled by 1000
+	return 299*r + 587*g + 114*b
+}
+
+// Distance is the number of bits two hashes differ in
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
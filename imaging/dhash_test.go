@@ -0,0 +1,68 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gradient is a picture getting brighter to the right, or to the left when inverted, with a bright spot
+func gradient(width int, height int, inverted bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 255 * x / width
+			if inverted {
+				v = 255 - v
+			}
+			if x < width/4 && y < height/4 {
+				v = 255
+			}
+			img.Set(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	original := gradient(360, 240, false)
+	tests := []struct {
+		name        string
+		img         image.Image
+		maxDistance int
+		minDistance int
+	}{
+		{"same picture", gradient(360, 240, false), 0, 0},
+		{"resized copy", Resize(original, 90, 60), 4, 0},
+		{"stretched copy", Resize(original, 200, 200), 4, 0},
+		{"other picture", gradient(360, 240, true), 64, 32},
+	}
+	hash := DHash(original)
+	for _, test := range tests {
+		d := Distance(hash, DHash(test.img))
+		if d > test.maxDistance || d < test.minDistance {
+			t.Errorf("%s: distance %d, want between %d and %d", test.name, d, test.minDistance, test.maxDistance)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0, ^uint64(0), 64},
+		{1, 3, 1},
+		{0xf0, 0x0f, 8},
+		{0x8000000000000000, 0, 1},
+	}
+	for _, test := range tests {
+		if got := Distance(test.a, test.b); got != test.want {
+			t.Errorf("Distance(%#x, %#x) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := Distance(test.b, test.a); got != test.want {
+			t.Errorf("Distance(%#x, %#x) = %d, want %d", test.b, test.a, got, test.want)
+		}
+	}
+}
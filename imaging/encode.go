@@ -0,0 +1,41 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// jpegQuality is the quality resized JPEGs are written with
+const jpegQuality = 85
+
+// originalQuality is the quality turned originals are written with, they replace the upload so they lose less than copies
+const originalQuality = 95
+
+// EncodeOriginal writes img as a JPEG standing in for an uploaded original
+func EncodeOriginal(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: originalQuality})
+}
+
+// Encode writes img in the format of contentType, "image/jpeg" or "image/png"
+func Encode(w io.Writer, img image.Image, contentType string) error {
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("cannot encode %s", contentType)
+	}
+}
+
+// ResizedType is the content type resized copies of a contentType image are written in
+// JPEGs stay JPEGs, everything else becomes a PNG, which keeps transparency
+func ResizedType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return contentType
+	}
+	return "image/png"
+}
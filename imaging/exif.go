@@ -0,0 +1,350 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNoEXIF is returned by ReadEXIF for JPEGs without an EXIF segment and for other formats
+var ErrNoEXIF = errors.New("no exif data")
+
+// errBadEXIF is returned for EXIF segments pointing outside of themselves
+var errBadEXIF = errors.New("malformed exif data")
+
+// EXIF tags read or stripped, see the EXIF 2.3 specification
+const (
+	tagMake               = 0x010f
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+)
+
+// exifHeader starts the APP1 segment holding EXIF data
+var exifHeader = []byte("Exif\x00\x00")
+
+// xmpHeaders start the APP1 segments holding XMP data, the standard packet and the chunks of an extended one
+var xmpHeaders = [][]byte{
+	[]byte("http://ns.adobe.com/xap/1.0/\x00"),
+	[]byte("http://ns.adobe.com/xmp/extension/\x00"),
+}
+
+// maxEXIFString bounds the text values kept from EXIF data, in bytes
+const maxEXIFString = 128
+
+// EXIF is the metadata of a photo read from its EXIF data
+type EXIF struct {
+	// TakenAt is when the photo was taken, zero when not recorded
+	// Cameras record the local time, it is read as UTC unless the offset is recorded too
+	TakenAt time.Time
+	// Orientation is how the stored pixels have to be turned to show the photo upright, from 1 to 8
+	Orientation int
+	Make        string
+	Model       string
+	// HasGPS tells whether the data includes a location, it is false after StripGPS
+	HasGPS bool
+}
+
+// ReadEXIF reads the EXIF data of a JPEG
+// It returns ErrNoEXIF when there is none and an error for data it cannot read
+func ReadEXIF(data []byte) (EXIF, error) {
+	t, err := findEXIF(data)
+	if err != nil {
+		return EXIF{}, err
+	}
+	ifd0, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return EXIF{}, err
+	}
+	res := EXIF{Orientation: 1}
+	var dateTime, original, offset string
+	var exifIFD []ifdEntry
+	for _, entry := range ifd0 {
+		switch entry.tag {
+		case tagMake:
+			res.Make = t.ascii(entry)
+		case tagModel:
+			res.Model = t.ascii(entry)
+		case tagOrientation:
+			if o := int(t.short(entry)); o >= 1 && o <= 8 {
+				res.Orientation = o
+			}
+		case tagDateTime:
+			dateTime = t.ascii(entry)
+		case tagExifIFD:
+			// A broken sub IFD loses the capture time, not the rest
+			exifIFD, _ = t.ifd(int(t.long(entry)))
+		case tagGPSIFD:
+			// StripGPS leaves the pointer to an empty directory, a directory that cannot be read may hold anything
+			gps, err := t.ifd(int(t.long(entry)))
+			res.HasGPS = err != nil || len(gps) > 0
+		}
+	}
+	for _, entry := range exifIFD {
+		switch entry.tag {
+		case tagDateTimeOriginal:
+			original = t.ascii(entry)
+		case tagOffsetTimeOriginal:
+			offset = t.ascii(entry)
+		}
+	}
+	if original == "" {
+		original = dateTime
+	}
+	res.TakenAt = parseEXIFTime(original, offset)
+	return res, nil
+}
+
+// StripGPS returns a copy of a JPEG without the location in its metadata, other formats are returned as is
+// The GPS directory of the EXIF data is emptied in place, so the rest of the EXIF data keeps its layout, and the XMP
+// segments are removed since they may repeat the location. It returns an error when the GPS directory holds fields
+// it cannot tell the extent of. Locations vendors keep in their own maker notes are not found
+func StripGPS(data []byte) ([]byte, error) {
+	segments, err := jpegSegments(data)
+	if err == ErrNoEXIF {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := append([]byte{}, data[:2]...)
+	next := 2
+	for _, segment := range segments {
+		if segment.isXMP(data) {
+			res = append(res, data[next:segment.start]...)
+			next = segment.end
+		}
+	}
+	res = append(res, data[next:]...)
+
+	t, err := findEXIF(res)
+	if err == ErrNoEXIF {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ifd0, err := t.ifd(t.firstIFD())
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range ifd0 {
+		if entry.tag != tagGPSIFD {
+			continue
+		}
+		offset := int(t.long(entry))
+		gps, err := t.ifd(offset)
+		if err != nil {
+			return nil, err
+		}
+		// The values of fields ifd left out could be anywhere
+		if len(gps) != int(t.order.Uint16(t.b[offset:])) {
+			return nil, errBadEXIF
+		}
+		for _, field := range gps {
+			if field.size > 4 {
+				zero(t.b[field.value : field.value+field.size])
+			}
+			zero(t.b[field.pos : field.pos+12])
+		}
+		// An empty directory, its next directory offset is among the zeroed entries
+		t.order.PutUint16(t.b[offset:], 0)
+	}
+	return res, nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// parseEXIFTime parses the "2006:01:02 15:04:05" times of EXIF data with an optional "+01:00" offset
+func parseEXIFTime(value string, offset string) time.Time {
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// tiff is the TIFF structure EXIF data is stored in
+type tiff struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is one field of an image file directory
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count int
+	// pos is where the entry starts, value where its value starts and size how long the value is
+	pos   int
+	value int
+	size  int
+}
+
+// typeSizes are the sizes of the TIFF field types in bytes
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// findEXIF returns the TIFF structure of the EXIF segment of a JPEG, sharing the bytes of data
+func findEXIF(data []byte) (tiff, error) {
+	segments, err := jpegSegments(data)
+	if err != nil {
+		return tiff{}, err
+	}
+	for _, segment := range segments {
+		payload := data[segment.start+4 : segment.end]
+		if segment.marker == 0xe1 && bytes.HasPrefix(payload, exifHeader) {
+			return newTIFF(payload[len(exifHeader):])
+		}
+	}
+	return tiff{}, ErrNoEXIF
+}
+
+// jpegSegment is a marker segment of a JPEG, from its marker at start to the end of its payload
+type jpegSegment struct {
+	marker byte
+	start  int
+	end    int
+}
+
+func (s jpegSegment) isXMP(data []byte) bool {
+	if s.marker != 0xe1 {
+		return false
+	}
+	for _, header := range xmpHeaders {
+		if bytes.HasPrefix(data[s.start+4:s.end], header) {
+			return true
+		}
+	}
+	return false
+}
+
+// jpegSegments returns the segments of a JPEG before its image data, where the metadata is
+// It returns ErrNoEXIF for other formats and errBadEXIF for segments running past the data
+func jpegSegments(data []byte) ([]jpegSegment, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, ErrNoEXIF
+	}
+	segments := []jpegSegment{}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return nil, errBadEXIF
+		}
+		marker := data[pos+1]
+		// Start of scan and end of image, metadata segments come before
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errBadEXIF
+		}
+		segments = append(segments, jpegSegment{marker: marker, start: pos, end: end})
+		pos = end
+	}
+	return segments, nil
+}
+
+func newTIFF(b []byte) (tiff, error) {
+	if len(b) < 8 {
+		return tiff{}, errBadEXIF
+	}
+	t := tiff{b: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return tiff{}, errBadEXIF
+	}
+	if t.order.Uint16(b[2:]) != 42 {
+		return tiff{}, errBadEXIF
+	}
+	return t, nil
+}
+
+func (t tiff) firstIFD() int {
+	return int(t.order.Uint32(t.b[4:]))
+}
+
+// ifd reads the entries of the directory at offset, entries of unknown types or with values out of bounds are left out
+func (t tiff) ifd(offset int) ([]ifdEntry, error) {
+	if offset < 8 || offset+2 > len(t.b) {
+		return nil, errBadEXIF
+	}
+	count := int(t.order.Uint16(t.b[offset:]))
+	if offset+2+12*count > len(t.b) {
+		return nil, errBadEXIF
+	}
+	entries := []ifdEntry{}
+	for i := 0; i < count; i++ {
+		pos := offset + 2 + 12*i
+		entry := ifdEntry{
+			tag:   t.order.Uint16(t.b[pos:]),
+			typ:   t.order.Uint16(t.b[pos+2:]),
+			count: int(t.order.Uint32(t.b[pos+4:])),
+			pos:   pos,
+			value: pos + 8,
+		}
+		typeSize, ok := typeSizes[entry.typ]
+		if !ok || entry.count < 0 || entry.count > len(t.b) {
+			continue
+		}
+		entry.size = typeSize * entry.count
+		// Values over four bytes are stored elsewhere, the entry holds their offset
+		if entry.size > 4 {
+			entry.value = int(t.order.Uint32(t.b[pos+8:]))
+		}
+		if entry.value < 0 || entry.value+entry.size > len(t.b) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (t tiff) short(entry ifdEntry) uint16 {
+	if entry.typ != 3 || entry.count < 1 {
+		return 0
+	}
+	return t.order.Uint16(t.b[entry.value:])
+}
+
+func (t tiff) long(entry ifdEntry) uint32 {
+	if entry.typ != 4 || entry.count < 1 {
+		return 0
+	}
+	return t.order.Uint32(t.b[entry.value:])
+}
+
+// ascii returns a text value without its terminating NUL and padding
+func (t tiff) ascii(entry ifdEntry) string {
+	if entry.typ != 2 {
+		return ""
+	}
+	value := t.b[entry.value : entry.value+entry.size]
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	if len(value) > maxEXIFString {
+		value = value[:maxEXIFString]
+	}
+	return strings.TrimSpace(string(value))
+}
@@ -0,0 +1,266 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// tiffEntry is a field of a directory built by buildTIFF, sub points to another directory
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+	sub   []tiffEntry
+}
+
+func asciiEntry(tag uint16, s string) tiffEntry {
+	return tiffEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func shortEntry(tag uint16, v uint16) tiffEntry {
+	value := make([]byte, 2)
+	binary.LittleEndian.PutUint16(value, v)
+	return tiffEntry{tag: tag, typ: 3, count: 1, value: value}
+}
+
+func longEntry(tag uint16, v uint32) tiffEntry {
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, v)
+	return tiffEntry{tag: tag, typ: 4, count: 1, value: value}
+}
+
+// gpsLatitude is a latitude of three rationals, its numerators are easy to find in the bytes
+var gpsLatitude = tiffEntry{tag: 2, typ: 5, count: 3, value: []byte{
+	0x47, 0x47, 0x47, 0x47, 1, 0, 0, 0,
+	0x48, 0x48, 0x48, 0x48, 1, 0, 0, 0,
+	0x49, 0x49, 0x49, 0x49, 1, 0, 0, 0,
+}}
+
+// buildTIFF lays out a little endian TIFF structure with ifd0 as its first directory
+func buildTIFF(ifd0 []tiffEntry) []byte {
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	writeIFD(&b, ifd0)
+	return b
+}
+
+func writeIFD(b *[]byte, entries []tiffEntry) int {
+	offset := len(*b)
+	*b = append(*b, make([]byte, 2+12*len(entries)+4)...)
+	binary.LittleEndian.PutUint16((*b)[offset:], uint16(len(entries)))
+	for i, entry := range entries {
+		pos := offset + 2 + 12*i
+		binary.LittleEndian.PutUint16((*b)[pos:], entry.tag)
+		binary.LittleEndian.PutUint16((*b)[pos+2:], entry.typ)
+		binary.LittleEndian.PutUint32((*b)[pos+4:], entry.count)
+		switch {
+		case entry.sub != nil:
+			// Written first, the directory may move b
+			sub := writeIFD(b, entry.sub)
+			binary.LittleEndian.PutUint32((*b)[pos+8:], uint32(sub))
+		case len(entry.value) > 4:
+			binary.LittleEndian.PutUint32((*b)[pos+8:], uint32(len(*b)))
+			*b = append(*b, entry.value...)
+			if len(*b)%2 == 1 {
+				*b = append(*b, 0)
+			}
+		default:
+			copy((*b)[pos+8:], entry.value)
+		}
+	}
+	return offset
+}
+
+// app1 is an APP1 segment with payload
+func app1(payload []byte) []byte {
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(2+len(payload)))
+	return append(segment, payload...)
+}
+
+func exifSegment(tiff []byte) []byte {
+	return app1(append([]byte("Exif\x00\x00"), tiff...))
+}
+
+var xmpSegment = app1([]byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta><exif:GPSLatitude>12,34N</exif:GPSLatitude></x:xmpmeta>"))
+
+// testJPEG is a 16x8 JPEG with segments inserted after its start of image marker
+func testJPEG(t *testing.T, segments ...[]byte) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	res := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		res = append(res, segment...)
+	}
+	return append(res, data[2:]...)
+}
+
+// cameraEXIF is the EXIF data of a camera photo with a location, gps replaces its GPS directory
+func cameraEXIF(orientation uint16, gps []tiffEntry) []byte {
+	ifd0 := []tiffEntry{
+		asciiEntry(tagMake, "Canon"),
+		asciiEntry(tagModel, "EOS 5D"),
+		shortEntry(tagOrientation, orientation),
+		asciiEntry(tagDateTime, "2020:01:01 00:00:00"),
+		{tag: tagExifIFD, typ: 4, count: 1, sub: []tiffEntry{
+			asciiEntry(tagDateTimeOriginal, "2021:05:01 12:30:00"),
+			asciiEntry(tagOffsetTimeOriginal, "+02:00"),
+		}},
+	}
+	if gps != nil {
+		ifd0 = append(ifd0, tiffEntry{tag: tagGPSIFD, typ: 4, count: 1, sub: gps})
+	}
+	return buildTIFF(ifd0)
+}
+
+var northGPS = []tiffEntry{asciiEntry(1, "N"), gpsLatitude}
+
+func TestReadEXIF(t *testing.T) {
+	var pngBytes bytes.Buffer
+	png.Encode(&pngBytes, image.NewGray(image.Rect(0, 0, 1, 1)))
+	truncated := testJPEG(t, exifSegment(cameraEXIF(1, northGPS)))[:30]
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    EXIF
+		wantErr error
+		// anyErr accepts every error but ErrNoEXIF
+		anyErr bool
+	}{
+		{name: "png", data: pngBytes.Bytes(), wantErr: ErrNoEXIF},
+		{name: "jpeg without exif", data: testJPEG(t), wantErr: ErrNoEXIF},
+		{name: "jpeg with only xmp", data: testJPEG(t, xmpSegment), wantErr: ErrNoEXIF},
+		{
+			name: "camera photo",
+			data: testJPEG(t, exifSegment(cameraEXIF(6, northGPS))),
+			want: EXIF{
+				TakenAt:     time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC),
+				Orientation: 6,
+				Make:        "Canon",
+				Model:       "EOS 5D",
+				HasGPS:      true,
+			},
+		},
+		{
+			name: "without location",
+			data: testJPEG(t, exifSegment(cameraEXIF(1, nil))),
+			want: EXIF{TakenAt: time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), Orientation: 1, Make: "Canon", Model: "EOS 5D"},
+		},
+		{
+			name: "empty gps directory",
+			data: testJPEG(t, exifSegment(cameraEXIF(1, []tiffEntry{}))),
+			want: EXIF{TakenAt: time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC), Orientation: 1, Make: "Canon", Model: "EOS 5D"},
+		},
+		{
+			name: "gps directory out of bounds",
+			data: testJPEG(t, exifSegment(buildTIFF([]tiffEntry{longEntry(tagGPSIFD, 0xffff)}))),
+			want: EXIF{Orientation: 1, HasGPS: true},
+		},
+		{
+			name: "unknown orientation and local time only",
+			data: testJPEG(t, exifSegment(buildTIFF([]tiffEntry{shortEntry(tagOrientation, 9), asciiEntry(tagDateTime, "2019:12:31 23:59:59")}))),
+			want: EXIF{TakenAt: time.Date(2019, 12, 31, 23, 59, 59, 0, time.UTC), Orientation: 1},
+		},
+		{name: "truncated segment", data: truncated, anyErr: true},
+		{name: "bad byte order", data: testJPEG(t, exifSegment([]byte("XX*\x00\x08\x00\x00\x00\x00\x00"))), anyErr: true},
+	}
+	for _, test := range tests {
+		got, err := ReadEXIF(test.data)
+		if test.anyErr {
+			if err == nil || err == ErrNoEXIF {
+				t.Errorf("%s: error %v, want a malformed data error", test.name, err)
+			}
+			continue
+		}
+		if err != test.wantErr {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !got.TakenAt.Equal(test.want.TakenAt) {
+			t.Errorf("%s: taken at %v, want %v", test.name, got.TakenAt, test.want.TakenAt)
+		}
+		got.TakenAt, test.want.TakenAt = time.Time{}, time.Time{}
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStripGPS(t *testing.T) {
+	var pngBytes bytes.Buffer
+	png.Encode(&pngBytes, image.NewGray(image.Rect(0, 0, 1, 1)))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantErr  bool
+		wantEXIF bool
+	}{
+		{name: "png", data: pngBytes.Bytes()},
+		{name: "jpeg without metadata", data: testJPEG(t)},
+		{name: "location in exif", data: testJPEG(t, exifSegment(cameraEXIF(1, northGPS))), wantEXIF: true},
+		{name: "location in exif and xmp", data: testJPEG(t, xmpSegment, exifSegment(cameraEXIF(1, northGPS)), xmpSegment), wantEXIF: true},
+		{name: "location only in xmp", data: testJPEG(t, xmpSegment)},
+		{name: "exif without location", data: testJPEG(t, exifSegment(cameraEXIF(1, nil))), wantEXIF: true},
+		{
+			name:    "gps field of unknown type",
+			data:    testJPEG(t, exifSegment(cameraEXIF(1, []tiffEntry{{tag: 2, typ: 99, count: 3, value: gpsLatitude.value}}))),
+			wantErr: true,
+		},
+		{
+			name:    "gps directory out of bounds",
+			data:    testJPEG(t, exifSegment(buildTIFF([]tiffEntry{longEntry(tagGPSIFD, 0xffff)}))),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		original := append([]byte{}, test.data...)
+		got, err := StripGPS(test.data)
+		if !bytes.Equal(test.data, original) {
+			t.Errorf("%s: the input was changed", test.name)
+		}
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: no error, want one", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if bytes.Contains(got, []byte{0x47, 0x47, 0x47, 0x47}) || bytes.Contains(got, []byte("http://ns.adobe.com/")) {
+			t.Errorf("%s: the location is still in the bytes", test.name)
+		}
+		if _, _, err := image.DecodeConfig(bytes.NewReader(got)); err != nil {
+			t.Errorf("%s: stripped photo does not decode: %v", test.name, err)
+		}
+		meta, err := ReadEXIF(got)
+		if !test.wantEXIF {
+			if err != ErrNoEXIF {
+				t.Errorf("%s: reading stripped exif: %v, want ErrNoEXIF", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: reading stripped exif: %v", test.name, err)
+			continue
+		}
+		if meta.HasGPS || meta.Make != "Canon" || meta.TakenAt.IsZero() {
+			t.Errorf("%s: stripped exif %+v, want the camera without location", test.name, meta)
+		}
+		again, err := StripGPS(got)
+		if err != nil || !bytes.Equal(again, got) {
+			t.Errorf("%s: stripping twice changed the bytes again: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	// 3x2 pixels, each with its coordinates as color
+	const width, height = 3, 2
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), A: 255})
+		}
+	}
+	type point struct{ x, y int }
+	tests := []struct {
+		orientation int
+		wantW       int
+		wantH       int
+		// The source pixels that end up in the top left and top right corner
+		topLeft  point
+		topRight point
+	}{
+		{0, 3, 2, point{0, 0}, point{2, 0}},
+		{1, 3, 2, point{0, 0}, point{2, 0}},
+		{2, 3, 2, point{2, 0}, point{0, 0}},
+		{3, 3, 2, point{2, 1}, point{0, 1}},
+		{4, 3, 2, point{0, 1}, point{2, 1}},
+		{5, 2, 3, point{0, 0}, point{0, 1}},
+		{6, 2, 3, point{0, 1}, point{0, 0}},
+		{7, 2, 3, point{2, 1}, point{2, 0}},
+		{8, 2, 3, point{2, 0}, point{2, 1}},
+		{9, 3, 2, point{0, 0}, point{2, 0}},
+	}
+	for _, test := range tests {
+		res := Orient(src, test.orientation)
+		bounds := res.Bounds()
+		if bounds.Dx() != test.wantW || bounds.Dy() != test.wantH {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", test.orientation, bounds.Dx(), bounds.Dy(), test.wantW, test.wantH)
+			continue
+		}
+		for _, corner := range []struct {
+			x, y int
+			want point
+		}{{0, 0, test.topLeft}, {test.wantW - 1, 0, test.topRight}} {
+			r, g, _, _ := res.At(bounds.Min.X+corner.x, bounds.Min.Y+corner.y).RGBA()
+			if got := (point{int(r >> 8), int(g >> 8)}); got != corner.want {
+				t.Errorf("orientation %d: pixel %d,%d comes from %v, want %v", test.orientation, corner.x, corner.y, got, corner.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package imaging
+
+import (
+	"image"
+)
+
+// Fit scales img down so its longer edge is at most maxSize pixels, keeping the aspect ratio
+// Images that fit already are returned unchanged, images are never scaled up
+func Fit(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return img
+	}
+	if width >= height {
+		width, height = maxSize, height*maxSize/width
+	} else {
+		width, height = width*maxSize/height, maxSize
+	}
+	// Very thin images keep at least one pixel
+	if width == 0 {
+		width = 1
+	}
+	if height == 0 {
+		height = 1
+	}
+	return Resize(img, width, height)
+}
+
+// Resize scales img down to width x height with a box filter: every pixel is the average of the source pixels it covers
+// Colors are averaged premultiplied, so transparent pixels do not bleed their color into the edges
+func Resize(img image.Image, width int, height int) *image.RGBA {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	// Column spans are the same for every row
+	x0s, x1s := spans(srcWidth, width)
+	y0s, y1s := spans(srcHeight, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b, a, n uint64
+			for sy := y0s[y]; sy < y1s[y]; sy++ {
+				for sx := x0s[x]; sx < x1s[x]; sx++ {
+					sr, sg, sb, sa := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r += uint64(sr)
+					g += uint64(sg)
+					b += uint64(sb)
+					a += uint64(sa)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			// 16 bit channels down to 8 bit
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// spans returns for every one of n target pixels the range of the src source pixels it covers, each at least one wide
+func spans(src int, n int) (starts []int, ends []int) {
+	starts, ends = make([]int, n), make([]int, n)
+	for i := 0; i < n; i++ {
+		starts[i] = i * src / n
+		ends[i] = (i + 1) * src / n
+		if ends[i] <= starts[i] {
+			ends[i] = starts[i] + 1
+		}
+	}
+	return starts, ends
+}
@@ -0,0 +1,71 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, height int
+		maxSize       int
+		wantW, wantH  int
+	}{
+		{1000, 500, 200, 200, 100},
+		{500, 1000, 200, 100, 200},
+		{200, 200, 200, 200, 200},
+		{100, 50, 200, 100, 50},
+		{1000, 1, 100, 100, 1},
+		{1, 1000, 10, 1, 10},
+		{3000, 10, 100, 100, 1},
+	}
+	for _, test := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, test.width, test.height))
+		res := Fit(img, test.maxSize)
+		if size := res.Bounds().Size(); size.X != test.wantW || size.Y != test.wantH {
+			t.Errorf("Fit(%dx%d, %d) = %dx%d, want %dx%d", test.width, test.height, test.maxSize, size.X, size.Y, test.wantW, test.wantH)
+		}
+		if test.width <= test.maxSize && test.height <= test.maxSize && res != image.Image(img) {
+			t.Errorf("Fit(%dx%d, %d) copied an image that fits already", test.width, test.height, test.maxSize)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	// Left half black, right half white
+	halves := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			halves.Set(x, y, color.Gray{Y: uint8(255 * (x / 2))})
+		}
+	}
+	// A transparent red pixel next to an opaque white one
+	edge := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	edge.Set(0, 0, color.NRGBA{R: 255, A: 0})
+	edge.Set(1, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+
+	tests := []struct {
+		name          string
+		img           image.Image
+		width, height int
+		want          []color.RGBA
+	}{
+		{"halves kept", halves, 2, 2, []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}, {0, 0, 0, 255}, {255, 255, 255, 255}}},
+		{"halves averaged", halves, 1, 1, []color.RGBA{{127, 127, 127, 255}}},
+		{"transparent color does not bleed", edge, 1, 1, []color.RGBA{{127, 127, 127, 127}}},
+		{"offset bounds", halves.SubImage(image.Rect(2, 0, 4, 4)), 1, 1, []color.RGBA{{255, 255, 255, 255}}},
+	}
+	for _, test := range tests {
+		res := Resize(test.img, test.width, test.height)
+		if size := res.Bounds().Size(); size.X != test.width || size.Y != test.height {
+			t.Errorf("%s: size %v, want %dx%d", test.name, size, test.width, test.height)
+			continue
+		}
+		for i, want := range test.want {
+			if got := res.RGBAAt(i%test.width, i/test.width); got != want {
+				t.Errorf("%s: pixel %d is %v, want %v", test.name, i, got, want)
+			}
+		}
+	}
+}
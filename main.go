@@ -1,44 +1,91 @@
 package main
 
 import (
-	"gguan/cwgcf_db/clients"
-	"gguan/cwgcf_db/models"
+	"context"
+	"flag"
+	"fmt"
+	"gguan/cwgcf_db/app"
+	"gguan/cwgcf_db/config"
 	"log"
 	"net/http"
-
-	"github.com/gorilla/mux"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	router := mux.NewRouter()
-
-	mongoAPI := router.PathPrefix("/mongo/v1").Subrouter()
-
-	ps := models.NewProfileServer()
-	mongoAPI.HandleFunc("/profile", ps.GetAll).Methods(http.MethodGet)
-	mongoAPI.HandleFunc("/profile/{userID}", ps.Get).Methods(http.MethodGet)
-	mongoAPI.HandleFunc("/profile/{userID}", ps.Post).Methods(http.MethodPost)
-	mongoAPI.HandleFunc("/profile", ps.Put).Methods(http.MethodPut)
-	mongoAPI.HandleFunc("/profile/{userID}", ps.Delete).Methods(http.MethodDelete)
-
-	albumServer := models.NewAlbumServer()
-	mongoAPI.HandleFunc("/album", albumServer.GetAll).Methods(http.MethodGet)
-	mongoAPI.HandleFunc("/album", albumServer.Put).Methods(http.MethodPut)
-
-	forumServer := models.NewForumServer()
-	mongoAPI.HandleFunc("/forum/post", forumServer.GetAllPosts).Methods(http.MethodGet)
-	mongoAPI.HandleFunc("/forum/post/{postID}", forumServer.GetPost).Methods(http.MethodGet)
-	mongoAPI.HandleFunc("/forum/commentsofpost/{postID}", forumServer.GetCommentsForPost).Methods(http.MethodGet)
-	mongoAPI.HandleFunc("/forum/post", forumServer.PutPost).Methods(http.MethodPut)
-	mongoAPI.HandleFunc("/forum/comment/{parentID}", forumServer.AddCommentV2).Methods(http.MethodPost)
-	mongoAPI.HandleFunc("/forum/vote/{id}", forumServer.GetUserVoteMap).Methods(http.MethodGet)
-	mongoAPI.HandleFunc("/forum/vote", forumServer.Vote).Methods(http.MethodPost)
-
-	forumServerV2 := clients.NewForumServer()
-	mongoAPI.HandleFunc("/forum/v2/post", forumServerV2.GetForumPosts).Methods(http.MethodGet)
-	mongoAPI.HandleFunc("/forum/v2/post", forumServerV2.SaveForumPost).Methods(http.MethodPut)
-	mongoAPI.HandleFunc("/forum/v2/vote", forumServerV2.HandleVoteEvent).Methods(http.MethodPost)
-	mongoAPI.HandleFunc("/forum/v2/vote", forumServerV2.GetVoteMap).Methods(http.MethodGet)
-
-	log.Fatal(http.ListenAndServe(":8080", router))
+	configPath := flag.String("config", os.Getenv("CWGCF_CONFIG"), "path to a JSON config file, see config.example.json")
+	flag.Usage = usage
+	flag.Parse()
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := flag.Arg(0)
+	if name == "" || name == "serve" {
+		serve(cfg)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := runCommand(cfg, cmd, flag.Args()[1:]); err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config file] [command]\n\nCommands:\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "  serve\trun the API (default)\n")
+	for _, name := range commandNames() {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\t%s\n", name, commands[name].help)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// serve runs the API until SIGINT or SIGTERM
+func serve(cfg *config.Config) {
+	a, err := app.New(context.Background(), cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a.StartJobs()
+
+	srv := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      a.Router(),
+		ReadTimeout:  cfg.Server.ReadTimeout.Duration,
+		WriteTimeout: cfg.Server.WriteTimeout.Duration,
+		IdleTimeout:  cfg.Server.IdleTimeout.Duration,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", cfg.Server.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		log.Printf("Server stopped: %v", err)
+	case sig := <-stop:
+		log.Printf("Received %v, shutting down", sig)
+	}
+
+	// Drain requests, then background work, then the mongo client, all within one deadline
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Duration)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Failed to drain requests: %v", err)
+	}
+	if err := a.Close(ctx); err != nil {
+		log.Printf("Failed to close app: %v", err)
+	}
+	log.Print("Shut down")
 }
@@ -1,66 +1,145 @@
 package models
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/blob"
 	"gguan/cwgcf_db/config"
+	"image"
+	// Register the formats image.DecodeConfig reads
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/gorilla/mux"
 )
 
-// AlbumServer is the definition of a REST API for photos
+// PhotoFormField is the multipart form field an upload carries the photo in
+const PhotoFormField = "photo"
+
+// photoContentTypes are the accepted upload formats, detected from the bytes rather than trusted from the client
+var photoContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// photoSortTakenAt lists the photos by the time they were taken at
+const photoSortTakenAt = "takenAt"
+
+// multipartOverhead is what the request body of an upload may carry besides the photo
+const multipartOverhead = 64 << 10
+
+// AlbumServer is the definition of a REST API for photos and the albums they are arranged in
 type AlbumServer struct {
-	Client *mongo.Client
+	Photos         PhotoRepository
+	Albums         AlbumRepository
+	Blobs          blob.Store
+	Worker         *PhotoWorker
+	Authorizer     *Authorizer
+	MaxUploadBytes int64
+	// MaxPixels bounds width times height of an upload, checked before it is decoded
+	MaxPixels   int64
+	CacheMaxAge time.Duration
+	// DuplicateDistance is how far the perceptual hash of an upload may be from that of a photo to be a copy of it
+	DuplicateDistance int
+	// KeepGPS keeps the location in the EXIF data of uploaded JPEGs
+	KeepGPS bool
+	Timeout time.Duration
+	Limits  config.Pagination
 }
 
 // NewAlbumServer creates a new Server instance
-func NewAlbumServer() *AlbumServer {
-	s := &AlbumServer{}
+func NewAlbumServer(store *Store, cfg *config.Config, blobs blob.Store, worker *PhotoWorker, authorizer *Authorizer) *AlbumServer {
+	return &AlbumServer{
+		Photos:            store.Photos,
+		Albums:            store.Albums,
+		Blobs:             blobs,
+		Worker:            worker,
+		Authorizer:        authorizer,
+		MaxUploadBytes:    cfg.Photos.MaxUploadBytes,
+		MaxPixels:         cfg.Photos.MaxPixels,
+		CacheMaxAge:       cfg.Photos.CacheMaxAge.Duration,
+		DuplicateDistance: cfg.Photos.DuplicateDistance,
+		KeepGPS:           cfg.Photos.KeepGPS,
+		Timeout:           cfg.Server.RequestTimeout.Duration,
+		Limits:            cfg.Pagination,
+	}
+}
 
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	// "mongodb+srv://<username>:<password>@<cluster-address>/test?w=majority"
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(
-		config.MongoDBUrl,
-	))
-	if err != nil {
-		log.Fatal(err)
+// PhotoURL is the path the API serves an uploaded photo at
+func PhotoURL(id string) string {
+	return "/mongo/v1/album/photo/" + id
+}
+
+// withURL sets the urls of an uploaded photo and its variants, which are not stored since they follow from the id
+func (p Photo) withURL() Photo {
+	if p.BlobKey != "" {
+		p.URL = PhotoURL(p.ID)
+	}
+	for i, variant := range p.Variants {
+		p.Variants[i].URL = PhotoURL(p.ID) + "/" + variant.Name
 	}
-	log.Print("Connected to MongoDB")
+	return p
+}
 
-	s.Client = client
-	return s
+// PostImage returns the image url of a post referencing an uploaded photo by imageID
+// It returns ErrNotFound when imageID is no uploaded photo
+func PostImage(ctx context.Context, photos PhotoRepository, imageID string) (string, error) {
+	photo, err := photos.GetPhoto(ctx, imageID)
+	if err != nil {
+		return "", err
+	}
+	if photo.BlobKey == "" {
+		return "", ErrNotFound
+	}
+	return PhotoURL(photo.ID), nil
 }
 
 // GetAll handles getAll requests
+// With sort=takenAt the photos are listed newest taken first, one page at a time selected with the limit and cursor
+// query parameters, the next cursor is sent in the X-Next-Cursor header. Without sort every photo is listed at once
+// Photos only in private albums are left out for users who may not see them, so a page may hold fewer than limit
 func (s *AlbumServer) GetAll(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	collection := s.Client.Database("cwgcf").Collection("album")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	cur, err := collection.Find(ctx, bson.D{})
+	query := r.URL.Query()
+	switch query.Get("sort") {
+	case "":
+	case photoSortTakenAt:
+		s.getAllByTakenAt(w, r)
+		return
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "unsupported sort: %s"}`, query.Get("sort"))))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	res, err := s.Photos.GetAllPhotos(ctx)
+	if err == nil {
+		res, err = s.visiblePhotos(ctx, res)
+	}
 	if err != nil {
 		log.Printf("Error getting album: %v", err)
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte(`{"error": "Not found"}`))
 		return
 	}
-	defer cur.Close(ctx)
-	res := []Photo{}
-	for cur.Next(ctx) {
-		var photo Photo
-
-		err := cur.Decode(&photo)
-		if err != nil {
-			log.Print(err)
-			continue
-		}
-		res = append(res, photo)
+	for i, photo := range res {
+		res[i] = photo.withURL()
 	}
 
 	resBytes, _ := json.Marshal(res)
@@ -69,7 +148,52 @@ func (s *AlbumServer) GetAll(w http.ResponseWriter, r *http.Request) {
 	w.Write(resBytes)
 }
 
-// Put handles put requests
+// getAllByTakenAt writes one page of the photos descending by the time they were taken at
+func (s *AlbumServer) getAllByTakenAt(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := QueryInt64(query, "limit")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	page, err := NewPageRequest(limit, query.Get("cursor"), s.Limits)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Invalid cursor"}`))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	res, next, err := s.Photos.GetPhotosByTakenAt(ctx, page)
+	if err == ErrInvalidCursor {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Invalid cursor"}`))
+		return
+	}
+	if err == nil {
+		res, err = s.visiblePhotos(ctx, res)
+	}
+	if err != nil {
+		log.Printf("Error getting album: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get photos"}`))
+		return
+	}
+	for i, photo := range res {
+		res[i] = photo.withURL()
+	}
+
+	resBytes, _ := json.Marshal(res)
+	w.Header().Set("X-Next-Cursor", next.Encode())
+	w.Header().Set("X-Has-More", strconv.FormatBool(next != nil))
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBytes)
+}
+
+// Put handles put requests, it adds a photo hosted elsewhere by its url
+// A url added before responds with the photo already there
 func (s *AlbumServer) Put(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var photo Photo
@@ -82,20 +206,338 @@ func (s *AlbumServer) Put(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"error": "Check your request"}`))
 		return
 	}
-
-	collection := s.Client.Database("cwgcf").Collection("album")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	doc := bson.M{
-		"url": photo.URL,
+	if photo.URL == "" {
+		invalid := &ValidationError{}
+		invalid.add("url", "is required")
+		writeValidationError(w, invalid)
+		return
 	}
 
-	dbRes, err := collection.InsertOne(ctx, doc)
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	existing, err := s.Photos.GetPhotoByURL(ctx, photo.URL)
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf(`{"insertID": "%s", "exists": true}`, existing.ID)))
+		return
+	}
+	if err != ErrNotFound {
+		log.Printf("Error getting photo by url: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to save photo"}`))
+		return
+	}
+	// Only uploads set the other fields
+	insertID, err := s.Photos.InsertPhoto(ctx, Photo{URL: photo.URL, TakenAt: time.Now().Unix()})
 	if err != nil {
-		log.Printf("Failed to decode body: %v", err)
-		w.WriteHeader(http.StatusConflict)
-		w.Write([]byte(`{"error": "Photo exists"}`))
+		log.Printf("Failed to insert photo: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to save photo"}`))
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(fmt.Sprintf(`{"insertID": %v}`, dbRes.InsertedID)))
+	w.Write([]byte(fmt.Sprintf(`{"insertID": "%s"}`, insertID)))
+}
+
+// Upload handles multipart uploads of a photo in the PhotoFormField field
+// JPEG, PNG and GIF photos up to MaxUploadBytes and MaxPixels are stored with their dimensions, the response is the new photo
+// A copy of a photo the user uploaded before is not stored again, the response is then that photo with status 200
+// JPEGs are turned upright by their EXIF orientation and stored without their location unless KeepGPS
+func (s *AlbumServer) Upload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxUploadBytes+multipartOverhead)
+	data, err := readFormFile(r, PhotoFormField, s.MaxUploadBytes)
+	if err == errTooLarge {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf(`{"error": "Photos may have at most %d bytes"}`, s.MaxUploadBytes)))
+		return
+	}
+	if err == io.EOF {
+		invalid := &ValidationError{}
+		invalid.add(PhotoFormField, "is required")
+		writeValidationError(w, invalid)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read upload: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Send the photo as multipart/form-data"}`))
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if !photoContentTypes[contentType] {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte(fmt.Sprintf(`{"error": "Photos must be JPEG, PNG or GIF, got %s"}`, contentType)))
+		return
+	}
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && int64(imageConfig.Width)*int64(imageConfig.Height) > s.MaxPixels {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf(`{"error": "Photos may have at most %d pixels"}`, s.MaxPixels)))
+		return
+	}
+	var img image.Image
+	if err == nil {
+		img, _, err = image.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		invalid := &ValidationError{}
+		invalid.add(PhotoFormField, fmt.Sprintf("is no readable image: %v", err))
+		writeValidationError(w, invalid)
+		return
+	}
+
+	upload, err := prepareUpload(data, contentType, img, s.KeepGPS)
+	if err != nil {
+		log.Printf("Failed to prepare upload: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to store photo"}`))
+		return
+	}
+
+	userID := auth.UserID(r.Context())
+	// The digest is of the bytes as uploaded so sending them again matches, the picture is compared upright
+	hashes := NewPhotoHashes(data, upload.img)
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	existing, err := FindDuplicatePhoto(ctx, s.Photos, userID, hashes, s.DuplicateDistance)
+	if err == nil {
+		res, _ := json.Marshal(existing.withURL())
+		w.WriteHeader(http.StatusOK)
+		w.Write(res)
+		return
+	}
+	if err != ErrNotFound {
+		log.Printf("Error looking up copies of an upload: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to save photo"}`))
+		return
+	}
+
+	now := time.Now().Unix()
+	bounds := upload.img.Bounds()
+	photo := Photo{
+		BlobKey:     newBlobKey("photos"),
+		ContentType: contentType,
+		Size:        int64(len(upload.data)),
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		UserID:      userID,
+		Tags:        []string{},
+		Metadata:    Metadata{CreatedBy: userID, CreatedAt: now, UpdatedBy: userID, UpdatedAt: now},
+		Variants:    []PhotoVariant{},
+		PhotoHashes: hashes,
+		TakenAt:     now,
+		EXIF:        upload.exif,
+	}
+	if upload.exif.TakenAt != 0 {
+		photo.TakenAt = upload.exif.TakenAt
+	}
+	if err := s.Blobs.Put(ctx, photo.BlobKey, bytes.NewReader(upload.data)); err != nil {
+		log.Printf("Failed to store photo: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to store photo"}`))
+		return
+	}
+	photo.ID, err = s.Photos.InsertPhoto(ctx, photo)
+	if err != nil {
+		log.Printf("Failed to insert photo: %v", err)
+		if err := s.Blobs.Delete(ctx, photo.BlobKey); err != nil {
+			log.Printf("Failed to delete the blob %s of a photo that was not saved: %v", photo.BlobKey, err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to save photo"}`))
+		return
+	}
+
+	s.Worker.Enqueue(photo.ID)
+
+	res, _ := json.Marshal(photo.withURL())
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// Serve handles requests for the bytes of an uploaded photo, photos added by url redirect there
+// The bytes behind an id never change, so clients may cache them for CacheMaxAge and revalidate with If-None-Match
+func (s *AlbumServer) Serve(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, "")
+}
+
+// ServeVariant handles requests for the bytes of a resized variant of an uploaded photo
+// Variants not generated yet are not found, the original is always available at Serve
+func (s *AlbumServer) ServeVariant(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r, mux.Vars(r)["variant"])
+}
+
+// serve writes the original of a photo, or its variant of that name
+// Photos only in private albums are not found for users who may not see them, and are only cached by their browser
+func (s *AlbumServer) serve(w http.ResponseWriter, r *http.Request, variant string) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	photo, err := s.Photos.GetPhoto(ctx, mux.Vars(r)["photoID"])
+	if err == nil && photo.BlobKey == "" && (photo.URL == "" || variant != "") {
+		err = ErrNotFound
+	}
+	var access photoAccess
+	if err == nil {
+		access, err = s.newPhotoAccess(ctx, []string{photo.ID})
+	}
+	if err == nil && !access.canSee(photo) {
+		err = ErrNotFound
+	}
+	cacheScope := "public"
+	if access.isPrivate(photo) {
+		cacheScope = "private"
+		w.Header().Set("Vary", "Authorization")
+	}
+	blobKey, contentType, etag := photo.BlobKey, photo.ContentType, photo.ID
+	// Variants are regenerated when their sizes change, so they are cached without the immutable hint
+	cacheControl := fmt.Sprintf("%s, max-age=%d, immutable", cacheScope, int64(s.CacheMaxAge.Seconds()))
+	if err == nil && variant != "" {
+		err = ErrNotFound
+		for _, v := range photo.Variants {
+			if v.Name == variant {
+				blobKey, contentType, err = v.BlobKey, v.ContentType, nil
+				etag = fmt.Sprintf("%s-%s-%dx%d", photo.ID, v.Name, v.Width, v.Height)
+				cacheControl = fmt.Sprintf("%s, max-age=%d", cacheScope, int64(s.CacheMaxAge.Seconds()))
+			}
+		}
+	}
+	if err == ErrNotFound {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting photo: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return
+	}
+	if blobKey == "" {
+		http.Redirect(w, r, photo.URL, http.StatusFound)
+		return
+	}
+	file, err := s.Blobs.Open(ctx, blobKey)
+	if err != nil {
+		log.Printf("Error opening the blob %s of photo %s: %v", blobKey, photo.ID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, etag))
+	http.ServeContent(w, r, "", time.Unix(photo.Metadata.CreatedAt, 0), file)
+}
+
+// DeletePhoto handles requests to delete a photo by its uploader or a moderator
+// The photo leaves every album and its bytes and variants are deleted, posts showing it keep a url that is no longer found
+func (s *AlbumServer) DeletePhoto(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	photoID := mux.Vars(r)["photoID"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	photo, err := s.Photos.GetPhoto(ctx, photoID)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting photo %s: %v", photoID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to delete photo"}`))
+		return
+	}
+	if !s.Authorizer.AuthorizeOwner(w, r, photo.UserID, RoleModerator) {
+		return
+	}
+	err = s.Photos.DeletePhoto(ctx, photoID)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete photo %s: %v", photoID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to delete photo"}`))
+		return
+	}
+	// The photo is gone first so it is not added to an album after leaving them
+	removed, err := s.Albums.RemovePhotoFromAlbums(ctx, photoID)
+	if err != nil {
+		log.Printf("Failed to remove deleted photo %s from its albums: %v", photoID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to remove photo from albums"}`))
+		return
+	}
+	// Nothing refers to the blobs anymore, a blob failing to delete is only left behind
+	blobKeys := []string{}
+	if photo.BlobKey != "" {
+		blobKeys = append(blobKeys, photo.BlobKey)
+	}
+	for _, variant := range photo.Variants {
+		blobKeys = append(blobKeys, variant.BlobKey)
+	}
+	for _, key := range blobKeys {
+		if err := s.Blobs.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete the blob %s of deleted photo %s: %v", key, photoID, err)
+		}
+	}
+	log.Printf("User %s deleted photo %s from %d albums", auth.UserID(r.Context()), photoID, removed)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"deletedPhotoId": "%s", "removedFromAlbums": %d}`, photoID, removed)))
+}
+
+// errTooLarge is returned by readFormFile for files over the limit
+var errTooLarge = errors.New("file too large")
+
+// readFormFile reads the first file in field of a multipart request
+// It returns io.EOF when the field is missing and errTooLarge when the file or the whole body is over the limit
+func readFormFile(r *http.Request, field string, limit int64) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, translateBodyError(err)
+		}
+		if part.FormName() != field {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, limit+1))
+		if err != nil {
+			return nil, translateBodyError(err)
+		}
+		if int64(len(data)) > limit {
+			return nil, errTooLarge
+		}
+		return data, nil
+	}
+}
+
+// translateBodyError maps the error of a body cut off by http.MaxBytesReader to errTooLarge
+func translateBodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errTooLarge
+	}
+	return err
+}
+
+// newBlobKey returns a random key below prefix
+func newBlobKey(prefix string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return prefix + "/" + hex.EncodeToString(b)
 }
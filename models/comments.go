@@ -0,0 +1,126 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// buildCommentTree nests the comments of a thread under their parents
+// comments must be sorted the way siblings are displayed, comments whose parent is missing are dropped
+func buildCommentTree(postID string, comments []ForumComment) []ForumComment {
+	children := map[string][]ForumComment{}
+	for _, comment := range comments {
+		children[comment.ParentID] = append(children[comment.ParentID], comment)
+	}
+	var attach func(parentID string) []ForumComment
+	attach = func(parentID string) []ForumComment {
+		res := []ForumComment{}
+		for _, comment := range children[parentID] {
+			comment.Comments = attach(comment.ID)
+			res = append(res, comment)
+		}
+		return res
+	}
+	return attach(postID)
+}
+
+// NestCommentsV2 nests the v2 comments of a thread under their parents like buildCommentTree
+func NestCommentsV2(postID string, comments []ForumCommentV2) []ForumCommentV2 {
+	children := map[string][]ForumCommentV2{}
+	for _, comment := range comments {
+		children[comment.ParentID] = append(children[comment.ParentID], comment)
+	}
+	var attach func(parentID string) []ForumCommentV2
+	attach = func(parentID string) []ForumCommentV2 {
+		res := []ForumCommentV2{}
+		for _, comment := range children[parentID] {
+			comment.Comments = attach(comment.ID)
+			res = append(res, comment)
+		}
+		return res
+	}
+	return attach(postID)
+}
+
+// DBCommentAncestry returns the post and ancestor path a v2 reply to parentID belongs under
+// parentID is either a v2 comment or, for top level comments, a v2 post
+// It returns ErrNotFound when the parent does not exist, is hidden or deleted
+func DBCommentAncestry(ctx context.Context, comments CommentRepository, posts PostRepository, parentID string) (postID string, path []string, err error) {
+	parent, err := comments.GetDBComment(ctx, parentID)
+	if err == nil {
+		if parent.Hidden || parent.Deleted {
+			return "", nil, ErrNotFound
+		}
+		return parent.PostID, append(append([]string{}, parent.Path...), parent.ID), nil
+	}
+	if err != ErrNotFound {
+		return "", nil, err
+	}
+	post, err := posts.GetDBPost(ctx, parentID)
+	if err != nil {
+		return "", nil, err
+	}
+	if post.Hidden || post.Deleted {
+		return "", nil, ErrNotFound
+	}
+	return post.ID, []string{}, nil
+}
+
+// PropagateCommentActivity moves metadata.updatedAt of the ancestors and the post of a new v2 comment
+// up to its metadata.createdAt, activity times never move back
+func PropagateCommentActivity(ctx context.Context, comments CommentRepository, posts PostRepository, comment DBForumComment) error {
+	updatedAt := comment.Metadata.CreatedAt
+	if err := comments.BumpDBCommentsUpdatedAt(ctx, comment.Path, updatedAt); err != nil {
+		return fmt.Errorf("updating parent comments: %v", err)
+	}
+	if err := posts.BumpDBPostUpdatedAt(ctx, comment.PostID, updatedAt); err != nil {
+		return fmt.Errorf("updating post: %v", err)
+	}
+	return nil
+}
+
+// commentAncestry returns the post and ancestor path a reply to parentID belongs under
+// parentID is either a comment or, for top level comments, the post itself
+func commentAncestry(ctx context.Context, comments CommentRepository, parentID string) (postID string, path []string, err error) {
+	parent, err := comments.GetComment(ctx, parentID)
+	if err == ErrNotFound {
+		return parentID, []string{}, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if parent.PostID == "" {
+		// Parent predates postId, walk up the thread
+		postID, path, err = commentAncestry(ctx, comments, parent.ParentID)
+		if err != nil {
+			return "", nil, err
+		}
+		return postID, append(path, parent.ID), nil
+	}
+	return parent.PostID, append(append([]string{}, parent.Path...), parent.ID), nil
+}
+
+// BackfillCommentAncestry sets postId and path on comments that only have parentId
+// It is safe to run repeatedly, it returns the number of comments updated
+func BackfillCommentAncestry(ctx context.Context, comments CommentRepository) (int, error) {
+	legacy, err := comments.GetCommentsWithoutPost(ctx)
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, comment := range legacy {
+		postID, path, err := commentAncestry(ctx, comments, comment.ParentID)
+		if err != nil {
+			return updated, err
+		}
+		if err := comments.SetCommentAncestry(ctx, comment.ID, postID, path); err != nil {
+			return updated, err
+		}
+		updated++
+		if updated%1000 == 0 {
+			log.Printf("Backfilled %d of %d comments", updated, len(legacy))
+		}
+	}
+	return updated, nil
+}
@@ -0,0 +1,300 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/config"
+
+	"github.com/gorilla/mux"
+)
+
+// EditServer is the definition of a REST API for authors to edit and delete their own posts and comments
+// Every change saves the version it replaces as a Revision, deletes are soft so replies stay in place
+type EditServer struct {
+	Posts      PostRepository
+	Comments   CommentRepository
+	Revisions  RevisionRepository
+	Authorizer *Authorizer
+	Timeout    time.Duration
+}
+
+// NewEditServer creates a new Server instance
+func NewEditServer(store *Store, cfg *config.Config, authorizer *Authorizer) *EditServer {
+	return &EditServer{
+		Posts:      store.Posts,
+		Comments:   store.Comments,
+		Revisions:  store.Revisions,
+		Authorizer: authorizer,
+		Timeout:    cfg.Server.RequestTimeout.Duration,
+	}
+}
+
+// editTarget is what edits need to know about a post or comment
+type editTarget struct {
+	kind     string
+	id       string
+	authorID string
+	// current is the stored version, saved as a revision once replaced
+	current Revision
+	deleted bool
+	// updatedAt returns the updatedAt of a change at now, v1 items keep the unit their client sent
+	updatedAt func(now time.Time) int64
+	apply     func(ctx context.Context, id string, from Revision, edit PostEdit) (bool, error)
+}
+
+// EditPost handles edits of a v1 post by its author
+func (s *EditServer) EditPost(w http.ResponseWriter, r *http.Request) {
+	s.change(w, r, false, s.loadPost)
+}
+
+// DeletePost handles soft deletes of a v1 post by its author, deleted posts are left out of listings
+func (s *EditServer) DeletePost(w http.ResponseWriter, r *http.Request) {
+	s.change(w, r, true, s.loadPost)
+}
+
+// EditDBPost handles edits of a v2 post by its author, metadata.updatedBy is set to the author
+func (s *EditServer) EditDBPost(w http.ResponseWriter, r *http.Request) {
+	s.change(w, r, false, s.loadDBPost)
+}
+
+// DeleteDBPost handles soft deletes of a v2 post by its author, deleted posts are left out of listings
+func (s *EditServer) DeleteDBPost(w http.ResponseWriter, r *http.Request) {
+	s.change(w, r, true, s.loadDBPost)
+}
+
+// EditComment handles edits of a v1 comment by its author
+func (s *EditServer) EditComment(w http.ResponseWriter, r *http.Request) {
+	s.change(w, r, false, s.loadComment)
+}
+
+// DeleteComment handles soft deletes of a v1 comment by its author, it is shown as DeletedContent with its replies below
+func (s *EditServer) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	s.change(w, r, true, s.loadComment)
+}
+
+// EditDBComment handles edits of a v2 comment by its author, metadata.updatedBy is set to the author
+func (s *EditServer) EditDBComment(w http.ResponseWriter, r *http.Request) {
+	s.change(w, r, false, s.loadDBComment)
+}
+
+// DeleteDBComment handles soft deletes of a v2 comment by its author, it is shown as DeletedContent with its replies below
+func (s *EditServer) DeleteDBComment(w http.ResponseWriter, r *http.Request) {
+	s.change(w, r, true, s.loadDBComment)
+}
+
+// GetPostRevisions handles requests for the former versions of a v1 post, newest first
+// Only the author and moderators may read them
+func (s *EditServer) GetPostRevisions(w http.ResponseWriter, r *http.Request) {
+	s.getRevisions(w, r, s.loadPost)
+}
+
+// GetDBPostRevisions handles requests for the former versions of a v2 post, newest first
+// Only the author and moderators may read them
+func (s *EditServer) GetDBPostRevisions(w http.ResponseWriter, r *http.Request) {
+	s.getRevisions(w, r, s.loadDBPost)
+}
+
+// GetCommentRevisions handles requests for the former versions of a v1 comment, newest first
+// Only the author and moderators may read them
+func (s *EditServer) GetCommentRevisions(w http.ResponseWriter, r *http.Request) {
+	s.getRevisions(w, r, s.loadComment)
+}
+
+// GetDBCommentRevisions handles requests for the former versions of a v2 comment, newest first
+// Only the author and moderators may read them
+func (s *EditServer) GetDBCommentRevisions(w http.ResponseWriter, r *http.Request) {
+	s.getRevisions(w, r, s.loadDBComment)
+}
+
+// loadPost loads a v1 post, v2 posts are not found so they are only changed with their metadata
+func (s *EditServer) loadPost(ctx context.Context, r *http.Request) (editTarget, error) {
+	post, err := s.Posts.GetPost(ctx, mux.Vars(r)["postID"])
+	if err != nil {
+		return editTarget{}, err
+	}
+	return editTarget{
+		kind:     RevisionPost,
+		id:       post.ID,
+		authorID: post.UserID,
+		current:  Revision{Title: post.Title, Content: post.Content},
+		deleted:  post.Deleted,
+		updatedAt: func(now time.Time) int64 {
+			return legacyTime(now, post.CreatedAt)
+		},
+		apply: s.Posts.EditPost,
+	}, nil
+}
+
+func (s *EditServer) loadDBPost(ctx context.Context, r *http.Request) (editTarget, error) {
+	post, err := s.Posts.GetDBPost(ctx, mux.Vars(r)["postID"])
+	if err != nil {
+		return editTarget{}, err
+	}
+	return editTarget{
+		kind:      RevisionPost,
+		id:        post.ID,
+		authorID:  post.UserID,
+		current:   Revision{Title: post.Title, Content: post.Content},
+		deleted:   post.Deleted,
+		updatedAt: time.Time.Unix,
+		apply:     s.Posts.EditDBPost,
+	}, nil
+}
+
+// loadComment loads a v1 comment, v2 comments are not found so they are only changed with their metadata
+func (s *EditServer) loadComment(ctx context.Context, r *http.Request) (editTarget, error) {
+	comment, err := s.Comments.GetComment(ctx, mux.Vars(r)["commentID"])
+	if err != nil {
+		return editTarget{}, err
+	}
+	return editTarget{
+		kind:     RevisionComment,
+		id:       comment.ID,
+		authorID: comment.UserID,
+		current:  Revision{Content: comment.Content},
+		deleted:  comment.Deleted,
+		updatedAt: func(now time.Time) int64 {
+			return legacyTime(now, comment.CreatedAt)
+		},
+		apply: s.Comments.EditComment,
+	}, nil
+}
+
+func (s *EditServer) loadDBComment(ctx context.Context, r *http.Request) (editTarget, error) {
+	comment, err := s.Comments.GetDBComment(ctx, mux.Vars(r)["commentID"])
+	if err != nil {
+		return editTarget{}, err
+	}
+	return editTarget{
+		kind:      RevisionComment,
+		id:        comment.ID,
+		authorID:  comment.UserID,
+		current:   Revision{Content: comment.Content},
+		deleted:   comment.Deleted,
+		updatedAt: time.Time.Unix,
+		apply:     s.Comments.EditDBComment,
+	}, nil
+}
+
+// change applies an edit, or a delete when deleting is set, and saves the replaced version
+// The body of a delete is optional and only read for updatedAt
+func (s *EditServer) change(w http.ResponseWriter, r *http.Request, deleting bool, load func(ctx context.Context, r *http.Request) (editTarget, error)) {
+	w.Header().Set("Content-Type", "application/json")
+	var request EditRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil && !(deleting && err == io.EOF) {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	if deleting {
+		request.Title, request.Content = nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	target, err := load(ctx, r)
+	if err == nil && target.deleted {
+		err = ErrNotFound
+	}
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting %s to change: %v", target.kind, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return
+	}
+	userID := auth.UserID(r.Context())
+	if userID != target.authorID {
+		WriteForbidden(w, fmt.Sprintf("only the author may change a %s", target.kind))
+		return
+	}
+	if !deleting {
+		if invalid := request.validate(target.kind == RevisionComment); invalid != nil {
+			writeValidationError(w, invalid)
+			return
+		}
+	}
+
+	edit := PostEdit{
+		Title:     request.Title,
+		Content:   request.Content,
+		Delete:    deleting,
+		UpdatedBy: userID,
+		UpdatedAt: target.updatedAt(time.Now()),
+	}
+	ok, err := target.apply(ctx, target.id, target.current, edit)
+	if err != nil {
+		log.Printf("Failed to change %s %s: %v", target.kind, target.id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"error": "Failed to change %s"}`, target.kind)))
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf(`{"error": "The %s changed meanwhile, reload it and try again"}`, target.kind)))
+		return
+	}
+
+	revision := target.current
+	revision.TargetKind = target.kind
+	revision.TargetID = target.id
+	revision.Action = RevisionEdited
+	if deleting {
+		revision.Action = RevisionDeleted
+	}
+	revision.Metadata = Metadata{CreatedBy: userID, CreatedAt: time.Now().Unix()}
+	// The change is already stored, a lost revision only shortens the history
+	if _, err := s.Revisions.InsertRevision(ctx, revision); err != nil {
+		log.Printf("Failed to save the revision of %s %s: %v", target.kind, target.id, err)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"id": "%s", "action": "%s"}`, target.id, revision.Action)))
+}
+
+func (s *EditServer) getRevisions(w http.ResponseWriter, r *http.Request, load func(ctx context.Context, r *http.Request) (editTarget, error)) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	target, err := load(ctx, r)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting %s: %v", target.kind, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return
+	}
+	if !s.Authorizer.AuthorizeOwner(w, r, target.authorID, RoleModerator) {
+		return
+	}
+	revisions, err := s.Revisions.GetRevisions(ctx, target.id)
+	if err != nil {
+		log.Printf("Error getting revisions of %s %s: %v", target.kind, target.id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get revisions"}`))
+		return
+	}
+	res, _ := json.Marshal(revisions)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
@@ -0,0 +1,142 @@
+package models_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/models"
+	"gguan/cwgcf_db/storage/memory"
+
+	"github.com/gorilla/mux"
+)
+
+// serve calls handler as userID with the route variables vars
+func serve(handler http.HandlerFunc, userID string, vars map[string]string, method string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req = mux.SetURLVars(req.WithContext(auth.WithUserID(req.Context(), userID)), vars)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// stalePosts returns v1 posts as they were before another edit, like an edit racing it would
+type stalePosts struct {
+	models.PostRepository
+}
+
+func (r stalePosts) GetPost(ctx context.Context, id string) (models.ForumPost, error) {
+	post, err := r.PostRepository.GetPost(ctx, id)
+	post.Title = "before"
+	return post, err
+}
+
+func TestEditServer(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	s := models.NewEditServer(store, config.Default(), models.NewAuthorizer(store, config.Default()))
+	// v1 clients send their times in seconds or in milliseconds
+	millisPost, err := store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", Content: "c", UserID: "author", CreatedAt: 1600000000000, UpdatedAt: 1600000000000})
+	check(t, err)
+	secondsComment, err := store.Comments.InsertComment(ctx, models.ForumComment{ParentID: millisPost, Content: "c", UserID: "author", CreatedAt: 1600000000})
+	check(t, err)
+	deletedPost, err := store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", UserID: "author", CreatedAt: 1600000000, Deleted: true})
+	check(t, err)
+	v2Post, err := store.Posts.InsertDBPost(ctx, models.DBForumPost{Title: "t", Content: "c", UserID: "author", Metadata: models.Metadata{CreatedAt: 1600000000}})
+	check(t, err)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		userID  string
+		vars    map[string]string
+		body    string
+		code    int
+	}{
+		{"not the author", s.EditPost, "other", map[string]string{"postID": millisPost}, `{"title": "x"}`, http.StatusForbidden},
+		{"missing post", s.EditPost, "author", map[string]string{"postID": "5e8f8f8f8f8f8f8f8f8f8f8f"}, `{"title": "x"}`, http.StatusNotFound},
+		{"deleted post", s.EditPost, "author", map[string]string{"postID": deletedPost}, `{"title": "x"}`, http.StatusNotFound},
+		{"v2 post on the v1 path", s.EditPost, "author", map[string]string{"postID": v2Post}, `{"title": "x"}`, http.StatusNotFound},
+		{"v1 post on the v2 path", s.EditDBPost, "author", map[string]string{"postID": millisPost}, `{"title": "x"}`, http.StatusNotFound},
+		{"invalid edit", s.EditPost, "author", map[string]string{"postID": millisPost}, `{"title": ""}`, http.StatusBadRequest},
+		{"v1 post", s.EditPost, "author", map[string]string{"postID": millisPost}, `{"title": "edited"}`, http.StatusOK},
+		{"v2 post", s.EditDBPost, "author", map[string]string{"postID": v2Post}, `{"content": "edited"}`, http.StatusOK},
+		{"v1 comment", s.EditComment, "author", map[string]string{"commentID": secondsComment}, `{"content": "edited"}`, http.StatusOK},
+		{"deleting the v1 comment", s.DeleteComment, "author", map[string]string{"commentID": secondsComment}, "", http.StatusOK},
+		{"editing the deleted comment", s.EditComment, "author", map[string]string{"commentID": secondsComment}, `{"content": "again"}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := serve(test.handler, test.userID, test.vars, http.MethodPatch, test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: status %d, want %d: %s", test.name, w.Code, test.code, w.Body.String())
+		}
+	}
+
+	// Every change saved the version it replaced
+	revisionTests := []struct {
+		id      string
+		actions []string
+		content string
+	}{
+		{millisPost, []string{models.RevisionEdited}, "c"},
+		{v2Post, []string{models.RevisionEdited}, "c"},
+		{secondsComment, []string{models.RevisionDeleted, models.RevisionEdited}, "c"},
+		{deletedPost, []string{}, ""},
+	}
+	for _, test := range revisionTests {
+		revisions, err := store.Revisions.GetRevisions(ctx, test.id)
+		if err != nil || len(revisions) != len(test.actions) {
+			t.Errorf("%s: revisions %+v: %v", test.id, revisions, err)
+			continue
+		}
+		for i, revision := range revisions {
+			if revision.Action != test.actions[i] || revision.TargetID != test.id || revision.Metadata.CreatedBy != "author" {
+				t.Errorf("%s: revision %d is %+v", test.id, i, revision)
+			}
+		}
+		if len(revisions) > 0 && revisions[len(revisions)-1].Content != test.content {
+			t.Errorf("%s: first revision holds %q, want %q", test.id, revisions[len(revisions)-1].Content, test.content)
+		}
+	}
+
+	// Edits keep the unit of the times their client sent
+	now := time.Now().Unix()
+	post, err := store.Posts.GetPost(ctx, millisPost)
+	if err != nil || post.Title != "edited" || post.UpdatedAt/1000 < now-5 || post.UpdatedAt/1000 > now+5 {
+		t.Errorf("edited post in milliseconds %+v: %v", post, err)
+	}
+	comment, err := store.Comments.GetComment(ctx, secondsComment)
+	if err != nil || !comment.Deleted || comment.UpdatedAt < now-5 || comment.UpdatedAt > now+5 {
+		t.Errorf("deleted comment in seconds %+v: %v", comment, err)
+	}
+	dbPost, err := store.Posts.GetDBPost(ctx, v2Post)
+	if err != nil || dbPost.Content != "edited" || dbPost.Metadata.UpdatedAt < now-5 || dbPost.Metadata.UpdatedAt > now+5 || dbPost.Metadata.UpdatedBy != "author" {
+		t.Errorf("edited v2 post %+v: %v", dbPost, err)
+	}
+}
+
+func TestEditServerConflict(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	s := models.NewEditServer(store, config.Default(), models.NewAuthorizer(store, config.Default()))
+	s.Posts = stalePosts{store.Posts}
+	postID, err := store.Posts.InsertPost(ctx, models.ForumPost{Title: "after", Content: "c", UserID: "author", CreatedAt: 1600000000})
+	check(t, err)
+
+	w := serve(s.EditPost, "author", map[string]string{"postID": postID}, http.MethodPatch, `{"content": "mine"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("editing a post changed meanwhile: status %d, want 409: %s", w.Code, w.Body.String())
+	}
+	post, err := store.Posts.GetPost(ctx, postID)
+	if err != nil || post.Title != "after" || post.Content != "c" {
+		t.Errorf("the losing edit was applied: %+v, %v", post, err)
+	}
+	if revisions, err := store.Revisions.GetRevisions(ctx, postID); err != nil || len(revisions) != 0 {
+		t.Errorf("the losing edit saved revisions %+v: %v", revisions, err)
+	}
+}
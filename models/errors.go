@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FieldError describes one invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request body
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		problems[i] = fmt.Sprintf("%s %s", field.Field, field.Message)
+	}
+	return "validation failed: " + strings.Join(problems, "; ")
+}
+
+// add records an invalid field
+func (e *ValidationError) add(field string, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// orNil returns nil when no field was invalid
+func (e *ValidationError) orNil() *ValidationError {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ErrorResponse is the JSON body of a failed request
+// Fields is only set for validation errors and Reason for denied requests
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// writeValidationError responds 400 with the invalid fields
+func writeValidationError(w http.ResponseWriter, err *ValidationError) {
+	res, _ := json.Marshal(ErrorResponse{Error: "Validation failed", Fields: err.Fields})
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(res)
+}
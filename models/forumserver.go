@@ -4,73 +4,123 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/background"
 	"gguan/cwgcf_db/config"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // ForumServer is the definition of a REST API for forum
 type ForumServer struct {
-	Client        *mongo.Client
-	ProfileClient *ProfileServer
+	Posts      PostRepository
+	Comments   CommentRepository
+	UserVotes  UserVoteRepository
+	VoteEvents VoteEventRepository
+	Profiles   ProfileRepository
+	SubForums  SubForumRepository
+	Photos     PhotoRepository
+	Authorizer *Authorizer
+	Timeout    time.Duration
+	Limits     config.Pagination
+	// Background tracks the updates that run after the response is written
+	Background *background.Group
 }
 
 // NewForumServer creates a new Server instance
-func NewForumServer() *ForumServer {
-	s := &ForumServer{}
-
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	// "mongodb+srv://<username>:<password>@<cluster-address>/test?w=majority"
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(
-		config.MongoDBUrl,
-	))
-	if err != nil {
-		log.Fatal(err)
+func NewForumServer(store *Store, cfg *config.Config, tasks *background.Group, authorizer *Authorizer) *ForumServer {
+	return &ForumServer{
+		Posts:      store.Posts,
+		Comments:   store.Comments,
+		UserVotes:  store.UserVotes,
+		VoteEvents: store.VoteEvents,
+		Profiles:   store.Profiles,
+		SubForums:  store.SubForums,
+		Photos:     store.Photos,
+		Authorizer: authorizer,
+		Timeout:    cfg.Server.RequestTimeout.Duration,
+		Limits:     cfg.Pagination,
+		Background: tasks,
 	}
-	log.Print("Connected to MongoDB")
-
-	s.ProfileClient = NewProfileServer()
-
-	s.Client = client
-	return s
 }
 
 // GetAllPosts handles getAll requests
+// Pages are selected with the limit and cursor query parameters, the next cursor is sent in the X-Next-Cursor header
+// The sort query parameter picks the order, SortTop by default, and window limits SortTop to recent posts
 func (s *ForumServer) GetAllPosts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	collection := s.Client.Database("cwgcf").Collection("forumPosts")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	opt := options.Find()
-	opt.SetSort(bson.D{{"forumVotes.votesSum", -1}, {"updatedAt", -1}})
-	cur, err := collection.Find(ctx, bson.D{}, opt)
+	query := r.URL.Query()
+	sort, err := NewPostSort(query.Get("sort"), query.Get("window"), SortTop)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	limit, err := QueryInt64(query, "limit")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	page, err := NewPageRequest(limit, query.Get("cursor"), s.Limits)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Invalid cursor"}`))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if sort.Sub = query.Get("sub"); sort.Sub != "" {
+		if _, err := s.SubForums.GetSubForum(ctx, sort.Sub); err != nil {
+			log.Printf("Error getting sub %s: %v", sort.Sub, err)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "Sub not found"}`))
+			return
+		}
+	}
+	posts, next, err := s.Posts.GetAllPosts(ctx, sort, page)
+	if err == ErrInvalidCursor {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Invalid cursor"}`))
+		return
+	}
+	// Pinned posts come before the first page, only they carry their pin
+	var pinned []ForumPost
+	if err == nil && page.After == nil {
+		pinned, err = s.Posts.GetPinnedPosts(ctx, sort)
+	}
 	if err != nil {
 		log.Printf("Error getting forum posts: %v", err)
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte(`{"error": "Not found"}`))
 		return
 	}
-	defer cur.Close(ctx)
+	for i := range posts {
+		posts[i].Pin = nil
+	}
+	posts = append(pinned, posts...)
+	// Get user profiles
+	loader := NewLoader(s.Profiles, nil)
+	for _, post := range posts {
+		loader.QueueProfile(post.UserID)
+	}
+	if err := loader.Load(ctx); err != nil {
+		log.Printf("Error getting profiles: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return
+	}
 	res := []ForumPost{}
-	for cur.Next(ctx) {
-		var post ForumPost
-
-		err := cur.Decode(&post)
-		if err != nil {
-			log.Printf("Error getting post: %v", err)
-			continue
-		}
-		// Get user profile
-		profile, err := s.ProfileClient.GetProfile(post.UserID)
-		if err != nil {
-			log.Printf("Error getting profile for ID %s: %v", post.UserID, err)
+	for _, post := range posts {
+		profile, ok := loader.Profile(post.UserID)
+		if !ok {
+			log.Printf("Error getting profile for ID %s: %v", post.UserID, ErrNotFound)
 			continue
 		}
 		post.UserProfile = profile
@@ -80,6 +130,8 @@ func (s *ForumServer) GetAllPosts(w http.ResponseWriter, r *http.Request) {
 
 	resBytes, _ := json.Marshal(res)
 
+	w.Header().Set("X-Next-Cursor", next.Encode())
+	w.Header().Set("X-Has-More", strconv.FormatBool(next != nil))
 	w.WriteHeader(http.StatusOK)
 	w.Write(resBytes)
 }
@@ -90,21 +142,28 @@ func (s *ForumServer) GetPost(w http.ResponseWriter, r *http.Request) {
 	pathParams := mux.Vars(r)
 
 	if postID, ok := pathParams["postID"]; ok {
-		var forumPost ForumPost
-		collection := s.Client.Database("cwgcf").Collection("forumPosts")
-		ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-		objectID, _ := primitive.ObjectIDFromHex(postID)
-		filter := bson.M{"_id": objectID}
-		err := collection.FindOne(ctx, filter).Decode(&forumPost)
+		ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+		defer cancel()
+		forumPost, err := s.Posts.GetPost(ctx, postID)
+		if err == nil && (forumPost.Hidden || forumPost.Deleted) {
+			err = ErrNotFound
+		}
 		if err != nil {
 			log.Printf("Error getting post with id %s from DB: %v", postID, err)
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 		// Get user profile
-		profile, err := s.ProfileClient.GetProfile(forumPost.UserID)
-		if err != nil {
+		loader := NewLoader(s.Profiles, nil)
+		loader.QueueProfile(forumPost.UserID)
+		if err := loader.Load(ctx); err != nil {
 			log.Printf("Error getting profile for ID %s: %v", forumPost.UserID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		profile, ok := loader.Profile(forumPost.UserID)
+		if !ok {
+			log.Printf("Error getting profile for ID %s: %v", forumPost.UserID, ErrNotFound)
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
@@ -117,13 +176,15 @@ func (s *ForumServer) GetPost(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotFound)
 }
 
-// GetCommentsForPostV2 gets all comment for given post id
+// GetCommentsForPost gets all comment for given post id
 func (s *ForumServer) GetCommentsForPost(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	pathParams := mux.Vars(r)
 
 	if postID, ok := pathParams["postID"]; ok {
-		comments := s.queryCommentByParent(postID)
+		ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+		defer cancel()
+		comments := s.queryCommentsByPost(ctx, postID)
 		if comments == nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -150,19 +211,48 @@ func (s *ForumServer) PutPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	collection := s.Client.Database("cwgcf").Collection("forumPosts")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	doc := bson.M{
-		"title":      forumPost.Title,
-		"content":    forumPost.Content,
-		"image":      forumPost.Image,
-		"createdAt":  forumPost.CreatedAt,
-		"updatedAt":  forumPost.CreatedAt,
-		"userId":     forumPost.UserID,
-		"forumVotes": forumPost.ForumVotes,
+	forumPost.UserID = auth.UserID(r.Context())
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	forumPost.Sub, err = PostingSub(ctx, s.SubForums, s.Authorizer, forumPost.UserID, forumPost.Sub)
+	if err == ErrNotFound {
+		invalid := &ValidationError{}
+		invalid.add("sub", "does not exist")
+		writeValidationError(w, invalid)
+		return
 	}
-
-	dbRes, err := collection.InsertOne(ctx, doc)
+	if err == ErrPostingDenied {
+		WriteForbidden(w, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to check the sub of a post: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return
+	}
+	if forumPost.ImageID != "" {
+		forumPost.Image, err = PostImage(ctx, s.Photos, forumPost.ImageID)
+		if err == ErrNotFound {
+			invalid := &ValidationError{}
+			invalid.add("imageId", "is no uploaded photo")
+			writeValidationError(w, invalid)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to check the image of a post: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "Internal error"}`))
+			return
+		}
+	}
+	// Votes, pins and moderation are only changed through their own endpoints, new posts start without them
+	forumPost.ForumVotes = ForumVotes{}
+	forumPost.Pin = nil
+	forumPost.Hidden, forumPost.Deleted = false, false
+	forumPost.UpdatedAt = forumPost.CreatedAt
+	forumPost.Ranking = NewRanking(VoteTally{}, time.Now().Unix())
+	insertID, err := s.Posts.InsertPost(ctx, forumPost)
 	if err != nil {
 		log.Printf("Failed to insert post: %v", err)
 		w.WriteHeader(http.StatusConflict)
@@ -170,10 +260,8 @@ func (s *ForumServer) PutPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	objectID, _ := dbRes.InsertedID.(primitive.ObjectID)
-
 	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(fmt.Sprintf(`{"insertID": %v}`, objectID.Hex())))
+	w.Write([]byte(fmt.Sprintf(`{"insertID": %v}`, insertID)))
 }
 
 // AddCommentV2 handles request to add a comment and updates all parents' updatedAt
@@ -193,33 +281,39 @@ func (s *ForumServer) AddCommentV2(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		// Insert comment
-		collection := s.Client.Database("cwgcf").Collection("forumComments")
-		ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-		doc := bson.M{
-			"parentId":   parentID,
-			"content":    forumComment.Content,
-			"createdAt":  forumComment.CreatedAt,
-			"updatedAt":  forumComment.CreatedAt,
-			"userId":     forumComment.UserID,
-			"forumVotes": forumComment.ForumVotes,
+		ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+		defer cancel()
+		forumComment.UserID = auth.UserID(r.Context())
+		forumComment.ParentID = parentID
+		// Votes and moderation are only changed through their own endpoints, new comments start without them
+		forumComment.ForumVotes = ForumVotes{}
+		forumComment.Hidden, forumComment.Deleted = false, false
+		forumComment.Comments = nil
+		forumComment.UpdatedAt = forumComment.CreatedAt
+		forumComment.PostID, forumComment.Path, err = commentAncestry(ctx, s.Comments, parentID)
+		if err != nil {
+			log.Printf("Failed to find parent %s: %v", parentID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "Failed to insert comment"}`))
+			return
 		}
-		dbRes, err := collection.InsertOne(ctx, doc)
+		commentID, err := s.Comments.InsertComment(ctx, forumComment)
 		if err != nil {
 			log.Printf("Failed to insert comment: %v", err)
 			w.WriteHeader(http.StatusConflict)
 			w.Write([]byte(`{"error": "Failed to insert comment"}`))
 			return
 		}
-		objectID, _ := dbRes.InsertedID.(primitive.ObjectID)
-		commentID := objectID.Hex()
 
 		// Update parents' updatedAt
-		go func() {
-			err = s.updateCommentUpdatedAt(parentID, forumComment.CreatedAt)
+		s.Background.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+			defer cancel()
+			err := s.updateCommentUpdatedAt(ctx, parentID, time.Now())
 			if err != nil {
 				log.Printf("Failed to update updatedAt: %v", err)
 			}
-		}()
+		})
 
 		w.WriteHeader(http.StatusAccepted)
 		w.Write([]byte(fmt.Sprintf(`{"insertID": %v}`, commentID)))
@@ -228,262 +322,77 @@ func (s *ForumServer) AddCommentV2(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusBadRequest)
 }
 
-// AddComment handles request to add a comment
-func (s *ForumServer) AddComment(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	pathParams := mux.Vars(r)
-
-	if parentID, ok := pathParams["parentID"]; ok {
-		// ensure the object exists
-		s.insertSubCommentIdsArray(parentID)
-
-		var forumComment ForumComment
-
-		decoder := json.NewDecoder(r.Body)
-		err := decoder.Decode(&forumComment)
-		if err != nil {
-			log.Printf("Failed to decode body: %v", err)
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{"error": "Check your request"}`))
-			return
-		}
-
-		collection := s.Client.Database("cwgcf").Collection("forumComments")
-		ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-		doc := bson.M{
-			"content":    forumComment.Content,
-			"createdAt":  forumComment.CreatedAt,
-			"updatedAt":  forumComment.CreatedAt,
-			"userId":     forumComment.UserID,
-			"forumVotes": forumComment.ForumVotes,
-		}
-
-		dbRes, err := collection.InsertOne(ctx, doc)
-		if err != nil {
-			log.Printf("Failed to insert comment: %v", err)
-			w.WriteHeader(http.StatusConflict)
-			w.Write([]byte(`{"error": "Failed to insert comment"}`))
-			return
-		}
-		objectID, _ := dbRes.InsertedID.(primitive.ObjectID)
-		commentID := objectID.Hex()
-
-		collection = s.Client.Database("cwgcf").Collection("forumSubComments")
-		filter := bson.M{"parentId": parentID}
-		update := bson.M{"$push": bson.M{"commentIds": commentID}}
-		_, err = collection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			log.Printf("Failed to link comment and parent: %v", err)
-			w.WriteHeader(http.StatusConflict)
-			w.Write([]byte(`{"error": "Failed to link comment and parent"}`))
-			return
-		}
-
-		w.WriteHeader(http.StatusAccepted)
-		w.Write([]byte(fmt.Sprintf(`{"insertID": %v}`, commentID)))
+// queryCommentsByPost loads a whole thread with one query and nests it with the authors' profiles
+func (s *ForumServer) queryCommentsByPost(ctx context.Context, postID string) []ForumComment {
+	comments, err := s.Comments.GetCommentsByPost(ctx, postID)
+	if err != nil {
+		log.Printf("Error getting comments with postID %s: %v", postID, err)
+		return nil
 	}
-}
-
-func (s *ForumServer) setUpdateKeyValue(unvote bool, upvote bool) (string, bool) {
-	var prefix string
-	var value bool
-	if unvote {
-		value = false
-		if upvote {
-			prefix = "forumVotes.upvotedIds."
-		} else {
-			prefix = "forumVotes.downvotedIds."
+	loader := NewLoader(s.Profiles, nil)
+	for i, comment := range comments {
+		if comment.Hidden {
+			comments[i].Content = HiddenContent
 		}
-	} else {
-		value = true
-		if upvote {
-			prefix = "forumVotes.upvotedIds."
-		} else {
-			prefix = "forumVotes.downvotedIds."
+		if comment.Deleted {
+			comments[i].Content = DeletedContent
+			comments[i].UserID = DeletedUserID
 		}
+		loader.QueueProfile(comments[i].UserID)
 	}
-	return prefix, value
-}
-
-// votePost is the internal core for VotePost and UnvotePost
-func (s *ForumServer) _votePost(w http.ResponseWriter, r *http.Request, update map[string]interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	pathParams := mux.Vars(r)
-	if id, ok := pathParams["id"]; ok {
-		if score, ok := pathParams["score"]; ok {
-			upvoted := false
-			if score == "1" {
-				upvoted = true
-			}
-			collection := s.Client.Database("cwgcf").Collection("forumPosts")
-			ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-			objectID, _ := primitive.ObjectIDFromHex(id)
-			filter := bson.M{"_id": objectID}
-			var update map[string]interface{}
-			if upvoted {
-				update = bson.M{"$inc": bson.M{"forumVotes.upvotes": 1, "forumVotes.votesSum": 1}}
-			} else {
-				update = bson.M{"$inc": bson.M{"forumVotes.downvotes": 1, "forumVotes.votesSum": -1}}
-			}
-			var forumPost ForumPost
-			err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&forumPost)
-			// _, err := collection.UpdateOne(ctx, filter, update)
-			if err != nil {
-				log.Printf("Failed to update voting: %v", err)
-				w.WriteHeader(http.StatusConflict)
-				w.Write([]byte(`{"error": "Failed to update voting"}`))
-				return
-			}
-			if upvoted {
-				forumPost.ForumVotes.Upvotes++
-				forumPost.ForumVotes.VotesSum++
-			} else {
-				forumPost.ForumVotes.Downvotes++
-				forumPost.ForumVotes.VotesSum--
-			}
-			res, _ := json.Marshal(forumPost)
-			w.WriteHeader(http.StatusOK)
-			w.Write(res)
-			return
-		}
-	}
-	w.WriteHeader(http.StatusBadRequest)
-}
-
-// VoteComment handles request to vote comment
-func (s *ForumServer) _VoteComment(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	pathParams := mux.Vars(r)
-	if id, ok := pathParams["id"]; ok {
-		if score, ok := pathParams["score"]; ok {
-			upvoted := false
-			if score == "1" {
-				upvoted = true
-			}
-			collection := s.Client.Database("cwgcf").Collection("forumComments")
-			ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-			objectID, _ := primitive.ObjectIDFromHex(id)
-			filter := bson.M{"_id": objectID}
-			var update map[string]interface{}
-			if upvoted {
-				update = bson.M{"$inc": bson.M{"forumVotes.upvotes": 1, "forumVotes.votesSum": 1}}
-			} else {
-				update = bson.M{"$inc": bson.M{"forumVotes.downvotes": 1, "forumVotes.votesSum": -1}}
-			}
-			var forumComment ForumComment
-			err := collection.FindOneAndUpdate(ctx, filter, update).Decode(&forumComment)
-			if err != nil {
-				log.Printf("Failed to update voting: %v", err)
-				w.WriteHeader(http.StatusConflict)
-				w.Write([]byte(`{"error": "Failed to update voting"}`))
-				return
-			}
-			if upvoted {
-				forumComment.ForumVotes.Upvotes++
-				forumComment.ForumVotes.VotesSum++
-			} else {
-				forumComment.ForumVotes.Downvotes++
-				forumComment.ForumVotes.VotesSum--
-			}
-			res, _ := json.Marshal(forumComment)
-			w.WriteHeader(http.StatusOK)
-			w.Write(res)
-			return
-		}
+	if err := loader.Load(ctx); err != nil {
+		log.Printf("Error getting profiles: %v", err)
+		return nil
 	}
-	w.WriteHeader(http.StatusBadRequest)
+	return attachCommentProfiles(buildCommentTree(postID, comments), loader)
 }
 
-// queryCommentByParent queries comments recursively
-func (s *ForumServer) queryCommentByParent(parentID string) []ForumComment {
-	collection := s.Client.Database("cwgcf").Collection("forumComments")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	filter := bson.M{"parentId": parentID}
-	opt := options.Find()
-	opt.SetSort(bson.D{{"forumVotes.votesSum", -1}, {"updatedAt", -1}})
-	cur, err := collection.Find(ctx, filter, opt)
-	if err != nil {
-		log.Printf("Error getting comments with parentID %s: %v", parentID, err)
-		return nil
-	}
-	defer cur.Close(ctx)
+// attachCommentProfiles sets loaded profiles on a comment tree, dropping comments whose author is missing
+func attachCommentProfiles(comments []ForumComment, loader *Loader) []ForumComment {
 	res := []ForumComment{}
-	for cur.Next(ctx) {
-		var comment ForumComment
-
-		err := cur.Decode(&comment)
-		if err != nil {
-			log.Printf("Error getting comment: %v", err)
-			continue
-		}
-		// Get user profile
-		profile, err := s.ProfileClient.GetProfile(comment.UserID)
-		if err != nil {
-			log.Printf("Error getting profile for ID %s: %v", comment.UserID, err)
+	for _, comment := range comments {
+		profile, ok := loader.Profile(comment.UserID)
+		if !ok {
+			log.Printf("Error getting profile for ID %s: %v", comment.UserID, ErrNotFound)
 			continue
 		}
 		comment.UserProfile = profile
-
-		// Find children comments
-		subComments := s.queryCommentByParent(comment.ID)
-		if subComments != nil {
-			comment.Comments = subComments
-		}
-
+		comment.Comments = attachCommentProfiles(comment.Comments, loader)
 		res = append(res, comment)
 	}
 	return res
 }
 
-func (s *ForumServer) insertSubCommentIdsArray(id string) {
-	// Insert empty comment array
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	collection := s.Client.Database("cwgcf").Collection("forumSubComments")
-	doc := bson.M{
-		"parentId": id,
-	}
-
-	_, err := collection.InsertOne(ctx, doc)
+// updatePostUpdatedAt sets the updatedAt of a post to now, in the unit of the times its client sent
+func (s *ForumServer) updatePostUpdatedAt(ctx context.Context, id string, now time.Time) (err error) {
+	post, err := s.Posts.GetPost(ctx, id)
 	if err != nil {
-		log.Printf("Failed to create subcomment array for id %s: %v", id, err)
-		return
+		log.Printf("Failed to get post with ID %s: %v", id, err)
+		return err
 	}
-}
-
-func (s *ForumServer) updatePostUpdatedAt(id string, updatedAt int64) (err error) {
-	collection := s.Client.Database("cwgcf").Collection("forumPosts")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	objectID, _ := primitive.ObjectIDFromHex(id)
-	filter := bson.M{"_id": objectID}
-	update := bson.M{"$set": bson.M{"updatedAt": updatedAt}}
-	_, err = collection.UpdateOne(ctx, filter, update)
+	err = s.Posts.SetPostUpdatedAt(ctx, id, legacyTime(now, post.CreatedAt))
 	if err != nil {
 		log.Printf("Failed to update updatedAt for post id %s: %v", id, err)
 	}
 	return err
 }
 
-func (s *ForumServer) updateCommentUpdatedAt(id string, updatedAt int64) (err error) {
+// updateCommentUpdatedAt sets the updatedAt of a comment and its ancestors to now, in the unit of the times their clients sent
+func (s *ForumServer) updateCommentUpdatedAt(ctx context.Context, id string, now time.Time) (err error) {
 	// Find parent
-	collection := s.Client.Database("cwgcf").Collection("forumComments")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	objectID, _ := primitive.ObjectIDFromHex(id)
-	filter := bson.M{"_id": objectID}
-	var comment ForumComment
-	err = collection.FindOne(ctx, filter).Decode(&comment)
+	comment, err := s.Comments.GetComment(ctx, id)
 	// Try post if not found
 	if err != nil {
 		log.Printf("Failed to get comment with ID %s: %v", id, err)
-		return s.updatePostUpdatedAt(id, updatedAt)
+		return s.updatePostUpdatedAt(ctx, id, now)
 	}
 	// Update parents recursively
 	if len(comment.ParentID) > 0 {
-		s.updateCommentUpdatedAt(comment.ParentID, updatedAt)
+		s.updateCommentUpdatedAt(ctx, comment.ParentID, now)
 	}
 
 	// Update self
-	update := bson.M{"$set": bson.M{"updatedAt": updatedAt}}
-	_, err = collection.UpdateOne(ctx, filter, update)
+	err = s.Comments.SetCommentUpdatedAt(ctx, id, legacyTime(now, comment.CreatedAt))
 	if err != nil {
 		log.Printf("Failed to update updatedAt for comment id %s: %v", id, err)
 	}
@@ -505,7 +414,10 @@ func (s *ForumServer) GetUserVoteMap(w http.ResponseWriter, r *http.Request) {
 	}()
 	pathParams := mux.Vars(r)
 	if id, ok := pathParams["id"]; ok {
-		forumUserVotes, err := s.getUserVoteMap(id)
+		ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+		defer cancel()
+		var forumUserVotes ForumUserVotes
+		forumUserVotes, err = s.UserVotes.GetUserVotes(ctx, id)
 		if err != nil {
 			header = http.StatusInternalServerError
 			return
@@ -515,18 +427,6 @@ func (s *ForumServer) GetUserVoteMap(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *ForumServer) getUserVoteMap(id string) (*ForumUserVotes, error) {
-	collection := s.Client.Database("cwgcf").Collection("forumUserVotes")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	filter := bson.M{"userId": id}
-	var forumUserVotes ForumUserVotes
-	err := collection.FindOne(ctx, filter).Decode(&forumUserVotes)
-	if err != nil {
-		return nil, err
-	}
-	return &forumUserVotes, nil
-}
-
 // Vote handles vote requests
 func (s *ForumServer) Vote(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -549,18 +449,21 @@ func (s *ForumServer) Vote(w http.ResponseWriter, r *http.Request) {
 		header = http.StatusBadRequest
 		return
 	}
+	request.UserID = auth.UserID(r.Context())
 
 	// Get votemap and find current vote status
-	forumUserVotes, err := s.getUserVoteMap(request.UserID)
-	if err != nil {
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	forumUserVotes, getErr := s.UserVotes.GetUserVotes(ctx, request.UserID)
+	if getErr != nil {
 		// User not voted yet
 		log.Printf("User %s hasn't voted before", request.UserID)
-		forumUserVotes = &ForumUserVotes{
+		forumUserVotes = ForumUserVotes{
 			VoteMap: map[string]ForumVoteWithTime{},
 		}
 	}
 	// If not found, defaulted vote status to 0
-	voteWithTime, _ := forumUserVotes.VoteMap[request.VoteID]
+	voteWithTime := forumUserVotes.VoteMap[request.VoteID]
 
 	// Update vote
 	prevStatus := voteWithTime.VoteStatus
@@ -570,18 +473,14 @@ func (s *ForumServer) Vote(w http.ResponseWriter, r *http.Request) {
 	} else if !request.TapUpvote && prevStatus != -1 {
 		curStatus = -1
 	}
-	collectionName := "forumComments"
-	if request.IsPost {
-		collectionName = "forumPosts"
-	}
-	err = s.vote(request, curStatus-prevStatus, collectionName)
+	err = s.vote(ctx, request, prevStatus, curStatus)
 	if err != nil {
 		header = http.StatusInternalServerError
 		return
 	}
 
 	// Update voteMap
-	err = s.postUserVoteMap(request, curStatus)
+	err = s.UserVotes.SetUserVote(ctx, request.UserID, request.VoteID, curStatus)
 	if err != nil {
 		header = http.StatusInternalServerError
 		return
@@ -590,34 +489,28 @@ func (s *ForumServer) Vote(w http.ResponseWriter, r *http.Request) {
 	res, _ = json.Marshal(curStatus)
 }
 
-// vote changes the votesSum value
-// offset: do upvote OR undo downvote = 1; do downvote OR undo upvote = -1;
-func (s *ForumServer) vote(request ForumVoteRequest, offset int, collectionName string) error {
-	collection := s.Client.Database("cwgcf").Collection(collectionName)
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	objectID, _ := primitive.ObjectIDFromHex(request.VoteID)
-	filter := bson.M{"_id": objectID}
-	update := bson.M{"$inc": bson.M{"forumVotes.votesSum": offset}}
-	opt := options.Update()
-	opt.SetUpsert(true)
-	_, err := collection.UpdateOne(ctx, filter, update, opt)
-	if err != nil {
-		return err
+// vote changes the forumVotes of the post or comment and logs the change
+func (s *ForumServer) vote(ctx context.Context, request ForumVoteRequest, prevStatus int, curStatus int) error {
+	change := TallyChange(prevStatus, curStatus)
+	kind := CountComment
+	var err error
+	if request.IsPost {
+		kind = CountPost
+		err = s.Posts.IncPostVotes(ctx, request.VoteID, change)
+	} else {
+		err = s.Comments.IncCommentVotes(ctx, request.VoteID, change)
 	}
-	return nil
-}
-
-// postUserVoteMap updates a user's voteMap
-func (s *ForumServer) postUserVoteMap(request ForumVoteRequest, curStatus int) error {
-	collection := s.Client.Database("cwgcf").Collection("forumUserVotes")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	filter := bson.M{"userId": request.UserID}
-	update := bson.M{"$set": bson.M{fmt.Sprintf("voteMap.%s.voteStatus", request.VoteID): curStatus}}
-	opt := options.Update()
-	opt.SetUpsert(true)
-	_, err := collection.UpdateOne(ctx, filter, update, opt)
 	if err != nil {
 		return err
 	}
-	return nil
+	event := VoteEvent{
+		TargetKind: kind,
+		TargetID:   request.VoteID,
+		UserID:     request.UserID,
+		From:       prevStatus,
+		To:         curStatus,
+		CreatedAt:  time.Now().Unix(),
+	}
+	_, err = s.VoteEvents.InsertVoteEvent(ctx, event)
+	return err
 }
@@ -0,0 +1,49 @@
+package models_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"gguan/cwgcf_db/background"
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/models"
+	"gguan/cwgcf_db/storage/memory"
+)
+
+func TestAddCommentBumpsAncestors(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	tasks := &background.Group{}
+	s := models.NewForumServer(store, config.Default(), tasks, models.NewAuthorizer(store, config.Default()))
+	// The post was sent in milliseconds and the comment in seconds, by different clients
+	postID, err := store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", UserID: "u1", CreatedAt: 1600000000000, UpdatedAt: 1600000000000})
+	check(t, err)
+	commentID, err := store.Comments.InsertComment(ctx, models.ForumComment{ParentID: postID, PostID: postID, UserID: "u2", CreatedAt: 1600000000, UpdatedAt: 1600000000})
+	check(t, err)
+
+	w := serve(s.AddCommentV2, "u1", map[string]string{"parentID": commentID}, http.MethodPost, `{"content": "reply", "createdAt": 1}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	check(t, tasks.Wait(ctx))
+
+	now := time.Now().Unix()
+	post, err := store.Posts.GetPost(ctx, postID)
+	check(t, err)
+	comment, err := store.Comments.GetComment(ctx, commentID)
+	check(t, err)
+	tests := []struct {
+		name      string
+		updatedAt int64
+	}{
+		{"post", post.UpdatedAt / 1000},
+		{"comment", comment.UpdatedAt},
+	}
+	for _, test := range tests {
+		if test.updatedAt < now-5 || test.updatedAt > now+5 {
+			t.Errorf("%s: updatedAt %d, want about %d", test.name, test.updatedAt, now)
+		}
+	}
+}
@@ -1,14 +1,24 @@
 package models
 
 // GetForumPostsRequest is the request definition for mobile to get forum posts
+// Cursor is the NextCursor of the previous page, empty for the first page
+// UserID is optional, when set the user's vote statuses are filled into the response
+// Sort is one of the Sort* modes, Window limits SortTop to posts created within a duration like "24h"
 type GetForumPostsRequest struct {
-	Limit int64 `bson:"limit" json:"limit"`
+	UserID string `bson:"userId" json:"userId"`
+	Limit  int64  `bson:"limit" json:"limit"`
+	Cursor string `bson:"cursor" json:"cursor"`
+	Sort   string `bson:"sort" json:"sort"`
+	Window string `bson:"window" json:"window"`
+	Sub    string `bson:"sub" json:"sub"`
 }
 
 // GetForumPostsResponse is the response definition for mobile to get forum posts
 type GetForumPostsResponse struct {
 	ForumPosts    []ForumPostV2
 	ForumVotesMap map[string]ForumVote
+	NextCursor    string
+	HasMore       bool
 }
 
 // SaveForumPostsRequest is the request definition for mobile to get forum posts
@@ -16,21 +26,68 @@ type SaveForumPostsRequest struct {
 	ForumPost DBForumPost
 }
 
+// GetForumCommentsRequest is the request definition for mobile to get the comments of a post
+// UserID is optional, when set the user's vote statuses are filled into the response
+type GetForumCommentsRequest struct {
+	UserID string `bson:"userId" json:"userId"`
+}
+
+// GetForumCommentsResponse is the response definition for mobile to get the comments of a post
+// ForumComments are the top level comments with their replies nested, ForumVotesMap is keyed by voteId
+type GetForumCommentsResponse struct {
+	ForumComments []ForumCommentV2
+	ForumVotesMap map[string]ForumVote
+}
+
+// SaveForumCommentRequest is the request definition for mobile to save a comment
+// ForumComment.ParentID is the post for top level comments and the comment replied to otherwise
+type SaveForumCommentRequest struct {
+	ForumComment DBForumComment
+}
+
+// SaveForumCommentResponse is the response definition of a saved comment
+type SaveForumCommentResponse struct {
+	Success  bool
+	ErrorMsg string
+	ID       string
+	VoteID   string
+}
+
 // BasicResponse is a basic response definition
 type BasicResponse struct {
 	Success  bool
 	ErrorMsg string
 }
 
+// VoteResponse is the response definition of a vote event
+// Vote carries the resulting count and the user's VoteStatus
+type VoteResponse struct {
+	Success  bool
+	ErrorMsg string
+	Vote     ForumVote
+}
+
 // DBForumPost is the definition of forum post in DB
 type DBForumPost struct {
-	ID       string   `bson:"_id" json:"_id"`
-	Title    string   `bson:"title" json:"title"`
-	Content  string   `bson:"content" json:"content"`
-	Image    string   `bson:"image" json:"image"`
-	UserID   string   `bson:"userId" json:"userId"`
-	VoteID   string   `bson:"voteId" json:"voteId"`
+	ID      string `bson:"_id" json:"_id"`
+	Title   string `bson:"title" json:"title"`
+	Content string `bson:"content" json:"content"`
+	Image   string `bson:"image" json:"image"`
+	// ImageID references an uploaded photo, Image is set to its url
+	ImageID string `bson:"imageId" json:"imageId"`
+	UserID  string `bson:"userId" json:"userId"`
+	VoteID  string `bson:"voteId" json:"voteId"`
+	// Sub is the slug of the sub-forum of the post
+	Sub      string   `bson:"sub" json:"sub"`
 	Metadata Metadata `bson:"metadata" json:"metadata"`
+	// Ranking is computed from the vote, values sent by clients are ignored
+	Ranking Ranking `bson:"ranking" json:"ranking"`
+	// Pin is set by moderators, it is kept with Pinned false once unpinned
+	Pin *Pin `bson:"pin,omitempty" json:"pin,omitempty"`
+	// Hidden posts are left out of listings by moderators
+	Hidden bool `bson:"hidden" json:"hidden"`
+	// Deleted posts are left out of listings by their author, their former versions are kept as revisions
+	Deleted bool `bson:"deleted" json:"deleted"`
 }
 
 // ForumPostV2 is the definition of a forum post sent back to mobile
@@ -39,27 +96,69 @@ type ForumPostV2 struct {
 	Title       string   `bson:"title" json:"title"`
 	Content     string   `bson:"content" json:"content"`
 	Image       string   `bson:"image" json:"image"`
+	ImageID     string   `bson:"imageId" json:"imageId"`
 	UserProfile Profile  `bson:"userProfile" json:"userProfile"`
 	VoteID      string   `bson:"voteId" json:"voteId"`
+	Sub         string   `bson:"sub" json:"sub"`
 	Metadata    Metadata `bson:"metadata" json:"metadata"`
+	// Pin is only set on the pinned posts listed first
+	Pin *Pin `bson:"pin,omitempty" json:"pin,omitempty"`
 }
 
-// DBForumVote is the definition of a forum vote in DB
-type DBForumVote struct {
+// DBForumComment is the definition of a v2 forum comment in DB
+// v1 and v2 comments share a collection, v2 comments are the ones with metadata
+// Like v1 comments, PostID is the post at the root of the thread and Path lists the ancestor comment ids from the top down
+type DBForumComment struct {
 	ID       string   `bson:"_id" json:"_id"`
-	Count    int64    `bson:"count" json:"count"`
+	ParentID string   `bson:"parentId" json:"parentId"`
+	PostID   string   `bson:"postId" json:"postId"`
+	Path     []string `bson:"path" json:"path"`
+	Content  string   `bson:"content" json:"content"`
+	UserID   string   `bson:"userId" json:"userId"`
+	VoteID   string   `bson:"voteId" json:"voteId"`
+	// Metadata.UpdatedAt is the latest activity in the comment and the replies below it
 	Metadata Metadata `bson:"metadata" json:"metadata"`
+	// Hidden comments are shown as HiddenContent so their replies stay in place
+	Hidden bool `bson:"hidden" json:"hidden"`
+	// Deleted comments are shown as DeletedContent by DeletedUserID so their replies stay in place
+	Deleted bool `bson:"deleted" json:"deleted"`
+}
+
+// ForumCommentV2 is the definition of a forum comment sent back to mobile, replies are nested in Comments
+type ForumCommentV2 struct {
+	ID          string           `bson:"_id" json:"_id"`
+	ParentID    string           `bson:"parentId" json:"parentId"`
+	PostID      string           `bson:"postId" json:"postId"`
+	Content     string           `bson:"content" json:"content"`
+	UserProfile Profile          `bson:"userProfile" json:"userProfile"`
+	VoteID      string           `bson:"voteId" json:"voteId"`
+	Metadata    Metadata         `bson:"metadata" json:"metadata"`
+	Comments    []ForumCommentV2 `bson:"comments" json:"comments"`
+}
+
+// DBForumVote is the definition of a forum vote in DB
+type DBForumVote struct {
+	ID        string   `bson:"_id" json:"_id"`
+	Count     int64    `bson:"count" json:"count"`
+	Upvotes   int64    `bson:"upvotes" json:"upvotes"`
+	Downvotes int64    `bson:"downvotes" json:"downvotes"`
+	Metadata  Metadata `bson:"metadata" json:"metadata"`
 }
 
 // ForumVote is the definition of a forum vote
+// Count is upvotes minus downvotes
 type ForumVote struct {
 	ID         string   `bson:"_id" json:"_id"`
 	Count      int64    `bson:"count" json:"count"`
+	Upvotes    int64    `bson:"upvotes" json:"upvotes"`
+	Downvotes  int64    `bson:"downvotes" json:"downvotes"`
 	VoteStatus int      `bson:"voteStatus" json:"voteStatus"`
 	Metadata   Metadata `bson:"metadata" json:"metadata"`
 }
 
 // ForumVoteUpdateRequest is the definition of vote update request
+// UserID is taken from the bearer token, a value sent in the body is ignored
+// VoteStatus is the status the user wants, Offset is ignored because the server computes the change from the stored status
 type ForumVoteUpdateRequest struct {
 	VoteID     string   `bson:"voteId" json:"voteId"`
 	Offset     int64    `bson:"offset" json:"offset"`
@@ -0,0 +1,88 @@
+package models
+
+import "context"
+
+// Loader batches the profile and vote lookups needed to render one response
+// Ids are queued while the response is assembled and fetched with one query per kind on Load
+// Results are memoized, so a Loader should live no longer than the request it serves
+type Loader struct {
+	profiles ProfileRepository
+	votes    VoteRepository
+
+	profileCache    map[string]Profile
+	voteCache       map[string]ForumVote
+	pendingProfiles map[string]bool
+	pendingVotes    map[string]bool
+}
+
+// NewLoader creates a Loader, votes may be nil when the response has no v2 votes
+func NewLoader(profiles ProfileRepository, votes VoteRepository) *Loader {
+	return &Loader{
+		profiles: profiles,
+		votes:    votes,
+		// Anonymized posts and comments have no profile document
+		profileCache:    map[string]Profile{DeletedUserID: deletedProfile},
+		voteCache:       map[string]ForumVote{},
+		pendingProfiles: map[string]bool{},
+		pendingVotes:    map[string]bool{},
+	}
+}
+
+// QueueProfile schedules a profile to be fetched by the next Load
+func (l *Loader) QueueProfile(userID string) {
+	if _, ok := l.profileCache[userID]; !ok {
+		l.pendingProfiles[userID] = true
+	}
+}
+
+// QueueVote schedules a vote to be fetched by the next Load
+func (l *Loader) QueueVote(voteID string) {
+	if _, ok := l.voteCache[voteID]; !ok {
+		l.pendingVotes[voteID] = true
+	}
+}
+
+// Load fetches every queued id
+func (l *Loader) Load(ctx context.Context) error {
+	if len(l.pendingProfiles) > 0 {
+		profiles, err := l.profiles.GetProfiles(ctx, keys(l.pendingProfiles))
+		if err != nil {
+			return err
+		}
+		for id, profile := range profiles {
+			l.profileCache[id] = profile
+		}
+		l.pendingProfiles = map[string]bool{}
+	}
+	if len(l.pendingVotes) > 0 && l.votes != nil {
+		votes, err := l.votes.GetVotes(ctx, keys(l.pendingVotes))
+		if err != nil {
+			return err
+		}
+		for id, vote := range votes {
+			l.voteCache[id] = vote
+		}
+		l.pendingVotes = map[string]bool{}
+	}
+	return nil
+}
+
+// Profile returns a loaded profile, ok is false when it does not exist
+func (l *Loader) Profile(userID string) (profile Profile, ok bool) {
+	profile, ok = l.profileCache[userID]
+	return profile, ok
+}
+
+// Vote returns a loaded vote, ok is false when it does not exist
+func (l *Loader) Vote(voteID string) (vote ForumVote, ok bool) {
+	vote, ok = l.voteCache[voteID]
+	return vote, ok
+}
+
+func keys(set map[string]bool) []string {
+	res := make([]string, 0, len(set))
+	for key := range set {
+		res = append(res, key)
+	}
+	return res
+}
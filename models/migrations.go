@@ -0,0 +1,203 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migration is a versioned change of the stored data, the migrate command applies each once in version order
+// Run must be idempotent: an interrupted migration is not recorded and runs again from the start, skipping what it changed already
+type Migration struct {
+	Version int
+	Name    string
+	Help    string
+	// RetiresV1 migrations convert the v1 forum, writes through the v1 routes after them would not be converted
+	RetiresV1 bool
+	// Run returns the number of documents it changed
+	Run func(ctx context.Context, store *Store) (int, error)
+}
+
+// AppliedMigration records a migration in the migrations collection, AppliedAt is in unix seconds
+type AppliedMigration struct {
+	Version   int    `bson:"_id" json:"version"`
+	Name      string `bson:"name" json:"name"`
+	Changed   int    `bson:"changed" json:"changed"`
+	AppliedAt int64  `bson:"appliedAt" json:"appliedAt"`
+}
+
+// Migrations lists every migration by ascending version, versions are never reused or reordered
+// The first ones are the backfills that used to be run by hand, running them again on migrated data changes nothing
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "backfill-comments",
+		Help:    "set postId and path on comments that only have parentId",
+		Run: func(ctx context.Context, store *Store) (int, error) {
+			return BackfillCommentAncestry(ctx, store.Comments)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "backfill-vote-events",
+		Help:    "log an event for every vote cast before the vote event log existed and fill in upvotes and downvotes",
+		Run:     BackfillVoteEvents,
+	},
+	{
+		Version: 3,
+		Name:    "rank-posts",
+		Help:    "compute the ranking of posts created before rankings existed",
+		Run:     RankPosts,
+	},
+	{
+		Version: 4,
+		Name:    "backfill-subs",
+		Help:    "move the posts created before subs existed into the default sub",
+		Run:     BackfillSubForums,
+	},
+	{
+		Version:   5,
+		Name:      "v1-forum-to-v2",
+		Help:      "convert v1 posts, comments and user votes to v2, keeping their ids",
+		RetiresV1: true,
+		Run:       MigrateV1Forum,
+	},
+	{
+		Version: 6,
+		Name:    "backfill-photo-taken-at",
+		Help:    "set the taken-at time of photos added before EXIF data was read to when they were added",
+		Run:     BackfillPhotoTakenAt,
+	},
+}
+
+// PendingMigrations returns the migrations that were not applied yet, in version order
+func PendingMigrations(ctx context.Context, repo MigrationRepository) ([]Migration, error) {
+	applied, err := repo.GetAppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := map[int]bool{}
+	for _, migration := range applied {
+		done[migration.Version] = true
+	}
+	pending := []Migration{}
+	for _, migration := range Migrations {
+		if !done[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// ApplyMigration runs a migration and records it, a failed migration is left unrecorded so the next run retries it
+func ApplyMigration(ctx context.Context, store *Store, migration Migration) (AppliedMigration, error) {
+	changed, err := migration.Run(ctx, store)
+	applied := AppliedMigration{Version: migration.Version, Name: migration.Name, Changed: changed}
+	if err != nil {
+		return applied, fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+	}
+	applied.AppliedAt = time.Now().Unix()
+	if err := store.Migrations.InsertAppliedMigration(ctx, applied); err != nil {
+		if err == ErrConflict {
+			return applied, fmt.Errorf("migration %d %s was recorded by another run meanwhile", migration.Version, migration.Name)
+		}
+		return applied, fmt.Errorf("recording migration %d %s: %v", migration.Version, migration.Name, err)
+	}
+	return applied, nil
+}
+
+// MigrateV1Forum converts the v1 forum to v2 and returns the number of posts, comments and vote maps converted
+// Posts and comments keep their ids and get a vote object with the same id, so the keys of forumUserVotes carry over
+// into forumVoteMap unchanged. Every step skips what is v2 already, so an interrupted run picks up where it stopped
+func MigrateV1Forum(ctx context.Context, store *Store) (int, error) {
+	converted := 0
+	err := store.Posts.ForEachPost(ctx, func(post ForumPost) error {
+		metadata := v1Metadata(post.UserID, post.CreatedAt, post.UpdatedAt)
+		vote, err := ensureV1Vote(ctx, store.Votes, post.ID, post.ForumVotes, metadata)
+		if err != nil {
+			return err
+		}
+		dbPost := DBForumPost{Metadata: metadata, Ranking: post.Ranking}
+		ok, err := store.Posts.ConvertPostToV2(ctx, post.ID, vote.ID, metadata, dbPost.Rank(vote.Tally()))
+		if err != nil {
+			return fmt.Errorf("converting post %s: %v", post.ID, err)
+		}
+		if ok {
+			converted++
+		}
+		return nil
+	})
+	if err != nil {
+		return converted, fmt.Errorf("converting posts: %v", err)
+	}
+	log.Printf("Converted %d v1 posts", converted)
+
+	err = store.Comments.ForEachComment(ctx, func(comment ForumComment) error {
+		metadata := v1Metadata(comment.UserID, comment.CreatedAt, comment.UpdatedAt)
+		vote, err := ensureV1Vote(ctx, store.Votes, comment.ID, comment.ForumVotes, metadata)
+		if err != nil {
+			return err
+		}
+		ok, err := store.Comments.ConvertCommentToV2(ctx, comment.ID, vote.ID, metadata)
+		if err != nil {
+			return fmt.Errorf("converting comment %s: %v", comment.ID, err)
+		}
+		if ok {
+			converted++
+		}
+		return nil
+	})
+	if err != nil {
+		return converted, fmt.Errorf("converting comments: %v", err)
+	}
+
+	// A user's votes are merged before they are deleted, a rerun merges nothing new
+	err = store.UserVotes.ForEachUserVotes(ctx, func(userVotes ForumUserVotes) error {
+		entries := map[string]ForumVoteMapEntry{}
+		for id, vote := range userVotes.VoteMap {
+			if vote.VoteStatus == VoteNone {
+				continue
+			}
+			entries[id] = ForumVoteMapEntry{
+				VoteStatus: vote.VoteStatus,
+				Metadata:   v1Metadata(userVotes.UserID, vote.UpdatedAt, vote.UpdatedAt),
+			}
+		}
+		if _, err := store.VoteMaps.MergeVoteMap(ctx, userVotes.UserID, entries); err != nil {
+			return fmt.Errorf("merging the votes of %s: %v", userVotes.UserID, err)
+		}
+		if _, err := store.UserVotes.DeleteUserVotes(ctx, userVotes.UserID); err != nil && err != ErrNotFound {
+			return fmt.Errorf("deleting the v1 votes of %s: %v", userVotes.UserID, err)
+		}
+		converted++
+		return nil
+	})
+	if err != nil {
+		return converted, fmt.Errorf("converting user votes: %v", err)
+	}
+	return converted, nil
+}
+
+// v1Metadata builds the metadata of a converted v1 document, updatedAt falls back to createdAt when it was never set
+func v1Metadata(userID string, createdAt int64, updatedAt int64) Metadata {
+	if updatedAt == 0 {
+		updatedAt = createdAt
+	}
+	return Metadata{CreatedBy: userID, CreatedAt: createdAt, UpdatedBy: userID, UpdatedAt: updatedAt}
+}
+
+// ensureV1Vote returns the vote object of a v1 post or comment, creating it with the v1 tally on the first run
+func ensureV1Vote(ctx context.Context, votes VoteRepository, id string, forumVotes ForumVotes, metadata Metadata) (ForumVote, error) {
+	tally := forumVotes.Tally()
+	vote := ForumVote{ID: id, Count: tally.Sum, Upvotes: tally.Up, Downvotes: tally.Down, Metadata: metadata}
+	if _, err := votes.EnsureVote(ctx, vote); err != nil {
+		return vote, fmt.Errorf("creating the vote of %s: %v", id, err)
+	}
+	// A vote left by an interrupted run may have been voted on since, its tally wins
+	vote, err := votes.GetVote(ctx, id)
+	if err != nil {
+		return vote, fmt.Errorf("getting the vote of %s: %v", id, err)
+	}
+	return vote, nil
+}
@@ -0,0 +1,141 @@
+package models_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"gguan/cwgcf_db/models"
+	"gguan/cwgcf_db/storage/memory"
+)
+
+// seedV1Forum stores v1 data from before the backfills: a post without sub or ranking, a thread without postId and
+// path, votes without events and a photo without takenAt
+func seedV1Forum(t *testing.T, store *models.Store) (postID string, replyID string) {
+	ctx := context.Background()
+	postID, err := store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", Content: "c", UserID: "u1", CreatedAt: 1600000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commentID, err := store.Comments.InsertComment(ctx, models.ForumComment{ParentID: postID, Content: "c", UserID: "u2", CreatedAt: 1600000100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replyID, err = store.Comments.InsertComment(ctx, models.ForumComment{ParentID: commentID, Content: "r", UserID: "u1", CreatedAt: 1600000200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, vote := range []struct {
+		userID, targetID string
+		status           int
+		inc              func(ctx context.Context, id string, change models.VoteTally) error
+	}{
+		{"u1", postID, models.VoteUp, store.Posts.IncPostVotes},
+		{"u2", postID, models.VoteUp, store.Posts.IncPostVotes},
+		{"u1", commentID, models.VoteDown, store.Comments.IncCommentVotes},
+	} {
+		if err := store.UserVotes.SetUserVote(ctx, vote.userID, vote.targetID, vote.status); err != nil {
+			t.Fatal(err)
+		}
+		if err := vote.inc(ctx, vote.targetID, models.TallyChange(models.VoteNone, vote.status)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The store ranks the posts it inserts, unlike the ones from before rankings existed
+	if err := store.Posts.SetPostRanking(ctx, postID, models.Ranking{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Photos.InsertPhoto(ctx, models.Photo{URL: "https://example.com/a.jpg", Metadata: models.Metadata{CreatedAt: 1600000300}}); err != nil {
+		t.Fatal(err)
+	}
+	return postID, replyID
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	postID, replyID := seedV1Forum(t, store)
+
+	pending, err := models.PendingMigrations(ctx, store.Migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions(pending), versions(models.Migrations)) {
+		t.Fatalf("pending %v on a new store, want every migration", versions(pending))
+	}
+	tests := []struct {
+		version int
+		// changed is how many documents the first run changes
+		changed int
+	}{
+		{1, 2}, // two comments get postId and path
+		{2, 3}, // three votes get an event
+		{3, 1}, // the post gets a ranking
+		{4, 1}, // the post moves to the default sub
+		{5, 5}, // a post, two comments and the votes of two users are converted
+		{6, 1}, // the photo gets a taken at time
+	}
+	for i, test := range tests {
+		migration := models.Migrations[i]
+		if migration.Version != test.version {
+			t.Fatalf("migration %d is version %d, want %d", i, migration.Version, test.version)
+		}
+		applied, err := models.ApplyMigration(ctx, store, migration)
+		if err != nil {
+			t.Fatalf("migration %d: %v", test.version, err)
+		}
+		if applied.Changed != test.changed {
+			t.Errorf("migration %d changed %d documents, want %d", test.version, applied.Changed, test.changed)
+		}
+		// An interrupted run starts over, a migration that ran already finds nothing left to change
+		again, err := migration.Run(ctx, store)
+		if err != nil || again != 0 {
+			t.Errorf("migration %d run again changed %d documents: %v", test.version, again, err)
+		}
+		if _, err := models.ApplyMigration(ctx, store, migration); err == nil {
+			t.Errorf("migration %d was recorded twice", test.version)
+		}
+	}
+	if pending, err := models.PendingMigrations(ctx, store.Migrations); err != nil || len(pending) != 0 {
+		t.Errorf("pending %v after migrating: %v", versions(pending), err)
+	}
+
+	post, err := store.Posts.GetDBPost(ctx, postID)
+	if err != nil {
+		t.Fatalf("the v1 post was not converted: %v", err)
+	}
+	if post.Sub != models.DefaultSubSlug || post.VoteID != postID || post.Metadata.CreatedBy != "u1" {
+		t.Errorf("converted post %+v", post)
+	}
+	vote, err := store.Votes.GetVote(ctx, postID)
+	if err != nil || vote.Tally() != (models.VoteTally{Sum: 2, Up: 2}) {
+		t.Errorf("vote of the converted post %+v: %v", vote, err)
+	}
+	reply, err := store.Comments.GetDBComment(ctx, replyID)
+	if err != nil || reply.PostID != postID || len(reply.Path) != 1 {
+		t.Errorf("converted reply %+v: %v", reply, err)
+	}
+	voteMap, err := store.VoteMaps.GetVoteMap(ctx, "u1")
+	if err != nil || len(voteMap.VoteMap) != 2 || voteMap.VoteMap[postID].VoteStatus != models.VoteUp {
+		t.Errorf("vote map of u1 %+v: %v", voteMap, err)
+	}
+	if _, err := store.UserVotes.GetUserVotes(ctx, "u1"); err != models.ErrNotFound {
+		t.Errorf("the v1 votes of u1 are kept: %v", err)
+	}
+	photos, err := store.Photos.GetAllPhotos(ctx)
+	if err != nil || len(photos) != 1 || photos[0].TakenAt != 1600000300 {
+		t.Errorf("photos %+v: %v", photos, err)
+	}
+	report, err := models.ReconcileVotes(ctx, store, false)
+	if err != nil || len(report.Discrepancies) != 0 {
+		t.Errorf("migrated counts do not match the votes: %v %v", report.Discrepancies, err)
+	}
+}
+
+func versions(migrations []models.Migration) []int {
+	res := []int{}
+	for _, migration := range migrations {
+		res = append(res, migration.Version)
+	}
+	return res
+}
@@ -0,0 +1,355 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/config"
+
+	"github.com/gorilla/mux"
+)
+
+// HiddenContent replaces the content of comments hidden by moderators
+const HiddenContent = "[hidden]"
+
+// ModerationServer is the definition of a REST API for moderators
+// It covers v1 and v2 posts, which share a collection, and comments
+// Routes are expected to be guarded with Authorizer.RequireRole(RoleModerator, ...), or RequirePostModerator for
+// the routes hiding and pinning a post, which the moderators of its sub may use too
+type ModerationServer struct {
+	Posts      PostRepository
+	Comments   CommentRepository
+	Votes      VoteRepository
+	VoteEvents VoteEventRepository
+	Profiles   ProfileRepository
+	Revisions  RevisionRepository
+	SubForums  SubForumRepository
+	Authorizer *Authorizer
+	Timeout    time.Duration
+	Limits     config.Pagination
+}
+
+// NewModerationServer creates a new Server instance
+func NewModerationServer(store *Store, cfg *config.Config, authorizer *Authorizer) *ModerationServer {
+	return &ModerationServer{
+		Posts:      store.Posts,
+		Comments:   store.Comments,
+		Votes:      store.Votes,
+		VoteEvents: store.VoteEvents,
+		Profiles:   store.Profiles,
+		Revisions:  store.Revisions,
+		SubForums:  store.SubForums,
+		Authorizer: authorizer,
+		Timeout:    cfg.Server.RequestTimeout.Duration,
+		Limits:     cfg.Pagination,
+	}
+}
+
+// RequirePostModerator rejects requests on the post at {postID} from users who are neither moderators nor
+// moderators of the sub of the post, anonymous requests get 401, other users 403 and missing posts 404
+func (s *ModerationServer) RequirePostModerator(next http.HandlerFunc) http.HandlerFunc {
+	return auth.Required(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+		defer cancel()
+		userID := auth.UserID(r.Context())
+		ok, err := s.moderatesPost(ctx, userID, mux.Vars(r)["postID"])
+		if err == ErrNotFound {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "Not found"}`))
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking the moderators of a post: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "Internal error"}`))
+			return
+		}
+		if !ok {
+			WriteForbidden(w, fmt.Sprintf("%s role or moderator of the sub required", RoleModerator))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// moderatesPost reports whether userID has RoleModerator or moderates the sub of a v1 or v2 post
+func (s *ModerationServer) moderatesPost(ctx context.Context, userID string, postID string) (bool, error) {
+	role, err := s.Authorizer.Role(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	slug, err := postSub(ctx, s.Posts, postID)
+	if err != nil {
+		return false, err
+	}
+	if HasRole(role, RoleModerator) {
+		return true, nil
+	}
+	sub, err := s.SubForums.GetSubForum(ctx, slug)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return sub.IsModerator(userID), nil
+}
+
+// postSub returns the sub slug of a v1 or v2 post, posts from before subs existed belong to DefaultSubSlug
+func postSub(ctx context.Context, posts PostRepository, id string) (string, error) {
+	dbPost, err := posts.GetDBPost(ctx, id)
+	slug := dbPost.Sub
+	if err == ErrNotFound {
+		var post ForumPost
+		post, err = posts.GetPost(ctx, id)
+		slug = post.Sub
+	}
+	if err != nil {
+		return "", err
+	}
+	if slug == "" {
+		return DefaultSubSlug, nil
+	}
+	return slug, nil
+}
+
+// Voter is a user whose current vote on a target is not VoteNone
+type Voter struct {
+	UserID      string
+	VoteStatus  int
+	VotedAt     int64
+	UserProfile Profile
+}
+
+// VotersResponse is the response definition of a who voted request
+// WindowScore sums the vote changes made within Window, both are empty without the window query parameter
+type VotersResponse struct {
+	Voters      []Voter
+	Window      string
+	WindowScore int64
+}
+
+// VoteEventsResponse is the response definition of a vote history request
+type VoteEventsResponse struct {
+	Events     []VoteEvent
+	NextCursor string
+	HasMore    bool
+}
+
+// HidePost handles requests to leave a post out of listings
+func (s *ModerationServer) HidePost(w http.ResponseWriter, r *http.Request) {
+	s.setHidden(w, r, "post", mux.Vars(r)["postID"], true, s.Posts.SetPostHidden)
+}
+
+// UnhidePost handles requests to show a hidden post again
+func (s *ModerationServer) UnhidePost(w http.ResponseWriter, r *http.Request) {
+	s.setHidden(w, r, "post", mux.Vars(r)["postID"], false, s.Posts.SetPostHidden)
+}
+
+// HideComment handles requests to replace the content of a comment with HiddenContent
+func (s *ModerationServer) HideComment(w http.ResponseWriter, r *http.Request) {
+	s.setHidden(w, r, "comment", mux.Vars(r)["commentID"], true, s.Comments.SetCommentHidden)
+}
+
+// UnhideComment handles requests to show a hidden comment again
+func (s *ModerationServer) UnhideComment(w http.ResponseWriter, r *http.Request) {
+	s.setHidden(w, r, "comment", mux.Vars(r)["commentID"], false, s.Comments.SetCommentHidden)
+}
+
+// DeletePost handles requests to delete a post with its comments, their vote objects and its revisions
+func (s *ModerationServer) DeletePost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	postID := mux.Vars(r)["postID"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	voteID, err := s.Posts.DeletePost(ctx, postID)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	var voteIDs []string
+	if err == nil {
+		voteIDs, err = s.Comments.DeleteCommentsByPosts(ctx, []string{postID})
+	}
+	if err == nil && voteID != "" {
+		voteIDs = append(voteIDs, voteID)
+	}
+	if err == nil && len(voteIDs) > 0 {
+		err = s.Votes.DeleteVotes(ctx, voteIDs)
+	}
+	if err == nil {
+		err = s.Revisions.DeleteRevisions(ctx, []string{postID})
+	}
+	if err != nil {
+		log.Printf("Failed to delete post %s: %v", postID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to delete post"}`))
+		return
+	}
+	log.Printf("Moderator %s deleted post %s", auth.UserID(r.Context()), postID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"deletedID": "%s"}`, postID)))
+}
+
+// DeleteComment handles requests to delete a comment with every reply below it, their vote objects and its revisions
+func (s *ModerationServer) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	commentID := mux.Vars(r)["commentID"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	voteIDs, err := s.Comments.DeleteComment(ctx, commentID)
+	if err == nil && len(voteIDs) > 0 {
+		err = s.Votes.DeleteVotes(ctx, voteIDs)
+	}
+	if err == nil {
+		err = s.Revisions.DeleteRevisions(ctx, []string{commentID})
+	}
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete comment %s: %v", commentID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to delete comment"}`))
+		return
+	}
+	log.Printf("Moderator %s deleted comment %s", auth.UserID(r.Context()), commentID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"deletedID": "%s"}`, commentID)))
+}
+
+// GetVoters handles who voted requests for a v2 vote id or a v1 post or comment id
+// The optional window query parameter, e.g. "24h", adds the score of the target within that window
+func (s *ModerationServer) GetVoters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	targetID := mux.Vars(r)["targetID"]
+	response := VotersResponse{Voters: []Voter{}, Window: r.URL.Query().Get("window")}
+	var window time.Duration
+	if response.Window != "" {
+		var err error
+		window, err = time.ParseDuration(response.Window)
+		if err != nil || window <= 0 {
+			invalid := &ValidationError{}
+			invalid.add("window", "must be a positive duration like 24h")
+			writeValidationError(w, invalid)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	events, err := s.VoteEvents.GetVoters(ctx, targetID)
+	if err != nil {
+		log.Printf("Error getting voters of %s: %v", targetID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get voters"}`))
+		return
+	}
+	loader := NewLoader(s.Profiles, nil)
+	for _, event := range events {
+		loader.QueueProfile(event.UserID)
+	}
+	if err := loader.Load(ctx); err != nil {
+		log.Printf("Error getting profiles: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get voters"}`))
+		return
+	}
+	for _, event := range events {
+		// Voters without a profile, e.g. anonymized ones, are listed with an empty profile
+		profile, _ := loader.Profile(event.UserID)
+		response.Voters = append(response.Voters, Voter{
+			UserID:      event.UserID,
+			VoteStatus:  event.To,
+			VotedAt:     event.CreatedAt,
+			UserProfile: profile,
+		})
+	}
+	if window > 0 {
+		since := time.Now().Add(-window).Unix()
+		scores, err := s.VoteEvents.WindowScores(ctx, []string{targetID}, since)
+		if err != nil {
+			log.Printf("Error getting window score of %s: %v", targetID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "Failed to get window score"}`))
+			return
+		}
+		response.WindowScore = scores[targetID]
+	}
+	resBytes, _ := json.Marshal(response)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBytes)
+}
+
+// GetVoteEvents handles vote history requests for a v2 vote id or a v1 post or comment id
+// Query parameters: limit, cursor
+func (s *ModerationServer) GetVoteEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	targetID := mux.Vars(r)["targetID"]
+	query := r.URL.Query()
+	limit, err := QueryInt64(query, "limit")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	page, err := NewPageRequest(limit, query.Get("cursor"), s.Limits)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	events, next, err := s.VoteEvents.GetVoteEvents(ctx, targetID, page)
+	if err == ErrInvalidCursor {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting vote events of %s: %v", targetID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get vote events"}`))
+		return
+	}
+	response := VoteEventsResponse{Events: events, NextCursor: next.Encode(), HasMore: next != nil}
+	resBytes, _ := json.Marshal(response)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBytes)
+}
+
+func (s *ModerationServer) setHidden(w http.ResponseWriter, r *http.Request, kind string, id string, hidden bool, set func(ctx context.Context, id string, hidden bool) error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	err := set(ctx, id, hidden)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to set hidden=%v on %s %s: %v", hidden, kind, id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf(`{"error": "Failed to update %s"}`, kind)))
+		return
+	}
+	log.Printf("Moderator %s set hidden=%v on %s %s", auth.UserID(r.Context()), hidden, kind, id)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"id": "%s", "hidden": %v}`, id, hidden)))
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gguan/cwgcf_db/config"
+	"net/url"
+	"strconv"
+)
+
+// ErrInvalidCursor is returned when a client sends a cursor this server did not issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest asks for one page of a listing
+// After is nil for the first page
+type PageRequest struct {
+	Limit int64
+	After *Cursor
+}
+
+// Cursor marks the last item of a page by its sort keys and id
+// Listings are sorted descending by every key and then by id, Sort is the feed mode the cursor was issued for
+type Cursor struct {
+	Keys []float64 `json:"k"`
+	ID   string    `json:"id"`
+	Sort string    `json:"s,omitempty"`
+}
+
+// Encode returns the opaque form handed to clients
+func (c *Cursor) Encode() string {
+	if c == nil {
+		return ""
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a cursor returned by Encode, an empty string yields nil
+func DecodeCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// NewPageRequest validates the cursor and clamps limit to the configured bounds
+// A limit of 0 means the default page size
+func NewPageRequest(limit int64, cursor string, limits config.Pagination) (PageRequest, error) {
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return PageRequest{}, err
+	}
+	if limit <= 0 {
+		limit = limits.DefaultLimit
+	}
+	if limit > limits.MaxLimit {
+		limit = limits.MaxLimit
+	}
+	return PageRequest{Limit: limit, After: after}, nil
+}
+
+// QueryInt64 parses an optional integer query parameter, a missing one is 0
+func QueryInt64(query url.Values, key string) (int64, error) {
+	value := query.Get(key)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", key)
+	}
+	return n, nil
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/base64"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"gguan/cwgcf_db/config"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []*Cursor{
+		{Keys: []float64{}, ID: "5e8f8f8f8f8f8f8f8f8f8f8f"},
+		{Keys: []float64{3, 1577836800}, ID: "a", Sort: SortTop},
+		{Keys: []float64{-1.5, 0.25}, ID: "b", Sort: SortHot},
+	}
+	for _, cursor := range tests {
+		got, err := DecodeCursor(cursor.Encode())
+		if err != nil {
+			t.Errorf("decoding %+v: %v", cursor, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, cursor) {
+			t.Errorf("decoded %+v, want %+v", got, cursor)
+		}
+	}
+	var none *Cursor
+	if none.Encode() != "" {
+		t.Errorf("a nil cursor encodes as %q", none.Encode())
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name    string
+		cursor  string
+		wantNil bool
+		wantErr error
+	}{
+		{name: "empty is the first page", cursor: "", wantNil: true},
+		{name: "not base64", cursor: "!!!", wantErr: ErrInvalidCursor},
+		{name: "not json", cursor: encode("nope"), wantErr: ErrInvalidCursor},
+		{name: "without id", cursor: encode(`{"k":[1]}`), wantErr: ErrInvalidCursor},
+		{name: "keys of the wrong type", cursor: encode(`{"k":["x"],"id":"a"}`), wantErr: ErrInvalidCursor},
+		{name: "valid", cursor: encode(`{"k":[1],"id":"a","s":"new"}`)},
+	}
+	for _, test := range tests {
+		got, err := DecodeCursor(test.cursor)
+		if err != test.wantErr {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err == nil && (got == nil) != test.wantNil {
+			t.Errorf("%s: cursor %+v", test.name, got)
+		}
+	}
+}
+
+func TestNewPageRequest(t *testing.T) {
+	limits := config.Pagination{DefaultLimit: 20, MaxLimit: 100}
+	cursor := (&Cursor{Keys: []float64{1}, ID: "a"}).Encode()
+	tests := []struct {
+		limit     int64
+		cursor    string
+		wantLimit int64
+		wantAfter bool
+		wantErr   error
+	}{
+		{limit: 0, wantLimit: 20},
+		{limit: -5, wantLimit: 20},
+		{limit: 50, wantLimit: 50},
+		{limit: 1000, wantLimit: 100},
+		{limit: 10, cursor: cursor, wantLimit: 10, wantAfter: true},
+		{limit: 10, cursor: "garbage", wantErr: ErrInvalidCursor},
+	}
+	for _, test := range tests {
+		page, err := NewPageRequest(test.limit, test.cursor, limits)
+		if err != test.wantErr {
+			t.Errorf("limit %d cursor %q: error %v, want %v", test.limit, test.cursor, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if page.Limit != test.wantLimit || (page.After != nil) != test.wantAfter {
+			t.Errorf("limit %d cursor %q: got %+v", test.limit, test.cursor, page)
+		}
+	}
+}
+
+func TestQueryInt64(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int64
+		wantErr bool
+	}{
+		{query: "", want: 0},
+		{query: "limit=", want: 0},
+		{query: "limit=25", want: 25},
+		{query: "limit=-3", want: -3},
+		{query: "limit=abc", wantErr: true},
+		{query: "limit=2.5", wantErr: true},
+		{query: "limit=99999999999999999999", wantErr: true},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		got, err := QueryInt64(query, "limit")
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("%q: got %d, %v", test.query, got, err)
+		}
+	}
+}
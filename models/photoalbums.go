@@ -0,0 +1,192 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Album visibilities, private albums and their photo listings are only shown to the owner and admins
+const (
+	AlbumPublic  = "public"
+	AlbumPrivate = "private"
+)
+
+// Limits of album and photo fields, in characters
+const (
+	maxAlbumTitleLength       = 100
+	maxAlbumDescriptionLength = 1000
+	maxCaptionLength          = 1000
+	maxPhotoTags              = 20
+)
+
+// tagPattern allows lowercase letters, digits and dashes, tags are lowercased before they are checked
+var tagPattern = regexp.MustCompile(`^[\p{Ll}\p{N}-]{1,32}$`)
+
+// Album is a named collection of photos, a photo may be in several albums
+type Album struct {
+	ID          string `bson:"_id" json:"_id"`
+	Title       string `bson:"title" json:"title"`
+	Description string `bson:"description" json:"description"`
+	// CoverPhotoID is one of the photos in the album, empty for none
+	CoverPhotoID string `bson:"coverPhotoId" json:"coverPhotoId"`
+	CoverURL     string `bson:"-" json:"coverUrl"`
+	OwnerID      string `bson:"ownerId" json:"ownerId"`
+	// Visibility is AlbumPublic or AlbumPrivate
+	Visibility string   `bson:"visibility" json:"visibility"`
+	Metadata   Metadata `bson:"metadata" json:"metadata"`
+}
+
+// withCoverURL sets the url of the cover, which is not stored since it follows from the id
+func (a Album) withCoverURL() Album {
+	if a.CoverPhotoID != "" {
+		a.CoverURL = PhotoURL(a.CoverPhotoID)
+	}
+	return a
+}
+
+// AlbumUpdate is a partial update of an album, nil fields are left unchanged
+type AlbumUpdate struct {
+	Title        *string `json:"title"`
+	Description  *string `json:"description"`
+	CoverPhotoID *string `json:"coverPhotoId"`
+	Visibility   *string `json:"visibility"`
+}
+
+// Apply sets the non-nil fields of u on album
+func (u AlbumUpdate) Apply(album *Album) {
+	if u.Title != nil {
+		album.Title = *u.Title
+	}
+	if u.Description != nil {
+		album.Description = *u.Description
+	}
+	if u.CoverPhotoID != nil {
+		album.CoverPhotoID = *u.CoverPhotoID
+	}
+	if u.Visibility != nil {
+		album.Visibility = *u.Visibility
+	}
+}
+
+// validateAlbum checks the fields of a new album, which has no photos to be covered by yet
+func validateAlbum(album Album) *ValidationError {
+	invalid := AlbumUpdate{
+		Title:       &album.Title,
+		Description: &album.Description,
+		Visibility:  &album.Visibility,
+	}.validate()
+	if album.CoverPhotoID != "" {
+		if invalid == nil {
+			invalid = &ValidationError{}
+		}
+		invalid.add("coverPhotoId", "must be set once the photo is in the album")
+	}
+	return invalid
+}
+
+// validate checks the fields that are set, whether the cover is in the album is up to the caller
+func (u AlbumUpdate) validate() *ValidationError {
+	invalid := &ValidationError{}
+	if u.Title != nil {
+		if strings.TrimSpace(*u.Title) == "" {
+			invalid.add("title", "is required")
+		} else if utf8.RuneCountInString(*u.Title) > maxAlbumTitleLength {
+			invalid.add("title", fmt.Sprintf("must be at most %d characters", maxAlbumTitleLength))
+		}
+	}
+	if u.Description != nil && utf8.RuneCountInString(*u.Description) > maxAlbumDescriptionLength {
+		invalid.add("description", fmt.Sprintf("must be at most %d characters", maxAlbumDescriptionLength))
+	}
+	if u.Visibility != nil && *u.Visibility != AlbumPublic && *u.Visibility != AlbumPrivate {
+		invalid.add("visibility", fmt.Sprintf("must be %s or %s", AlbumPublic, AlbumPrivate))
+	}
+	return invalid.orNil()
+}
+
+// AlbumPhoto places a photo in an album
+// Albums list their photos descending by Rank, so photos added later get a lower rank than every photo already in it
+type AlbumPhoto struct {
+	ID      string  `bson:"_id" json:"_id"`
+	AlbumID string  `bson:"albumId" json:"albumId"`
+	PhotoID string  `bson:"photoId" json:"photoId"`
+	Rank    float64 `bson:"rank" json:"rank"`
+	// Metadata records who added the photo to the album and when
+	Metadata Metadata `bson:"metadata" json:"metadata"`
+	// Photo is only set in responses
+	Photo *Photo `bson:"-" json:"photo,omitempty"`
+}
+
+// PhotoUpdate is a partial update of the description of a photo, nil fields are left unchanged
+type PhotoUpdate struct {
+	Caption *string   `json:"caption"`
+	Tags    *[]string `json:"tags"`
+}
+
+// Apply sets the non-nil fields of u on photo
+func (u PhotoUpdate) Apply(photo *Photo) {
+	if u.Caption != nil {
+		photo.Caption = *u.Caption
+	}
+	if u.Tags != nil {
+		photo.Tags = *u.Tags
+	}
+}
+
+// normalize lowercases and trims the tags and drops duplicates, so they match regardless of how they were typed
+func (u *PhotoUpdate) normalize() {
+	if u.Tags == nil {
+		return
+	}
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range *u.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	u.Tags = &tags
+}
+
+// validate checks the fields that are set, tags are expected to be normalized
+func (u PhotoUpdate) validate() *ValidationError {
+	invalid := &ValidationError{}
+	if u.Caption != nil && utf8.RuneCountInString(*u.Caption) > maxCaptionLength {
+		invalid.add("caption", fmt.Sprintf("must be at most %d characters", maxCaptionLength))
+	}
+	if u.Tags != nil {
+		if len(*u.Tags) > maxPhotoTags {
+			invalid.add("tags", fmt.Sprintf("must have at most %d entries", maxPhotoTags))
+		}
+		for _, tag := range *u.Tags {
+			if !tagPattern.MatchString(tag) {
+				invalid.add("tags", fmt.Sprintf("%q must be 1 to 32 letters, digits or dashes", tag))
+				break
+			}
+		}
+	}
+	return invalid.orNil()
+}
+
+// orderRanks gives the photos of an album the ranks that list them in the order of photoIDs
+// It returns nil unless photoIDs holds every photo of the album, held lists them in their current order
+func orderRanks(held []string, photoIDs []string) map[string]float64 {
+	if len(held) != len(photoIDs) {
+		return nil
+	}
+	inAlbum := map[string]bool{}
+	for _, id := range held {
+		inAlbum[id] = true
+	}
+	ranks := map[string]float64{}
+	for i, id := range photoIDs {
+		if _, seen := ranks[id]; seen || !inAlbum[id] {
+			return nil
+		}
+		ranks[id] = float64(len(photoIDs) - i)
+	}
+	return ranks
+}
@@ -0,0 +1,642 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gguan/cwgcf_db/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// addAlbumPhotoRequest is the body of AddAlbumPhoto
+type addAlbumPhotoRequest struct {
+	PhotoID string `json:"photoId"`
+}
+
+// orderAlbumPhotosRequest is the body of OrderAlbumPhotos, it lists every photo of the album in the new order
+type orderAlbumPhotosRequest struct {
+	PhotoIDs []string `json:"photoIds"`
+}
+
+// moveAlbumPhotoRequest is the body of MoveAlbumPhoto
+type moveAlbumPhotoRequest struct {
+	AlbumID string `json:"albumId"`
+}
+
+// GetAlbums handles requests to list the albums the user may see, newest first, admins see every album
+// The owner query parameter limits them to the albums of one user
+func (s *AlbumServer) GetAlbums(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	userID := auth.UserID(r.Context())
+	role := RoleMember
+	var err error
+	if userID != "" {
+		role, err = s.Authorizer.Role(ctx, userID)
+	}
+	var albums []Album
+	if err == nil {
+		albums, err = s.Albums.GetAlbums(ctx, r.URL.Query().Get("owner"), userID, HasRole(role, RoleAdmin))
+	}
+	if err != nil {
+		log.Printf("Error getting albums: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get albums"}`))
+		return
+	}
+	for i, album := range albums {
+		albums[i] = album.withCoverURL()
+	}
+	res, _ := json.Marshal(albums)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// GetAlbum handles requests for one album, private albums are not found for other users than the owner and admins
+func (s *AlbumServer) GetAlbum(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	album, ok := s.loadAlbum(ctx, w, mux.Vars(r)["albumID"], false)
+	if !ok {
+		return
+	}
+	res, _ := json.Marshal(album.withCoverURL())
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// PostAlbum handles requests to create an album owned by the user, albums are public unless created private
+func (s *AlbumServer) PostAlbum(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var album Album
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&album)
+	if err != nil {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	if album.Visibility == "" {
+		album.Visibility = AlbumPublic
+	}
+	if invalid := validateAlbum(album); invalid != nil {
+		writeValidationError(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	userID := auth.UserID(r.Context())
+	now := time.Now().Unix()
+	album.OwnerID = userID
+	album.Metadata = Metadata{CreatedBy: userID, CreatedAt: now, UpdatedBy: userID, UpdatedAt: now}
+	album.ID, err = s.Albums.InsertAlbum(ctx, album)
+	if err != nil {
+		log.Printf("Failed to insert album: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to create album"}`))
+		return
+	}
+	res, _ := json.Marshal(album)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// PatchAlbum handles partial updates of an album by its owner or an admin, the cover has to be a photo of the album
+func (s *AlbumServer) PatchAlbum(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	albumID := mux.Vars(r)["albumID"]
+	var update AlbumUpdate
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&update)
+	if err != nil {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	if invalid := update.validate(); invalid != nil {
+		writeValidationError(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if _, ok := s.loadAlbum(ctx, w, albumID, true); !ok {
+		return
+	}
+	if update.CoverPhotoID != nil && *update.CoverPhotoID != "" {
+		_, err := s.Albums.GetAlbumPhoto(ctx, albumID, *update.CoverPhotoID)
+		if err == ErrNotFound {
+			invalid := &ValidationError{}
+			invalid.add("coverPhotoId", "is not in the album")
+			writeValidationError(w, invalid)
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting photo %s of album %s: %v", *update.CoverPhotoID, albumID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "Failed to update album"}`))
+			return
+		}
+	}
+	userID := auth.UserID(r.Context())
+	album, err := s.Albums.UpdateAlbum(ctx, albumID, update, Metadata{UpdatedBy: userID, UpdatedAt: time.Now().Unix()})
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update album %s: %v", albumID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to update album"}`))
+		return
+	}
+	res, _ := json.Marshal(album.withCoverURL())
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// DeleteAlbum handles requests to delete an album by its owner or an admin, its photos stay in the other albums and the library
+func (s *AlbumServer) DeleteAlbum(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	albumID := mux.Vars(r)["albumID"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if _, ok := s.loadAlbum(ctx, w, albumID, true); !ok {
+		return
+	}
+	err := s.Albums.DeleteAlbum(ctx, albumID)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete album %s: %v", albumID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to delete album"}`))
+		return
+	}
+	// The album is gone first so no photo is added to it after emptying it
+	removed, err := s.Albums.RemoveAllAlbumPhotos(ctx, albumID)
+	if err != nil {
+		log.Printf("Failed to remove the photos of deleted album %s: %v", albumID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to remove photos"}`))
+		return
+	}
+	log.Printf("User %s deleted album %s holding %d photos", auth.UserID(r.Context()), albumID, removed)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"deletedAlbumId": "%s", "removedPhotos": %d}`, albumID, removed)))
+}
+
+// GetAlbumPhotos handles requests for the photos of an album in their manual order
+// Pages are selected with the limit and cursor query parameters, the next cursor is sent in the X-Next-Cursor header
+func (s *AlbumServer) GetAlbumPhotos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	albumID := mux.Vars(r)["albumID"]
+
+	query := r.URL.Query()
+	limit, err := QueryInt64(query, "limit")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf(`{"error": "%v"}`, err)))
+		return
+	}
+	page, err := NewPageRequest(limit, query.Get("cursor"), s.Limits)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Invalid cursor"}`))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if _, ok := s.loadAlbum(ctx, w, albumID, false); !ok {
+		return
+	}
+	entries, next, err := s.Albums.GetAlbumPhotos(ctx, albumID, page)
+	if err == ErrInvalidCursor {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Invalid cursor"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting the photos of album %s: %v", albumID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get photos"}`))
+		return
+	}
+	photoIDs := []string{}
+	for _, entry := range entries {
+		photoIDs = append(photoIDs, entry.PhotoID)
+	}
+	photos, err := s.Photos.GetPhotos(ctx, photoIDs)
+	if err != nil {
+		log.Printf("Error getting photos: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get photos"}`))
+		return
+	}
+	res := []AlbumPhoto{}
+	for _, entry := range entries {
+		photo, ok := photos[entry.PhotoID]
+		if !ok {
+			log.Printf("Error getting photo %s of album %s: %v", entry.PhotoID, albumID, ErrNotFound)
+			continue
+		}
+		photo = photo.withURL()
+		entry.Photo = &photo
+		res = append(res, entry)
+	}
+
+	resBytes, _ := json.Marshal(res)
+	w.Header().Set("X-Next-Cursor", next.Encode())
+	w.Header().Set("X-Has-More", strconv.FormatBool(next != nil))
+	w.WriteHeader(http.StatusOK)
+	w.Write(resBytes)
+}
+
+// AddAlbumPhoto handles requests to put a photo at the end of an album, by the owner of the album or an admin
+func (s *AlbumServer) AddAlbumPhoto(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	albumID := mux.Vars(r)["albumID"]
+	var req addAlbumPhotoRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&req)
+	if err != nil {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if _, ok := s.loadAlbum(ctx, w, albumID, true); !ok {
+		return
+	}
+	photo, err := s.Photos.GetPhoto(ctx, req.PhotoID)
+	// Adding a private photo to a public album would publish it, so photos the user may not see are not found
+	var access photoAccess
+	if err == nil {
+		access, err = s.newPhotoAccess(ctx, []string{photo.ID})
+	}
+	if err == nil && !access.canSee(photo) {
+		err = ErrNotFound
+	}
+	if err == ErrNotFound {
+		invalid := &ValidationError{}
+		invalid.add("photoId", "is no photo")
+		writeValidationError(w, invalid)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting photo %s: %v", req.PhotoID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to add photo"}`))
+		return
+	}
+	userID := auth.UserID(r.Context())
+	now := time.Now().Unix()
+	entry, err := s.Albums.AddAlbumPhoto(ctx, AlbumPhoto{
+		AlbumID:  albumID,
+		PhotoID:  photo.ID,
+		Metadata: Metadata{CreatedBy: userID, CreatedAt: now, UpdatedBy: userID, UpdatedAt: now},
+	})
+	if err == ErrConflict {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": "The photo is in the album already"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to add photo %s to album %s: %v", photo.ID, albumID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to add photo"}`))
+		return
+	}
+	photo = photo.withURL()
+	entry.Photo = &photo
+	res, _ := json.Marshal(entry)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// OrderAlbumPhotos handles requests to put the photos of an album in a new order
+// The body lists every photo of the album exactly once, so a concurrent change of the album fails validation
+func (s *AlbumServer) OrderAlbumPhotos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	albumID := mux.Vars(r)["albumID"]
+	var req orderAlbumPhotosRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&req)
+	if err != nil {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if _, ok := s.loadAlbum(ctx, w, albumID, true); !ok {
+		return
+	}
+	held, err := s.Albums.GetAlbumPhotoIDs(ctx, albumID)
+	if err != nil {
+		log.Printf("Error getting the photos of album %s: %v", albumID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to order photos"}`))
+		return
+	}
+	ranks := orderRanks(held, req.PhotoIDs)
+	if ranks == nil {
+		invalid := &ValidationError{}
+		invalid.add("photoIds", fmt.Sprintf("must list each of the %d photos of the album once", len(held)))
+		writeValidationError(w, invalid)
+		return
+	}
+	if err := s.Albums.SetAlbumPhotoRanks(ctx, albumID, ranks); err != nil {
+		log.Printf("Failed to order the photos of album %s: %v", albumID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to order photos"}`))
+		return
+	}
+	res, _ := json.Marshal(req)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// MoveAlbumPhoto handles requests to move a photo to the end of another album
+// The user has to be allowed to change both albums, the photo stops being the cover of the album it leaves
+func (s *AlbumServer) MoveAlbumPhoto(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	albumID, photoID := vars["albumID"], vars["photoID"]
+	var req moveAlbumPhotoRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&req)
+	if err != nil {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	if req.AlbumID == albumID {
+		invalid := &ValidationError{}
+		invalid.add("albumId", "must be another album")
+		writeValidationError(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if _, ok := s.loadAlbum(ctx, w, albumID, true); !ok {
+		return
+	}
+	if _, ok := s.loadAlbum(ctx, w, req.AlbumID, true); !ok {
+		return
+	}
+	entry, err := s.Albums.MoveAlbumPhoto(ctx, albumID, photoID, req.AlbumID)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err == ErrConflict {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": "The photo is in the other album already"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to move photo %s from album %s to %s: %v", photoID, albumID, req.AlbumID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to move photo"}`))
+		return
+	}
+	if err := s.Albums.ClearAlbumCover(ctx, albumID, photoID); err != nil {
+		log.Printf("Failed to clear the cover of album %s: %v", albumID, err)
+	}
+	res, _ := json.Marshal(entry)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// RemoveAlbumPhoto handles requests to take a photo out of an album, the photo itself is kept
+func (s *AlbumServer) RemoveAlbumPhoto(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	albumID, photoID := vars["albumID"], vars["photoID"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if _, ok := s.loadAlbum(ctx, w, albumID, true); !ok {
+		return
+	}
+	err := s.Albums.RemoveAlbumPhoto(ctx, albumID, photoID)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to remove photo %s from album %s: %v", photoID, albumID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to remove photo"}`))
+		return
+	}
+	if err := s.Albums.ClearAlbumCover(ctx, albumID, photoID); err != nil {
+		log.Printf("Failed to clear the cover of album %s: %v", albumID, err)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"albumId": "%s", "removedPhotoId": "%s"}`, albumID, photoID)))
+}
+
+// PatchPhoto handles updates of the caption and tags of a photo, by its uploader or a moderator
+func (s *AlbumServer) PatchPhoto(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	photoID := mux.Vars(r)["photoID"]
+	var update PhotoUpdate
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&update)
+	if err != nil {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	update.normalize()
+	if invalid := update.validate(); invalid != nil {
+		writeValidationError(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	photo, err := s.Photos.GetPhoto(ctx, photoID)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting photo %s: %v", photoID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to update photo"}`))
+		return
+	}
+	if !s.Authorizer.AuthorizeOwner(w, r, photo.UserID, RoleModerator) {
+		return
+	}
+	userID := auth.UserID(r.Context())
+	photo, err = s.Photos.UpdatePhoto(ctx, photoID, update, Metadata{UpdatedBy: userID, UpdatedAt: time.Now().Unix()})
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update photo %s: %v", photoID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to update photo"}`))
+		return
+	}
+	res, _ := json.Marshal(photo.withURL())
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// loadAlbum returns an album the user may see, or may change with modify, and responds otherwise
+// Private albums are not found for users who may not see them, so their existence is not revealed
+func (s *AlbumServer) loadAlbum(ctx context.Context, w http.ResponseWriter, id string, modify bool) (Album, bool) {
+	album, err := s.Albums.GetAlbum(ctx, id)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return Album{}, false
+	}
+	if err != nil {
+		log.Printf("Error getting album %s: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return Album{}, false
+	}
+	if album.Visibility == AlbumPublic && !modify {
+		return album, true
+	}
+	ok, err := s.Authorizer.CanModify(ctx, album.OwnerID, RoleAdmin)
+	if err != nil {
+		log.Printf("Error getting role: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return Album{}, false
+	}
+	if ok {
+		return album, true
+	}
+	if album.Visibility != AlbumPublic {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return Album{}, false
+	}
+	WriteForbidden(w, fmt.Sprintf("owner or %s role required", RoleAdmin))
+	return Album{}, false
+}
+
+// photoAccess tells which of a set of photos the requesting user may see
+// Photos only in private albums are hidden like those albums, from everyone but their uploader, the album owners and admins
+type photoAccess struct {
+	// private maps the photos only in private albums to the owners of those albums
+	private  map[string][]string
+	viewerID string
+	admin    bool
+}
+
+// newPhotoAccess looks up which of photoIDs are private, the role of the user is only looked up when some are
+func (s *AlbumServer) newPhotoAccess(ctx context.Context, photoIDs []string) (photoAccess, error) {
+	access := photoAccess{viewerID: auth.UserID(ctx)}
+	private, err := s.Albums.GetPrivatePhotos(ctx, photoIDs)
+	if err != nil {
+		return access, err
+	}
+	access.private = private
+	if len(private) > 0 && access.viewerID != "" {
+		role, err := s.Authorizer.Role(ctx, access.viewerID)
+		if err != nil {
+			return access, err
+		}
+		access.admin = HasRole(role, RoleAdmin)
+	}
+	return access, nil
+}
+
+// isPrivate tells whether photo is only in private albums
+func (a photoAccess) isPrivate(photo Photo) bool {
+	_, ok := a.private[photo.ID]
+	return ok
+}
+
+// canSee tells whether the user may see photo
+func (a photoAccess) canSee(photo Photo) bool {
+	owners, ok := a.private[photo.ID]
+	if !ok || a.admin {
+		return true
+	}
+	if a.viewerID == "" {
+		return false
+	}
+	if a.viewerID == photo.UserID {
+		return true
+	}
+	for _, owner := range owners {
+		if a.viewerID == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// visiblePhotos returns the photos the user may see, in their order
+func (s *AlbumServer) visiblePhotos(ctx context.Context, photos []Photo) ([]Photo, error) {
+	ids := []string{}
+	for _, photo := range photos {
+		ids = append(ids, photo.ID)
+	}
+	access, err := s.newPhotoAccess(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	res := []Photo{}
+	for _, photo := range photos {
+		if access.canSee(photo) {
+			res = append(res, photo)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"gguan/cwgcf_db/imaging"
+	"image"
+)
+
+// photoHashBands is the number of bytes DHash is split into for looking up similar photos
+// Two hashes differing in fewer bits than that share at least one byte, see config.MaxDuplicateDistance
+const photoHashBands = 8
+
+// NewPhotoHashes hashes the uploaded bytes of a photo and the picture they decode to
+func NewPhotoHashes(data []byte, img image.Image) PhotoHashes {
+	sum := sha256.Sum256(data)
+	hash := imaging.DHash(img)
+	bands := make([]int64, photoHashBands)
+	for i := range bands {
+		// The position keeps equal bytes at different places apart
+		bands[i] = int64(i)<<8 | int64(hash>>(8*uint(i))&0xff)
+	}
+	return PhotoHashes{
+		SHA256:     hex.EncodeToString(sum[:]),
+		DHash:      int64(hash),
+		DHashBands: bands,
+	}
+}
+
+// FindDuplicatePhoto returns the photo userID uploaded before that hashes shows to be the same picture
+// Identical bytes always match, otherwise the photo with the closest perceptual hash at most distance bits away
+// It returns ErrNotFound when there is none
+func FindDuplicatePhoto(ctx context.Context, photos PhotoRepository, userID string, hashes PhotoHashes, distance int) (Photo, error) {
+	photo, err := photos.GetPhotoBySHA256(ctx, userID, hashes.SHA256)
+	if err != ErrNotFound || distance < 0 {
+		return photo, err
+	}
+	candidates, err := photos.GetSimilarPhotos(ctx, userID, hashes.DHashBands)
+	if err != nil {
+		return Photo{}, err
+	}
+	best, bestDistance := Photo{}, distance+1
+	for _, candidate := range candidates {
+		if d := imaging.Distance(uint64(candidate.DHash), uint64(hashes.DHash)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best.ID == "" {
+		return Photo{}, ErrNotFound
+	}
+	return best, nil
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gguan/cwgcf_db/imaging"
+	"image"
+	"log"
+	"strconv"
+)
+
+// uprightUpload is an upload ready to be stored
+type uprightUpload struct {
+	// data are the bytes to store, the upload unless it had to be turned or lose its location
+	data []byte
+	// img is the picture turned upright
+	img  image.Image
+	exif PhotoEXIF
+}
+
+// prepareUpload reads the EXIF data of an uploaded JPEG, turns its picture upright and removes its location unless keepGPS
+// Turned photos are encoded again and so lose all their metadata, as do JPEGs whose metadata cannot be read or stripped
+// Other formats are stored as they are
+func prepareUpload(data []byte, contentType string, img image.Image, keepGPS bool) (uprightUpload, error) {
+	upload := uprightUpload{data: data, img: img}
+	if contentType != "image/jpeg" {
+		return upload, nil
+	}
+	meta, err := imaging.ReadEXIF(data)
+	if err != nil && err != imaging.ErrNoEXIF {
+		// Where the location is cannot be told either, so none of the metadata is kept
+		log.Printf("Dropping unreadable EXIF data of an upload: %v", err)
+		upload.data, err = encodeOriginal(img)
+		return upload, err
+	}
+	if err == nil {
+		upload.exif = PhotoEXIF{
+			Orientation: meta.Orientation,
+			Make:        meta.Make,
+			Model:       meta.Model,
+		}
+		if !meta.TakenAt.IsZero() {
+			upload.exif.TakenAt = meta.TakenAt.Unix()
+		}
+		if meta.Orientation != 1 {
+			upload.img = imaging.Orient(img, meta.Orientation)
+			upload.data, err = encodeOriginal(upload.img)
+			return upload, err
+		}
+	}
+	if keepGPS {
+		return upload, nil
+	}
+	// XMP data may hold a location too, so uploads are stripped even without one in their EXIF data
+	stripped, err := imaging.StripGPS(data)
+	if err != nil {
+		log.Printf("Dropping EXIF data of an upload whose location cannot be stripped: %v", err)
+		upload.data, err = encodeOriginal(img)
+		return upload, err
+	}
+	upload.data = stripped
+	return upload, nil
+}
+
+func encodeOriginal(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := imaging.EncodeOriginal(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// idTime returns the unix time an ObjectID hex string was created at, 0 for other ids
+func idTime(id string) int64 {
+	if len(id) != 24 {
+		return 0
+	}
+	seconds, err := strconv.ParseInt(id[:8], 16, 64)
+	if err != nil {
+		return 0
+	}
+	return seconds
+}
+
+// BackfillPhotoTakenAt sets the TakenAt of photos added before it existed to when they were added
+// Photos without metadata, which were added by url, fall back to the creation time of their id
+func BackfillPhotoTakenAt(ctx context.Context, store *Store) (int, error) {
+	photos, err := store.Photos.GetAllPhotos(ctx)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, photo := range photos {
+		if photo.TakenAt != 0 {
+			continue
+		}
+		takenAt := photo.Metadata.CreatedAt
+		if takenAt == 0 {
+			takenAt = idTime(photo.ID)
+		}
+		if takenAt == 0 {
+			continue
+		}
+		err := store.Photos.SetPhotoTakenAt(ctx, photo.ID, takenAt)
+		// Deleted since it was listed
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return changed, fmt.Errorf("setting the time of photo %s: %v", photo.ID, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
@@ -0,0 +1,209 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gguan/cwgcf_db/blob"
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/imaging"
+	"image"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	// photoQueueSize bounds the uploads waiting for their variants, later ones are left to the process-photos command
+	photoQueueSize = 256
+	// photoProcessTimeout bounds generating the variants of one photo
+	photoProcessTimeout = 2 * time.Minute
+)
+
+// PhotoWorker generates the configured variants of uploaded photos
+type PhotoWorker struct {
+	Photos   PhotoRepository
+	Blobs    blob.Store
+	Variants []config.PhotoVariant
+	// MaxPixels bounds the photos decoded, uploads are checked against it already but older photos were not
+	MaxPixels int64
+	queue     chan string
+}
+
+// NewPhotoWorker creates a new PhotoWorker, Run has to be started for Enqueue to have an effect
+func NewPhotoWorker(store *Store, cfg *config.Config, blobs blob.Store) *PhotoWorker {
+	return &PhotoWorker{
+		Photos:    store.Photos,
+		Blobs:     blobs,
+		Variants:  cfg.Photos.Variants,
+		MaxPixels: cfg.Photos.MaxPixels,
+		queue:     make(chan string, photoQueueSize),
+	}
+}
+
+// Enqueue schedules the variants of a photo without blocking
+// When the queue is full the photo is skipped, the process-photos command catches up on it
+func (w *PhotoWorker) Enqueue(id string) {
+	select {
+	case w.queue <- id:
+	default:
+		log.Printf("Photo queue is full, run process-photos to generate the variants of %s", id)
+	}
+}
+
+// Run processes queued photos one at a time until ctx is done, photos still queued then are left to process-photos
+func (w *PhotoWorker) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-w.queue:
+			if err := w.process(ctx, id); err != nil {
+				log.Printf("Error generating the variants of photo %s: %v", id, err)
+			}
+		}
+	}
+}
+
+func (w *PhotoWorker) process(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, photoProcessTimeout)
+	defer cancel()
+	photo, err := w.Photos.GetPhoto(ctx, id)
+	if err != nil {
+		return err
+	}
+	return w.ProcessPhoto(ctx, photo)
+}
+
+// ProcessPhoto generates every configured variant of an uploaded photo and replaces its stored variants
+// Variants are stored under the key of the photo suffixed with their name, so processing again overwrites them
+// and the blobs of variants no longer configured are deleted. Photos uploaded before hashes existed get them too
+func (w *PhotoWorker) ProcessPhoto(ctx context.Context, photo Photo) error {
+	if photo.BlobKey == "" {
+		return nil
+	}
+	file, err := w.Blobs.Open(ctx, photo.BlobKey)
+	if err != nil {
+		return fmt.Errorf("opening original: %v", err)
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("reading original: %v", err)
+	}
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decoding original: %v", err)
+	}
+	if pixels := int64(imageConfig.Width) * int64(imageConfig.Height); pixels > w.MaxPixels {
+		return fmt.Errorf("original has %d pixels, more than photos.maxPixels", pixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decoding original: %v", err)
+	}
+	if photo.SHA256 == "" {
+		if err := w.Photos.SetPhotoHashes(ctx, photo.ID, NewPhotoHashes(data, img)); err != nil {
+			return fmt.Errorf("saving hashes: %v", err)
+		}
+	}
+
+	contentType := imaging.ResizedType(photo.ContentType)
+	variants := []PhotoVariant{}
+	for _, size := range w.Variants {
+		resized := imaging.Fit(img, size.MaxSize)
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, resized, contentType); err != nil {
+			return fmt.Errorf("encoding %s: %v", size.Name, err)
+		}
+		variant := PhotoVariant{
+			Name:        size.Name,
+			BlobKey:     photo.BlobKey + "-" + size.Name,
+			ContentType: contentType,
+			Size:        int64(buf.Len()),
+			Width:       resized.Bounds().Dx(),
+			Height:      resized.Bounds().Dy(),
+		}
+		if err := w.Blobs.Put(ctx, variant.BlobKey, &buf); err != nil {
+			return fmt.Errorf("storing %s: %v", size.Name, err)
+		}
+		variants = append(variants, variant)
+	}
+	if err := w.Photos.SetPhotoVariants(ctx, photo.ID, variants); err != nil {
+		// A photo deleted meanwhile would leave the new variants behind
+		if err == ErrNotFound {
+			w.deleteVariants(ctx, photo.ID, variants)
+		}
+		return fmt.Errorf("saving variants: %v", err)
+	}
+	stale := []PhotoVariant{}
+	for _, old := range photo.Variants {
+		if !hasVariant(variants, old.Name) {
+			stale = append(stale, old)
+		}
+	}
+	w.deleteVariants(ctx, photo.ID, stale)
+	return nil
+}
+
+// deleteVariants deletes the blobs of variants of a photo, failures are logged
+func (w *PhotoWorker) deleteVariants(ctx context.Context, photoID string, variants []PhotoVariant) {
+	for _, variant := range variants {
+		if err := w.Blobs.Delete(ctx, variant.BlobKey); err != nil {
+			log.Printf("Error deleting the %s variant of photo %s: %v", variant.Name, photoID, err)
+		}
+	}
+}
+
+// ProcessPhotos generates the variants of the uploaded photos missing a configured one or their hashes, or of every uploaded photo with all
+// It returns the number of photos processed, a photo that fails is logged and skipped
+func (w *PhotoWorker) ProcessPhotos(ctx context.Context, all bool) (int, error) {
+	photos, err := w.Photos.GetAllPhotos(ctx)
+	if err != nil {
+		return 0, err
+	}
+	processed, failed := 0, 0
+	for _, photo := range photos {
+		if photo.BlobKey == "" || (!all && w.complete(photo)) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return processed, err
+		}
+		pctx, cancel := context.WithTimeout(ctx, photoProcessTimeout)
+		err := w.ProcessPhoto(pctx, photo)
+		cancel()
+		if err != nil {
+			log.Printf("Error generating the variants of photo %s: %v", photo.ID, err)
+			failed++
+			continue
+		}
+		processed++
+	}
+	if failed > 0 {
+		return processed, fmt.Errorf("%d photos failed", failed)
+	}
+	return processed, nil
+}
+
+// complete reports whether a photo has its hashes and every configured variant
+func (w *PhotoWorker) complete(photo Photo) bool {
+	if photo.SHA256 == "" {
+		return false
+	}
+	for _, size := range w.Variants {
+		if !hasVariant(photo.Variants, size.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasVariant(variants []PhotoVariant, name string) bool {
+	for _, variant := range variants {
+		if variant.Name == name {
+			return true
+		}
+	}
+	return false
+}
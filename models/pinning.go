@@ -0,0 +1,126 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gguan/cwgcf_db/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// Pin is set on posts by moderators, pinned posts are listed before the ranked ones on the first page of the feeds
+// Metadata.CreatedBy/CreatedAt record who pinned the post, UpdatedBy/UpdatedAt who last pinned or unpinned it
+type Pin struct {
+	Pinned bool `bson:"pinned" json:"pinned"`
+	// Order sorts pinned posts ascending, posts with the same order are listed latest pinned first
+	Order int `bson:"order" json:"order"`
+	// ExpiresAt is the unix time the pin ends at, 0 means never
+	ExpiresAt int64    `bson:"expiresAt" json:"expiresAt"`
+	Metadata  Metadata `bson:"metadata" json:"metadata"`
+}
+
+// Active reports whether the pin is in effect at the unix time at
+func (p *Pin) Active(at int64) bool {
+	return p != nil && p.Pinned && (p.ExpiresAt == 0 || p.ExpiresAt > at)
+}
+
+// PinPostRequest is the request definition of a pin request, an empty body pins with order 0 and no expiry
+type PinPostRequest struct {
+	Order     int   `json:"order"`
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// Validate lists every invalid field of the request
+func (r PinPostRequest) Validate(now int64) *ValidationError {
+	invalid := &ValidationError{}
+	if r.Order < 0 {
+		invalid.add("order", "must not be negative")
+	}
+	if r.ExpiresAt != 0 && r.ExpiresAt <= now {
+		invalid.add("expiresAt", "must be in the future, or 0 to never expire")
+	}
+	return invalid.orNil()
+}
+
+// PinPost handles requests to pin a v1 or v2 post, pinning a pinned post again replaces its order and expiry
+func (s *ModerationServer) PinPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	postID := mux.Vars(r)["postID"]
+	var request PinPostRequest
+
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil && err != io.EOF {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	now := time.Now().Unix()
+	if invalid := request.Validate(now); invalid != nil {
+		writeValidationError(w, invalid)
+		return
+	}
+	moderatorID := auth.UserID(r.Context())
+	pin := Pin{
+		Pinned:    true,
+		Order:     request.Order,
+		ExpiresAt: request.ExpiresAt,
+		Metadata: Metadata{
+			CreatedBy: moderatorID,
+			CreatedAt: now,
+			UpdatedBy: moderatorID,
+			UpdatedAt: now,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	err = s.Posts.PinPost(ctx, postID, pin)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to pin post %s: %v", postID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to pin post"}`))
+		return
+	}
+	log.Printf("Moderator %s pinned post %s with order %d", moderatorID, postID, pin.Order)
+	res, _ := json.Marshal(pin)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"id": "%s", "pin": %s}`, postID, res)))
+}
+
+// UnpinPost handles requests to unpin a post, the pin is kept with Pinned false to record who unpinned it
+func (s *ModerationServer) UnpinPost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	postID := mux.Vars(r)["postID"]
+	moderatorID := auth.UserID(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	err := s.Posts.UnpinPost(ctx, postID, moderatorID, time.Now().Unix())
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to unpin post %s: %v", postID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to unpin post"}`))
+		return
+	}
+	log.Printf("Moderator %s unpinned post %s", moderatorID, postID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"id": "%s", "pinned": false}`, postID)))
+}
@@ -0,0 +1,235 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gguan/cwgcf_db/config"
+)
+
+// DeletedUserID is the author of posts and comments whose profile was deleted with the anonymize policy
+const DeletedUserID = "deleted"
+
+// deletedProfile is shown as the author of anonymized posts and comments
+var deletedProfile = Profile{ID: DeletedUserID, Name: "[deleted]"}
+
+// Maximum lengths of profile fields, in characters
+const (
+	maxNameLength        = 50
+	maxTitleLength       = 100
+	maxDescriptionLength = 1000
+	maxAvatarURLLength   = 2048
+)
+
+// ProfileUpdate is a partial update of a profile, nil fields are left unchanged
+type ProfileUpdate struct {
+	Name        *string `json:"name"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	AvatarURL   *string `json:"avatarUrl"`
+}
+
+// Apply sets the non-nil fields of u on profile
+func (u ProfileUpdate) Apply(profile *Profile) {
+	if u.Name != nil {
+		profile.Name = *u.Name
+	}
+	if u.Title != nil {
+		profile.Title = *u.Title
+	}
+	if u.Description != nil {
+		profile.Description = *u.Description
+	}
+	if u.AvatarURL != nil {
+		profile.AvatarURL = *u.AvatarURL
+	}
+}
+
+// validateProfile checks the fields of a new profile
+func validateProfile(profile Profile) *ValidationError {
+	return ProfileUpdate{
+		Name:        &profile.Name,
+		Title:       &profile.Title,
+		Description: &profile.Description,
+		AvatarURL:   &profile.AvatarURL,
+	}.validate()
+}
+
+// validate checks the fields that are set
+func (u ProfileUpdate) validate() *ValidationError {
+	invalid := &ValidationError{}
+	if u.Name != nil && strings.TrimSpace(*u.Name) == "" {
+		invalid.add("name", "is required")
+	}
+	lengths := []struct {
+		field string
+		value *string
+		max   int
+	}{
+		{"name", u.Name, maxNameLength},
+		{"title", u.Title, maxTitleLength},
+		{"description", u.Description, maxDescriptionLength},
+		{"avatarUrl", u.AvatarURL, maxAvatarURLLength},
+	}
+	for _, length := range lengths {
+		if length.value != nil && utf8.RuneCountInString(*length.value) > length.max {
+			invalid.add(length.field, fmt.Sprintf("must be at most %d characters", length.max))
+		}
+	}
+	if u.AvatarURL != nil && strings.ContainsAny(*u.AvatarURL, " \t\r\n") {
+		invalid.add("avatarUrl", "must not contain whitespace")
+	}
+	return invalid.orNil()
+}
+
+// deleteUserContent applies the delete policy to the posts, comments and votes of a user
+func (s *ProfileServer) deleteUserContent(ctx context.Context, userID string) error {
+	// Moderating a sub is no content to keep, the user leaves every sub under both policies
+	if err := s.SubForums.RemoveSubForumModerator(ctx, userID); err != nil {
+		return fmt.Errorf("removing sub moderator: %v", err)
+	}
+	switch s.DeletePolicy {
+	case config.DeletePolicyCascade:
+		return s.cascadeUserContent(ctx, userID)
+	default:
+		return s.anonymizeUserContent(ctx, userID)
+	}
+}
+
+// anonymizeUserContent keeps posts, comments and vote counts but unlinks them from the user
+// Vote maps move to a random id rather than DeletedUserID so each stays a separate voter
+func (s *ProfileServer) anonymizeUserContent(ctx context.Context, userID string) error {
+	if err := s.Posts.ReassignUserPosts(ctx, userID, DeletedUserID); err != nil {
+		return fmt.Errorf("anonymizing posts: %v", err)
+	}
+	if err := s.Comments.ReassignUserComments(ctx, userID, DeletedUserID); err != nil {
+		return fmt.Errorf("anonymizing comments: %v", err)
+	}
+	if err := s.Revisions.ReassignUserRevisions(ctx, userID, DeletedUserID); err != nil {
+		return fmt.Errorf("anonymizing revisions: %v", err)
+	}
+	voterID, err := anonymousVoterID()
+	if err != nil {
+		return err
+	}
+	if err := s.VoteMaps.ReassignVoteMap(ctx, userID, voterID); err != nil {
+		return fmt.Errorf("anonymizing vote map: %v", err)
+	}
+	if err := s.UserVotes.ReassignUserVotes(ctx, userID, voterID); err != nil {
+		return fmt.Errorf("anonymizing user votes: %v", err)
+	}
+	if err := s.VoteEvents.ReassignUserVoteEvents(ctx, userID, voterID); err != nil {
+		return fmt.Errorf("anonymizing vote events: %v", err)
+	}
+	return nil
+}
+
+// cascadeUserContent withdraws the votes of a user, then deletes their comments with the replies below them,
+// and their posts with every comment of those posts, along with the vote objects of all of them
+func (s *ProfileServer) cascadeUserContent(ctx context.Context, userID string) error {
+	if err := s.withdrawVoteMap(ctx, userID); err != nil {
+		return fmt.Errorf("withdrawing votes: %v", err)
+	}
+	if err := s.withdrawUserVotes(ctx, userID); err != nil {
+		return fmt.Errorf("withdrawing user votes: %v", err)
+	}
+	// The withdrawn votes no longer count, so they leave the windowed scores with their history
+	if err := s.VoteEvents.DeleteUserVoteEvents(ctx, userID); err != nil {
+		return fmt.Errorf("deleting vote events: %v", err)
+	}
+	commentVoteIDs, err := s.Comments.DeleteUserComments(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("deleting comments: %v", err)
+	}
+	postIDs, voteIDs, err := s.Posts.DeleteUserPosts(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("deleting posts: %v", err)
+	}
+	threadVoteIDs, err := s.Comments.DeleteCommentsByPosts(ctx, postIDs)
+	if err != nil {
+		return fmt.Errorf("deleting comments of posts: %v", err)
+	}
+	voteIDs = append(append(voteIDs, commentVoteIDs...), threadVoteIDs...)
+	if err := s.Votes.DeleteVotes(ctx, voteIDs); err != nil {
+		return fmt.Errorf("deleting votes of posts and comments: %v", err)
+	}
+	// Only authors revise, so this covers the revisions of their posts and comments
+	if err := s.Revisions.DeleteUserRevisions(ctx, userID); err != nil {
+		return fmt.Errorf("deleting revisions: %v", err)
+	}
+	return nil
+}
+
+// withdrawVoteMap deletes the v2 vote map of a user and takes their votes out of the tallies
+func (s *ProfileServer) withdrawVoteMap(ctx context.Context, userID string) error {
+	voteMap, err := s.VoteMaps.DeleteVoteMap(ctx, userID)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(voteMap.VoteMap))
+	for id := range voteMap.VoteMap {
+		ids = append(ids, id)
+	}
+	// IncVote creates missing votes, so only existing ones are decremented
+	votes, err := s.Votes.GetVotes(ctx, ids)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for id := range votes {
+		if status := voteMap.VoteMap[id].VoteStatus; status != 0 {
+			if err := s.Votes.IncVote(ctx, id, TallyChange(status, VoteNone), now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// withdrawUserVotes deletes the v1 votes of a user and takes them out of the forumVotes of posts and comments
+func (s *ProfileServer) withdrawUserVotes(ctx context.Context, userID string) error {
+	userVotes, err := s.UserVotes.DeleteUserVotes(ctx, userID)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// v1 votes do not record whether they target a post or a comment, and the Inc methods create missing targets
+	for id, vote := range userVotes.VoteMap {
+		if vote.VoteStatus == 0 {
+			continue
+		}
+		change := TallyChange(vote.VoteStatus, VoteNone)
+		if _, err := s.Posts.GetPost(ctx, id); err == nil {
+			err = s.Posts.IncPostVotes(ctx, id, change)
+		} else if err == ErrNotFound {
+			if _, err = s.Comments.GetComment(ctx, id); err == nil {
+				err = s.Comments.IncCommentVotes(ctx, id, change)
+			} else if err == ErrNotFound {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// anonymousVoterID returns a fresh id for the votes of a deleted user
+func anonymousVoterID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return DeletedUserID + "-" + hex.EncodeToString(b), nil
+}
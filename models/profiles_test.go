@@ -0,0 +1,171 @@
+package models_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/models"
+	"gguan/cwgcf_db/storage/memory"
+)
+
+// userContent is what TestDeletePolicies stores for the deleted user and another one
+type userContent struct {
+	goneID, staysID string
+	// gonePost is a v1 post of the deleted user with a comment of the other user below it
+	gonePost, staysComment string
+	// goneComment is a v1 comment of the deleted user on staysPost, with a reply of the other user
+	staysPost, goneComment, staysReply string
+	// goneDBPost and staysDBPost are v2 posts voted on by the other user
+	goneDBPost, staysDBPost models.DBForumPost
+}
+
+func seedUserContent(t *testing.T, store *models.Store) userContent {
+	ctx := context.Background()
+	c := userContent{}
+	var err error
+	c.goneID, err = store.Profiles.InsertProfile(ctx, models.Profile{Name: "gone"})
+	check(t, err)
+	c.staysID, err = store.Profiles.InsertProfile(ctx, models.Profile{Name: "stays"})
+	check(t, err)
+	_, err = store.SubForums.InsertSubForum(ctx, models.SubForum{Slug: "open", Name: "Open", Moderators: []string{c.goneID, c.staysID}})
+	check(t, err)
+
+	c.gonePost, err = store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", UserID: c.goneID, CreatedAt: 1600000000})
+	check(t, err)
+	c.staysComment, err = store.Comments.InsertComment(ctx, models.ForumComment{ParentID: c.gonePost, PostID: c.gonePost, UserID: c.staysID, CreatedAt: 1600000000})
+	check(t, err)
+	c.staysPost, err = store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", UserID: c.staysID, CreatedAt: 1600000000})
+	check(t, err)
+	c.goneComment, err = store.Comments.InsertComment(ctx, models.ForumComment{ParentID: c.staysPost, PostID: c.staysPost, UserID: c.goneID, CreatedAt: 1600000000})
+	check(t, err)
+	c.staysReply, err = store.Comments.InsertComment(ctx, models.ForumComment{ParentID: c.goneComment, PostID: c.staysPost, Path: []string{c.goneComment}, UserID: c.staysID, CreatedAt: 1600000000})
+	check(t, err)
+	// Both vote on the post of the other user in v1
+	for _, vote := range []struct{ userID, postID string }{{c.goneID, c.staysPost}, {c.staysID, c.gonePost}} {
+		check(t, store.UserVotes.SetUserVote(ctx, vote.userID, vote.postID, models.VoteUp))
+		check(t, store.Posts.IncPostVotes(ctx, vote.postID, models.TallyChange(models.VoteNone, models.VoteUp)))
+	}
+
+	c.goneDBPost = models.DBForumPost{Title: "t", UserID: c.goneID, VoteID: insertVote(t, store), Metadata: models.Metadata{CreatedAt: 1600000000}}
+	c.staysDBPost = models.DBForumPost{Title: "t", UserID: c.staysID, VoteID: insertVote(t, store), Metadata: models.Metadata{CreatedAt: 1600000000}}
+	for _, post := range []*models.DBForumPost{&c.goneDBPost, &c.staysDBPost} {
+		post.ID, err = store.Posts.InsertDBPost(ctx, *post)
+		check(t, err)
+	}
+	// Both vote up the v2 post of the other user, the other user votes their own post down
+	for _, vote := range []struct {
+		userID, voteID string
+		status         int
+	}{
+		{c.goneID, c.staysDBPost.VoteID, models.VoteUp},
+		{c.staysID, c.goneDBPost.VoteID, models.VoteUp},
+		{c.staysID, c.staysDBPost.VoteID, models.VoteDown},
+	} {
+		_, err := store.Votes.ApplyVote(ctx, vote.userID, vote.voteID, vote.status, models.Metadata{UpdatedAt: 1600000000})
+		check(t, err)
+	}
+	return c
+}
+
+func TestDeletePolicies(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		policy string
+		// check looks at the content left once the profile is deleted
+		check func(t *testing.T, store *models.Store, c userContent)
+	}{
+		{config.DeletePolicyAnonymize, func(t *testing.T, store *models.Store, c userContent) {
+			post, err := store.Posts.GetPost(ctx, c.gonePost)
+			if err != nil || post.UserID != models.DeletedUserID {
+				t.Errorf("anonymized post %+v: %v", post, err)
+			}
+			comment, err := store.Comments.GetComment(ctx, c.goneComment)
+			if err != nil || comment.UserID != models.DeletedUserID {
+				t.Errorf("anonymized comment %+v: %v", comment, err)
+			}
+			dbPost, err := store.Posts.GetDBPost(ctx, c.goneDBPost.ID)
+			if err != nil || dbPost.UserID != models.DeletedUserID {
+				t.Errorf("anonymized v2 post %+v: %v", dbPost, err)
+			}
+			// The votes still count, cast by an anonymous voter
+			if post, err := store.Posts.GetPost(ctx, c.staysPost); err != nil || post.ForumVotes.Tally() != (models.VoteTally{Sum: 1, Up: 1}) {
+				t.Errorf("v1 votes after anonymizing %+v: %v", post.ForumVotes, err)
+			}
+			if vote, err := store.Votes.GetVote(ctx, c.staysDBPost.VoteID); err != nil || vote.Tally() != (models.VoteTally{Sum: 0, Up: 1, Down: 1}) {
+				t.Errorf("v2 votes after anonymizing %+v: %v", vote, err)
+			}
+			for _, id := range []string{c.staysPost, c.staysDBPost.VoteID} {
+				voters, err := store.VoteEvents.GetVoters(ctx, id)
+				for _, voter := range voters {
+					if voter.UserID == c.goneID || voter.UserID == models.DeletedUserID {
+						t.Errorf("voter %+v of %s is not anonymous: %v", voter, id, err)
+					}
+				}
+			}
+		}},
+		{config.DeletePolicyCascade, func(t *testing.T, store *models.Store, c userContent) {
+			// The posts of the user go with their threads, the comments of the user with their replies
+			for _, id := range []string{c.gonePost, c.goneDBPost.ID} {
+				if _, err := store.Posts.GetPost(ctx, id); err != models.ErrNotFound {
+					t.Errorf("post %s is kept: %v", id, err)
+				}
+				if _, err := store.Posts.GetDBPost(ctx, id); err != models.ErrNotFound {
+					t.Errorf("v2 post %s is kept: %v", id, err)
+				}
+			}
+			for _, id := range []string{c.staysComment, c.goneComment, c.staysReply} {
+				if _, err := store.Comments.GetComment(ctx, id); err != models.ErrNotFound {
+					t.Errorf("comment %s is kept: %v", id, err)
+				}
+			}
+			if _, err := store.Votes.GetVote(ctx, c.goneDBPost.VoteID); err != models.ErrNotFound {
+				t.Errorf("the vote of a deleted post is kept: %v", err)
+			}
+			// The votes of the user are withdrawn from what is kept
+			if post, err := store.Posts.GetPost(ctx, c.staysPost); err != nil || post.ForumVotes.Tally() != (models.VoteTally{}) {
+				t.Errorf("v1 votes after deleting %+v: %v", post.ForumVotes, err)
+			}
+			if vote, err := store.Votes.GetVote(ctx, c.staysDBPost.VoteID); err != nil || vote.Tally() != (models.VoteTally{Sum: -1, Down: 1}) {
+				t.Errorf("v2 votes after deleting %+v: %v", vote, err)
+			}
+			if voters, err := store.VoteEvents.GetVoters(ctx, c.staysDBPost.VoteID); err != nil || len(voters) != 1 || voters[0].UserID != c.staysID {
+				t.Errorf("voters after deleting %+v: %v", voters, err)
+			}
+		}},
+	}
+	for _, test := range tests {
+		store := memory.NewStore()
+		cfg := config.Default()
+		cfg.Profiles.DeletePolicy = test.policy
+		s := models.NewProfileServer(store, cfg, auth.NewTokens("secret", time.Hour, time.Hour), models.NewAuthorizer(store, cfg))
+		c := seedUserContent(t, store)
+
+		w := serve(s.Delete, c.goneID, map[string]string{"userID": c.goneID}, http.MethodDelete, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", test.policy, w.Code, w.Body.String())
+		}
+		test.check(t, store, c)
+
+		// Under both policies the user is gone
+		if _, err := store.Profiles.GetProfile(ctx, c.goneID); err != models.ErrNotFound {
+			t.Errorf("%s: profile is kept: %v", test.policy, err)
+		}
+		if _, err := store.VoteMaps.GetVoteMap(ctx, c.goneID); err != models.ErrNotFound {
+			t.Errorf("%s: vote map is kept: %v", test.policy, err)
+		}
+		if _, err := store.UserVotes.GetUserVotes(ctx, c.goneID); err != models.ErrNotFound {
+			t.Errorf("%s: v1 votes are kept: %v", test.policy, err)
+		}
+		if sub, err := store.SubForums.GetSubForum(ctx, "open"); err != nil || len(sub.Moderators) != 1 || sub.Moderators[0] != c.staysID {
+			t.Errorf("%s: moderators %v: %v", test.policy, sub.Moderators, err)
+		}
+		// What is kept still adds up
+		if report, err := models.ReconcileVotes(ctx, store, false); err != nil || len(report.Discrepancies) != 0 {
+			t.Errorf("%s: tallies do not match the votes: %v %v", test.policy, report.Discrepancies, err)
+		}
+	}
+}
@@ -8,56 +8,48 @@ import (
 	"net/http"
 	"time"
 
+	"gguan/cwgcf_db/auth"
 	"gguan/cwgcf_db/config"
 
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // ProfileServer is the definition of a REST API for user profiles
+// Posts, Comments and the vote repositories are only used to apply DeletePolicy when a profile is deleted
 type ProfileServer struct {
-	Profiles map[string]Profile
-	Client   *mongo.Client
+	Profiles     ProfileRepository
+	Posts        PostRepository
+	Comments     CommentRepository
+	UserVotes    UserVoteRepository
+	Votes        VoteRepository
+	VoteMaps     VoteMapRepository
+	VoteEvents   VoteEventRepository
+	SubForums    SubForumRepository
+	Revisions    RevisionRepository
+	Timeout      time.Duration
+	DeletePolicy string
+	// Tokens issues the token returned when a profile is created and renews tokens
+	Tokens     *auth.Tokens
+	Authorizer *Authorizer
 }
 
 // NewProfileServer creates a new Server instance
-func NewProfileServer() *ProfileServer {
-	s := &ProfileServer{}
-	// jsonFile, err := os.Open("fixtures/mock_profiles.json")
-	// if err != nil {
-	// 	log.Printf("Error opening file: %v", err)
-	// 	return s
-	// }
-	// defer jsonFile.Close()
-
-	// bytes, err := ioutil.ReadAll(jsonFile)
-	// if err != nil {
-	// 	log.Printf("Error reading file: %v", err)
-	// 	return s
-	// }
-
-	// var profiles map[string]Profile
-	// err = json.Unmarshal(bytes, &profiles)
-	// if err != nil {
-	// 	log.Printf("Error unmarshalling file: %v", err)
-	// 	return s
-	// }
-
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	// "mongodb+srv://<username>:<password>@<cluster-address>/test?w=majority"
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(
-		config.MongoDBUrl,
-	))
-	if err != nil {
-		log.Fatal(err)
+func NewProfileServer(store *Store, cfg *config.Config, tokens *auth.Tokens, authorizer *Authorizer) *ProfileServer {
+	return &ProfileServer{
+		Profiles:     store.Profiles,
+		Posts:        store.Posts,
+		Comments:     store.Comments,
+		UserVotes:    store.UserVotes,
+		Votes:        store.Votes,
+		VoteMaps:     store.VoteMaps,
+		VoteEvents:   store.VoteEvents,
+		SubForums:    store.SubForums,
+		Revisions:    store.Revisions,
+		Timeout:      cfg.Server.RequestTimeout.Duration,
+		DeletePolicy: cfg.Profiles.DeletePolicy,
+		Tokens:       tokens,
+		Authorizer:   authorizer,
 	}
-	log.Print("Connected to MongoDB")
-
-	s.Client = client
-	return s
 }
 
 // Get handles get requests
@@ -69,7 +61,9 @@ func (s *ProfileServer) Get(w http.ResponseWriter, r *http.Request) {
 		header := http.StatusOK
 		res := []byte{}
 
-		profile, err := s.GetProfile(userID)
+		ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+		defer cancel()
+		profile, err := s.GetProfile(ctx, userID)
 		if err != nil {
 			header = http.StatusNotFound
 			log.Printf("Error getting profile from DB: %v", err)
@@ -83,40 +77,23 @@ func (s *ProfileServer) Get(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetProfile finds a profile with given userID
-func (s *ProfileServer) GetProfile(userID string) (profile Profile, err error) {
-	collection := s.Client.Database("cwgcf").Collection("profiles")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	objectID, _ := primitive.ObjectIDFromHex(userID)
-	filter := bson.M{"_id": objectID}
-	err = collection.FindOne(ctx, filter).Decode(&profile)
-	return profile, err
+func (s *ProfileServer) GetProfile(ctx context.Context, userID string) (profile Profile, err error) {
+	return s.Profiles.GetProfile(ctx, userID)
 }
 
 // GetAll handles getAll requests
 func (s *ProfileServer) GetAll(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	collection := s.Client.Database("cwgcf").Collection("profiles")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	cur, err := collection.Find(ctx, bson.D{})
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	res, err := s.Profiles.GetAllProfiles(ctx)
 	if err != nil {
 		log.Printf("Error getting profiles: %v", err)
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte(`{"error": "Not found"}`))
 		return
 	}
-	defer cur.Close(ctx)
-	res := []Profile{}
-	for cur.Next(ctx) {
-		var profile Profile
-
-		err := cur.Decode(&profile)
-		if err != nil {
-			log.Print(err)
-			continue
-		}
-		res = append(res, profile)
-	}
 
 	resBytes, _ := json.Marshal(res)
 
@@ -124,16 +101,15 @@ func (s *ProfileServer) GetAll(w http.ResponseWriter, r *http.Request) {
 	w.Write(resBytes)
 }
 
-// NOT IMPLEMENTED
-// Post handles post requests
-func (s *ProfileServer) Post(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(`{"message": "post called"}`))
+// CreateProfileResponse is the response definition of profile creation
+// Token authenticates the new user, creating a profile is how users sign up
+type CreateProfileResponse struct {
+	InsertID string `json:"insertID"`
+	auth.TokenResponse
 }
 
-// Put handles put requests
-func (s *ProfileServer) Put(w http.ResponseWriter, r *http.Request) {
+// Post handles create requests, the id is generated
+func (s *ProfileServer) Post(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var profile Profile
 
@@ -145,33 +121,191 @@ func (s *ProfileServer) Put(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"error": "Check your request"}`))
 		return
 	}
+	if invalid := validateProfile(profile); invalid != nil {
+		writeValidationError(w, invalid)
+		return
+	}
+
+	// Roles are only granted by admins through SetRole
+	profile.Role = RoleMember
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	insertID, err := s.Profiles.InsertProfile(ctx, profile)
+	if err != nil {
+		log.Printf("Failed to insert profile: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to create profile"}`))
+		return
+	}
+	token, err := s.Tokens.NewTokenResponse(insertID)
+	if err != nil {
+		log.Printf("Failed to issue token for %s: %v", insertID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to issue token"}`))
+		return
+	}
+	res, _ := json.Marshal(CreateProfileResponse{InsertID: insertID, TokenResponse: token})
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
 
-	collection := s.Client.Database("cwgcf").Collection("profiles")
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	doc := bson.M{
-		"name":        profile.Name,
-		"title":       profile.Title,
-		"description": profile.Description,
-		"avatarUrl":   profile.AvatarURL,
+// Patch handles partial update requests, only the fields present in the body are changed
+// Users can only update their own profile, admins can update any
+func (s *ProfileServer) Patch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID := mux.Vars(r)["userID"]
+	if !s.Authorizer.AuthorizeOwner(w, r, userID, RoleAdmin) {
+		return
 	}
+	var update ProfileUpdate
 
-	dbRes, err := collection.InsertOne(ctx, doc)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&update)
 	if err != nil {
 		log.Printf("Failed to decode body: %v", err)
-		w.WriteHeader(http.StatusConflict)
-		w.Write([]byte(`{"error": "User exists"}`))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	if invalid := update.validate(); invalid != nil {
+		writeValidationError(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	profile, err := s.Profiles.UpdateProfile(ctx, userID, update)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
 		return
 	}
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(fmt.Sprintf(`{"insertID": %v}`, dbRes.InsertedID)))
+	if err != nil {
+		log.Printf("Failed to update profile %s: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to update profile"}`))
+		return
+	}
+	res, _ := json.Marshal(profile)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
 }
 
-// NOT IMPLEMENTED
 // Delete handles delete requests
+// The posts, comments and votes of the user are anonymized or deleted first, according to DeletePolicy
+// Users can only delete their own profile, admins can delete any
 func (s *ProfileServer) Delete(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	userID := mux.Vars(r)["userID"]
+	if !s.Authorizer.AuthorizeOwner(w, r, userID, RoleAdmin) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if _, err := s.Profiles.GetProfile(ctx, userID); err != nil {
+		log.Printf("Error getting profile %s: %v", userID, err)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err := s.deleteUserContent(ctx, userID); err != nil {
+		log.Printf("Failed to apply %s policy for user %s: %v", s.DeletePolicy, userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to delete profile"}`))
+		return
+	}
+	if err := s.Profiles.DeleteProfile(ctx, userID); err != nil {
+		log.Printf("Failed to delete profile %s: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to delete profile"}`))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"deletedID": "%s", "policy": "%s"}`, userID, s.DeletePolicy)))
+}
+
+// SetRoleRequest is the request definition to change the role of a user
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// SetRole handles role change requests, the route is restricted to admins
+func (s *ProfileServer) SetRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	userID := mux.Vars(r)["userID"]
+	var request SetRoleRequest
+
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&request)
+	if err != nil {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	if err := ValidateRole(request.Role); err != nil {
+		invalid := &ValidationError{}
+		invalid.add("role", err.Error())
+		writeValidationError(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	profile, err := s.Profiles.SetProfileRole(ctx, userID, request.Role)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to set role of %s: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to set role"}`))
+		return
+	}
+	log.Printf("User %s set the role of %s to %s", auth.UserID(r.Context()), userID, request.Role)
+	res, _ := json.Marshal(profile)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// Refresh handles token refresh requests, it renews the token of the authenticated user
+// Users whose profile was deleted and tokens first issued more than auth.maxTokenLifetime ago are refused with 401
+func (s *ProfileServer) Refresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	claims := auth.TokenClaims(r.Context())
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	_, err := s.Profiles.GetProfile(ctx, claims.Subject)
+	if err == ErrNotFound {
+		auth.Unauthorized(w, "Profile not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting profile %s: %v", claims.Subject, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to issue token"}`))
+		return
+	}
+	res, err := s.Tokens.NewRenewedTokenResponse(claims)
+	if err == auth.ErrLifetimeExceeded {
+		auth.Unauthorized(w, "Token lifetime exceeded, sign in again")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to issue token: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to issue token"}`))
+		return
+	}
+	resBytes, _ := json.Marshal(res)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"message": "delete called"}`))
+	w.Write(resBytes)
 }
 
 // NOT IMPLEMENTED
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// hotEpoch and hotDecay place a post on the hot scale: ten times the votes outweigh hotDecay seconds of age
+	hotEpoch = 1577836800 // 2020-01-01
+	hotDecay = 45000
+	// wilsonZ is the z-score of the 95% confidence level of the Wilson lower bound
+	wilsonZ = 1.96
+)
+
+// Ranking holds the scores the feeds are sorted by, it is stored with posts and updated on every vote
+// CreatedAt is the creation time of the post in unix seconds, Score is the vote sum
+type Ranking struct {
+	CreatedAt     int64   `bson:"createdAt" json:"createdAt"`
+	Score         int64   `bson:"score" json:"score"`
+	Hot           float64 `bson:"hot" json:"hot"`
+	Controversial float64 `bson:"controversial" json:"controversial"`
+	Wilson        float64 `bson:"wilson" json:"wilson"`
+}
+
+// NewRanking computes the ranking of a post created at createdAt, in unix seconds, with the given votes
+func NewRanking(tally VoteTally, createdAt int64) Ranking {
+	return Ranking{
+		CreatedAt:     createdAt,
+		Score:         tally.Sum,
+		Hot:           hotScore(tally.Sum, createdAt),
+		Controversial: controversialScore(tally.Up, tally.Down),
+		Wilson:        wilsonScore(tally.Up, tally.Down),
+	}
+}
+
+// Rank computes the ranking of a v1 post from its forumVotes
+func (p ForumPost) Rank() Ranking {
+	return NewRanking(p.ForumVotes.Tally(), rankedAt(p.Ranking, p.CreatedAt))
+}
+
+// Rank computes the ranking of a v2 post from the tally of its vote
+func (p DBForumPost) Rank(tally VoteTally) Ranking {
+	return NewRanking(tally, rankedAt(p.Ranking, p.Metadata.CreatedAt))
+}
+
+// rankedAt returns the creation time a post is ranked with
+// Posts ranked before keep their time, others fall back to the time sent by the client, which may be in milliseconds
+func rankedAt(ranking Ranking, createdAt int64) int64 {
+	if ranking.CreatedAt != 0 {
+		return ranking.CreatedAt
+	}
+	if inMillis(createdAt) {
+		return createdAt / 1000
+	}
+	return createdAt
+}
+
+// inMillis reports whether a v1 timestamp sent by a client is in milliseconds rather than seconds
+// Seconds only pass 1e11 in the year 5138 and milliseconds passed it in 1973
+func inMillis(t int64) bool {
+	return t > 1e11
+}
+
+// legacyTime returns now in the unit of like, a v1 timestamp sent by a client
+// Times the server writes into v1 posts and comments use it, so they sort among the ones clients sent
+func legacyTime(now time.Time, like int64) int64 {
+	if inMillis(like) {
+		return now.UnixNano() / int64(time.Millisecond)
+	}
+	return now.Unix()
+}
+
+// hotScore orders by votes on a log scale plus age, so newer posts need fewer votes to stay on top
+func hotScore(score int64, createdAt int64) float64 {
+	order := math.Log10(math.Max(math.Abs(float64(score)), 1))
+	sign := 0.0
+	if score > 0 {
+		sign = 1
+	} else if score < 0 {
+		sign = -1
+	}
+	return sign*order + float64(createdAt-hotEpoch)/hotDecay
+}
+
+// controversialScore favors many votes split evenly between up and down
+func controversialScore(up int64, down int64) float64 {
+	if up <= 0 || down <= 0 {
+		return 0
+	}
+	magnitude := float64(up + down)
+	balance := float64(down) / float64(up)
+	if up < down {
+		balance = float64(up) / float64(down)
+	}
+	return math.Pow(magnitude, balance)
+}
+
+// wilsonScore is the lower bound of the Wilson score interval of the share of upvotes
+func wilsonScore(up int64, down int64) float64 {
+	n := float64(up + down)
+	if n <= 0 {
+		return 0
+	}
+	p := float64(up) / n
+	z2 := wilsonZ * wilsonZ
+	return (p + z2/(2*n) - wilsonZ*math.Sqrt((p*(1-p)+z2/(4*n))/n)) / (1 + z2/n)
+}
+
+// RankPosts recomputes the ranking of every v1 and v2 post, hidden ones included, and stores the ones that changed
+// Run it once for posts created before rankings existed, a vote landing while a post is re-ranked may leave
+// that post one vote behind until its next vote
+func RankPosts(ctx context.Context, store *Store) (int, error) {
+	updated := 0
+	err := store.Posts.ForEachPost(ctx, func(post ForumPost) error {
+		ranking := post.Rank()
+		if ranking == post.Ranking {
+			return nil
+		}
+		updated++
+		return store.Posts.SetPostRanking(ctx, post.ID, ranking)
+	})
+	if err != nil {
+		return updated, fmt.Errorf("ranking posts: %v", err)
+	}
+	votes := map[string]VoteTally{}
+	err = store.Votes.ForEachVote(ctx, func(vote ForumVote) error {
+		votes[vote.ID] = vote.Tally()
+		return nil
+	})
+	if err != nil {
+		return updated, fmt.Errorf("reading votes: %v", err)
+	}
+	err = store.Posts.ForEachDBPost(ctx, func(post DBForumPost) error {
+		ranking := post.Rank(votes[post.VoteID])
+		if ranking == post.Ranking {
+			return nil
+		}
+		updated++
+		return store.Posts.SetPostRanking(ctx, post.ID, ranking)
+	})
+	if err != nil {
+		return updated, fmt.Errorf("ranking v2 posts: %v", err)
+	}
+	return updated, nil
+}
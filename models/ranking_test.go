@@ -0,0 +1,109 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewRanking(t *testing.T) {
+	const createdAt = hotEpoch + 10*hotDecay
+	// Wilson scores are the published lower bounds at 95% confidence, rounded to four places
+	tests := []struct {
+		name  string
+		tally VoteTally
+		want  Ranking
+	}{
+		{
+			name:  "no votes",
+			tally: VoteTally{},
+			want:  Ranking{CreatedAt: createdAt, Hot: 10},
+		},
+		{
+			name:  "only upvotes",
+			tally: VoteTally{Sum: 10, Up: 10},
+			want:  Ranking{CreatedAt: createdAt, Score: 10, Hot: 11, Wilson: 0.7225},
+		},
+		{
+			name:  "only downvotes",
+			tally: VoteTally{Sum: -100, Down: 100},
+			want:  Ranking{CreatedAt: createdAt, Score: -100, Hot: 8},
+		},
+		{
+			name:  "split evenly",
+			tally: VoteTally{Sum: 0, Up: 50, Down: 50},
+			want:  Ranking{CreatedAt: createdAt, Hot: 10, Controversial: 100, Wilson: 0.4038},
+		},
+		{
+			name:  "split unevenly",
+			tally: VoteTally{Sum: 2, Up: 4, Down: 2},
+			want:  Ranking{CreatedAt: createdAt, Score: 2, Hot: 10 + math.Log10(2), Controversial: math.Sqrt(6), Wilson: 0.3000},
+		},
+	}
+	for _, test := range tests {
+		got := NewRanking(test.tally, createdAt)
+		if got.CreatedAt != test.want.CreatedAt || got.Score != test.want.Score ||
+			!near(got.Hot, test.want.Hot, 1e-9) || !near(got.Controversial, test.want.Controversial, 1e-9) || !near(got.Wilson, test.want.Wilson, 1e-4) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRankingOrders(t *testing.T) {
+	const day = 24 * 60 * 60
+	tests := []struct {
+		name          string
+		higher, lower func() Ranking
+		score         func(Ranking) float64
+	}{
+		{
+			name:   "hot prefers a newer post with the same votes",
+			higher: func() Ranking { return NewRanking(VoteTally{Sum: 10, Up: 10}, hotEpoch+day) },
+			lower:  func() Ranking { return NewRanking(VoteTally{Sum: 10, Up: 10}, hotEpoch) },
+			score:  func(r Ranking) float64 { return r.Hot },
+		},
+		{
+			name:   "hot prefers more votes at the same age",
+			higher: func() Ranking { return NewRanking(VoteTally{Sum: 100, Up: 100}, hotEpoch) },
+			lower:  func() Ranking { return NewRanking(VoteTally{Sum: 10, Up: 10}, hotEpoch) },
+			score:  func(r Ranking) float64 { return r.Hot },
+		},
+		{
+			name:   "controversial prefers more votes split evenly",
+			higher: func() Ranking { return NewRanking(VoteTally{Up: 100, Down: 100}, hotEpoch) },
+			lower:  func() Ranking { return NewRanking(VoteTally{Sum: 80, Up: 120, Down: 40}, hotEpoch) },
+			score:  func(r Ranking) float64 { return r.Controversial },
+		},
+		{
+			name:   "wilson prefers a share backed by more votes",
+			higher: func() Ranking { return NewRanking(VoteTally{Sum: 80, Up: 90, Down: 10}, hotEpoch) },
+			lower:  func() Ranking { return NewRanking(VoteTally{Sum: 8, Up: 9, Down: 1}, hotEpoch) },
+			score:  func(r Ranking) float64 { return r.Wilson },
+		},
+	}
+	for _, test := range tests {
+		if higher, lower := test.score(test.higher()), test.score(test.lower()); higher <= lower {
+			t.Errorf("%s: %v is not above %v", test.name, higher, lower)
+		}
+	}
+}
+
+func TestRankedAt(t *testing.T) {
+	tests := []struct {
+		ranking   Ranking
+		createdAt int64
+		want      int64
+	}{
+		{Ranking{}, 1600000000, 1600000000},
+		{Ranking{}, 1600000000123, 1600000000},
+		{Ranking{CreatedAt: 1500000000}, 1600000000, 1500000000},
+	}
+	for _, test := range tests {
+		if got := rankedAt(test.ranking, test.createdAt); got != test.want {
+			t.Errorf("rankedAt(%+v, %d) = %d, want %d", test.ranking, test.createdAt, got, test.want)
+		}
+	}
+}
+
+func near(a float64, b float64, tolerance float64) bool {
+	return math.Abs(a-b) < tolerance
+}
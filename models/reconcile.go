@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Kinds of vote targets, used by ReconcileVotes and VoteEvent
+const (
+	CountVote    = "vote"
+	CountPost    = "post"
+	CountComment = "comment"
+)
+
+// Discrepancy is a stored tally that differs from the tally recomputed from the users' votes
+type Discrepancy struct {
+	Kind     string    `json:"kind"`
+	ID       string    `json:"id"`
+	Stored   VoteTally `json:"stored"`
+	Expected VoteTally `json:"expected"`
+	// Fixed is false in dry runs and when the count changed while reconciling
+	Fixed bool `json:"fixed"`
+}
+
+func (d Discrepancy) String() string {
+	res := fmt.Sprintf("%s %s: stored %s, expected %s", d.Kind, d.ID, d.Stored, d.Expected)
+	if d.Fixed {
+		res += " (fixed)"
+	}
+	return res
+}
+
+// ReconcileReport is the outcome of ReconcileVotes
+type ReconcileReport struct {
+	Checked       int
+	Discrepancies []Discrepancy
+}
+
+// Fixed counts the discrepancies that were fixed
+func (r ReconcileReport) Fixed() int {
+	fixed := 0
+	for _, d := range r.Discrepancies {
+		if d.Fixed {
+			fixed++
+		}
+	}
+	return fixed
+}
+
+// ReconcileVotes recomputes v2 vote tallies from forumVoteMap and v1 forumVotes from forumUserVotes
+// With fix, differing tallies are overwritten, a tally that changes during the run is left for the next run
+// Stored tallies are read before the votes, so a vote landing mid-run either is in both or makes the fix skip that tally
+// Upvotes and downvotes were not kept before the vote event log, fixing fills them in
+func ReconcileVotes(ctx context.Context, store *Store, fix bool) (ReconcileReport, error) {
+	report := ReconcileReport{}
+
+	// v2
+	votes := map[string]VoteTally{}
+	err := store.Votes.ForEachVote(ctx, func(vote ForumVote) error {
+		votes[vote.ID] = vote.Tally()
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("reading votes: %v", err)
+	}
+	counts := map[string]VoteTally{}
+	err = store.VoteMaps.ForEachVoteMap(ctx, func(voteMap ForumVoteMap) error {
+		for id, entry := range voteMap.VoteMap {
+			tally := counts[id]
+			tally.Count(entry.VoteStatus)
+			counts[id] = tally
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("reading vote maps: %v", err)
+	}
+	if err := reconcileCounts(ctx, &report, CountVote, votes, counts, fix, store.Votes.SetVoteTally); err != nil {
+		return report, err
+	}
+
+	// v1, votes on posts and comments share forumUserVotes
+	posts := map[string]VoteTally{}
+	err = store.Posts.ForEachPost(ctx, func(post ForumPost) error {
+		posts[post.ID] = post.ForumVotes.Tally()
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("reading posts: %v", err)
+	}
+	comments := map[string]VoteTally{}
+	err = store.Comments.ForEachComment(ctx, func(comment ForumComment) error {
+		comments[comment.ID] = comment.ForumVotes.Tally()
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("reading comments: %v", err)
+	}
+	sums := map[string]VoteTally{}
+	err = store.UserVotes.ForEachUserVotes(ctx, func(userVotes ForumUserVotes) error {
+		for id, vote := range userVotes.VoteMap {
+			tally := sums[id]
+			tally.Count(vote.VoteStatus)
+			sums[id] = tally
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("reading user votes: %v", err)
+	}
+	if err := reconcileCounts(ctx, &report, CountPost, posts, sums, fix, store.Posts.SetPostVotes); err != nil {
+		return report, err
+	}
+	if err := reconcileCounts(ctx, &report, CountComment, comments, sums, fix, store.Comments.SetCommentVotes); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// reconcileCounts compares stored with expected and records the differences in report, fixing them with set when fix is true
+func reconcileCounts(ctx context.Context, report *ReconcileReport, kind string, stored map[string]VoteTally, expected map[string]VoteTally, fix bool, set func(ctx context.Context, id string, stored VoteTally, tally VoteTally) (bool, error)) error {
+	ids := make([]string, 0, len(stored))
+	for id := range stored {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		report.Checked++
+		if stored[id] == expected[id] {
+			continue
+		}
+		d := Discrepancy{Kind: kind, ID: id, Stored: stored[id], Expected: expected[id]}
+		if fix {
+			fixed, err := set(ctx, id, d.Stored, d.Expected)
+			if err != nil {
+				return fmt.Errorf("fixing %s %s: %v", kind, id, err)
+			}
+			d.Fixed = fixed
+		}
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	return nil
+}
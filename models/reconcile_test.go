@@ -0,0 +1,122 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+
+	"gguan/cwgcf_db/models"
+	"gguan/cwgcf_db/storage/memory"
+)
+
+func TestReconcileVotes(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// drift stores a tally that differs from the users' votes and returns its id
+		drift    func(t *testing.T, store *models.Store) string
+		kind     string
+		stored   models.VoteTally
+		expected models.VoteTally
+	}{
+		{
+			name: "v2 vote counted twice",
+			drift: func(t *testing.T, store *models.Store) string {
+				id := insertVote(t, store)
+				for userID, status := range map[string]int{"u1": models.VoteUp, "u2": models.VoteDown} {
+					if _, err := store.Votes.ApplyVote(ctx, userID, id, status, models.Metadata{}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				check(t, store.Votes.IncVote(ctx, id, models.VoteTally{Sum: 1, Up: 1}, 0))
+				return id
+			},
+			kind:     models.CountVote,
+			stored:   models.VoteTally{Sum: 1, Up: 2, Down: 1},
+			expected: models.VoteTally{Sum: 0, Up: 1, Down: 1},
+		},
+		{
+			name: "v2 vote without voters",
+			drift: func(t *testing.T, store *models.Store) string {
+				id := insertVote(t, store)
+				check(t, store.Votes.IncVote(ctx, id, models.VoteTally{Sum: 2, Up: 2}, 0))
+				return id
+			},
+			kind:     models.CountVote,
+			stored:   models.VoteTally{Sum: 2, Up: 2},
+			expected: models.VoteTally{},
+		},
+		{
+			name: "v1 post missing a vote",
+			drift: func(t *testing.T, store *models.Store) string {
+				id, err := store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", UserID: "u1", CreatedAt: 1600000000})
+				check(t, err)
+				check(t, store.UserVotes.SetUserVote(ctx, "u1", id, models.VoteUp))
+				check(t, store.UserVotes.SetUserVote(ctx, "u2", id, models.VoteUp))
+				check(t, store.Posts.IncPostVotes(ctx, id, models.VoteTally{Sum: 1, Up: 1}))
+				return id
+			},
+			kind:     models.CountPost,
+			stored:   models.VoteTally{Sum: 1, Up: 1},
+			expected: models.VoteTally{Sum: 2, Up: 2},
+		},
+		{
+			name: "v1 comment from before upvotes and downvotes were kept",
+			drift: func(t *testing.T, store *models.Store) string {
+				id, err := store.Comments.InsertComment(ctx, models.ForumComment{ParentID: "p", UserID: "u1", CreatedAt: 1600000000})
+				check(t, err)
+				check(t, store.UserVotes.SetUserVote(ctx, "u1", id, models.VoteDown))
+				check(t, store.Comments.IncCommentVotes(ctx, id, models.VoteTally{Sum: -1}))
+				return id
+			},
+			kind:     models.CountComment,
+			stored:   models.VoteTally{Sum: -1},
+			expected: models.VoteTally{Sum: -1, Down: 1},
+		},
+	}
+	for _, test := range tests {
+		store := memory.NewStore()
+		id := test.drift(t, store)
+		want := models.Discrepancy{Kind: test.kind, ID: id, Stored: test.stored, Expected: test.expected}
+
+		// A dry run reports the same discrepancy every time
+		for run := 0; run < 2; run++ {
+			report, err := models.ReconcileVotes(ctx, store, false)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if len(report.Discrepancies) != 1 || report.Discrepancies[0] != want || report.Fixed() != 0 {
+				t.Errorf("%s: dry run %d reported %v, want %v", test.name, run, report.Discrepancies, want)
+			}
+		}
+
+		report, err := models.ReconcileVotes(ctx, store, true)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		want.Fixed = true
+		if len(report.Discrepancies) != 1 || report.Discrepancies[0] != want || report.Fixed() != 1 {
+			t.Errorf("%s: fixing reported %v, want %v", test.name, report.Discrepancies, want)
+		}
+
+		report, err = models.ReconcileVotes(ctx, store, false)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(report.Discrepancies) != 0 || report.Checked == 0 {
+			t.Errorf("%s: checked %d tallies after fixing, found %v", test.name, report.Checked, report.Discrepancies)
+		}
+	}
+}
+
+func insertVote(t *testing.T, store *models.Store) string {
+	id, err := store.Votes.InsertVote(context.Background(), models.Metadata{CreatedBy: "u1", CreatedAt: 1600000000})
+	check(t, err)
+	return id
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,306 @@
+package models
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by repositories when the requested document does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when an insert would duplicate a unique key
+var ErrConflict = errors.New("already exists")
+
+// Store groups the repositories the servers depend on
+type Store struct {
+	Posts      PostRepository
+	Comments   CommentRepository
+	UserVotes  UserVoteRepository
+	Votes      VoteRepository
+	VoteMaps   VoteMapRepository
+	Profiles   ProfileRepository
+	Photos     PhotoRepository
+	Albums     AlbumRepository
+	VoteEvents VoteEventRepository
+	SubForums  SubForumRepository
+	Revisions  RevisionRepository
+	Migrations MigrationRepository
+}
+
+// ProfileRepository stores user profiles
+type ProfileRepository interface {
+	GetProfile(ctx context.Context, id string) (Profile, error)
+	// GetProfiles returns the profiles that exist among ids, keyed by id
+	GetProfiles(ctx context.Context, ids []string) (map[string]Profile, error)
+	GetAllProfiles(ctx context.Context) ([]Profile, error)
+	InsertProfile(ctx context.Context, profile Profile) (string, error)
+	// UpdateProfile sets the non-nil fields of update and returns the updated profile
+	UpdateProfile(ctx context.Context, id string, update ProfileUpdate) (Profile, error)
+	DeleteProfile(ctx context.Context, id string) error
+	SetProfileRole(ctx context.Context, id string, role string) (Profile, error)
+}
+
+// PhotoRepository stores the photos of the album
+type PhotoRepository interface {
+	GetAllPhotos(ctx context.Context) ([]Photo, error)
+	GetPhoto(ctx context.Context, id string) (Photo, error)
+	// GetPhotos returns the photos that exist among ids, keyed by id
+	GetPhotos(ctx context.Context, ids []string) (map[string]Photo, error)
+	InsertPhoto(ctx context.Context, photo Photo) (string, error)
+	// UpdatePhoto sets the non-nil fields of update and metadata.updatedBy/updatedAt, and returns the updated photo
+	UpdatePhoto(ctx context.Context, id string, update PhotoUpdate, metadata Metadata) (Photo, error)
+	// SetPhotoVariants replaces the variants of a photo
+	SetPhotoVariants(ctx context.Context, id string, variants []PhotoVariant) error
+	// SetPhotoHashes sets the hashes of an uploaded photo
+	SetPhotoHashes(ctx context.Context, id string, hashes PhotoHashes) error
+	// GetPhotoByURL returns the photo added with url, the newest if there are several
+	GetPhotoByURL(ctx context.Context, url string) (Photo, error)
+	// GetPhotoBySHA256 returns the photo userID uploaded with the given digest, the newest if there are several
+	GetPhotoBySHA256(ctx context.Context, userID string, sha256 string) (Photo, error)
+	// GetSimilarPhotos returns the photos userID uploaded that share one of bands
+	GetSimilarPhotos(ctx context.Context, userID string, bands []int64) ([]Photo, error)
+	DeletePhoto(ctx context.Context, id string) error
+	// GetPhotosByTakenAt returns one page of the photos descending by the time they were taken at
+	GetPhotosByTakenAt(ctx context.Context, page PageRequest) ([]Photo, *Cursor, error)
+	SetPhotoTakenAt(ctx context.Context, id string, takenAt int64) error
+}
+
+// PostRepository stores forum posts
+// v1 posts (ForumPost) and v2 posts (DBForumPost) share the same collection
+type PostRepository interface {
+	// GetAllPosts returns a page of v1 posts in the order of sort
+	// Posts pinned at sort.At are left out, the feeds list them before the first page, sort.Sub limits the page to one sub
+	// The returned cursor is nil on the last page, cursors issued for another sort mode are rejected with ErrInvalidCursor
+	GetAllPosts(ctx context.Context, sort PostSort, page PageRequest) ([]ForumPost, *Cursor, error)
+	// GetPost returns a v1 post, v2 posts are not found
+	GetPost(ctx context.Context, id string) (ForumPost, error)
+	InsertPost(ctx context.Context, post ForumPost) (string, error)
+	SetPostUpdatedAt(ctx context.Context, id string, updatedAt int64) error
+	// IncPostVotes adds change to forumVotes and updates the ranking, creating the post if missing
+	IncPostVotes(ctx context.Context, id string, change VoteTally) error
+
+	// GetDBPosts returns a page of v2 posts in the order of sort
+	// Posts pinned at sort.At are left out, the feeds list them before the first page, sort.Sub limits the page to one sub
+	// The returned cursor is nil on the last page, cursors issued for another sort mode are rejected with ErrInvalidCursor
+	GetDBPosts(ctx context.Context, sort PostSort, page PageRequest) ([]DBForumPost, *Cursor, error)
+	InsertDBPost(ctx context.Context, post DBForumPost) (string, error)
+	// ForEachDBPost calls fn with every v2 post, hidden ones included, stopping at the first error
+	ForEachDBPost(ctx context.Context, fn func(DBForumPost) error) error
+	// SetPostRanking sets the ranking of a v1 or v2 post
+	SetPostRanking(ctx context.Context, id string, ranking Ranking) error
+
+	// GetPinnedPosts returns the v1 posts of sort.Sub whose pin is active at sort.At, in pin order, leaving out hidden ones
+	GetPinnedPosts(ctx context.Context, sort PostSort) ([]ForumPost, error)
+	// GetPinnedDBPosts returns the v2 posts of sort.Sub whose pin is active at sort.At, in pin order, leaving out hidden ones
+	GetPinnedDBPosts(ctx context.Context, sort PostSort) ([]DBForumPost, error)
+	// PinPost sets the pin of a v1 or v2 post
+	PinPost(ctx context.Context, id string, pin Pin) error
+	// UnpinPost sets pin.pinned to false and records who unpinned the post in pin.metadata
+	UnpinPost(ctx context.Context, id string, updatedBy string, updatedAt int64) error
+
+	// MoveSubPosts moves the v1 and v2 posts of one sub to another and returns how many moved
+	// An empty from moves the posts without a sub
+	MoveSubPosts(ctx context.Context, from string, to string) (int, error)
+
+	// GetDBPost returns a v2 post, deleted and hidden ones included
+	GetDBPost(ctx context.Context, id string) (DBForumPost, error)
+	// EditPost applies the edit of a v1 post only while its title and content still equal from and it is not deleted
+	// ok is false when the post changed meanwhile
+	EditPost(ctx context.Context, id string, from Revision, edit PostEdit) (ok bool, err error)
+	// EditDBPost applies the edit of a v2 post like EditPost, setting metadata.updatedBy as well
+	EditDBPost(ctx context.Context, id string, from Revision, edit PostEdit) (ok bool, err error)
+	// BumpDBPostUpdatedAt sets metadata.updatedAt of a v2 post to updatedAt unless it is later already
+	BumpDBPostUpdatedAt(ctx context.Context, id string, updatedAt int64) error
+	// ConvertPostToV2 turns a v1 post into a v2 post with the same id, replacing createdAt, updatedAt and forumVotes
+	// with metadata and voteId, ok is false when there is no v1 post with that id, so converting twice changes nothing
+	ConvertPostToV2(ctx context.Context, id string, voteID string, metadata Metadata, ranking Ranking) (ok bool, err error)
+
+	// SetPostHidden hides or shows a v1 or v2 post, hidden posts are left out of GetAllPosts and GetDBPosts
+	SetPostHidden(ctx context.Context, id string, hidden bool) error
+	// DeletePost deletes a v1 or v2 post and returns the vote id of a v2 post
+	DeletePost(ctx context.Context, id string) (voteID string, err error)
+
+	// ForEachPost calls fn with every v1 post, hidden ones included, stopping at the first error
+	ForEachPost(ctx context.Context, fn func(ForumPost) error) error
+	// SetPostVotes sets forumVotes and updates the ranking only while forumVotes still equals stored, ok is false when it changed meanwhile
+	SetPostVotes(ctx context.Context, id string, stored VoteTally, tally VoteTally) (ok bool, err error)
+
+	// ReassignUserPosts moves the v1 and v2 posts of a user, including metadata.createdBy/updatedBy, and the pins set by the user to another user id
+	ReassignUserPosts(ctx context.Context, userID string, newUserID string) error
+	// DeleteUserPosts deletes the v1 and v2 posts of a user and returns their ids and the vote ids of the v2 posts
+	DeleteUserPosts(ctx context.Context, userID string) (postIDs []string, voteIDs []string, err error)
+}
+
+// CommentRepository stores forum comments
+// v1 comments (ForumComment) and v2 comments (DBForumComment) share the same collection
+type CommentRepository interface {
+	// GetComment returns a v1 comment, v2 comments are not found
+	GetComment(ctx context.Context, id string) (ForumComment, error)
+	// GetCommentsByPost returns every v1 comment of a thread sorted by votesSum and updatedAt
+	GetCommentsByPost(ctx context.Context, postID string) ([]ForumComment, error)
+	// GetCommentsWithoutPost returns the comments stored before postId and path existed
+	GetCommentsWithoutPost(ctx context.Context) ([]ForumComment, error)
+	SetCommentAncestry(ctx context.Context, id string, postID string, path []string) error
+	InsertComment(ctx context.Context, comment ForumComment) (string, error)
+	SetCommentUpdatedAt(ctx context.Context, id string, updatedAt int64) error
+	// IncCommentVotes adds change to forumVotes, creating the comment if missing
+	IncCommentVotes(ctx context.Context, id string, change VoteTally) error
+	SetCommentHidden(ctx context.Context, id string, hidden bool) error
+	// EditComment applies the edit of a comment only while its content still equals from and it is not deleted
+	// ok is false when the comment changed meanwhile
+	EditComment(ctx context.Context, id string, from Revision, edit PostEdit) (ok bool, err error)
+	// DeleteComment deletes a v1 or v2 comment together with every reply below it
+	// It returns the vote ids of the deleted v2 comments
+	DeleteComment(ctx context.Context, id string) (voteIDs []string, err error)
+	// ForEachComment calls fn with every v1 comment, stopping at the first error
+	ForEachComment(ctx context.Context, fn func(ForumComment) error) error
+	// SetCommentVotes sets forumVotes only while it still equals stored, ok is false when it changed meanwhile
+	SetCommentVotes(ctx context.Context, id string, stored VoteTally, tally VoteTally) (ok bool, err error)
+	// ReassignUserComments moves the v1 and v2 comments of a user, including metadata.createdBy/updatedBy, to another user id
+	ReassignUserComments(ctx context.Context, userID string, newUserID string) error
+	// DeleteUserComments deletes the v1 and v2 comments of a user together with every reply below them
+	// It returns the vote ids of the deleted v2 comments
+	DeleteUserComments(ctx context.Context, userID string) (voteIDs []string, err error)
+	// DeleteCommentsByPosts deletes the v1 and v2 comments of the given posts and returns the vote ids of the v2 ones
+	DeleteCommentsByPosts(ctx context.Context, postIDs []string) (voteIDs []string, err error)
+
+	// GetDBComment returns a v2 comment, deleted and hidden ones included
+	GetDBComment(ctx context.Context, id string) (DBForumComment, error)
+	// GetDBCommentsByPost returns every v2 comment of a thread sorted by metadata.updatedAt, latest first
+	GetDBCommentsByPost(ctx context.Context, postID string) ([]DBForumComment, error)
+	InsertDBComment(ctx context.Context, comment DBForumComment) (string, error)
+	// BumpDBCommentsUpdatedAt sets metadata.updatedAt of the given v2 comments to updatedAt where it is earlier
+	BumpDBCommentsUpdatedAt(ctx context.Context, ids []string, updatedAt int64) error
+	// EditDBComment applies the edit of a v2 comment like EditComment, setting metadata.updatedBy as well
+	EditDBComment(ctx context.Context, id string, from Revision, edit PostEdit) (ok bool, err error)
+	// ConvertCommentToV2 turns a v1 comment into a v2 comment with the same id like ConvertPostToV2
+	ConvertCommentToV2(ctx context.Context, id string, voteID string, metadata Metadata) (ok bool, err error)
+}
+
+// UserVoteRepository stores what each user voted in v1 (forumUserVotes)
+type UserVoteRepository interface {
+	GetUserVotes(ctx context.Context, userID string) (ForumUserVotes, error)
+	SetUserVote(ctx context.Context, userID string, voteID string, voteStatus int) error
+	ReassignUserVotes(ctx context.Context, userID string, newUserID string) error
+	// DeleteUserVotes deletes the votes of a user and returns them, ErrNotFound when the user never voted
+	DeleteUserVotes(ctx context.Context, userID string) (ForumUserVotes, error)
+	// ForEachUserVotes calls fn with the votes of every user, stopping at the first error
+	ForEachUserVotes(ctx context.Context, fn func(ForumUserVotes) error) error
+}
+
+// VoteRepository stores v2 vote objects (forumVotes)
+type VoteRepository interface {
+	InsertVote(ctx context.Context, metadata Metadata) (string, error)
+	GetVote(ctx context.Context, id string) (ForumVote, error)
+	// GetVotes returns the votes that exist among ids, keyed by id
+	GetVotes(ctx context.Context, ids []string) (map[string]ForumVote, error)
+	// IncVote adds change to count, upvotes and downvotes, creating the vote if missing
+	// Like every method changing a tally, it updates the ranking of the v2 post of the vote in the same transaction
+	IncVote(ctx context.Context, id string, change VoteTally, updatedAt int64) error
+	// ApplyVote sets the status of a user's vote, changing the tally by TallyChange from the status in the user's vote map
+	// The tally, the post ranking, the vote map entry and a VoteEvent change atomically, applying the same status twice changes nothing
+	// It returns the resulting vote with VoteStatus set, ErrNotFound when the vote does not exist
+	ApplyVote(ctx context.Context, userID string, id string, status int, metadata Metadata) (ForumVote, error)
+	DeleteVotes(ctx context.Context, ids []string) error
+	// ForEachVote calls fn with every vote, stopping at the first error
+	ForEachVote(ctx context.Context, fn func(ForumVote) error) error
+	// SetVoteTally sets count, upvotes and downvotes only while they still equal stored, ok is false when they changed meanwhile
+	SetVoteTally(ctx context.Context, id string, stored VoteTally, tally VoteTally) (ok bool, err error)
+	// EnsureVote inserts vote with its id and tally unless a vote with that id exists, created is false then
+	EnsureVote(ctx context.Context, vote ForumVote) (created bool, err error)
+}
+
+// VoteMapRepository stores what each user voted in v2 (forumVoteMap)
+type VoteMapRepository interface {
+	GetVoteMap(ctx context.Context, userID string) (ForumVoteMap, error)
+	ReassignVoteMap(ctx context.Context, userID string, newUserID string) error
+	// DeleteVoteMap deletes the vote map of a user and returns it, ErrNotFound when the user never voted
+	DeleteVoteMap(ctx context.Context, userID string) (ForumVoteMap, error)
+	// ForEachVoteMap calls fn with the vote map of every user, stopping at the first error
+	ForEachVoteMap(ctx context.Context, fn func(ForumVoteMap) error) error
+	// MergeVoteMap adds the entries the vote map of a user lacks and returns how many it added, entries the user has already are kept
+	// A user without a vote map gets one once an entry is added
+	MergeVoteMap(ctx context.Context, userID string, entries map[string]ForumVoteMapEntry) (int, error)
+}
+
+// VoteEventRepository stores the log of vote changes (forumVoteEvents)
+type VoteEventRepository interface {
+	InsertVoteEvent(ctx context.Context, event VoteEvent) (string, error)
+	// GetVoteEvents returns a page of the events of a target sorted by createdAt, newest first
+	// The returned cursor is nil on the last page
+	GetVoteEvents(ctx context.Context, targetID string, page PageRequest) ([]VoteEvent, *Cursor, error)
+	// GetVoters returns the latest event of every user whose vote on a target is not VoteNone
+	GetVoters(ctx context.Context, targetID string) ([]VoteEvent, error)
+	HasVoteEvents(ctx context.Context, targetID string, userID string) (bool, error)
+	// WindowScores sums the changes made since the given unix time per target, targets without changes are left out
+	WindowScores(ctx context.Context, targetIDs []string, since int64) (map[string]int64, error)
+	ReassignUserVoteEvents(ctx context.Context, userID string, newUserID string) error
+	DeleteUserVoteEvents(ctx context.Context, userID string) error
+}
+
+// AlbumRepository stores albums and which photos they hold in what order
+type AlbumRepository interface {
+	// GetAlbums returns the albums of ownerID, or of every owner when it is empty, that are public or owned by viewerID
+	// With private the private albums of other owners are included too. Newer albums come first
+	GetAlbums(ctx context.Context, ownerID string, viewerID string, private bool) ([]Album, error)
+	GetAlbum(ctx context.Context, id string) (Album, error)
+	InsertAlbum(ctx context.Context, album Album) (string, error)
+	// UpdateAlbum sets the non-nil fields of update and metadata.updatedBy/updatedAt, and returns the updated album
+	UpdateAlbum(ctx context.Context, id string, update AlbumUpdate, metadata Metadata) (Album, error)
+	// ClearAlbumCover unsets the cover of an album if it is photoID
+	ClearAlbumCover(ctx context.Context, id string, photoID string) error
+	DeleteAlbum(ctx context.Context, id string) error
+	// GetAlbumPhotos returns one page of the photos of an album descending by rank
+	GetAlbumPhotos(ctx context.Context, albumID string, page PageRequest) ([]AlbumPhoto, *Cursor, error)
+	// GetAlbumPhotoIDs returns the ids of every photo of an album descending by rank
+	GetAlbumPhotoIDs(ctx context.Context, albumID string) ([]string, error)
+	GetAlbumPhoto(ctx context.Context, albumID string, photoID string) (AlbumPhoto, error)
+	// AddAlbumPhoto places a photo after the last one of its album and returns it with its id and rank
+	// It returns ErrConflict when the album holds the photo already
+	AddAlbumPhoto(ctx context.Context, entry AlbumPhoto) (AlbumPhoto, error)
+	// MoveAlbumPhoto moves a photo to the end of another album, returning ErrConflict when that album holds it already
+	MoveAlbumPhoto(ctx context.Context, albumID string, photoID string, toAlbumID string) (AlbumPhoto, error)
+	// SetAlbumPhotoRanks sets the rank of the photos of an album, keyed by photo id
+	SetAlbumPhotoRanks(ctx context.Context, albumID string, ranks map[string]float64) error
+	RemoveAlbumPhoto(ctx context.Context, albumID string, photoID string) error
+	// RemoveAllAlbumPhotos empties an album and returns how many photos it held
+	RemoveAllAlbumPhotos(ctx context.Context, albumID string) (int, error)
+	// RemovePhotoFromAlbums takes a photo out of every album, and off their covers, and returns how many held it
+	RemovePhotoFromAlbums(ctx context.Context, photoID string) (int, error)
+	// GetPrivatePhotos returns, for those of photoIDs that are only in private albums, the owners of those albums
+	// Photos in a public album or in none are left out
+	GetPrivatePhotos(ctx context.Context, photoIDs []string) (map[string][]string, error)
+}
+
+// SubForumRepository stores sub-forums, which are addressed by their unique slug
+type SubForumRepository interface {
+	// GetSubForums returns every sub ordered by slug
+	GetSubForums(ctx context.Context) ([]SubForum, error)
+	GetSubForum(ctx context.Context, slug string) (SubForum, error)
+	// InsertSubForum returns ErrConflict when the slug is taken
+	InsertSubForum(ctx context.Context, sub SubForum) (string, error)
+	// UpdateSubForum sets the non-nil fields of update and metadata.updatedBy/updatedAt, and returns the updated sub
+	UpdateSubForum(ctx context.Context, slug string, update SubForumUpdate, metadata Metadata) (SubForum, error)
+	DeleteSubForum(ctx context.Context, slug string) error
+	// RemoveSubForumModerator removes a user from the moderators of every sub
+	RemoveSubForumModerator(ctx context.Context, userID string) error
+}
+
+// RevisionRepository stores the former versions of edited and deleted posts and comments (forumRevisions)
+type RevisionRepository interface {
+	InsertRevision(ctx context.Context, revision Revision) (string, error)
+	// GetRevisions returns the revisions of a post or comment sorted by createdAt, newest first
+	GetRevisions(ctx context.Context, targetID string) ([]Revision, error)
+	// DeleteRevisions deletes the revisions of the given posts and comments
+	DeleteRevisions(ctx context.Context, targetIDs []string) error
+	ReassignUserRevisions(ctx context.Context, userID string, newUserID string) error
+	DeleteUserRevisions(ctx context.Context, userID string) error
+}
+
+// MigrationRepository records which data migrations were applied (migrations)
+type MigrationRepository interface {
+	// GetAppliedMigrations returns the applied migrations ordered by version
+	GetAppliedMigrations(ctx context.Context) ([]AppliedMigration, error)
+	// InsertAppliedMigration returns ErrConflict when the version is recorded already
+	InsertAppliedMigration(ctx context.Context, migration AppliedMigration) error
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"strings"
+)
+
+// DeletedContent replaces the content of comments deleted by their author
+const DeletedContent = "[deleted]"
+
+// Kinds of revisions, named after what was revised
+const (
+	RevisionPost    = "post"
+	RevisionComment = "comment"
+)
+
+// Actions that replace a version of a post or comment
+const (
+	RevisionEdited  = "edited"
+	RevisionDeleted = "deleted"
+)
+
+// Revision is a former version of a post or comment, saved when its author edits or deletes it
+// Metadata.CreatedBy/CreatedAt record who replaced the version and when, in server unix seconds
+type Revision struct {
+	ID         string `bson:"_id" json:"_id"`
+	TargetKind string `bson:"targetKind" json:"targetKind"`
+	TargetID   string `bson:"targetId" json:"targetId"`
+	// Title is empty for comments
+	Title   string `bson:"title" json:"title"`
+	Content string `bson:"content" json:"content"`
+	// Action is how the version was replaced, RevisionEdited or RevisionDeleted
+	Action   string   `bson:"action" json:"action"`
+	Metadata Metadata `bson:"metadata" json:"metadata"`
+}
+
+// EditRequest is the request definition of an edit, nil fields are left unchanged
+// UpdatedAt is still accepted from older clients but ignored, the server records the time of every change
+type EditRequest struct {
+	Title     *string `json:"title"`
+	Content   *string `json:"content"`
+	UpdatedAt int64   `json:"updatedAt"`
+}
+
+// validate checks an edit of a post, or of a comment when comment is set
+func (r EditRequest) validate(comment bool) *ValidationError {
+	invalid := &ValidationError{}
+	if r.Title == nil && r.Content == nil {
+		invalid.add("content", "nothing to change")
+	}
+	if r.Title != nil {
+		if comment {
+			invalid.add("title", "comments have no title")
+		} else if strings.TrimSpace(*r.Title) == "" {
+			invalid.add("title", "must not be empty")
+		}
+	}
+	if r.Content != nil && comment && strings.TrimSpace(*r.Content) == "" {
+		invalid.add("content", "must not be empty")
+	}
+	return invalid.orNil()
+}
+
+// PostEdit is a change of a post or comment by its author
+// The repositories apply it only while the stored title and content still equal those of the revision they are given
+// and the target is not deleted, UpdatedAt is the unix time of the change
+type PostEdit struct {
+	Title     *string
+	Content   *string
+	Delete    bool
+	UpdatedBy string
+	UpdatedAt int64
+}
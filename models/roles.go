@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/config"
+)
+
+// Roles in increasing order of privilege
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{
+	"":            0,
+	RoleMember:    0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ValidateRole checks that role is one of the known roles
+func ValidateRole(role string) error {
+	if role == "" {
+		return fmt.Errorf("role is required")
+	}
+	if _, ok := roleRanks[role]; !ok {
+		return fmt.Errorf("unknown role: %s", role)
+	}
+	return nil
+}
+
+// HasRole reports whether role grants at least the privileges of required
+func HasRole(role string, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// Authorizer decides what the authenticated user may do, based on the role stored with their profile
+type Authorizer struct {
+	Profiles ProfileRepository
+	Timeout  time.Duration
+}
+
+// NewAuthorizer creates a new Authorizer instance
+func NewAuthorizer(store *Store, cfg *config.Config) *Authorizer {
+	return &Authorizer{
+		Profiles: store.Profiles,
+		Timeout:  cfg.Server.RequestTimeout.Duration,
+	}
+}
+
+// Role returns the role of a user, users without a profile have no privileges
+func (a *Authorizer) Role(ctx context.Context, userID string) (string, error) {
+	profile, err := a.Profiles.GetProfile(ctx, userID)
+	if err == ErrNotFound {
+		return RoleMember, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if profile.Role == "" {
+		return RoleMember, nil
+	}
+	return profile.Role, nil
+}
+
+// CanModify reports whether the authenticated user may change something owned by ownerID
+// Owners always may, other users need the given role
+func (a *Authorizer) CanModify(ctx context.Context, ownerID string, role string) (bool, error) {
+	userID := auth.UserID(ctx)
+	if userID == "" {
+		return false, nil
+	}
+	if userID == ownerID {
+		return true, nil
+	}
+	userRole, err := a.Role(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return HasRole(userRole, role), nil
+}
+
+// AuthorizeOwner responds 403 and returns false unless CanModify allows the authenticated user
+func (a *Authorizer) AuthorizeOwner(w http.ResponseWriter, r *http.Request, ownerID string, role string) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), a.Timeout)
+	defer cancel()
+	ok, err := a.CanModify(ctx, ownerID, role)
+	if err != nil {
+		log.Printf("Error getting role: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return false
+	}
+	if !ok {
+		WriteForbidden(w, fmt.Sprintf("owner or %s role required", role))
+		return false
+	}
+	return true
+}
+
+// RequireRole rejects requests from users without the given role
+// Anonymous requests get 401 and users without the role get 403
+func (a *Authorizer) RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return auth.Required(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), a.Timeout)
+		defer cancel()
+		userRole, err := a.Role(ctx, auth.UserID(r.Context()))
+		if err != nil {
+			log.Printf("Error getting role: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "Internal error"}`))
+			return
+		}
+		if !HasRole(userRole, role) {
+			WriteForbidden(w, fmt.Sprintf("%s role required", role))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// WriteForbidden responds 403 with the reason the action was denied, every 403 of the API has this body
+func WriteForbidden(w http.ResponseWriter, reason string) {
+	res, _ := json.Marshal(ErrorResponse{Error: "Forbidden", Reason: reason})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(res)
+}
@@ -0,0 +1,24 @@
+package models
+
+import "testing"
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		role     string
+		required string
+		want     bool
+	}{
+		{"", RoleMember, true},
+		{"", RoleModerator, false},
+		{RoleMember, RoleModerator, false},
+		{RoleModerator, RoleModerator, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleAdmin, RoleModerator, true},
+		{"unknown", RoleModerator, false},
+	}
+	for _, test := range tests {
+		if got := HasRole(test.role, test.required); got != test.want {
+			t.Errorf("HasRole(%q, %q) = %v, want %v", test.role, test.required, got, test.want)
+		}
+	}
+}
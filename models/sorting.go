@@ -0,0 +1,91 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sort modes of the forum feeds
+const (
+	// SortNew orders posts by latest activity, it is the default of the v2 feed
+	SortNew = "new"
+	// SortTop orders posts by vote sum, optionally only those created within a window, it is the default of the v1 feed
+	SortTop = "top"
+	// SortHot orders posts by votes decayed with age
+	SortHot = "hot"
+	// SortControversial orders posts by how many votes they have split evenly between up and down
+	SortControversial = "controversial"
+	// SortWilson orders posts by the lower bound of the confidence interval of their share of upvotes
+	SortWilson = "wilson"
+)
+
+// PostSort selects the order of a feed
+type PostSort struct {
+	Mode string
+	// Since limits SortTop to posts created at or after this unix time, 0 means all time
+	Since int64
+	// At is the unix time the feed was requested at, posts pinned at that time are left out of the ranked pages
+	At int64
+	// Sub limits the feed to the posts of one sub-forum, empty means every sub
+	Sub string
+}
+
+// NewPostSort validates the sort and window parameters of a feed request, an empty mode means defaultMode
+// A window like "24h" is only accepted with SortTop
+func NewPostSort(mode string, window string, defaultMode string) (PostSort, error) {
+	if mode == "" {
+		mode = defaultMode
+	}
+	switch mode {
+	case SortNew, SortTop, SortHot, SortControversial, SortWilson:
+	default:
+		return PostSort{}, fmt.Errorf("unsupported sort: %s", mode)
+	}
+	now := time.Now()
+	sort := PostSort{Mode: mode, At: now.Unix()}
+	if window == "" {
+		return sort, nil
+	}
+	if mode != SortTop {
+		return PostSort{}, fmt.Errorf("window is only supported with sort=%s", SortTop)
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration <= 0 {
+		return PostSort{}, fmt.Errorf("window must be a positive duration like 24h, got %s", window)
+	}
+	sort.Since = now.Add(-duration).Unix()
+	return sort, nil
+}
+
+// CheckCursor rejects cursors issued for another sort mode
+func (s PostSort) CheckCursor(page PageRequest) error {
+	if page.After != nil && page.After.Sort != s.Mode {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// SortKeys returns the values a v1 post is sorted by in mode, matching the fields the repositories sort on
+func (p ForumPost) SortKeys(mode string) []float64 {
+	return sortKeys(mode, p.UpdatedAt, p.ForumVotes.VotesSum, p.Ranking)
+}
+
+// SortKeys returns the values a v2 post is sorted by in mode, matching the fields the repositories sort on
+func (p DBForumPost) SortKeys(mode string) []float64 {
+	return sortKeys(mode, p.Metadata.UpdatedAt, p.Ranking.Score, p.Ranking)
+}
+
+func sortKeys(mode string, updatedAt int64, score int64, ranking Ranking) []float64 {
+	switch mode {
+	case SortTop:
+		return []float64{float64(score), float64(updatedAt)}
+	case SortHot:
+		return []float64{ranking.Hot}
+	case SortControversial:
+		return []float64{ranking.Controversial}
+	case SortWilson:
+		return []float64{ranking.Wilson}
+	default:
+		return []float64{float64(updatedAt)}
+	}
+}
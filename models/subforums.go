@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultSubSlug is the sub posts go to when they name none, posts from before subs existed are moved into it
+const DefaultSubSlug = "general"
+
+// ErrPostingDenied is returned by PostingSub when the rules of a sub do not allow the user to post in it
+var ErrPostingDenied = errors.New("posting in this sub is restricted")
+
+// Limits of sub fields, in characters
+const (
+	maxSubNameLength        = 50
+	maxSubDescriptionLength = 1000
+	maxSubGuidelines        = 20
+	maxSubGuidelineLength   = 500
+)
+
+// subSlugPattern allows lowercase letters, digits and dashes, slugs are used in URLs and never change
+var subSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+
+// SubForum is a section of the forum, posts name it by slug in their sub field
+type SubForum struct {
+	ID          string `bson:"_id" json:"_id"`
+	Slug        string `bson:"slug" json:"slug"`
+	Name        string `bson:"name" json:"name"`
+	Description string `bson:"description" json:"description"`
+	// Moderators are user ids allowed to update the sub and always allowed to post in it
+	Moderators   []string     `bson:"moderators" json:"moderators"`
+	PostingRules PostingRules `bson:"postingRules" json:"postingRules"`
+	Metadata     Metadata     `bson:"metadata" json:"metadata"`
+}
+
+// PostingRules decide who may post in a sub
+type PostingRules struct {
+	// MinRole is the lowest role allowed to post, empty means every member
+	MinRole string `bson:"minRole" json:"minRole"`
+	// Guidelines are shown to users before they post
+	Guidelines []string `bson:"guidelines" json:"guidelines"`
+}
+
+// IsModerator reports whether userID moderates the sub
+func (s SubForum) IsModerator(userID string) bool {
+	for _, moderator := range s.Moderators {
+		if moderator == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// CanPost reports whether a user with role may post in the sub
+func (s SubForum) CanPost(userID string, role string) bool {
+	return s.IsModerator(userID) || HasRole(role, s.PostingRules.MinRole)
+}
+
+// SubForumUpdate is a partial update of a sub, nil fields are left unchanged
+// The slug is not part of it since posts refer to subs by slug
+type SubForumUpdate struct {
+	Name         *string       `json:"name"`
+	Description  *string       `json:"description"`
+	Moderators   *[]string     `json:"moderators"`
+	PostingRules *PostingRules `json:"postingRules"`
+}
+
+// Apply sets the non-nil fields of u on sub
+func (u SubForumUpdate) Apply(sub *SubForum) {
+	if u.Name != nil {
+		sub.Name = *u.Name
+	}
+	if u.Description != nil {
+		sub.Description = *u.Description
+	}
+	if u.Moderators != nil {
+		sub.Moderators = *u.Moderators
+	}
+	if u.PostingRules != nil {
+		sub.PostingRules = *u.PostingRules
+	}
+}
+
+// validateSubForum checks the fields of a new sub
+func validateSubForum(sub SubForum) *ValidationError {
+	invalid := &ValidationError{}
+	if !subSlugPattern.MatchString(sub.Slug) {
+		invalid.add("slug", "must be 2 to 32 lowercase letters, digits or dashes, starting with a letter or digit")
+	}
+	fields := SubForumUpdate{
+		Name:         &sub.Name,
+		Description:  &sub.Description,
+		Moderators:   &sub.Moderators,
+		PostingRules: &sub.PostingRules,
+	}.validate()
+	if fields != nil {
+		invalid.Fields = append(invalid.Fields, fields.Fields...)
+	}
+	return invalid.orNil()
+}
+
+// validate checks the fields that are set
+func (u SubForumUpdate) validate() *ValidationError {
+	invalid := &ValidationError{}
+	if u.Name != nil {
+		if strings.TrimSpace(*u.Name) == "" {
+			invalid.add("name", "is required")
+		} else if utf8.RuneCountInString(*u.Name) > maxSubNameLength {
+			invalid.add("name", fmt.Sprintf("must be at most %d characters", maxSubNameLength))
+		}
+	}
+	if u.Description != nil && utf8.RuneCountInString(*u.Description) > maxSubDescriptionLength {
+		invalid.add("description", fmt.Sprintf("must be at most %d characters", maxSubDescriptionLength))
+	}
+	if u.Moderators != nil {
+		seen := map[string]bool{}
+		for _, moderator := range *u.Moderators {
+			if moderator == "" || seen[moderator] {
+				invalid.add("moderators", "must be distinct user ids")
+				break
+			}
+			seen[moderator] = true
+		}
+	}
+	if rules := u.PostingRules; rules != nil {
+		if rules.MinRole != "" {
+			if err := ValidateRole(rules.MinRole); err != nil {
+				invalid.add("postingRules.minRole", err.Error())
+			}
+		}
+		if len(rules.Guidelines) > maxSubGuidelines {
+			invalid.add("postingRules.guidelines", fmt.Sprintf("must have at most %d entries", maxSubGuidelines))
+		}
+		for _, guideline := range rules.Guidelines {
+			if utf8.RuneCountInString(guideline) > maxSubGuidelineLength {
+				invalid.add("postingRules.guidelines", fmt.Sprintf("entries must be at most %d characters", maxSubGuidelineLength))
+				break
+			}
+		}
+	}
+	return invalid.orNil()
+}
+
+// PostingSub returns the slug of the sub userID posts into, an empty slug means DefaultSubSlug
+// Unknown subs give ErrNotFound and users the posting rules leave out ErrPostingDenied
+func PostingSub(ctx context.Context, subs SubForumRepository, authorizer *Authorizer, userID string, slug string) (string, error) {
+	if slug == "" {
+		slug = DefaultSubSlug
+	}
+	sub, err := subs.GetSubForum(ctx, slug)
+	if err != nil {
+		return "", err
+	}
+	role, err := authorizer.Role(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if !sub.CanPost(userID, role) {
+		return "", ErrPostingDenied
+	}
+	return slug, nil
+}
+
+// EnsureDefaultSubForum creates the DefaultSubSlug sub unless it exists
+func EnsureDefaultSubForum(ctx context.Context, subs SubForumRepository) error {
+	_, err := subs.GetSubForum(ctx, DefaultSubSlug)
+	if err != ErrNotFound {
+		return err
+	}
+	now := time.Now().Unix()
+	_, err = subs.InsertSubForum(ctx, SubForum{
+		Slug:       DefaultSubSlug,
+		Name:       "General",
+		Moderators: []string{},
+		Metadata:   Metadata{CreatedAt: now, UpdatedAt: now},
+	})
+	// Another instance may have created it meanwhile
+	if err == ErrConflict {
+		return nil
+	}
+	return err
+}
+
+// BackfillSubForums creates the default sub and moves the v1 and v2 posts without a sub into it
+func BackfillSubForums(ctx context.Context, store *Store) (int, error) {
+	if err := EnsureDefaultSubForum(ctx, store.SubForums); err != nil {
+		return 0, fmt.Errorf("creating the default sub: %v", err)
+	}
+	moved, err := store.Posts.MoveSubPosts(ctx, "", DefaultSubSlug)
+	if err != nil {
+		return moved, fmt.Errorf("moving posts: %v", err)
+	}
+	return moved, nil
+}
@@ -0,0 +1,264 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gguan/cwgcf_db/auth"
+	"gguan/cwgcf_db/config"
+
+	"github.com/gorilla/mux"
+)
+
+// SubForumServer is the definition of a REST API for sub-forums
+// Admins create and delete subs, admins and the moderators of a sub update it
+type SubForumServer struct {
+	SubForums  SubForumRepository
+	Posts      PostRepository
+	Profiles   ProfileRepository
+	Authorizer *Authorizer
+	Timeout    time.Duration
+}
+
+// NewSubForumServer creates a new Server instance
+func NewSubForumServer(store *Store, cfg *config.Config, authorizer *Authorizer) *SubForumServer {
+	return &SubForumServer{
+		SubForums:  store.SubForums,
+		Posts:      store.Posts,
+		Profiles:   store.Profiles,
+		Authorizer: authorizer,
+		Timeout:    cfg.Server.RequestTimeout.Duration,
+	}
+}
+
+// GetAll handles requests to list every sub, ordered by slug
+func (s *SubForumServer) GetAll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	subs, err := s.SubForums.GetSubForums(ctx)
+	if err != nil {
+		log.Printf("Error getting subs: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get subs"}`))
+		return
+	}
+	res, _ := json.Marshal(subs)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// Get handles requests for one sub by slug
+func (s *SubForumServer) Get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	slug := mux.Vars(r)["slug"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	sub, err := s.SubForums.GetSubForum(ctx, slug)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting sub %s: %v", slug, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to get sub"}`))
+		return
+	}
+	res, _ := json.Marshal(sub)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// Post handles requests to create a sub, routes are expected to require RoleAdmin
+func (s *SubForumServer) Post(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var sub SubForum
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&sub)
+	if err != nil {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	if sub.Moderators == nil {
+		sub.Moderators = []string{}
+	}
+	invalid := validateSubForum(sub)
+	if invalid != nil {
+		writeValidationError(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	if !s.checkModerators(ctx, w, sub.Moderators) {
+		return
+	}
+	userID := auth.UserID(r.Context())
+	now := time.Now().Unix()
+	sub.Metadata = Metadata{CreatedBy: userID, CreatedAt: now, UpdatedBy: userID, UpdatedAt: now}
+	insertID, err := s.SubForums.InsertSubForum(ctx, sub)
+	if err == ErrConflict {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf(`{"error": "Sub %s already exists"}`, sub.Slug)))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to insert sub %s: %v", sub.Slug, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to create sub"}`))
+		return
+	}
+	sub.ID = insertID
+	log.Printf("User %s created sub %s", userID, sub.Slug)
+	res, _ := json.Marshal(sub)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// Patch handles partial updates of a sub, only admins may change its moderators
+func (s *SubForumServer) Patch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	slug := mux.Vars(r)["slug"]
+	var update SubForumUpdate
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&update)
+	if err != nil {
+		log.Printf("Failed to decode body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Check your request"}`))
+		return
+	}
+	if invalid := update.validate(); invalid != nil {
+		writeValidationError(w, invalid)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	sub, err := s.SubForums.GetSubForum(ctx, slug)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting sub %s: %v", slug, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to update sub"}`))
+		return
+	}
+	userID := auth.UserID(r.Context())
+	role, err := s.Authorizer.Role(ctx, userID)
+	if err != nil {
+		log.Printf("Error getting role: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return
+	}
+	isAdmin := HasRole(role, RoleAdmin)
+	if !isAdmin && !sub.IsModerator(userID) {
+		WriteForbidden(w, fmt.Sprintf("moderator of the sub or %s role required", RoleAdmin))
+		return
+	}
+	if update.Moderators != nil {
+		if !isAdmin {
+			WriteForbidden(w, fmt.Sprintf("%s role required to change moderators", RoleAdmin))
+			return
+		}
+		if !s.checkModerators(ctx, w, *update.Moderators) {
+			return
+		}
+	}
+	sub, err = s.SubForums.UpdateSubForum(ctx, slug, update, Metadata{UpdatedBy: userID, UpdatedAt: time.Now().Unix()})
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update sub %s: %v", slug, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to update sub"}`))
+		return
+	}
+	res, _ := json.Marshal(sub)
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// Delete handles requests to delete a sub, its posts move to DefaultSubSlug, which cannot be deleted
+// Routes are expected to require RoleAdmin
+func (s *SubForumServer) Delete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	slug := mux.Vars(r)["slug"]
+	if slug == DefaultSubSlug {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": "The default sub cannot be deleted"}`))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	err := s.SubForums.DeleteSubForum(ctx, slug)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "Not found"}`))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete sub %s: %v", slug, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to delete sub"}`))
+		return
+	}
+	// The sub is gone first so no post lands in it after the move
+	moved, err := s.Posts.MoveSubPosts(ctx, slug, DefaultSubSlug)
+	if err != nil {
+		log.Printf("Failed to move the posts of deleted sub %s: %v", slug, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to move posts"}`))
+		return
+	}
+	log.Printf("User %s deleted sub %s and moved %d posts to %s", auth.UserID(r.Context()), slug, moved, DefaultSubSlug)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"deletedSlug": "%s", "movedPosts": %d}`, slug, moved)))
+}
+
+// checkModerators responds 400 and returns false unless every moderator has a profile
+func (s *SubForumServer) checkModerators(ctx context.Context, w http.ResponseWriter, moderators []string) bool {
+	if len(moderators) == 0 {
+		return true
+	}
+	profiles, err := s.Profiles.GetProfiles(ctx, moderators)
+	if err != nil {
+		log.Printf("Error getting profiles: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Internal error"}`))
+		return false
+	}
+	invalid := &ValidationError{}
+	for _, moderator := range moderators {
+		if _, ok := profiles[moderator]; !ok {
+			invalid.add("moderators", fmt.Sprintf("no profile for %s", moderator))
+		}
+	}
+	if invalid.orNil() != nil {
+		writeValidationError(w, invalid)
+		return false
+	}
+	return true
+}
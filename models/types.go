@@ -7,30 +7,94 @@ type Profile struct {
 	Title       string `bson:"title" json:"title"`
 	Description string `bson:"description" json:"description"`
 	AvatarURL   string `bson:"avatarUrl" json:"avatarUrl"`
+	// Role is one of RoleMember, RoleModerator and RoleAdmin, empty for profiles created before roles existed
+	Role string `bson:"role" json:"role"`
 }
 
 // Photo is the definition of a photo
+// Photos added with a url are hosted elsewhere, uploaded ones are kept in the blob store and served by the API
 type Photo struct {
 	ID  string `bson:"_id" json:"_id"`
 	URL string `bson:"url" json:"url"`
+	// BlobKey locates the bytes of an uploaded photo, it is empty for photos added by url
+	BlobKey     string `bson:"blobKey" json:"-"`
+	ContentType string `bson:"contentType" json:"contentType"`
+	Size        int64  `bson:"size" json:"size"`
+	Width       int    `bson:"width" json:"width"`
+	Height      int    `bson:"height" json:"height"`
+	// UserID is the uploader
+	UserID   string   `bson:"userId" json:"userId"`
+	Caption  string   `bson:"caption" json:"caption"`
+	Tags     []string `bson:"tags" json:"tags"`
+	Metadata Metadata `bson:"metadata" json:"metadata"`
+	// Variants are the resized copies, they are generated in the background after the upload
+	Variants []PhotoVariant `bson:"variants" json:"variants"`
+	// PhotoHashes are set for uploaded photos, photos uploaded before they existed get them from process-photos
+	PhotoHashes `bson:",inline"`
+	// TakenAt is the unix time the photo was taken at if its EXIF data tells, else when it was added
+	TakenAt int64 `bson:"takenAt" json:"takenAt"`
+	// EXIF is what was read from the EXIF data of an uploaded JPEG
+	EXIF PhotoEXIF `bson:"exif" json:"exif"`
+}
+
+// PhotoEXIF is the metadata kept from the EXIF data of an upload, the location is never kept here
+type PhotoEXIF struct {
+	// TakenAt is the capture time as recorded, 0 when it was not
+	TakenAt int64 `bson:"takenAt" json:"takenAt"`
+	// Orientation is the orientation of the upload, stored photos are already turned upright
+	Orientation int    `bson:"orientation" json:"orientation"`
+	Make        string `bson:"make" json:"make"`
+	Model       string `bson:"model" json:"model"`
+}
+
+// PhotoHashes identify the picture of an uploaded photo to find copies of it
+type PhotoHashes struct {
+	// SHA256 is the hex digest of the uploaded bytes
+	SHA256 string `bson:"sha256" json:"sha256"`
+	// DHash is the perceptual hash of the picture, stored as int64 since BSON has no unsigned integers
+	DHash int64 `bson:"dHash" json:"-"`
+	// DHashBands are the bytes of DHash tagged with their position, photos sharing one are candidates for a copy
+	DHashBands []int64 `bson:"dHashBands" json:"-"`
+}
+
+// PhotoVariant is a resized copy of an uploaded photo, stored next to it in the blob store
+type PhotoVariant struct {
+	Name        string `bson:"name" json:"name"`
+	URL         string `bson:"-" json:"url"`
+	BlobKey     string `bson:"blobKey" json:"-"`
+	ContentType string `bson:"contentType" json:"contentType"`
+	Size        int64  `bson:"size" json:"size"`
+	Width       int    `bson:"width" json:"width"`
+	Height      int    `bson:"height" json:"height"`
 }
 
 // ForumPost is the definition of a post in forum
 // Save comments in a different table with key being post ID because comments are usually not fetched at the same time the content is fetched
 type ForumPost struct {
-	ID          string     `bson:"_id" json:"_id"`
-	Title       string     `bson:"title" json:"title"`
-	Content     string     `bson:"content" json:"content"`
-	Image       string     `bson:"image" json:"image"`
-	CreatedAt   int64      `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   int64      `bson:"updatedAt" json:"updatedAt"`
-	UserID      string     `bson:"userId" json:"userId"`
-	UserProfile Profile    `bson:"userProfile" json:"userProfile"`
-	ForumVotes  ForumVotes `bson:"forumVotes" json:"forumVotes"`
+	ID      string `bson:"_id" json:"_id"`
+	Title   string `bson:"title" json:"title"`
+	Content string `bson:"content" json:"content"`
+	Image   string `bson:"image" json:"image"`
+	// ImageID references an uploaded photo, Image is set to its url
+	ImageID     string  `bson:"imageId" json:"imageId"`
+	CreatedAt   int64   `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   int64   `bson:"updatedAt" json:"updatedAt"`
+	UserID      string  `bson:"userId" json:"userId"`
+	UserProfile Profile `bson:"userProfile" json:"userProfile"`
+	// Sub is the slug of the sub-forum of the post
+	Sub        string     `bson:"sub" json:"sub"`
+	ForumVotes ForumVotes `bson:"forumVotes" json:"forumVotes"`
+	Ranking    Ranking    `bson:"ranking" json:"ranking"`
+	// Pin is only returned by the feeds on the pinned posts they list first
+	Pin *Pin `bson:"pin,omitempty" json:"pin,omitempty"`
+	// Hidden posts are left out of listings by moderators
+	Hidden bool `bson:"hidden" json:"hidden"`
+	// Deleted posts are left out of listings by their author, their former versions are kept as revisions
+	Deleted bool `bson:"deleted" json:"deleted"`
 }
 
 // ForumVotes is the definition of votes of a forum post/comment
-// Upvotes/downvotes not used at the moment
+// Upvotes and downvotes are kept alongside votesSum since the vote event log, the backfill-vote-events migration fills them in for older data
 type ForumVotes struct {
 	Upvotes   int64 `bson:"upvotes" json:"upvotes"`
 	Downvotes int64 `bson:"downvotes" json:"downvotes"`
@@ -39,9 +103,13 @@ type ForumVotes struct {
 
 // ForumComment is the definition of a forum comment
 // Subcomments are usually fetched alongside with parent comments
+// PostID is the post at the root of the thread and Path lists the ancestor comment ids from the top down,
+// so a whole thread is loaded with one query on postId
 type ForumComment struct {
 	ID          string         `bson:"_id" json:"_id"`
 	ParentID    string         `bson:"parentId" json:"parentId"`
+	PostID      string         `bson:"postId" json:"postId"`
+	Path        []string       `bson:"path" json:"path"`
 	Content     string         `bson:"content" json:"content"`
 	CreatedAt   int64          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt   int64          `bson:"updatedAt" json:"updatedAt"`
@@ -49,12 +117,17 @@ type ForumComment struct {
 	UserProfile Profile        `bson:"userProfile" json:"userProfile"`
 	ForumVotes  ForumVotes     `bson:"forumVotes" json:"forumVotes"`
 	Comments    []ForumComment `bson:"comments" json:"comments"`
+	// Hidden comments are shown as HiddenContent so their replies stay in place
+	Hidden bool `bson:"hidden" json:"hidden"`
+	// Deleted comments are shown as DeletedContent by DeletedUserID so their replies stay in place
+	Deleted bool `bson:"deleted" json:"deleted"`
 }
 
 //ForumVoteRequest is the definition for vote request
 /*
 	IsPost indicates if vote is for forumPost or forumComment
 	TapUpvote indicates if tapped on upvote or downvote (could be vote or unvote, will be decided in Backend)
+	UserID is taken from the bearer token, a value sent in the body is ignored
 */
 type ForumVoteRequest struct {
 	VoteID    string `bson:"voteId" json:"voteId"`
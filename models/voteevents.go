@@ -0,0 +1,83 @@
+package models
+
+import (
+	"context"
+	"fmt"
+)
+
+// VoteEvent is one change of a user's vote, forumVoteEvents is append-only apart from deleting a user
+// TargetKind is CountVote for v2 votes and CountPost or CountComment for v1 votes
+// CreatedAt is the server time of the change in unix seconds
+type VoteEvent struct {
+	ID         string `bson:"_id" json:"_id"`
+	TargetKind string `bson:"targetKind" json:"targetKind"`
+	TargetID   string `bson:"targetId" json:"targetId"`
+	UserID     string `bson:"userId" json:"userId"`
+	From       int    `bson:"from" json:"from"`
+	To         int    `bson:"to" json:"to"`
+	CreatedAt  int64  `bson:"createdAt" json:"createdAt"`
+	// Backfilled events were recreated from the vote maps, the time of the vote is unknown so CreatedAt is 0
+	Backfilled bool `bson:"backfilled" json:"backfilled"`
+}
+
+// BackfillVoteEvents records an event for every vote in forumVoteMap and forumUserVotes that has none yet, then
+// reconciles the tallies to fill in the upvotes and downvotes that were not kept before the event log
+// Votes with an event already are skipped, so running it again only fills in what is missing
+// It returns how many events were recorded and tallies fixed
+func BackfillVoteEvents(ctx context.Context, store *Store) (int, error) {
+	inserted := 0
+	backfill := func(kind string, targetID string, userID string, status int) error {
+		if status == VoteNone {
+			return nil
+		}
+		ok, err := store.VoteEvents.HasVoteEvents(ctx, targetID, userID)
+		if err != nil || ok {
+			return err
+		}
+		event := VoteEvent{TargetKind: kind, TargetID: targetID, UserID: userID, From: VoteNone, To: status, Backfilled: true}
+		if _, err := store.VoteEvents.InsertVoteEvent(ctx, event); err != nil {
+			return err
+		}
+		inserted++
+		return nil
+	}
+
+	err := store.VoteMaps.ForEachVoteMap(ctx, func(voteMap ForumVoteMap) error {
+		for id, entry := range voteMap.VoteMap {
+			if err := backfill(CountVote, id, voteMap.UserID, entry.VoteStatus); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return inserted, fmt.Errorf("backfilling from vote maps: %v", err)
+	}
+
+	// v1 votes do not record whether they target a post or a comment
+	posts := map[string]bool{}
+	err = store.Posts.ForEachPost(ctx, func(post ForumPost) error {
+		posts[post.ID] = true
+		return nil
+	})
+	if err != nil {
+		return inserted, fmt.Errorf("reading posts: %v", err)
+	}
+	err = store.UserVotes.ForEachUserVotes(ctx, func(userVotes ForumUserVotes) error {
+		for id, vote := range userVotes.VoteMap {
+			kind := CountComment
+			if posts[id] {
+				kind = CountPost
+			}
+			if err := backfill(kind, id, userVotes.UserID, vote.VoteStatus); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return inserted, fmt.Errorf("backfilling from user votes: %v", err)
+	}
+	report, err := ReconcileVotes(ctx, store, true)
+	return inserted + report.Fixed(), err
+}
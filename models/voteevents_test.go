@@ -0,0 +1,73 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+
+	"gguan/cwgcf_db/models"
+	"gguan/cwgcf_db/storage/memory"
+)
+
+func TestBackfillVoteEvents(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	// Votes from before the event log, whose tallies only kept the sum
+	postID, err := store.Posts.InsertPost(ctx, models.ForumPost{Title: "t", UserID: "u1", CreatedAt: 1600000000})
+	check(t, err)
+	commentID, err := store.Comments.InsertComment(ctx, models.ForumComment{ParentID: postID, UserID: "u2", CreatedAt: 1600000100})
+	check(t, err)
+	check(t, store.UserVotes.SetUserVote(ctx, "u1", postID, models.VoteUp))
+	check(t, store.UserVotes.SetUserVote(ctx, "u2", postID, models.VoteDown))
+	check(t, store.UserVotes.SetUserVote(ctx, "u1", commentID, models.VoteUp))
+	check(t, store.Comments.IncCommentVotes(ctx, commentID, models.VoteTally{Sum: 1}))
+	// A v2 vote cast since has its event already
+	voteID := insertVote(t, store)
+	_, err = store.Votes.ApplyVote(ctx, "u1", voteID, models.VoteUp, models.Metadata{})
+	check(t, err)
+
+	changed, err := models.BackfillVoteEvents(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three events and the tallies of the post and the comment
+	if changed != 5 {
+		t.Errorf("changed %d, want 5", changed)
+	}
+	tests := []struct {
+		targetID string
+		voters   int
+		tally    func() (models.VoteTally, error)
+		want     models.VoteTally
+	}{
+		{postID, 2, func() (models.VoteTally, error) {
+			post, err := store.Posts.GetPost(ctx, postID)
+			return post.ForumVotes.Tally(), err
+		}, models.VoteTally{Sum: 0, Up: 1, Down: 1}},
+		{commentID, 1, func() (models.VoteTally, error) {
+			comment, err := store.Comments.GetComment(ctx, commentID)
+			return comment.ForumVotes.Tally(), err
+		}, models.VoteTally{Sum: 1, Up: 1}},
+		{voteID, 1, func() (models.VoteTally, error) {
+			vote, err := store.Votes.GetVote(ctx, voteID)
+			return vote.Tally(), err
+		}, models.VoteTally{Sum: 1, Up: 1}},
+	}
+	for _, test := range tests {
+		voters, err := store.VoteEvents.GetVoters(ctx, test.targetID)
+		if err != nil || len(voters) != test.voters {
+			t.Errorf("%s: voters %+v, want %d: %v", test.targetID, voters, test.voters, err)
+		}
+		for _, voter := range voters {
+			if voter.Backfilled != (test.targetID != voteID) {
+				t.Errorf("%s: event %+v", test.targetID, voter)
+			}
+		}
+		if tally, err := test.tally(); err != nil || tally != test.want {
+			t.Errorf("%s: tally %v, want %v: %v", test.targetID, tally, test.want, err)
+		}
+	}
+
+	if changed, err := models.BackfillVoteEvents(ctx, store); err != nil || changed != 0 {
+		t.Errorf("running again changed %d: %v", changed, err)
+	}
+}
@@ -0,0 +1,73 @@
+package models
+
+import "fmt"
+
+// Vote statuses of a user on a post or comment
+const (
+	VoteDown = -1
+	VoteNone = 0
+	VoteUp   = 1
+)
+
+// ValidateVoteStatus checks that status is one of VoteDown, VoteNone and VoteUp
+func ValidateVoteStatus(status int) error {
+	if status < VoteDown || status > VoteUp {
+		return fmt.Errorf("voteStatus must be -1, 0 or 1, got %d", status)
+	}
+	return nil
+}
+
+// VoteTally is the vote count of a post, comment or v2 vote split into upvotes and downvotes
+// Sum is forumVotes.votesSum for v1 posts and comments and count for v2 votes
+type VoteTally struct {
+	Sum  int64 `json:"sum"`
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+func (t VoteTally) String() string {
+	return fmt.Sprintf("%d (+%d/-%d)", t.Sum, t.Up, t.Down)
+}
+
+// TallyChange returns how a tally changes when a user's vote goes from one status to another
+func TallyChange(from int, to int) VoteTally {
+	change := VoteTally{Sum: int64(to - from)}
+	switch from {
+	case VoteUp:
+		change.Up--
+	case VoteDown:
+		change.Down--
+	}
+	switch to {
+	case VoteUp:
+		change.Up++
+	case VoteDown:
+		change.Down++
+	}
+	return change
+}
+
+// Add returns the tally changed by change
+func (t VoteTally) Add(change VoteTally) VoteTally {
+	return VoteTally{Sum: t.Sum + change.Sum, Up: t.Up + change.Up, Down: t.Down + change.Down}
+}
+
+// Count adds a vote with the given status to the tally
+func (t *VoteTally) Count(status int) {
+	*t = t.Add(TallyChange(VoteNone, status))
+}
+
+// ForumVotes returns the tally in the form stored with v1 posts and comments
+func (t VoteTally) ForumVotes() ForumVotes {
+	return ForumVotes{Upvotes: t.Up, Downvotes: t.Down, VotesSum: t.Sum}
+}
+
+// Tally returns the tally of a v1 post or comment
+func (v ForumVotes) Tally() VoteTally {
+	return VoteTally{Sum: v.VotesSum, Up: v.Upvotes, Down: v.Downvotes}
+}
+
+// Tally returns the tally of a v2 vote
+func (v ForumVote) Tally() VoteTally {
+	return VoteTally{Sum: v.Count, Up: v.Upvotes, Down: v.Downvotes}
+}
@@ -0,0 +1,77 @@
+package models
+
+import "testing"
+
+func TestTallyChange(t *testing.T) {
+	tests := []struct {
+		from, to int
+		want     VoteTally
+	}{
+		{VoteNone, VoteNone, VoteTally{}},
+		{VoteNone, VoteUp, VoteTally{Sum: 1, Up: 1}},
+		{VoteNone, VoteDown, VoteTally{Sum: -1, Down: 1}},
+		{VoteUp, VoteUp, VoteTally{}},
+		{VoteUp, VoteNone, VoteTally{Sum: -1, Up: -1}},
+		{VoteUp, VoteDown, VoteTally{Sum: -2, Up: -1, Down: 1}},
+		{VoteDown, VoteDown, VoteTally{}},
+		{VoteDown, VoteNone, VoteTally{Sum: 1, Down: -1}},
+		{VoteDown, VoteUp, VoteTally{Sum: 2, Up: 1, Down: -1}},
+	}
+	for _, test := range tests {
+		if got := TallyChange(test.from, test.to); got != test.want {
+			t.Errorf("TallyChange(%d, %d) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+// Votes are sent as the wanted status, so retrying a status or taking a detour must end at the same tally
+func TestTallyChangeComposes(t *testing.T) {
+	statuses := []int{VoteDown, VoteNone, VoteUp}
+	for _, from := range statuses {
+		for _, via := range statuses {
+			for _, to := range statuses {
+				direct := TallyChange(from, to)
+				detour := TallyChange(from, via).Add(TallyChange(via, to))
+				if direct != detour {
+					t.Errorf("%d to %d is %v, via %d it is %v", from, to, direct, via, detour)
+				}
+				retried := TallyChange(from, to).Add(TallyChange(to, to))
+				if direct != retried {
+					t.Errorf("%d to %d is %v, sent twice it is %v", from, to, direct, retried)
+				}
+			}
+		}
+	}
+}
+
+func TestVoteTallyCount(t *testing.T) {
+	tests := []struct {
+		statuses []int
+		want     VoteTally
+	}{
+		{nil, VoteTally{}},
+		{[]int{VoteUp, VoteUp, VoteDown}, VoteTally{Sum: 1, Up: 2, Down: 1}},
+		{[]int{VoteNone, VoteDown}, VoteTally{Sum: -1, Down: 1}},
+	}
+	for _, test := range tests {
+		var tally VoteTally
+		for _, status := range test.statuses {
+			tally.Count(status)
+		}
+		if tally != test.want {
+			t.Errorf("counting %v gives %v, want %v", test.statuses, tally, test.want)
+		}
+		if back := tally.ForumVotes().Tally(); back != tally {
+			t.Errorf("%v stored as forum votes reads back as %v", tally, back)
+		}
+	}
+}
+
+func TestValidateVoteStatus(t *testing.T) {
+	for status := -3; status <= 3; status++ {
+		valid := status >= VoteDown && status <= VoteUp
+		if err := ValidateVoteStatus(status); (err == nil) != valid {
+			t.Errorf("ValidateVoteStatus(%d) = %v, want valid %v", status, err, valid)
+		}
+	}
+}
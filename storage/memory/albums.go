@@ -0,0 +1,270 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"sort"
+)
+
+type albumRepository struct {
+	db *db
+}
+
+func (r *albumRepository) GetAlbums(ctx context.Context, ownerID string, viewerID string, private bool) ([]models.Album, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	res := []models.Album{}
+	items := []keyed{}
+	for _, album := range r.db.albums {
+		if ownerID != "" && album.OwnerID != ownerID {
+			continue
+		}
+		if !private && album.Visibility != models.AlbumPublic && album.OwnerID != viewerID {
+			continue
+		}
+		res = append(res, *album)
+		items = append(items, keyed{keys: []float64{float64(album.Metadata.CreatedAt)}, id: album.ID})
+	}
+	sort.Sort(byKeys{items, func(i, j int) { res[i], res[j] = res[j], res[i] }})
+	return res, nil
+}
+
+func (r *albumRepository) GetAlbum(ctx context.Context, id string) (models.Album, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	if album := r.db.findAlbum(id); album != nil {
+		return *album, nil
+	}
+	return models.Album{}, models.ErrNotFound
+}
+
+func (r *albumRepository) InsertAlbum(ctx context.Context, album models.Album) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	album.ID = newID()
+	r.db.albums = append(r.db.albums, &album)
+	return album.ID, nil
+}
+
+func (r *albumRepository) UpdateAlbum(ctx context.Context, id string, update models.AlbumUpdate, metadata models.Metadata) (models.Album, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	album := r.db.findAlbum(id)
+	if album == nil {
+		return models.Album{}, models.ErrNotFound
+	}
+	update.Apply(album)
+	album.Metadata.UpdatedBy = metadata.UpdatedBy
+	album.Metadata.UpdatedAt = metadata.UpdatedAt
+	return *album, nil
+}
+
+func (r *albumRepository) ClearAlbumCover(ctx context.Context, id string, photoID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if album := r.db.findAlbum(id); album != nil && album.CoverPhotoID == photoID {
+		album.CoverPhotoID = ""
+	}
+	return nil
+}
+
+func (r *albumRepository) DeleteAlbum(ctx context.Context, id string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, album := range r.db.albums {
+		if album.ID == id {
+			r.db.albums = append(r.db.albums[:i], r.db.albums[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (r *albumRepository) GetAlbumPhotos(ctx context.Context, albumID string, page models.PageRequest) ([]models.AlbumPhoto, *models.Cursor, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	entries, items := r.db.albumEntries(albumID)
+	start, end, next, err := paginate(items, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entries[start:end], next, nil
+}
+
+func (r *albumRepository) GetAlbumPhotoIDs(ctx context.Context, albumID string) ([]string, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	entries, _ := r.db.albumEntries(albumID)
+	res := []string{}
+	for _, entry := range entries {
+		res = append(res, entry.PhotoID)
+	}
+	return res, nil
+}
+
+func (r *albumRepository) GetAlbumPhoto(ctx context.Context, albumID string, photoID string) (models.AlbumPhoto, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	if entry := r.db.findAlbumPhoto(albumID, photoID); entry != nil {
+		return *entry, nil
+	}
+	return models.AlbumPhoto{}, models.ErrNotFound
+}
+
+func (r *albumRepository) AddAlbumPhoto(ctx context.Context, entry models.AlbumPhoto) (models.AlbumPhoto, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if r.db.findAlbumPhoto(entry.AlbumID, entry.PhotoID) != nil {
+		return models.AlbumPhoto{}, models.ErrConflict
+	}
+	entry.ID = newID()
+	entry.Rank = r.db.nextAlbumRank(entry.AlbumID)
+	r.db.albumPhotos = append(r.db.albumPhotos, &entry)
+	return entry, nil
+}
+
+func (r *albumRepository) MoveAlbumPhoto(ctx context.Context, albumID string, photoID string, toAlbumID string) (models.AlbumPhoto, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	entry := r.db.findAlbumPhoto(albumID, photoID)
+	if entry == nil {
+		return models.AlbumPhoto{}, models.ErrNotFound
+	}
+	if r.db.findAlbumPhoto(toAlbumID, photoID) != nil {
+		return models.AlbumPhoto{}, models.ErrConflict
+	}
+	entry.Rank = r.db.nextAlbumRank(toAlbumID)
+	entry.AlbumID = toAlbumID
+	return *entry, nil
+}
+
+func (r *albumRepository) SetAlbumPhotoRanks(ctx context.Context, albumID string, ranks map[string]float64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, entry := range r.db.albumPhotos {
+		if rank, ok := ranks[entry.PhotoID]; ok && entry.AlbumID == albumID {
+			entry.Rank = rank
+		}
+	}
+	return nil
+}
+
+func (r *albumRepository) RemoveAlbumPhoto(ctx context.Context, albumID string, photoID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, entry := range r.db.albumPhotos {
+		if entry.AlbumID == albumID && entry.PhotoID == photoID {
+			r.db.albumPhotos = append(r.db.albumPhotos[:i], r.db.albumPhotos[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (r *albumRepository) RemoveAllAlbumPhotos(ctx context.Context, albumID string) (int, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	kept := []*models.AlbumPhoto{}
+	for _, entry := range r.db.albumPhotos {
+		if entry.AlbumID != albumID {
+			kept = append(kept, entry)
+		}
+	}
+	removed := len(r.db.albumPhotos) - len(kept)
+	r.db.albumPhotos = kept
+	return removed, nil
+}
+
+func (r *albumRepository) RemovePhotoFromAlbums(ctx context.Context, photoID string) (int, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	kept := []*models.AlbumPhoto{}
+	for _, entry := range r.db.albumPhotos {
+		if entry.PhotoID != photoID {
+			kept = append(kept, entry)
+		}
+	}
+	removed := len(r.db.albumPhotos) - len(kept)
+	r.db.albumPhotos = kept
+	for _, album := range r.db.albums {
+		if album.CoverPhotoID == photoID {
+			album.CoverPhotoID = ""
+		}
+	}
+	return removed, nil
+}
+
+func (r *albumRepository) GetPrivatePhotos(ctx context.Context, photoIDs []string) (map[string][]string, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	wanted := map[string]bool{}
+	for _, id := range photoIDs {
+		wanted[id] = true
+	}
+	res := map[string][]string{}
+	public := map[string]bool{}
+	for _, entry := range r.db.albumPhotos {
+		if !wanted[entry.PhotoID] {
+			continue
+		}
+		album := r.db.findAlbum(entry.AlbumID)
+		if album == nil {
+			continue
+		}
+		if album.Visibility == models.AlbumPublic {
+			public[entry.PhotoID] = true
+		} else {
+			res[entry.PhotoID] = append(res[entry.PhotoID], album.OwnerID)
+		}
+	}
+	for id := range public {
+		delete(res, id)
+	}
+	return res, nil
+}
+
+func (d *db) findAlbum(id string) *models.Album {
+	for _, album := range d.albums {
+		if album.ID == id {
+			return album
+		}
+	}
+	return nil
+}
+
+func (d *db) findAlbumPhoto(albumID string, photoID string) *models.AlbumPhoto {
+	for _, entry := range d.albumPhotos {
+		if entry.AlbumID == albumID && entry.PhotoID == photoID {
+			return entry
+		}
+	}
+	return nil
+}
+
+// albumEntries returns copies of the photos of an album sorted descending by rank, with their keys for paginate
+func (d *db) albumEntries(albumID string) ([]models.AlbumPhoto, []keyed) {
+	entries := []models.AlbumPhoto{}
+	items := []keyed{}
+	for _, entry := range d.albumPhotos {
+		if entry.AlbumID == albumID {
+			entries = append(entries, *entry)
+			items = append(items, keyed{keys: []float64{entry.Rank}, id: entry.ID})
+		}
+	}
+	sort.Sort(byKeys{items, func(i, j int) { entries[i], entries[j] = entries[j], entries[i] }})
+	return entries, items
+}
+
+// nextAlbumRank is the rank that places a photo after every photo of an album
+func (d *db) nextAlbumRank(albumID string) float64 {
+	rank, found := 0.0, false
+	for _, entry := range d.albumPhotos {
+		if entry.AlbumID == albumID && (!found || entry.Rank < rank) {
+			rank, found = entry.Rank, true
+		}
+	}
+	if !found {
+		return 0
+	}
+	return rank - 1
+}
@@ -0,0 +1,368 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"sort"
+)
+
+type commentRepository struct {
+	db *db
+}
+
+func (r *commentRepository) GetComment(ctx context.Context, id string) (models.ForumComment, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	if comment := r.db.findComment(id); comment != nil {
+		return r.db.copyComment(comment), nil
+	}
+	return models.ForumComment{}, models.ErrNotFound
+}
+
+func (r *commentRepository) GetCommentsByPost(ctx context.Context, postID string) ([]models.ForumComment, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	res := []models.ForumComment{}
+	for _, comment := range r.db.comments {
+		if comment.PostID == postID {
+			res = append(res, r.db.copyComment(comment))
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].ForumVotes.VotesSum != res[j].ForumVotes.VotesSum {
+			return res[i].ForumVotes.VotesSum > res[j].ForumVotes.VotesSum
+		}
+		return res[i].UpdatedAt > res[j].UpdatedAt
+	})
+	return res, nil
+}
+
+func (r *commentRepository) GetCommentsWithoutPost(ctx context.Context) ([]models.ForumComment, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	res := []models.ForumComment{}
+	for _, comment := range r.db.comments {
+		if comment.PostID == "" {
+			res = append(res, r.db.copyComment(comment))
+		}
+	}
+	return res, nil
+}
+
+func (r *commentRepository) SetCommentAncestry(ctx context.Context, id string, postID string, path []string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if comment := r.db.findComment(id); comment != nil {
+		comment.PostID = postID
+		comment.Path = append([]string{}, path...)
+	}
+	return nil
+}
+
+func (r *commentRepository) InsertComment(ctx context.Context, comment models.ForumComment) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	comment.ID = newID()
+	comment.Path = append([]string{}, comment.Path...)
+	comment.Comments = nil
+	r.db.comments = append(r.db.comments, &comment)
+	return comment.ID, nil
+}
+
+func (r *commentRepository) SetCommentUpdatedAt(ctx context.Context, id string, updatedAt int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if comment := r.db.findComment(id); comment != nil {
+		comment.UpdatedAt = updatedAt
+	}
+	return nil
+}
+
+func (r *commentRepository) IncCommentVotes(ctx context.Context, id string, change models.VoteTally) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	comment := r.db.findComment(id)
+	if comment == nil {
+		comment = &models.ForumComment{ID: id}
+		r.db.comments = append(r.db.comments, comment)
+	}
+	comment.ForumVotes = comment.ForumVotes.Tally().Add(change).ForumVotes()
+	return nil
+}
+
+func (r *commentRepository) SetCommentHidden(ctx context.Context, id string, hidden bool) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if comment := r.db.findComment(id); comment != nil {
+		comment.Hidden = hidden
+		return nil
+	}
+	if comment := r.db.findDBComment(id); comment != nil {
+		comment.Hidden = hidden
+		return nil
+	}
+	return models.ErrNotFound
+}
+
+func (r *commentRepository) EditComment(ctx context.Context, id string, from models.Revision, edit models.PostEdit) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	comment := r.db.findComment(id)
+	if comment == nil || comment.Deleted || comment.Content != from.Content {
+		return false, nil
+	}
+	applyEdit(nil, &comment.Content, &comment.Deleted, edit)
+	comment.UpdatedAt = edit.UpdatedAt
+	return true, nil
+}
+
+func (r *commentRepository) DeleteComment(ctx context.Context, id string) ([]string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if r.db.findComment(id) == nil && r.db.findDBComment(id) == nil {
+		return nil, models.ErrNotFound
+	}
+	return r.db.deleteThreads(map[string]bool{id: true}), nil
+}
+
+func (r *commentRepository) ForEachComment(ctx context.Context, fn func(models.ForumComment) error) error {
+	r.db.mu.RLock()
+	comments := make([]models.ForumComment, len(r.db.comments))
+	for i, comment := range r.db.comments {
+		comments[i] = r.db.copyComment(comment)
+	}
+	r.db.mu.RUnlock()
+	for _, comment := range comments {
+		if err := fn(comment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *commentRepository) SetCommentVotes(ctx context.Context, id string, stored models.VoteTally, tally models.VoteTally) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	comment := r.db.findComment(id)
+	if comment == nil || comment.ForumVotes.Tally() != stored {
+		return false, nil
+	}
+	comment.ForumVotes = tally.ForumVotes()
+	return true, nil
+}
+
+// findComment must be called with the lock held
+func (d *db) findComment(id string) *models.ForumComment {
+	for _, comment := range d.comments {
+		if comment.ID == id {
+			return comment
+		}
+	}
+	return nil
+}
+
+// copyComment detaches the returned comment from the stored slices
+func (d *db) copyComment(comment *models.ForumComment) models.ForumComment {
+	res := *comment
+	res.Path = append([]string{}, comment.Path...)
+	return res
+}
+
+func (r *commentRepository) ReassignUserComments(ctx context.Context, userID string, newUserID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, comment := range r.db.comments {
+		if comment.UserID == userID {
+			comment.UserID = newUserID
+		}
+	}
+	for _, comment := range r.db.dbComments {
+		if comment.UserID == userID {
+			comment.UserID = newUserID
+		}
+		if comment.Metadata.CreatedBy == userID {
+			comment.Metadata.CreatedBy = newUserID
+		}
+		if comment.Metadata.UpdatedBy == userID {
+			comment.Metadata.UpdatedBy = newUserID
+		}
+	}
+	return nil
+}
+
+func (r *commentRepository) DeleteUserComments(ctx context.Context, userID string) ([]string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	deleted := map[string]bool{}
+	for _, comment := range r.db.comments {
+		if comment.UserID == userID {
+			deleted[comment.ID] = true
+		}
+	}
+	for _, comment := range r.db.dbComments {
+		if comment.UserID == userID {
+			deleted[comment.ID] = true
+		}
+	}
+	return r.db.deleteThreads(deleted), nil
+}
+
+func (r *commentRepository) DeleteCommentsByPosts(ctx context.Context, postIDs []string) ([]string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	deleted := map[string]bool{}
+	for _, id := range postIDs {
+		deleted[id] = true
+	}
+	r.db.deleteComments(func(comment *models.ForumComment) bool {
+		return deleted[comment.PostID]
+	})
+	return r.db.deleteDBComments(func(comment *models.DBForumComment) bool {
+		return deleted[comment.PostID]
+	}), nil
+}
+
+// deleteThreads removes the v1 and v2 comments in ids with every reply below them and returns the vote ids of the v2 ones
+// It must be called with the lock held
+func (d *db) deleteThreads(ids map[string]bool) []string {
+	inThread := func(id string, path []string) bool {
+		if ids[id] {
+			return true
+		}
+		for _, ancestor := range path {
+			if ids[ancestor] {
+				return true
+			}
+		}
+		return false
+	}
+	d.deleteComments(func(comment *models.ForumComment) bool { return inThread(comment.ID, comment.Path) })
+	return d.deleteDBComments(func(comment *models.DBForumComment) bool { return inThread(comment.ID, comment.Path) })
+}
+
+// deleteComments removes the comments matching del, it must be called with the lock held
+func (d *db) deleteComments(del func(comment *models.ForumComment) bool) {
+	comments := d.comments[:0]
+	for _, comment := range d.comments {
+		if !del(comment) {
+			comments = append(comments, comment)
+		}
+	}
+	d.comments = comments
+}
+
+// deleteDBComments removes the v2 comments matching del and returns their vote ids, it must be called with the lock held
+func (d *db) deleteDBComments(del func(comment *models.DBForumComment) bool) []string {
+	voteIDs := []string{}
+	comments := d.dbComments[:0]
+	for _, comment := range d.dbComments {
+		if del(comment) {
+			if comment.VoteID != "" {
+				voteIDs = append(voteIDs, comment.VoteID)
+			}
+			continue
+		}
+		comments = append(comments, comment)
+	}
+	d.dbComments = comments
+	return voteIDs
+}
+
+func (r *commentRepository) GetDBComment(ctx context.Context, id string) (models.DBForumComment, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	if comment := r.db.findDBComment(id); comment != nil {
+		return copyDBComment(comment), nil
+	}
+	return models.DBForumComment{}, models.ErrNotFound
+}
+
+func (r *commentRepository) GetDBCommentsByPost(ctx context.Context, postID string) ([]models.DBForumComment, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	res := []models.DBForumComment{}
+	for _, comment := range r.db.dbComments {
+		if comment.PostID == postID {
+			res = append(res, copyDBComment(comment))
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Metadata.UpdatedAt > res[j].Metadata.UpdatedAt
+	})
+	return res, nil
+}
+
+func (r *commentRepository) InsertDBComment(ctx context.Context, comment models.DBForumComment) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	comment = copyDBComment(&comment)
+	comment.ID = newID()
+	r.db.dbComments = append(r.db.dbComments, &comment)
+	return comment.ID, nil
+}
+
+func (r *commentRepository) BumpDBCommentsUpdatedAt(ctx context.Context, ids []string, updatedAt int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, id := range ids {
+		if comment := r.db.findDBComment(id); comment != nil && comment.Metadata.UpdatedAt < updatedAt {
+			comment.Metadata.UpdatedAt = updatedAt
+		}
+	}
+	return nil
+}
+
+func (r *commentRepository) EditDBComment(ctx context.Context, id string, from models.Revision, edit models.PostEdit) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	comment := r.db.findDBComment(id)
+	if comment == nil || comment.Deleted || comment.Content != from.Content {
+		return false, nil
+	}
+	applyEdit(nil, &comment.Content, &comment.Deleted, edit)
+	comment.Metadata.UpdatedBy = edit.UpdatedBy
+	comment.Metadata.UpdatedAt = edit.UpdatedAt
+	return true, nil
+}
+
+func (r *commentRepository) ConvertCommentToV2(ctx context.Context, id string, voteID string, metadata models.Metadata) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, comment := range r.db.comments {
+		if comment.ID != id {
+			continue
+		}
+		r.db.comments = append(r.db.comments[:i], r.db.comments[i+1:]...)
+		r.db.dbComments = append(r.db.dbComments, &models.DBForumComment{
+			ID:       comment.ID,
+			ParentID: comment.ParentID,
+			PostID:   comment.PostID,
+			Path:     comment.Path,
+			Content:  comment.Content,
+			UserID:   comment.UserID,
+			VoteID:   voteID,
+			Metadata: metadata,
+			Hidden:   comment.Hidden,
+			Deleted:  comment.Deleted,
+		})
+		return true, nil
+	}
+	return false, nil
+}
+
+// findDBComment must be called with the lock held
+func (d *db) findDBComment(id string) *models.DBForumComment {
+	for _, comment := range d.dbComments {
+		if comment.ID == id {
+			return comment
+		}
+	}
+	return nil
+}
+
+// copyDBComment detaches the returned comment from the stored slices
+func copyDBComment(comment *models.DBForumComment) models.DBForumComment {
+	res := *comment
+	res.Path = append([]string{}, comment.Path...)
+	return res
+}
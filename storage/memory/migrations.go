@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"sort"
+)
+
+type migrationRepository struct {
+	db *db
+}
+
+func (r *migrationRepository) GetAppliedMigrations(ctx context.Context) ([]models.AppliedMigration, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	res := []models.AppliedMigration{}
+	for _, migration := range r.db.migrations {
+		res = append(res, *migration)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+func (r *migrationRepository) InsertAppliedMigration(ctx context.Context, migration models.AppliedMigration) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, applied := range r.db.migrations {
+		if applied.Version == migration.Version {
+			return models.ErrConflict
+		}
+	}
+	r.db.migrations = append(r.db.migrations, &migration)
+	return nil
+}
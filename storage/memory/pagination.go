@@ -0,0 +1,51 @@
+package memory
+
+import "gguan/cwgcf_db/models"
+
+// keyed is one item of a listing with the keys it is sorted by
+type keyed struct {
+	keys []float64
+	id   string
+}
+
+// less orders descending by keys and then by id, matching the mongo listings
+func (a keyed) less(b keyed) bool {
+	for i := range a.keys {
+		if a.keys[i] != b.keys[i] {
+			return a.keys[i] > b.keys[i]
+		}
+	}
+	return a.id > b.id
+}
+
+// paginate returns the bounds of the page that follows page.After in a listing already sorted with less
+func paginate(items []keyed, page models.PageRequest) (start int, end int, next *models.Cursor, err error) {
+	if page.After != nil {
+		if len(items) > 0 && len(page.After.Keys) != len(items[0].keys) {
+			return 0, 0, nil, models.ErrInvalidCursor
+		}
+		after := keyed{keys: page.After.Keys, id: page.After.ID}
+		for start < len(items) && !after.less(items[start]) {
+			start++
+		}
+	}
+	end = start + int(page.Limit)
+	if end >= len(items) {
+		return start, len(items), nil, nil
+	}
+	last := items[end-1]
+	return start, end, &models.Cursor{Keys: last.keys, ID: last.id}, nil
+}
+
+// byKeys sorts a listing with less, swapping the parallel slice of documents alongside
+type byKeys struct {
+	items []keyed
+	swap  func(i, j int)
+}
+
+func (b byKeys) Len() int           { return len(b.items) }
+func (b byKeys) Less(i, j int) bool { return b.items[i].less(b.items[j]) }
+func (b byKeys) Swap(i, j int) {
+	b.items[i], b.items[j] = b.items[j], b.items[i]
+	b.swap(i, j)
+}
@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/models"
+)
+
+// walk pages through items with limit and returns the ids in the order they were listed
+func walk(t *testing.T, items []keyed, limit int64) []string {
+	ids := []string{}
+	page := models.PageRequest{Limit: limit}
+	for pages := 0; pages <= len(items); pages++ {
+		start, end, next, err := paginate(items, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range items[start:end] {
+			ids = append(ids, item.id)
+		}
+		if next == nil {
+			return ids
+		}
+		if int64(end-start) != limit {
+			t.Errorf("limit %d: a page with a next cursor has %d items", limit, end-start)
+		}
+		page.After = next
+	}
+	t.Fatalf("limit %d: paging did not end", limit)
+	return nil
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []keyed
+	}{
+		{"empty", []keyed{}},
+		{"one", []keyed{{[]float64{1}, "a"}}},
+		{"distinct keys", []keyed{{[]float64{3}, "a"}, {[]float64{2}, "b"}, {[]float64{1}, "c"}}},
+		{"ties broken by id", []keyed{
+			{[]float64{5, 1}, "e"}, {[]float64{5, 1}, "d"}, {[]float64{5, 0}, "f"},
+			{[]float64{2, 9}, "c"}, {[]float64{2, 9}, "b"}, {[]float64{2, 9}, "a"},
+		}},
+		{"negative keys", []keyed{{[]float64{0.5}, "x"}, {[]float64{-0.5}, "y"}, {[]float64{-7}, "z"}}},
+	}
+	for _, test := range tests {
+		want := []string{}
+		for _, item := range test.items {
+			want = append(want, item.id)
+		}
+		if !sort.SliceIsSorted(test.items, func(i, j int) bool { return test.items[i].less(test.items[j]) }) {
+			t.Fatalf("%s: items are not in listing order", test.name)
+		}
+		for limit := int64(1); limit <= int64(len(test.items))+1; limit++ {
+			if got := walk(t, test.items, limit); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%s: limit %d lists %v, want %v", test.name, limit, got, want)
+			}
+		}
+	}
+}
+
+func TestPaginateAfterRemovedItem(t *testing.T) {
+	items := []keyed{{[]float64{3}, "c"}, {[]float64{1}, "a"}}
+	// The cursor names an item between the two that is gone since
+	start, end, next, err := paginate(items, models.PageRequest{Limit: 5, After: &models.Cursor{Keys: []float64{2}, ID: "b"}})
+	if err != nil || start != 1 || end != 2 || next != nil {
+		t.Errorf("got %d:%d, next %v, %v, want the last item", start, end, next, err)
+	}
+}
+
+func TestPaginateRejectsForeignCursor(t *testing.T) {
+	items := []keyed{{[]float64{3, 1}, "c"}}
+	_, _, _, err := paginate(items, models.PageRequest{Limit: 5, After: &models.Cursor{Keys: []float64{3}, ID: "c"}})
+	if err != models.ErrInvalidCursor {
+		t.Errorf("a cursor with fewer keys: %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestGetDBPostsPages(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+	// Pairs of posts with the same activity time, so pages split ties
+	for i := 0; i < 7; i++ {
+		post := models.DBForumPost{Title: fmt.Sprint(i), Metadata: models.Metadata{UpdatedAt: int64(100 + i/2)}}
+		if _, err := store.Posts.InsertDBPost(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, mode := range []string{models.SortNew, models.SortTop, models.SortHot} {
+		postSort := models.PostSort{Mode: mode}
+		all, _, err := store.Posts.GetDBPosts(ctx, postSort, models.PageRequest{Limit: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := []string{}
+		page := models.PageRequest{Limit: 3}
+		for {
+			posts, next, err := store.Posts.GetDBPosts(ctx, postSort, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, post := range posts {
+				seen = append(seen, post.ID)
+			}
+			if next == nil {
+				break
+			}
+			// The client only sees the encoded cursor
+			if page, err = models.NewPageRequest(3, next.Encode(), config.Default().Pagination); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := []string{}
+		for _, post := range all {
+			want = append(want, post.ID)
+		}
+		if len(want) != 7 || fmt.Sprint(seen) != fmt.Sprint(want) {
+			t.Errorf("%s: pages list %v, want %v", mode, seen, want)
+		}
+	}
+	_, next, _ := store.Posts.GetDBPosts(ctx, models.PostSort{Mode: models.SortNew}, models.PageRequest{Limit: 1})
+	if _, _, err := store.Posts.GetDBPosts(ctx, models.PostSort{Mode: models.SortTop}, models.PageRequest{Limit: 1, After: next}); err != models.ErrInvalidCursor {
+		t.Errorf("a cursor of another sort: %v, want ErrInvalidCursor", err)
+	}
+}
@@ -0,0 +1,192 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"sort"
+)
+
+type photoRepository struct {
+	db *db
+}
+
+func (r *photoRepository) GetAllPhotos(ctx context.Context) ([]models.Photo, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	res := []models.Photo{}
+	for _, photo := range r.db.photos {
+		res = append(res, copyPhoto(photo))
+	}
+	return res, nil
+}
+
+func (r *photoRepository) GetPhoto(ctx context.Context, id string) (models.Photo, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	for _, photo := range r.db.photos {
+		if photo.ID == id {
+			return copyPhoto(photo), nil
+		}
+	}
+	return models.Photo{}, models.ErrNotFound
+}
+
+func (r *photoRepository) GetPhotos(ctx context.Context, ids []string) (map[string]models.Photo, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	res := map[string]models.Photo{}
+	for _, photo := range r.db.photos {
+		if wanted[photo.ID] {
+			res[photo.ID] = copyPhoto(photo)
+		}
+	}
+	return res, nil
+}
+
+func (r *photoRepository) InsertPhoto(ctx context.Context, photo models.Photo) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	photo.ID = newID()
+	r.db.photos = append(r.db.photos, &photo)
+	return photo.ID, nil
+}
+
+func (r *photoRepository) UpdatePhoto(ctx context.Context, id string, update models.PhotoUpdate, metadata models.Metadata) (models.Photo, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, photo := range r.db.photos {
+		if photo.ID == id {
+			update.Apply(photo)
+			*photo = copyPhoto(photo)
+			photo.Metadata.UpdatedBy = metadata.UpdatedBy
+			photo.Metadata.UpdatedAt = metadata.UpdatedAt
+			return copyPhoto(photo), nil
+		}
+	}
+	return models.Photo{}, models.ErrNotFound
+}
+
+func (r *photoRepository) SetPhotoVariants(ctx context.Context, id string, variants []models.PhotoVariant) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, photo := range r.db.photos {
+		if photo.ID == id {
+			photo.Variants = append([]models.PhotoVariant{}, variants...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (r *photoRepository) SetPhotoHashes(ctx context.Context, id string, hashes models.PhotoHashes) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, photo := range r.db.photos {
+		if photo.ID == id {
+			photo.PhotoHashes = hashes
+			photo.DHashBands = append([]int64{}, hashes.DHashBands...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (r *photoRepository) GetPhotoByURL(ctx context.Context, url string) (models.Photo, error) {
+	return r.findNewest(func(photo *models.Photo) bool {
+		return photo.BlobKey == "" && photo.URL == url
+	})
+}
+
+func (r *photoRepository) GetPhotoBySHA256(ctx context.Context, userID string, sha256 string) (models.Photo, error) {
+	return r.findNewest(func(photo *models.Photo) bool {
+		return photo.UserID == userID && photo.SHA256 == sha256
+	})
+}
+
+func (r *photoRepository) GetSimilarPhotos(ctx context.Context, userID string, bands []int64) ([]models.Photo, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	wanted := map[int64]bool{}
+	for _, band := range bands {
+		wanted[band] = true
+	}
+	res := []models.Photo{}
+	for _, photo := range r.db.photos {
+		if photo.UserID != userID {
+			continue
+		}
+		for _, band := range photo.DHashBands {
+			if wanted[band] {
+				res = append(res, copyPhoto(photo))
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+func (r *photoRepository) DeletePhoto(ctx context.Context, id string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, photo := range r.db.photos {
+		if photo.ID == id {
+			r.db.photos = append(r.db.photos[:i], r.db.photos[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (r *photoRepository) GetPhotosByTakenAt(ctx context.Context, page models.PageRequest) ([]models.Photo, *models.Cursor, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	photos := []models.Photo{}
+	items := []keyed{}
+	for _, photo := range r.db.photos {
+		photos = append(photos, copyPhoto(photo))
+		items = append(items, keyed{keys: []float64{float64(photo.TakenAt)}, id: photo.ID})
+	}
+	sort.Sort(byKeys{items, func(i, j int) { photos[i], photos[j] = photos[j], photos[i] }})
+	start, end, next, err := paginate(items, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	return photos[start:end], next, nil
+}
+
+func (r *photoRepository) SetPhotoTakenAt(ctx context.Context, id string, takenAt int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, photo := range r.db.photos {
+		if photo.ID == id {
+			photo.TakenAt = takenAt
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+// findNewest returns the last inserted photo matching match
+func (r *photoRepository) findNewest(match func(photo *models.Photo) bool) (models.Photo, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	for i := len(r.db.photos) - 1; i >= 0; i-- {
+		if match(r.db.photos[i]) {
+			return copyPhoto(r.db.photos[i]), nil
+		}
+	}
+	return models.Photo{}, models.ErrNotFound
+}
+
+// copyPhoto detaches the returned photo from the stored one
+func copyPhoto(photo *models.Photo) models.Photo {
+	res := *photo
+	res.Variants = append([]models.PhotoVariant{}, photo.Variants...)
+	res.Tags = append([]string{}, photo.Tags...)
+	res.DHashBands = append([]int64{}, photo.DHashBands...)
+	return res
+}
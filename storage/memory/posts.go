@@ -0,0 +1,462 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"sort"
+)
+
+type postRepository struct {
+	db *db
+}
+
+func (r *postRepository) GetAllPosts(ctx context.Context, postSort models.PostSort, page models.PageRequest) ([]models.ForumPost, *models.Cursor, error) {
+	if err := postSort.CheckCursor(page); err != nil {
+		return nil, nil, err
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	posts := []models.ForumPost{}
+	items := []keyed{}
+	for _, post := range r.db.posts {
+		if post.Hidden || post.Deleted || post.Ranking.CreatedAt < postSort.Since || post.Pin.Active(postSort.At) || !inSub(post.Sub, postSort) {
+			continue
+		}
+		posts = append(posts, *post)
+		items = append(items, keyed{keys: post.SortKeys(postSort.Mode), id: post.ID})
+	}
+	sort.Sort(byKeys{items, func(i, j int) { posts[i], posts[j] = posts[j], posts[i] }})
+	start, end, next, err := paginate(items, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	if next != nil {
+		next.Sort = postSort.Mode
+	}
+	return posts[start:end], next, nil
+}
+
+func (r *postRepository) GetPost(ctx context.Context, id string) (models.ForumPost, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	if post := r.db.findPost(id); post != nil {
+		return *post, nil
+	}
+	return models.ForumPost{}, models.ErrNotFound
+}
+
+func (r *postRepository) InsertPost(ctx context.Context, post models.ForumPost) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	post.ID = newID()
+	r.db.posts = append(r.db.posts, &post)
+	return post.ID, nil
+}
+
+func (r *postRepository) SetPostUpdatedAt(ctx context.Context, id string, updatedAt int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if post := r.db.findPost(id); post != nil {
+		post.UpdatedAt = updatedAt
+	}
+	return nil
+}
+
+func (r *postRepository) IncPostVotes(ctx context.Context, id string, change models.VoteTally) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	post := r.db.findPost(id)
+	if post == nil {
+		post = &models.ForumPost{ID: id}
+		r.db.posts = append(r.db.posts, post)
+	}
+	post.ForumVotes = post.ForumVotes.Tally().Add(change).ForumVotes()
+	post.Ranking = post.Rank()
+	return nil
+}
+
+func (r *postRepository) GetDBPosts(ctx context.Context, postSort models.PostSort, page models.PageRequest) ([]models.DBForumPost, *models.Cursor, error) {
+	if err := postSort.CheckCursor(page); err != nil {
+		return nil, nil, err
+	}
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	posts := []models.DBForumPost{}
+	items := []keyed{}
+	for _, post := range r.db.dbPosts {
+		if post.Hidden || post.Deleted || post.Ranking.CreatedAt < postSort.Since || post.Pin.Active(postSort.At) || !inSub(post.Sub, postSort) {
+			continue
+		}
+		posts = append(posts, *post)
+		items = append(items, keyed{keys: post.SortKeys(postSort.Mode), id: post.ID})
+	}
+	sort.Sort(byKeys{items, func(i, j int) { posts[i], posts[j] = posts[j], posts[i] }})
+	start, end, next, err := paginate(items, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	if next != nil {
+		next.Sort = postSort.Mode
+	}
+	return posts[start:end], next, nil
+}
+
+func (r *postRepository) ForEachDBPost(ctx context.Context, fn func(models.DBForumPost) error) error {
+	r.db.mu.RLock()
+	posts := []models.DBForumPost{}
+	for _, post := range r.db.dbPosts {
+		posts = append(posts, *post)
+	}
+	r.db.mu.RUnlock()
+	for _, post := range posts {
+		if err := fn(post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *postRepository) SetPostRanking(ctx context.Context, id string, ranking models.Ranking) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if post := r.db.findPost(id); post != nil {
+		post.Ranking = ranking
+		return nil
+	}
+	if post := r.db.findDBPost(id); post != nil {
+		post.Ranking = ranking
+		return nil
+	}
+	return models.ErrNotFound
+}
+
+func (r *postRepository) GetPinnedPosts(ctx context.Context, postSort models.PostSort) ([]models.ForumPost, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	posts := []models.ForumPost{}
+	items := []keyed{}
+	for _, post := range r.db.posts {
+		if !post.Hidden && !post.Deleted && post.Pin.Active(postSort.At) && inSub(post.Sub, postSort) {
+			posts = append(posts, *post)
+			items = append(items, pinKey(post.Pin, post.ID))
+		}
+	}
+	sort.Sort(byKeys{items, func(i, j int) { posts[i], posts[j] = posts[j], posts[i] }})
+	return posts, nil
+}
+
+func (r *postRepository) GetPinnedDBPosts(ctx context.Context, postSort models.PostSort) ([]models.DBForumPost, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	posts := []models.DBForumPost{}
+	items := []keyed{}
+	for _, post := range r.db.dbPosts {
+		if !post.Hidden && !post.Deleted && post.Pin.Active(postSort.At) && inSub(post.Sub, postSort) {
+			posts = append(posts, *post)
+			items = append(items, pinKey(post.Pin, post.ID))
+		}
+	}
+	sort.Sort(byKeys{items, func(i, j int) { posts[i], posts[j] = posts[j], posts[i] }})
+	return posts, nil
+}
+
+func (r *postRepository) PinPost(ctx context.Context, id string, pin models.Pin) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if post := r.db.findPost(id); post != nil {
+		post.Pin = &pin
+		return nil
+	}
+	if post := r.db.findDBPost(id); post != nil {
+		post.Pin = &pin
+		return nil
+	}
+	return models.ErrNotFound
+}
+
+func (r *postRepository) UnpinPost(ctx context.Context, id string, updatedBy string, updatedAt int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	var pin **models.Pin
+	if post := r.db.findPost(id); post != nil {
+		pin = &post.Pin
+	} else if post := r.db.findDBPost(id); post != nil {
+		pin = &post.Pin
+	} else {
+		return models.ErrNotFound
+	}
+	// Stored pins are replaced rather than changed, copies handed out before keep their values
+	unpinned := models.Pin{}
+	if *pin != nil {
+		unpinned = **pin
+	}
+	unpinned.Pinned = false
+	unpinned.Metadata.UpdatedBy = updatedBy
+	unpinned.Metadata.UpdatedAt = updatedAt
+	*pin = &unpinned
+	return nil
+}
+
+// reassignPin returns pin with the moderator userID replaced, as a new pin when it changes
+func reassignPin(pin *models.Pin, userID string, newUserID string) *models.Pin {
+	if pin == nil || (pin.Metadata.CreatedBy != userID && pin.Metadata.UpdatedBy != userID) {
+		return pin
+	}
+	reassigned := *pin
+	if reassigned.Metadata.CreatedBy == userID {
+		reassigned.Metadata.CreatedBy = newUserID
+	}
+	if reassigned.Metadata.UpdatedBy == userID {
+		reassigned.Metadata.UpdatedBy = newUserID
+	}
+	return &reassigned
+}
+
+func (r *postRepository) MoveSubPosts(ctx context.Context, from string, to string) (int, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	moved := 0
+	for _, post := range r.db.posts {
+		if post.Sub == from {
+			post.Sub = to
+			moved++
+		}
+	}
+	for _, post := range r.db.dbPosts {
+		if post.Sub == from {
+			post.Sub = to
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// inSub reports whether a post of sub belongs in the feed of postSort
+func inSub(sub string, postSort models.PostSort) bool {
+	return postSort.Sub == "" || sub == postSort.Sub
+}
+
+// pinKey sorts pinned posts by ascending order and then latest pinned first, matching the mongo listing
+func pinKey(pin *models.Pin, id string) keyed {
+	return keyed{keys: []float64{-float64(pin.Order), float64(pin.Metadata.CreatedAt)}, id: id}
+}
+
+func (r *postRepository) InsertDBPost(ctx context.Context, post models.DBForumPost) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	post.ID = newID()
+	r.db.dbPosts = append(r.db.dbPosts, &post)
+	return post.ID, nil
+}
+
+func (r *postRepository) GetDBPost(ctx context.Context, id string) (models.DBForumPost, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	if post := r.db.findDBPost(id); post != nil {
+		return *post, nil
+	}
+	return models.DBForumPost{}, models.ErrNotFound
+}
+
+func (r *postRepository) EditPost(ctx context.Context, id string, from models.Revision, edit models.PostEdit) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	post := r.db.findPost(id)
+	if post == nil || post.Deleted || post.Title != from.Title || post.Content != from.Content {
+		return false, nil
+	}
+	applyEdit(&post.Title, &post.Content, &post.Deleted, edit)
+	post.UpdatedAt = edit.UpdatedAt
+	return true, nil
+}
+
+func (r *postRepository) EditDBPost(ctx context.Context, id string, from models.Revision, edit models.PostEdit) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	post := r.db.findDBPost(id)
+	if post == nil || post.Deleted || post.Title != from.Title || post.Content != from.Content {
+		return false, nil
+	}
+	applyEdit(&post.Title, &post.Content, &post.Deleted, edit)
+	post.Metadata.UpdatedBy = edit.UpdatedBy
+	post.Metadata.UpdatedAt = edit.UpdatedAt
+	return true, nil
+}
+
+func (r *postRepository) BumpDBPostUpdatedAt(ctx context.Context, id string, updatedAt int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if post := r.db.findDBPost(id); post != nil && post.Metadata.UpdatedAt < updatedAt {
+		post.Metadata.UpdatedAt = updatedAt
+	}
+	return nil
+}
+
+func (r *postRepository) ConvertPostToV2(ctx context.Context, id string, voteID string, metadata models.Metadata, ranking models.Ranking) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, post := range r.db.posts {
+		if post.ID != id {
+			continue
+		}
+		r.db.posts = append(r.db.posts[:i], r.db.posts[i+1:]...)
+		r.db.dbPosts = append(r.db.dbPosts, &models.DBForumPost{
+			ID:       post.ID,
+			Title:    post.Title,
+			Content:  post.Content,
+			Image:    post.Image,
+			ImageID:  post.ImageID,
+			UserID:   post.UserID,
+			VoteID:   voteID,
+			Sub:      post.Sub,
+			Metadata: metadata,
+			Ranking:  ranking,
+			Pin:      post.Pin,
+			Hidden:   post.Hidden,
+			Deleted:  post.Deleted,
+		})
+		return true, nil
+	}
+	return false, nil
+}
+
+// applyEdit sets the fields edit changes on a post or comment, title is nil for comments
+func applyEdit(title *string, content *string, deleted *bool, edit models.PostEdit) {
+	if edit.Title != nil && title != nil {
+		*title = *edit.Title
+	}
+	if edit.Content != nil {
+		*content = *edit.Content
+	}
+	if edit.Delete {
+		*deleted = true
+	}
+}
+
+func (r *postRepository) SetPostHidden(ctx context.Context, id string, hidden bool) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if post := r.db.findPost(id); post != nil {
+		post.Hidden = hidden
+		return nil
+	}
+	if post := r.db.findDBPost(id); post != nil {
+		post.Hidden = hidden
+		return nil
+	}
+	return models.ErrNotFound
+}
+
+func (r *postRepository) DeletePost(ctx context.Context, id string) (voteID string, err error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, post := range r.db.posts {
+		if post.ID == id {
+			r.db.posts = append(r.db.posts[:i], r.db.posts[i+1:]...)
+			return "", nil
+		}
+	}
+	for i, post := range r.db.dbPosts {
+		if post.ID == id {
+			r.db.dbPosts = append(r.db.dbPosts[:i], r.db.dbPosts[i+1:]...)
+			return post.VoteID, nil
+		}
+	}
+	return "", models.ErrNotFound
+}
+
+// findPost must be called with the lock held
+func (d *db) findPost(id string) *models.ForumPost {
+	for _, post := range d.posts {
+		if post.ID == id {
+			return post
+		}
+	}
+	return nil
+}
+
+func (r *postRepository) ReassignUserPosts(ctx context.Context, userID string, newUserID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, post := range r.db.posts {
+		if post.UserID == userID {
+			post.UserID = newUserID
+		}
+		post.Pin = reassignPin(post.Pin, userID, newUserID)
+	}
+	for _, post := range r.db.dbPosts {
+		if post.UserID == userID {
+			post.UserID = newUserID
+		}
+		if post.Metadata.CreatedBy == userID {
+			post.Metadata.CreatedBy = newUserID
+		}
+		if post.Metadata.UpdatedBy == userID {
+			post.Metadata.UpdatedBy = newUserID
+		}
+		post.Pin = reassignPin(post.Pin, userID, newUserID)
+	}
+	return nil
+}
+
+func (r *postRepository) DeleteUserPosts(ctx context.Context, userID string) (postIDs []string, voteIDs []string, err error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	posts := r.db.posts[:0]
+	for _, post := range r.db.posts {
+		if post.UserID == userID {
+			postIDs = append(postIDs, post.ID)
+			continue
+		}
+		posts = append(posts, post)
+	}
+	r.db.posts = posts
+	dbPosts := r.db.dbPosts[:0]
+	for _, post := range r.db.dbPosts {
+		if post.UserID == userID {
+			postIDs = append(postIDs, post.ID)
+			voteIDs = append(voteIDs, post.VoteID)
+			continue
+		}
+		dbPosts = append(dbPosts, post)
+	}
+	r.db.dbPosts = dbPosts
+	return postIDs, voteIDs, nil
+}
+
+// findDBPost must be called with the lock held
+func (d *db) findDBPost(id string) *models.DBForumPost {
+	for _, post := range d.dbPosts {
+		if post.ID == id {
+			return post
+		}
+	}
+	return nil
+}
+
+func (r *postRepository) ForEachPost(ctx context.Context, fn func(models.ForumPost) error) error {
+	r.db.mu.RLock()
+	posts := make([]models.ForumPost, len(r.db.posts))
+	for i, post := range r.db.posts {
+		posts[i] = *post
+	}
+	r.db.mu.RUnlock()
+	for _, post := range posts {
+		if err := fn(post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *postRepository) SetPostVotes(ctx context.Context, id string, stored models.VoteTally, tally models.VoteTally) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	post := r.db.findPost(id)
+	if post == nil || post.ForumVotes.Tally() != stored {
+		return false, nil
+	}
+	post.ForumVotes = tally.ForumVotes()
+	post.Ranking = post.Rank()
+	return true, nil
+}
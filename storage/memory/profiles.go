@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+)
+
+type profileRepository struct {
+	db *db
+}
+
+func (r *profileRepository) GetProfile(ctx context.Context, id string) (models.Profile, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	for _, profile := range r.db.profiles {
+		if profile.ID == id {
+			return *profile, nil
+		}
+	}
+	return models.Profile{}, models.ErrNotFound
+}
+
+func (r *profileRepository) GetProfiles(ctx context.Context, ids []string) (map[string]models.Profile, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	res := map[string]models.Profile{}
+	for _, profile := range r.db.profiles {
+		if wanted[profile.ID] {
+			res[profile.ID] = *profile
+		}
+	}
+	return res, nil
+}
+
+func (r *profileRepository) GetAllProfiles(ctx context.Context) ([]models.Profile, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	res := []models.Profile{}
+	for _, profile := range r.db.profiles {
+		res = append(res, *profile)
+	}
+	return res, nil
+}
+
+func (r *profileRepository) InsertProfile(ctx context.Context, profile models.Profile) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	profile.ID = newID()
+	r.db.profiles = append(r.db.profiles, &profile)
+	return profile.ID, nil
+}
+
+func (r *profileRepository) UpdateProfile(ctx context.Context, id string, update models.ProfileUpdate) (models.Profile, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, profile := range r.db.profiles {
+		if profile.ID == id {
+			update.Apply(profile)
+			return *profile, nil
+		}
+	}
+	return models.Profile{}, models.ErrNotFound
+}
+
+func (r *profileRepository) DeleteProfile(ctx context.Context, id string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, profile := range r.db.profiles {
+		if profile.ID == id {
+			r.db.profiles = append(r.db.profiles[:i], r.db.profiles[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (r *profileRepository) SetProfileRole(ctx context.Context, id string, role string) (models.Profile, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, profile := range r.db.profiles {
+		if profile.ID == id {
+			profile.Role = role
+			return *profile, nil
+		}
+	}
+	return models.Profile{}, models.ErrNotFound
+}
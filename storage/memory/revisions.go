@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"sort"
+)
+
+type revisionRepository struct {
+	db *db
+}
+
+func (r *revisionRepository) InsertRevision(ctx context.Context, revision models.Revision) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	revision.ID = newID()
+	r.db.revisions = append(r.db.revisions, &revision)
+	return revision.ID, nil
+}
+
+func (r *revisionRepository) GetRevisions(ctx context.Context, targetID string) ([]models.Revision, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	revisions := []models.Revision{}
+	items := []keyed{}
+	for _, revision := range r.db.revisions {
+		if revision.TargetID == targetID {
+			revisions = append(revisions, *revision)
+			items = append(items, keyed{keys: []float64{float64(revision.Metadata.CreatedAt)}, id: revision.ID})
+		}
+	}
+	sort.Sort(byKeys{items, func(i, j int) { revisions[i], revisions[j] = revisions[j], revisions[i] }})
+	return revisions, nil
+}
+
+func (r *revisionRepository) DeleteRevisions(ctx context.Context, targetIDs []string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	deleted := map[string]bool{}
+	for _, id := range targetIDs {
+		deleted[id] = true
+	}
+	r.db.deleteRevisions(func(revision *models.Revision) bool { return deleted[revision.TargetID] })
+	return nil
+}
+
+func (r *revisionRepository) ReassignUserRevisions(ctx context.Context, userID string, newUserID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, revision := range r.db.revisions {
+		if revision.Metadata.CreatedBy == userID {
+			revision.Metadata.CreatedBy = newUserID
+		}
+	}
+	return nil
+}
+
+func (r *revisionRepository) DeleteUserRevisions(ctx context.Context, userID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.deleteRevisions(func(revision *models.Revision) bool { return revision.Metadata.CreatedBy == userID })
+	return nil
+}
+
+// deleteRevisions must be called with the lock held
+func (d *db) deleteRevisions(del func(revision *models.Revision) bool) {
+	kept := d.revisions[:0]
+	for _, revision := range d.revisions {
+		if !del(revision) {
+			kept = append(kept, revision)
+		}
+	}
+	d.revisions = kept
+}
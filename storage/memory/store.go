@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"gguan/cwgcf_db/models"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// db holds every collection of the in-memory backend behind a single lock
+type db struct {
+	mu         sync.RWMutex
+	posts      []*models.ForumPost
+	dbPosts    []*models.DBForumPost
+	comments   []*models.ForumComment
+	dbComments []*models.DBForumComment
+	userVotes  map[string]*models.ForumUserVotes
+	votes      map[string]*models.ForumVote
+	voteMaps   map[string]*models.ForumVoteMap
+	// voteEvents is in insertion order
+	voteEvents []*models.VoteEvent
+	profiles   []*models.Profile
+	photos     []*models.Photo
+	albums     []*models.Album
+	// albumPhotos is in insertion order
+	albumPhotos []*models.AlbumPhoto
+	subForums   []*models.SubForum
+	revisions   []*models.Revision
+	migrations  []*models.AppliedMigration
+}
+
+// NewStore creates repositories that keep all data in process memory
+func NewStore() *models.Store {
+	d := &db{
+		userVotes: map[string]*models.ForumUserVotes{},
+		votes:     map[string]*models.ForumVote{},
+		voteMaps:  map[string]*models.ForumVoteMap{},
+	}
+	return &models.Store{
+		Posts:      &postRepository{d},
+		Comments:   &commentRepository{d},
+		UserVotes:  &userVoteRepository{d},
+		Votes:      &voteRepository{d},
+		VoteMaps:   &voteMapRepository{d},
+		Profiles:   &profileRepository{d},
+		Photos:     &photoRepository{d},
+		Albums:     &albumRepository{d},
+		VoteEvents: &voteEventRepository{d},
+		SubForums:  &subForumRepository{d},
+		Revisions:  &revisionRepository{d},
+		Migrations: &migrationRepository{d},
+	}
+}
+
+// newID generates ids in the same format mongo does
+func newID() string {
+	return primitive.NewObjectID().Hex()
+}
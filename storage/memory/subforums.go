@@ -0,0 +1,102 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"sort"
+)
+
+type subForumRepository struct {
+	db *db
+}
+
+func (r *subForumRepository) GetSubForums(ctx context.Context) ([]models.SubForum, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	res := []models.SubForum{}
+	for _, sub := range r.db.subForums {
+		res = append(res, copySubForum(sub))
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Slug < res[j].Slug })
+	return res, nil
+}
+
+func (r *subForumRepository) GetSubForum(ctx context.Context, slug string) (models.SubForum, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	if sub := r.db.findSubForum(slug); sub != nil {
+		return copySubForum(sub), nil
+	}
+	return models.SubForum{}, models.ErrNotFound
+}
+
+func (r *subForumRepository) InsertSubForum(ctx context.Context, sub models.SubForum) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if r.db.findSubForum(sub.Slug) != nil {
+		return "", models.ErrConflict
+	}
+	sub = copySubForum(&sub)
+	sub.ID = newID()
+	r.db.subForums = append(r.db.subForums, &sub)
+	return sub.ID, nil
+}
+
+func (r *subForumRepository) UpdateSubForum(ctx context.Context, slug string, update models.SubForumUpdate, metadata models.Metadata) (models.SubForum, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	sub := r.db.findSubForum(slug)
+	if sub == nil {
+		return models.SubForum{}, models.ErrNotFound
+	}
+	update.Apply(sub)
+	*sub = copySubForum(sub)
+	sub.Metadata.UpdatedBy = metadata.UpdatedBy
+	sub.Metadata.UpdatedAt = metadata.UpdatedAt
+	return copySubForum(sub), nil
+}
+
+func (r *subForumRepository) DeleteSubForum(ctx context.Context, slug string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for i, sub := range r.db.subForums {
+		if sub.Slug == slug {
+			r.db.subForums = append(r.db.subForums[:i], r.db.subForums[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (r *subForumRepository) RemoveSubForumModerator(ctx context.Context, userID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, sub := range r.db.subForums {
+		moderators := []string{}
+		for _, moderator := range sub.Moderators {
+			if moderator != userID {
+				moderators = append(moderators, moderator)
+			}
+		}
+		sub.Moderators = moderators
+	}
+	return nil
+}
+
+// findSubForum must be called with the lock held
+func (d *db) findSubForum(slug string) *models.SubForum {
+	for _, sub := range d.subForums {
+		if sub.Slug == slug {
+			return sub
+		}
+	}
+	return nil
+}
+
+// copySubForum copies the slices of a sub so callers and the store never share them
+func copySubForum(sub *models.SubForum) models.SubForum {
+	res := *sub
+	res.Moderators = append([]string{}, sub.Moderators...)
+	res.PostingRules.Guidelines = append([]string{}, sub.PostingRules.Guidelines...)
+	return res
+}
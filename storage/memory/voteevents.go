@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"sort"
+)
+
+type voteEventRepository struct {
+	db *db
+}
+
+func (r *voteEventRepository) InsertVoteEvent(ctx context.Context, event models.VoteEvent) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	return r.db.insertVoteEvent(event), nil
+}
+
+func (r *voteEventRepository) GetVoteEvents(ctx context.Context, targetID string, page models.PageRequest) ([]models.VoteEvent, *models.Cursor, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	events := []models.VoteEvent{}
+	items := []keyed{}
+	for _, event := range r.db.voteEvents {
+		if event.TargetID == targetID {
+			events = append(events, *event)
+			items = append(items, eventKey(event))
+		}
+	}
+	sort.Sort(byKeys{items, func(i, j int) { events[i], events[j] = events[j], events[i] }})
+	start, end, next, err := paginate(items, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	return events[start:end], next, nil
+}
+
+func (r *voteEventRepository) GetVoters(ctx context.Context, targetID string) ([]models.VoteEvent, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	latest := map[string]*models.VoteEvent{}
+	for _, event := range r.db.voteEvents {
+		if event.TargetID != targetID {
+			continue
+		}
+		// less sorts newest first
+		if prev, ok := latest[event.UserID]; !ok || eventKey(event).less(eventKey(prev)) {
+			latest[event.UserID] = event
+		}
+	}
+	events := []models.VoteEvent{}
+	items := []keyed{}
+	for _, event := range latest {
+		if event.To != models.VoteNone {
+			events = append(events, *event)
+			items = append(items, eventKey(event))
+		}
+	}
+	sort.Sort(byKeys{items, func(i, j int) { events[i], events[j] = events[j], events[i] }})
+	return events, nil
+}
+
+func (r *voteEventRepository) HasVoteEvents(ctx context.Context, targetID string, userID string) (bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	for _, event := range r.db.voteEvents {
+		if event.TargetID == targetID && event.UserID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *voteEventRepository) WindowScores(ctx context.Context, targetIDs []string, since int64) (map[string]int64, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	wanted := map[string]bool{}
+	for _, id := range targetIDs {
+		wanted[id] = true
+	}
+	scores := map[string]int64{}
+	for _, event := range r.db.voteEvents {
+		if wanted[event.TargetID] && event.CreatedAt >= since {
+			scores[event.TargetID] += int64(event.To - event.From)
+		}
+	}
+	return scores, nil
+}
+
+func (r *voteEventRepository) ReassignUserVoteEvents(ctx context.Context, userID string, newUserID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, event := range r.db.voteEvents {
+		if event.UserID == userID {
+			event.UserID = newUserID
+		}
+	}
+	return nil
+}
+
+func (r *voteEventRepository) DeleteUserVoteEvents(ctx context.Context, userID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	kept := r.db.voteEvents[:0]
+	for _, event := range r.db.voteEvents {
+		if event.UserID != userID {
+			kept = append(kept, event)
+		}
+	}
+	r.db.voteEvents = kept
+	return nil
+}
+
+// insertVoteEvent must be called with the lock held
+func (d *db) insertVoteEvent(event models.VoteEvent) string {
+	event.ID = newID()
+	d.voteEvents = append(d.voteEvents, &event)
+	return event.ID
+}
+
+// eventKey sorts events newest first, matching the mongo listing
+func eventKey(event *models.VoteEvent) keyed {
+	return keyed{keys: []float64{float64(event.CreatedAt)}, id: event.ID}
+}
@@ -0,0 +1,293 @@
+package memory
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"time"
+)
+
+type userVoteRepository struct {
+	db *db
+}
+
+func (r *userVoteRepository) GetUserVotes(ctx context.Context, userID string) (models.ForumUserVotes, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	votes, ok := r.db.userVotes[userID]
+	if !ok {
+		return models.ForumUserVotes{}, models.ErrNotFound
+	}
+	res := models.ForumUserVotes{UserID: votes.UserID, VoteMap: map[string]models.ForumVoteWithTime{}}
+	for id, vote := range votes.VoteMap {
+		res.VoteMap[id] = vote
+	}
+	return res, nil
+}
+
+func (r *userVoteRepository) SetUserVote(ctx context.Context, userID string, voteID string, voteStatus int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	votes, ok := r.db.userVotes[userID]
+	if !ok {
+		votes = &models.ForumUserVotes{UserID: userID, VoteMap: map[string]models.ForumVoteWithTime{}}
+		r.db.userVotes[userID] = votes
+	}
+	vote := votes.VoteMap[voteID]
+	vote.VoteStatus = voteStatus
+	votes.VoteMap[voteID] = vote
+	return nil
+}
+
+func (r *userVoteRepository) ReassignUserVotes(ctx context.Context, userID string, newUserID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if votes, ok := r.db.userVotes[userID]; ok {
+		delete(r.db.userVotes, userID)
+		votes.UserID = newUserID
+		r.db.userVotes[newUserID] = votes
+	}
+	return nil
+}
+
+func (r *userVoteRepository) DeleteUserVotes(ctx context.Context, userID string) (models.ForumUserVotes, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	votes, ok := r.db.userVotes[userID]
+	if !ok {
+		return models.ForumUserVotes{}, models.ErrNotFound
+	}
+	delete(r.db.userVotes, userID)
+	return *votes, nil
+}
+
+func (r *userVoteRepository) ForEachUserVotes(ctx context.Context, fn func(models.ForumUserVotes) error) error {
+	r.db.mu.RLock()
+	all := []models.ForumUserVotes{}
+	for _, votes := range r.db.userVotes {
+		res := models.ForumUserVotes{UserID: votes.UserID, VoteMap: map[string]models.ForumVoteWithTime{}}
+		for id, vote := range votes.VoteMap {
+			res.VoteMap[id] = vote
+		}
+		all = append(all, res)
+	}
+	r.db.mu.RUnlock()
+	for _, votes := range all {
+		if err := fn(votes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type voteRepository struct {
+	db *db
+}
+
+func (r *voteRepository) InsertVote(ctx context.Context, metadata models.Metadata) (string, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	vote := &models.ForumVote{ID: newID(), Metadata: metadata}
+	r.db.votes[vote.ID] = vote
+	return vote.ID, nil
+}
+
+func (r *voteRepository) GetVote(ctx context.Context, id string) (models.ForumVote, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	vote, ok := r.db.votes[id]
+	if !ok {
+		return models.ForumVote{}, models.ErrNotFound
+	}
+	return *vote, nil
+}
+
+func (r *voteRepository) GetVotes(ctx context.Context, ids []string) (map[string]models.ForumVote, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	res := map[string]models.ForumVote{}
+	for _, id := range ids {
+		if vote, ok := r.db.votes[id]; ok {
+			res[id] = *vote
+		}
+	}
+	return res, nil
+}
+
+func (r *voteRepository) IncVote(ctx context.Context, id string, change models.VoteTally, updatedAt int64) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	vote, ok := r.db.votes[id]
+	if !ok {
+		vote = &models.ForumVote{ID: id}
+		r.db.votes[id] = vote
+	}
+	r.db.setTally(vote, vote.Tally().Add(change))
+	vote.Metadata.UpdatedAt = updatedAt
+	return nil
+}
+
+func (r *voteRepository) DeleteVotes(ctx context.Context, ids []string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	for _, id := range ids {
+		delete(r.db.votes, id)
+	}
+	return nil
+}
+
+func (r *voteRepository) ApplyVote(ctx context.Context, userID string, id string, status int, metadata models.Metadata) (models.ForumVote, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	vote, ok := r.db.votes[id]
+	if !ok {
+		return models.ForumVote{}, models.ErrNotFound
+	}
+	voteMap, ok := r.db.voteMaps[userID]
+	if !ok {
+		voteMap = &models.ForumVoteMap{UserID: userID, VoteMap: map[string]models.ForumVoteMapEntry{}}
+		r.db.voteMaps[userID] = voteMap
+	}
+	if prev := voteMap.VoteMap[id].VoteStatus; prev != status {
+		r.db.setTally(vote, vote.Tally().Add(models.TallyChange(prev, status)))
+		vote.Metadata.UpdatedAt = metadata.UpdatedAt
+		voteMap.VoteMap[id] = models.ForumVoteMapEntry{VoteStatus: status, Metadata: metadata}
+		r.db.insertVoteEvent(models.VoteEvent{
+			TargetKind: models.CountVote,
+			TargetID:   id,
+			UserID:     userID,
+			From:       prev,
+			To:         status,
+			CreatedAt:  time.Now().Unix(),
+		})
+	}
+	res := *vote
+	res.VoteStatus = status
+	return res, nil
+}
+
+func (r *voteRepository) ForEachVote(ctx context.Context, fn func(models.ForumVote) error) error {
+	r.db.mu.RLock()
+	votes := []models.ForumVote{}
+	for _, vote := range r.db.votes {
+		votes = append(votes, *vote)
+	}
+	r.db.mu.RUnlock()
+	for _, vote := range votes {
+		if err := fn(vote); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *voteRepository) SetVoteTally(ctx context.Context, id string, stored models.VoteTally, tally models.VoteTally) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	vote, ok := r.db.votes[id]
+	if !ok || vote.Tally() != stored {
+		return false, nil
+	}
+	r.db.setTally(vote, tally)
+	return true, nil
+}
+
+func (r *voteRepository) EnsureVote(ctx context.Context, vote models.ForumVote) (bool, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if _, ok := r.db.votes[vote.ID]; ok {
+		return false, nil
+	}
+	r.db.votes[vote.ID] = &vote
+	return true, nil
+}
+
+// setTally must be called with the lock held, it also ranks the v2 post of the vote
+func (d *db) setTally(vote *models.ForumVote, tally models.VoteTally) {
+	vote.Count = tally.Sum
+	vote.Upvotes = tally.Up
+	vote.Downvotes = tally.Down
+	for _, post := range d.dbPosts {
+		if post.VoteID == vote.ID {
+			post.Ranking = post.Rank(tally)
+		}
+	}
+}
+
+type voteMapRepository struct {
+	db *db
+}
+
+func (r *voteMapRepository) GetVoteMap(ctx context.Context, userID string) (models.ForumVoteMap, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	voteMap, ok := r.db.voteMaps[userID]
+	if !ok {
+		return models.ForumVoteMap{}, models.ErrNotFound
+	}
+	res := models.ForumVoteMap{UserID: voteMap.UserID, VoteMap: map[string]models.ForumVoteMapEntry{}}
+	for id, entry := range voteMap.VoteMap {
+		res.VoteMap[id] = entry
+	}
+	return res, nil
+}
+
+func (r *voteMapRepository) ReassignVoteMap(ctx context.Context, userID string, newUserID string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	if voteMap, ok := r.db.voteMaps[userID]; ok {
+		delete(r.db.voteMaps, userID)
+		voteMap.UserID = newUserID
+		r.db.voteMaps[newUserID] = voteMap
+	}
+	return nil
+}
+
+func (r *voteMapRepository) DeleteVoteMap(ctx context.Context, userID string) (models.ForumVoteMap, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	voteMap, ok := r.db.voteMaps[userID]
+	if !ok {
+		return models.ForumVoteMap{}, models.ErrNotFound
+	}
+	delete(r.db.voteMaps, userID)
+	return *voteMap, nil
+}
+
+func (r *voteMapRepository) ForEachVoteMap(ctx context.Context, fn func(models.ForumVoteMap) error) error {
+	r.db.mu.RLock()
+	voteMaps := []models.ForumVoteMap{}
+	for _, voteMap := range r.db.voteMaps {
+		res := models.ForumVoteMap{UserID: voteMap.UserID, VoteMap: map[string]models.ForumVoteMapEntry{}}
+		for id, entry := range voteMap.VoteMap {
+			res.VoteMap[id] = entry
+		}
+		voteMaps = append(voteMaps, res)
+	}
+	r.db.mu.RUnlock()
+	for _, voteMap := range voteMaps {
+		if err := fn(voteMap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *voteMapRepository) MergeVoteMap(ctx context.Context, userID string, entries map[string]models.ForumVoteMapEntry) (int, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	voteMap, ok := r.db.voteMaps[userID]
+	if !ok {
+		voteMap = &models.ForumVoteMap{UserID: userID, VoteMap: map[string]models.ForumVoteMapEntry{}}
+	}
+	added := 0
+	for id, entry := range entries {
+		if _, ok := voteMap.VoteMap[id]; !ok {
+			voteMap.VoteMap[id] = entry
+			added++
+		}
+	}
+	if added > 0 {
+		r.db.voteMaps[userID] = voteMap
+	}
+	return added, nil
+}
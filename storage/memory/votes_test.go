@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"gguan/cwgcf_db/models"
+)
+
+func TestApplyVote(t *testing.T) {
+	type step struct {
+		userID string
+		status int
+		want   models.VoteTally
+		// events is how many vote events were logged after the step
+		events int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"upvote", []step{{"a", models.VoteUp, models.VoteTally{Sum: 1, Up: 1}, 1}}},
+		{"upvote retried", []step{
+			{"a", models.VoteUp, models.VoteTally{Sum: 1, Up: 1}, 1},
+			{"a", models.VoteUp, models.VoteTally{Sum: 1, Up: 1}, 1},
+			{"a", models.VoteUp, models.VoteTally{Sum: 1, Up: 1}, 1},
+		}},
+		{"no vote is no change", []step{{"a", models.VoteNone, models.VoteTally{}, 0}}},
+		{"up then down", []step{
+			{"a", models.VoteUp, models.VoteTally{Sum: 1, Up: 1}, 1},
+			{"a", models.VoteDown, models.VoteTally{Sum: -1, Down: 1}, 2},
+			{"a", models.VoteDown, models.VoteTally{Sum: -1, Down: 1}, 2},
+		}},
+		{"withdrawn", []step{
+			{"a", models.VoteDown, models.VoteTally{Sum: -1, Down: 1}, 1},
+			{"a", models.VoteNone, models.VoteTally{}, 2},
+			{"a", models.VoteNone, models.VoteTally{}, 2},
+		}},
+		{"several users", []step{
+			{"a", models.VoteUp, models.VoteTally{Sum: 1, Up: 1}, 1},
+			{"b", models.VoteUp, models.VoteTally{Sum: 2, Up: 2}, 2},
+			{"c", models.VoteDown, models.VoteTally{Sum: 1, Up: 2, Down: 1}, 3},
+			{"b", models.VoteUp, models.VoteTally{Sum: 1, Up: 2, Down: 1}, 3},
+			{"a", models.VoteNone, models.VoteTally{Sum: 0, Up: 1, Down: 1}, 4},
+		}},
+	}
+	ctx := context.Background()
+	for _, test := range tests {
+		store := NewStore()
+		d := store.Votes.(*voteRepository).db
+		voteID, err := store.Votes.InsertVote(ctx, models.Metadata{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, step := range test.steps {
+			vote, err := store.Votes.ApplyVote(ctx, step.userID, voteID, step.status, models.Metadata{UpdatedAt: int64(i)})
+			if err != nil {
+				t.Fatalf("%s: step %d: %v", test.name, i, err)
+			}
+			if vote.Tally() != step.want || vote.VoteStatus != step.status {
+				t.Errorf("%s: step %d: tally %v and status %d, want %v and %d", test.name, i, vote.Tally(), vote.VoteStatus, step.want, step.status)
+			}
+			if len(d.voteEvents) != step.events {
+				t.Errorf("%s: step %d: %d events, want %d", test.name, i, len(d.voteEvents), step.events)
+			}
+			voteMap, err := store.VoteMaps.GetVoteMap(ctx, step.userID)
+			if err != nil || voteMap.VoteMap[voteID].VoteStatus != step.status {
+				t.Errorf("%s: step %d: vote map %+v, %v", test.name, i, voteMap, err)
+			}
+		}
+	}
+}
+
+func TestApplyVoteMissingTarget(t *testing.T) {
+	store := NewStore()
+	_, err := store.Votes.ApplyVote(context.Background(), "a", "5e8f8f8f8f8f8f8f8f8f8f8f", models.VoteUp, models.Metadata{})
+	if err != models.ErrNotFound {
+		t.Errorf("voting on a missing vote object: %v, want ErrNotFound", err)
+	}
+	if _, err := store.VoteMaps.GetVoteMap(context.Background(), "a"); err != models.ErrNotFound {
+		t.Errorf("a failed vote created a vote map: %v", err)
+	}
+}
+
+func TestApplyVoteRanksPost(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+	voteID, _ := store.Votes.InsertVote(ctx, models.Metadata{})
+	postID, err := store.Posts.InsertDBPost(ctx, models.DBForumPost{VoteID: voteID, Metadata: models.Metadata{CreatedAt: 1600000000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []string{"a", "b", "a"} {
+		if _, err := store.Votes.ApplyVote(ctx, userID, voteID, models.VoteUp, models.Metadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	post, err := store.Posts.GetDBPost(ctx, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := models.NewRanking(models.VoteTally{Sum: 2, Up: 2}, 1600000000); post.Ranking != want {
+		t.Errorf("ranking %+v, want %+v", post.Ranking, want)
+	}
+}
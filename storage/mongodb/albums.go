@@ -0,0 +1,308 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// albumRepository keeps the albums in collection and which photos they hold in photos
+type albumRepository struct {
+	collection *mongo.Collection
+	photos     *mongo.Collection
+}
+
+func (r *albumRepository) GetAlbums(ctx context.Context, ownerID string, viewerID string, private bool) ([]models.Album, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"visibility": models.AlbumPublic},
+		bson.M{"ownerId": viewerID},
+	}}
+	if viewerID == "" {
+		filter = bson.M{"visibility": models.AlbumPublic}
+	}
+	if private {
+		filter = bson.M{}
+	}
+	if ownerID != "" {
+		filter = bson.M{"$and": bson.A{filter, bson.M{"ownerId": ownerID}}}
+	}
+	opt := options.Find().SetSort(bson.D{{Key: "metadata.createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.Album{}
+	for cur.Next(ctx) {
+		var album models.Album
+		if err := cur.Decode(&album); err != nil {
+			log.Printf("Error decoding album: %v", err)
+			continue
+		}
+		res = append(res, album)
+	}
+	return res, cur.Err()
+}
+
+func (r *albumRepository) GetAlbum(ctx context.Context, id string) (album models.Album, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&album)
+	return album, translateError(err)
+}
+
+func (r *albumRepository) InsertAlbum(ctx context.Context, album models.Album) (string, error) {
+	doc := bson.M{
+		"title":        album.Title,
+		"description":  album.Description,
+		"coverPhotoId": album.CoverPhotoID,
+		"ownerId":      album.OwnerID,
+		"visibility":   album.Visibility,
+		"metadata":     album.Metadata,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *albumRepository) UpdateAlbum(ctx context.Context, id string, update models.AlbumUpdate, metadata models.Metadata) (album models.Album, err error) {
+	set := bson.M{
+		"metadata.updatedBy": metadata.UpdatedBy,
+		"metadata.updatedAt": metadata.UpdatedAt,
+	}
+	if update.Title != nil {
+		set["title"] = *update.Title
+	}
+	if update.Description != nil {
+		set["description"] = *update.Description
+	}
+	if update.CoverPhotoID != nil {
+		set["coverPhotoId"] = *update.CoverPhotoID
+	}
+	if update.Visibility != nil {
+		set["visibility"] = *update.Visibility
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	opt := options.FindOneAndUpdate()
+	opt.SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, bson.M{"$set": set}, opt).Decode(&album)
+	return album, translateError(err)
+}
+
+func (r *albumRepository) ClearAlbumCover(ctx context.Context, id string, photoID string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "coverPhotoId": photoID}
+	_, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"coverPhotoId": ""}})
+	return err
+}
+
+func (r *albumRepository) DeleteAlbum(ctx context.Context, id string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	res, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *albumRepository) GetAlbumPhotos(ctx context.Context, albumID string, page models.PageRequest) ([]models.AlbumPhoto, *models.Cursor, error) {
+	filter, opt, err := keysetQuery(bson.M{"albumId": albumID}, []string{"rank"}, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := r.findAlbumPhotos(ctx, filter, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(res)) <= page.Limit {
+		return res, nil, nil
+	}
+	res = res[:page.Limit]
+	last := res[len(res)-1]
+	return res, &models.Cursor{Keys: []float64{last.Rank}, ID: last.ID}, nil
+}
+
+func (r *albumRepository) GetAlbumPhotoIDs(ctx context.Context, albumID string) ([]string, error) {
+	opt := options.Find().SetSort(bson.D{{Key: "rank", Value: -1}, {Key: "_id", Value: -1}})
+	entries, err := r.findAlbumPhotos(ctx, bson.M{"albumId": albumID}, opt)
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	for _, entry := range entries {
+		res = append(res, entry.PhotoID)
+	}
+	return res, nil
+}
+
+func (r *albumRepository) findAlbumPhotos(ctx context.Context, filter bson.M, opt *options.FindOptions) ([]models.AlbumPhoto, error) {
+	cur, err := r.photos.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.AlbumPhoto{}
+	for cur.Next(ctx) {
+		var entry models.AlbumPhoto
+		if err := cur.Decode(&entry); err != nil {
+			log.Printf("Error decoding album photo: %v", err)
+			continue
+		}
+		res = append(res, entry)
+	}
+	return res, cur.Err()
+}
+
+func (r *albumRepository) GetAlbumPhoto(ctx context.Context, albumID string, photoID string) (entry models.AlbumPhoto, err error) {
+	err = r.photos.FindOne(ctx, bson.M{"albumId": albumID, "photoId": photoID}).Decode(&entry)
+	return entry, translateError(err)
+}
+
+// nextAlbumRank is the rank that places a photo after every photo of an album
+// Photos added concurrently may get the same rank, they are then listed by id
+func (r *albumRepository) nextAlbumRank(ctx context.Context, albumID string) (float64, error) {
+	opt := options.FindOne().SetSort(bson.D{{Key: "rank", Value: 1}})
+	var last models.AlbumPhoto
+	err := r.photos.FindOne(ctx, bson.M{"albumId": albumID}, opt).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return last.Rank - 1, nil
+}
+
+func (r *albumRepository) AddAlbumPhoto(ctx context.Context, entry models.AlbumPhoto) (models.AlbumPhoto, error) {
+	rank, err := r.nextAlbumRank(ctx, entry.AlbumID)
+	if err != nil {
+		return models.AlbumPhoto{}, err
+	}
+	entry.Rank = rank
+	doc := bson.M{
+		"albumId":  entry.AlbumID,
+		"photoId":  entry.PhotoID,
+		"rank":     entry.Rank,
+		"metadata": entry.Metadata,
+	}
+	dbRes, err := r.photos.InsertOne(ctx, doc)
+	if err != nil {
+		return models.AlbumPhoto{}, translateWriteError(err)
+	}
+	entry.ID = insertedID(dbRes)
+	return entry, nil
+}
+
+func (r *albumRepository) MoveAlbumPhoto(ctx context.Context, albumID string, photoID string, toAlbumID string) (entry models.AlbumPhoto, err error) {
+	rank, err := r.nextAlbumRank(ctx, toAlbumID)
+	if err != nil {
+		return entry, err
+	}
+	filter := bson.M{"albumId": albumID, "photoId": photoID}
+	update := bson.M{"$set": bson.M{"albumId": toAlbumID, "rank": rank}}
+	opt := options.FindOneAndUpdate()
+	opt.SetReturnDocument(options.After)
+	err = r.photos.FindOneAndUpdate(ctx, filter, update, opt).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return entry, models.ErrNotFound
+	}
+	return entry, translateWriteError(err)
+}
+
+func (r *albumRepository) SetAlbumPhotoRanks(ctx context.Context, albumID string, ranks map[string]float64) error {
+	for photoID, rank := range ranks {
+		filter := bson.M{"albumId": albumID, "photoId": photoID}
+		if _, err := r.photos.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"rank": rank}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *albumRepository) RemoveAlbumPhoto(ctx context.Context, albumID string, photoID string) error {
+	res, err := r.photos.DeleteOne(ctx, bson.M{"albumId": albumID, "photoId": photoID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *albumRepository) RemoveAllAlbumPhotos(ctx context.Context, albumID string) (int, error) {
+	res, err := r.photos.DeleteMany(ctx, bson.M{"albumId": albumID})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.DeletedCount), nil
+}
+
+func (r *albumRepository) RemovePhotoFromAlbums(ctx context.Context, photoID string) (int, error) {
+	res, err := r.photos.DeleteMany(ctx, bson.M{"photoId": photoID})
+	if err != nil {
+		return 0, err
+	}
+	// Covers are photos of their album, so only albums that held the photo can have it as cover
+	if _, err := r.collection.UpdateMany(ctx, bson.M{"coverPhotoId": photoID}, bson.M{"$set": bson.M{"coverPhotoId": ""}}); err != nil {
+		return int(res.DeletedCount), err
+	}
+	return int(res.DeletedCount), nil
+}
+
+func (r *albumRepository) GetPrivatePhotos(ctx context.Context, photoIDs []string) (map[string][]string, error) {
+	res := map[string][]string{}
+	if len(photoIDs) == 0 {
+		return res, nil
+	}
+	entries, err := r.findAlbumPhotos(ctx, bson.M{"photoId": bson.M{"$in": photoIDs}}, options.Find())
+	if err != nil || len(entries) == 0 {
+		return res, err
+	}
+	albumIDs := []string{}
+	for _, entry := range entries {
+		albumIDs = append(albumIDs, entry.AlbumID)
+	}
+	cur, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs(albumIDs)}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	albums := map[string]models.Album{}
+	for cur.Next(ctx) {
+		var album models.Album
+		if err := cur.Decode(&album); err != nil {
+			log.Printf("Error decoding album: %v", err)
+			continue
+		}
+		albums[album.ID] = album
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	public := map[string]bool{}
+	for _, entry := range entries {
+		album, ok := albums[entry.AlbumID]
+		if !ok {
+			continue
+		}
+		if album.Visibility == models.AlbumPublic {
+			public[entry.PhotoID] = true
+		} else {
+			res[entry.PhotoID] = append(res[entry.PhotoID], album.OwnerID)
+		}
+	}
+	for id := range public {
+		delete(res, id)
+	}
+	return res, nil
+}
@@ -0,0 +1,44 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Connect opens a client pool and pings the primary so a bad URI fails at startup
+// Votes are applied in transactions, so a standalone server is refused rather than failing every vote later
+func Connect(ctx context.Context, uri string) (*mongo.Client, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to mongo: %v", err)
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("pinging mongo: %v", err)
+	}
+	if err := checkTransactions(ctx, client); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
+}
+
+// checkTransactions fails unless the server is a replica set member or a mongos, the deployments supporting transactions
+func checkTransactions(ctx context.Context, client *mongo.Client) error {
+	var res struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&res); err != nil {
+		return fmt.Errorf("checking the mongo deployment: %v", err)
+	}
+	if res.SetName == "" && res.Msg != "isdbgrid" {
+		return fmt.Errorf("mongo must run as a replica set, votes are applied in transactions: start mongod with --replSet and run rs.initiate()")
+	}
+	return nil
+}
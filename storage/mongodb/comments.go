@@ -0,0 +1,285 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// commentRepository keeps v1 and v2 comments in one collection, v2 comments are the ones with metadata
+type commentRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *commentRepository) GetComment(ctx context.Context, id string) (comment models.ForumComment, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": false}}
+	err = r.collection.FindOne(ctx, filter).Decode(&comment)
+	return comment, translateError(err)
+}
+
+func (r *commentRepository) GetCommentsByPost(ctx context.Context, postID string) ([]models.ForumComment, error) {
+	filter := bson.M{"postId": postID, "metadata": bson.M{"$exists": false}}
+	opt := options.Find()
+	opt.SetSort(bson.D{{Key: "forumVotes.votesSum", Value: -1}, {Key: "updatedAt", Value: -1}})
+	return r.find(ctx, filter, opt)
+}
+
+func (r *commentRepository) GetCommentsWithoutPost(ctx context.Context) ([]models.ForumComment, error) {
+	filter := bson.M{"postId": bson.M{"$exists": false}}
+	opt := options.Find()
+	opt.SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	return r.find(ctx, filter, opt)
+}
+
+func (r *commentRepository) SetCommentAncestry(ctx context.Context, id string, postID string, path []string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"$set": bson.M{"postId": postID, "path": path}}
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *commentRepository) find(ctx context.Context, filter interface{}, opt *options.FindOptions) ([]models.ForumComment, error) {
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.ForumComment{}
+	for cur.Next(ctx) {
+		var comment models.ForumComment
+		if err := cur.Decode(&comment); err != nil {
+			log.Printf("Error decoding comment: %v", err)
+			continue
+		}
+		res = append(res, comment)
+	}
+	return res, cur.Err()
+}
+
+func (r *commentRepository) InsertComment(ctx context.Context, comment models.ForumComment) (string, error) {
+	doc := bson.M{
+		"parentId":   comment.ParentID,
+		"postId":     comment.PostID,
+		"path":       comment.Path,
+		"content":    comment.Content,
+		"createdAt":  comment.CreatedAt,
+		"updatedAt":  comment.UpdatedAt,
+		"userId":     comment.UserID,
+		"forumVotes": comment.ForumVotes,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *commentRepository) SetCommentUpdatedAt(ctx context.Context, id string, updatedAt int64) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"$set": bson.M{"updatedAt": updatedAt}}
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *commentRepository) IncCommentVotes(ctx context.Context, id string, change models.VoteTally) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"$inc": forumVotesFields.inc(change)}
+	opt := options.Update()
+	opt.SetUpsert(true)
+	_, err := r.collection.UpdateOne(ctx, filter, update, opt)
+	return err
+}
+
+func (r *commentRepository) ReassignUserComments(ctx context.Context, userID string, newUserID string) error {
+	for _, key := range []string{"userId", "metadata.createdBy", "metadata.updatedBy"} {
+		filter := bson.M{key: userID}
+		update := bson.M{"$set": bson.M{key: newUserID}}
+		if _, err := r.collection.UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *commentRepository) DeleteUserComments(ctx context.Context, userID string) ([]string, error) {
+	opt := options.Find()
+	opt.SetProjection(bson.M{"_id": 1})
+	comments, err := r.find(ctx, bson.M{"userId": userID}, opt)
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.ID
+	}
+	return r.deleteWithVotes(ctx, threadsFilter(ids))
+}
+
+func (r *commentRepository) DeleteCommentsByPosts(ctx context.Context, postIDs []string) ([]string, error) {
+	if len(postIDs) == 0 {
+		return nil, nil
+	}
+	return r.deleteWithVotes(ctx, bson.M{"postId": bson.M{"$in": postIDs}})
+}
+
+// threadsFilter matches the given comments and every reply below them, replies list their ancestors in path
+func threadsFilter(ids []string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"_id": bson.M{"$in": objectIDs(ids)}},
+		bson.M{"path": bson.M{"$in": ids}},
+	}}
+}
+
+// deleteWithVotes deletes the comments matching filter and returns the vote ids of the v2 ones among them
+func (r *commentRepository) deleteWithVotes(ctx context.Context, filter bson.M) ([]string, error) {
+	opt := options.Find()
+	opt.SetProjection(bson.M{"voteId": 1})
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	voteIDs := []string{}
+	for cur.Next(ctx) {
+		var comment models.DBForumComment
+		if err := cur.Decode(&comment); err != nil {
+			log.Printf("Error decoding comment: %v", err)
+			continue
+		}
+		if comment.VoteID != "" {
+			voteIDs = append(voteIDs, comment.VoteID)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	_, err = r.collection.DeleteMany(ctx, filter)
+	return voteIDs, err
+}
+
+func (r *commentRepository) SetCommentHidden(ctx context.Context, id string, hidden bool) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"$set": bson.M{"hidden": hidden}}
+	res, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *commentRepository) EditComment(ctx context.Context, id string, from models.Revision, edit models.PostEdit) (bool, error) {
+	set := editFields(edit)
+	set["updatedAt"] = edit.UpdatedAt
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": false}}
+	return compareAndEdit(ctx, r.collection, filter, from, false, set)
+}
+
+func (r *commentRepository) DeleteComment(ctx context.Context, id string) ([]string, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	err := r.collection.FindOne(ctx, bson.M{"_id": objectID}).Err()
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return r.deleteWithVotes(ctx, threadsFilter([]string{id}))
+}
+
+func (r *commentRepository) ForEachComment(ctx context.Context, fn func(models.ForumComment) error) error {
+	return forEach(ctx, r.collection, bson.M{"metadata": bson.M{"$exists": false}}, func(cur *mongo.Cursor) error {
+		var comment models.ForumComment
+		if err := cur.Decode(&comment); err != nil {
+			log.Printf("Error decoding comment: %v", err)
+			return nil
+		}
+		return fn(comment)
+	})
+}
+
+func (r *commentRepository) SetCommentVotes(ctx context.Context, id string, stored models.VoteTally, tally models.VoteTally) (bool, error) {
+	return compareAndSetTally(ctx, r.collection, id, forumVotesFields, stored, tally)
+}
+
+func (r *commentRepository) GetDBComment(ctx context.Context, id string) (comment models.DBForumComment, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": true}}
+	err = r.collection.FindOne(ctx, filter).Decode(&comment)
+	return comment, translateError(err)
+}
+
+func (r *commentRepository) GetDBCommentsByPost(ctx context.Context, postID string) ([]models.DBForumComment, error) {
+	filter := bson.M{"postId": postID, "metadata": bson.M{"$exists": true}}
+	opt := options.Find()
+	opt.SetSort(bson.D{{Key: "metadata.updatedAt", Value: -1}, {Key: "_id", Value: -1}})
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.DBForumComment{}
+	for cur.Next(ctx) {
+		var comment models.DBForumComment
+		if err := cur.Decode(&comment); err != nil {
+			log.Printf("Error decoding comment: %v", err)
+			continue
+		}
+		res = append(res, comment)
+	}
+	return res, cur.Err()
+}
+
+func (r *commentRepository) InsertDBComment(ctx context.Context, comment models.DBForumComment) (string, error) {
+	doc := bson.M{
+		"parentId": comment.ParentID,
+		"postId":   comment.PostID,
+		"path":     comment.Path,
+		"content":  comment.Content,
+		"userId":   comment.UserID,
+		"voteId":   comment.VoteID,
+		"metadata": comment.Metadata,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *commentRepository) BumpDBCommentsUpdatedAt(ctx context.Context, ids []string, updatedAt int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	filter := bson.M{"_id": bson.M{"$in": objectIDs(ids)}, "metadata": bson.M{"$exists": true}}
+	update := bson.M{"$max": bson.M{"metadata.updatedAt": updatedAt}}
+	_, err := r.collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (r *commentRepository) EditDBComment(ctx context.Context, id string, from models.Revision, edit models.PostEdit) (bool, error) {
+	set := editFields(edit)
+	set["metadata.updatedBy"] = edit.UpdatedBy
+	set["metadata.updatedAt"] = edit.UpdatedAt
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": true}}
+	return compareAndEdit(ctx, r.collection, filter, from, false, set)
+}
+
+func (r *commentRepository) ConvertCommentToV2(ctx context.Context, id string, voteID string, metadata models.Metadata) (bool, error) {
+	set := bson.M{"voteId": voteID, "metadata": metadata}
+	return convertToV2(ctx, r.collection, id, set)
+}
@@ -0,0 +1,80 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"gguan/cwgcf_db/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the repositories rely on, existing indexes are left alone
+func EnsureIndexes(ctx context.Context, db *mongo.Database, collections config.Collections) error {
+	// One index per feed order, and the same prefixed with the sub for feeds of a single sub
+	postIndexes := []mongo.IndexModel{
+		// collections.ForumPosts is also used inside vote transactions
+		{Keys: bson.D{{Key: "voteId", Value: 1}}},
+		{Keys: bson.D{{Key: "pin.pinned", Value: 1}, {Key: "pin.order", Value: 1}, {Key: "pin.metadata.createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+	}
+	feedOrders := []bson.D{
+		{{Key: "ranking.hot", Value: -1}, {Key: "_id", Value: -1}},
+		{{Key: "ranking.controversial", Value: -1}, {Key: "_id", Value: -1}},
+		{{Key: "ranking.wilson", Value: -1}, {Key: "_id", Value: -1}},
+		{{Key: "ranking.score", Value: -1}, {Key: "metadata.updatedAt", Value: -1}, {Key: "_id", Value: -1}},
+		{{Key: "forumVotes.votesSum", Value: -1}, {Key: "updatedAt", Value: -1}, {Key: "_id", Value: -1}},
+		{{Key: "metadata.updatedAt", Value: -1}, {Key: "_id", Value: -1}},
+	}
+	for _, order := range feedOrders {
+		bySub := append(bson.D{{Key: "sub", Value: 1}}, order...)
+		postIndexes = append(postIndexes, mongo.IndexModel{Keys: order}, mongo.IndexModel{Keys: bySub})
+	}
+	indexes := map[string][]mongo.IndexModel{
+		collections.ForumPosts: postIndexes,
+		collections.ForumComments: {
+			{Keys: bson.D{{Key: "postId", Value: 1}}},
+		},
+		// Also creates the collection up front, ApplyVote cannot create it inside its transaction on MongoDB < 4.4
+		// Unique so concurrent first votes of a user cannot upsert two maps and count a vote twice
+		collections.ForumVoteMap: {
+			{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		// Also created up front for ApplyVote, which inserts events inside its transaction
+		collections.ForumVoteEvents: {
+			{Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "userId", Value: 1}}},
+		},
+		collections.SubForums: {
+			{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "moderators", Value: 1}}},
+		},
+		// Photos are listed by when they were taken
+		// Uploads look up copies among the photos of their user, photos added by url by their url
+		collections.Album: {
+			{Keys: bson.D{{Key: "takenAt", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "sha256", Value: 1}}},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "dHashBands", Value: 1}}},
+			{Keys: bson.D{{Key: "url", Value: 1}}},
+		},
+		collections.Albums: {
+			{Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "metadata.createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "visibility", Value: 1}, {Key: "metadata.createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		},
+		collections.AlbumPhotos: {
+			{Keys: bson.D{{Key: "albumId", Value: 1}, {Key: "photoId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "albumId", Value: 1}, {Key: "rank", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "photoId", Value: 1}}},
+		},
+		collections.ForumRevisions: {
+			{Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "metadata.createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "metadata.createdBy", Value: 1}}},
+		},
+	}
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("creating indexes on %s: %v", collection, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrationRepository keys applied migrations by version, so recording one twice violates _id
+type migrationRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *migrationRepository) GetAppliedMigrations(ctx context.Context) ([]models.AppliedMigration, error) {
+	opt := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cur, err := r.collection.Find(ctx, bson.D{}, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.AppliedMigration{}
+	for cur.Next(ctx) {
+		var migration models.AppliedMigration
+		if err := cur.Decode(&migration); err != nil {
+			log.Printf("Error decoding migration: %v", err)
+			continue
+		}
+		res = append(res, migration)
+	}
+	return res, cur.Err()
+}
+
+func (r *migrationRepository) InsertAppliedMigration(ctx context.Context, migration models.AppliedMigration) error {
+	doc := bson.M{
+		"_id":       migration.Version,
+		"name":      migration.Name,
+		"changed":   migration.Changed,
+		"appliedAt": migration.AppliedAt,
+	}
+	_, err := r.collection.InsertOne(ctx, doc)
+	return translateWriteError(err)
+}
+
+// convertToV2 sets the v2 fields on the v1 post or comment id and drops the v1 ones in a single update
+// The update only matches documents without metadata, so converting twice changes nothing
+func convertToV2(ctx context.Context, collection *mongo.Collection, id string, set bson.M) (bool, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": false}}
+	update := bson.M{
+		"$set":   set,
+		"$unset": bson.M{"createdAt": "", "updatedAt": "", "forumVotes": ""},
+	}
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
@@ -0,0 +1,48 @@
+package mongodb
+
+import (
+	"gguan/cwgcf_db/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// keysetQuery builds the filter and options for one page of a listing sorted descending by fields and then _id
+// One extra document is requested to tell whether another page follows
+func keysetQuery(filter bson.M, fields []string, page models.PageRequest) (bson.M, *options.FindOptions, error) {
+	sort := bson.D{}
+	for _, field := range fields {
+		sort = append(sort, bson.E{Key: field, Value: -1})
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: -1})
+	opt := options.Find()
+	opt.SetSort(sort)
+	opt.SetLimit(page.Limit + 1)
+
+	if page.After == nil {
+		return filter, opt, nil
+	}
+	if len(page.After.Keys) != len(fields) {
+		return nil, nil, models.ErrInvalidCursor
+	}
+	afterID, err := primitive.ObjectIDFromHex(page.After.ID)
+	if err != nil {
+		return nil, nil, models.ErrInvalidCursor
+	}
+	// (k1 < a1) or (k1 = a1 and k2 < a2) or ... or (all keys equal and _id < id)
+	or := bson.A{}
+	for i := 0; i <= len(fields); i++ {
+		clause := bson.M{}
+		for j := 0; j < i; j++ {
+			clause[fields[j]] = page.After.Keys[j]
+		}
+		if i < len(fields) {
+			clause[fields[i]] = bson.M{"$lt": page.After.Keys[i]}
+		} else {
+			clause["_id"] = bson.M{"$lt": afterID}
+		}
+		or = append(or, clause)
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{"$or": or}}}, opt, nil
+}
@@ -0,0 +1,210 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type photoRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *photoRepository) GetAllPhotos(ctx context.Context) ([]models.Photo, error) {
+	cur, err := r.collection.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.Photo{}
+	for cur.Next(ctx) {
+		var photo models.Photo
+		if err := cur.Decode(&photo); err != nil {
+			log.Printf("Error decoding photo: %v", err)
+			continue
+		}
+		res = append(res, photo)
+	}
+	return res, cur.Err()
+}
+
+func (r *photoRepository) GetPhoto(ctx context.Context, id string) (photo models.Photo, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	err = r.collection.FindOne(ctx, filter).Decode(&photo)
+	return photo, translateError(err)
+}
+
+func (r *photoRepository) GetPhotos(ctx context.Context, ids []string) (map[string]models.Photo, error) {
+	filter := bson.M{"_id": bson.M{"$in": objectIDs(ids)}}
+	cur, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := map[string]models.Photo{}
+	for cur.Next(ctx) {
+		var photo models.Photo
+		if err := cur.Decode(&photo); err != nil {
+			log.Printf("Error decoding photo: %v", err)
+			continue
+		}
+		res[photo.ID] = photo
+	}
+	return res, cur.Err()
+}
+
+func (r *photoRepository) InsertPhoto(ctx context.Context, photo models.Photo) (string, error) {
+	doc := bson.M{
+		"url":         photo.URL,
+		"blobKey":     photo.BlobKey,
+		"contentType": photo.ContentType,
+		"size":        photo.Size,
+		"width":       photo.Width,
+		"height":      photo.Height,
+		"userId":      photo.UserID,
+		"caption":     photo.Caption,
+		"tags":        photo.Tags,
+		"metadata":    photo.Metadata,
+		"variants":    photo.Variants,
+		"sha256":      photo.SHA256,
+		"dHash":       photo.DHash,
+		"dHashBands":  photo.DHashBands,
+		"takenAt":     photo.TakenAt,
+		"exif":        photo.EXIF,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *photoRepository) UpdatePhoto(ctx context.Context, id string, update models.PhotoUpdate, metadata models.Metadata) (photo models.Photo, err error) {
+	set := bson.M{
+		"metadata.updatedBy": metadata.UpdatedBy,
+		"metadata.updatedAt": metadata.UpdatedAt,
+	}
+	if update.Caption != nil {
+		set["caption"] = *update.Caption
+	}
+	if update.Tags != nil {
+		set["tags"] = *update.Tags
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	opt := options.FindOneAndUpdate()
+	opt.SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectID}, bson.M{"$set": set}, opt).Decode(&photo)
+	return photo, translateError(err)
+}
+
+func (r *photoRepository) SetPhotoVariants(ctx context.Context, id string, variants []models.PhotoVariant) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"variants": variants}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *photoRepository) SetPhotoHashes(ctx context.Context, id string, hashes models.PhotoHashes) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	set := bson.M{"sha256": hashes.SHA256, "dHash": hashes.DHash, "dHashBands": hashes.DHashBands}
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *photoRepository) GetPhotoByURL(ctx context.Context, url string) (models.Photo, error) {
+	return r.findNewest(ctx, bson.M{"url": url, "blobKey": bson.M{"$in": bson.A{"", nil}}})
+}
+
+func (r *photoRepository) GetPhotoBySHA256(ctx context.Context, userID string, sha256 string) (models.Photo, error) {
+	return r.findNewest(ctx, bson.M{"userId": userID, "sha256": sha256})
+}
+
+// findNewest returns the last inserted photo matching filter
+func (r *photoRepository) findNewest(ctx context.Context, filter bson.M) (photo models.Photo, err error) {
+	opt := options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})
+	err = r.collection.FindOne(ctx, filter, opt).Decode(&photo)
+	return photo, translateError(err)
+}
+
+func (r *photoRepository) GetSimilarPhotos(ctx context.Context, userID string, bands []int64) ([]models.Photo, error) {
+	filter := bson.M{"userId": userID, "dHashBands": bson.M{"$in": bands}}
+	return r.findPhotos(ctx, filter, options.Find())
+}
+
+func (r *photoRepository) GetPhotosByTakenAt(ctx context.Context, page models.PageRequest) ([]models.Photo, *models.Cursor, error) {
+	filter, opt, err := keysetQuery(bson.M{}, []string{"takenAt"}, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := r.findPhotos(ctx, filter, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(res)) <= page.Limit {
+		return res, nil, nil
+	}
+	res = res[:page.Limit]
+	last := res[len(res)-1]
+	return res, &models.Cursor{Keys: []float64{float64(last.TakenAt)}, ID: last.ID}, nil
+}
+
+func (r *photoRepository) findPhotos(ctx context.Context, filter bson.M, opt *options.FindOptions) ([]models.Photo, error) {
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.Photo{}
+	for cur.Next(ctx) {
+		var photo models.Photo
+		if err := cur.Decode(&photo); err != nil {
+			log.Printf("Error decoding photo: %v", err)
+			continue
+		}
+		res = append(res, photo)
+	}
+	return res, cur.Err()
+}
+
+func (r *photoRepository) SetPhotoTakenAt(ctx context.Context, id string, takenAt int64) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	res, err := r.collection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": bson.M{"takenAt": takenAt}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *photoRepository) DeletePhoto(ctx context.Context, id string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	res, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
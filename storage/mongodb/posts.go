@@ -0,0 +1,456 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type postRepository struct {
+	collection *mongo.Collection
+}
+
+// v1 and v2 posts share the collection, v2 posts are the ones with metadata
+// Listings leave out hidden and deleted posts
+var (
+	legacyPostFilter = bson.M{"metadata": bson.M{"$exists": false}, "hidden": bson.M{"$ne": true}, "deleted": bson.M{"$ne": true}}
+	dbPostFilter     = bson.M{"metadata": bson.M{"$exists": true}, "hidden": bson.M{"$ne": true}, "deleted": bson.M{"$ne": true}}
+)
+
+// postSortFields lists the fields a feed is sorted by in each mode, in the order of models.ForumPost.SortKeys
+// v1 and v2 posts keep their activity time and vote sum in different fields
+func postSortFields(mode string, updatedAt string, score string) []string {
+	switch mode {
+	case models.SortTop:
+		return []string{score, updatedAt}
+	case models.SortHot:
+		return []string{"ranking.hot"}
+	case models.SortControversial:
+		return []string{"ranking.controversial"}
+	case models.SortWilson:
+		return []string{"ranking.wilson"}
+	default:
+		return []string{updatedAt}
+	}
+}
+
+// postSortQuery builds the query for one page of a feed
+func postSortQuery(filter bson.M, fields []string, sort models.PostSort, page models.PageRequest) (bson.M, *options.FindOptions, error) {
+	if err := sort.CheckCursor(page); err != nil {
+		return nil, nil, err
+	}
+	and := bson.A{filter, notPinnedFilter(sort.At)}
+	if sort.Sub != "" {
+		and = append(and, bson.M{"sub": sort.Sub})
+	}
+	if sort.Since > 0 {
+		and = append(and, bson.M{"ranking.createdAt": bson.M{"$gte": sort.Since}})
+	}
+	return keysetQuery(bson.M{"$and": and}, fields, page)
+}
+
+// pinnedFilter matches the posts whose pin is active at the unix time at, as models.Pin.Active
+func pinnedFilter(at int64) bson.M {
+	return bson.M{
+		"pin.pinned": true,
+		"$or": bson.A{
+			bson.M{"pin.expiresAt": 0},
+			bson.M{"pin.expiresAt": bson.M{"$gt": at}},
+		},
+	}
+}
+
+// notPinnedFilter matches the posts left out by pinnedFilter
+func notPinnedFilter(at int64) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"pin.pinned": bson.M{"$ne": true}},
+		bson.M{"pin.expiresAt": bson.M{"$gt": 0, "$lte": at}},
+	}}
+}
+
+// pinnedQuery builds the query listing the pinned posts matching filter in pin order
+func pinnedQuery(filter bson.M, sort models.PostSort) (bson.M, *options.FindOptions) {
+	opt := options.Find()
+	opt.SetSort(bson.D{{Key: "pin.order", Value: 1}, {Key: "pin.metadata.createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	and := bson.A{filter, pinnedFilter(sort.At)}
+	if sort.Sub != "" {
+		and = append(and, bson.M{"sub": sort.Sub})
+	}
+	return bson.M{"$and": and}, opt
+}
+
+func (r *postRepository) GetAllPosts(ctx context.Context, sort models.PostSort, page models.PageRequest) ([]models.ForumPost, *models.Cursor, error) {
+	fields := postSortFields(sort.Mode, "updatedAt", "forumVotes.votesSum")
+	filter, opt, err := postSortQuery(legacyPostFilter, fields, sort, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.ForumPost{}
+	for cur.Next(ctx) {
+		var post models.ForumPost
+		if err := cur.Decode(&post); err != nil {
+			log.Printf("Error decoding post: %v", err)
+			continue
+		}
+		res = append(res, post)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, nil, err
+	}
+	if int64(len(res)) <= page.Limit {
+		return res, nil, nil
+	}
+	res = res[:page.Limit]
+	last := res[len(res)-1]
+	return res, &models.Cursor{Keys: last.SortKeys(sort.Mode), ID: last.ID, Sort: sort.Mode}, nil
+}
+
+func (r *postRepository) GetPost(ctx context.Context, id string) (post models.ForumPost, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": false}}
+	err = r.collection.FindOne(ctx, filter).Decode(&post)
+	return post, translateError(err)
+}
+
+func (r *postRepository) InsertPost(ctx context.Context, post models.ForumPost) (string, error) {
+	doc := bson.M{
+		"title":      post.Title,
+		"content":    post.Content,
+		"image":      post.Image,
+		"imageId":    post.ImageID,
+		"createdAt":  post.CreatedAt,
+		"updatedAt":  post.UpdatedAt,
+		"userId":     post.UserID,
+		"sub":        post.Sub,
+		"forumVotes": post.ForumVotes,
+		"ranking":    post.Ranking,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *postRepository) SetPostUpdatedAt(ctx context.Context, id string, updatedAt int64) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"$set": bson.M{"updatedAt": updatedAt}}
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *postRepository) IncPostVotes(ctx context.Context, id string, change models.VoteTally) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"$inc": forumVotesFields.inc(change)}
+	opt := options.FindOneAndUpdate()
+	opt.SetUpsert(true)
+	opt.SetReturnDocument(options.After)
+	var post models.ForumPost
+	if err := r.collection.FindOneAndUpdate(ctx, filter, update, opt).Decode(&post); err != nil {
+		return err
+	}
+	return r.rankPost(ctx, post)
+}
+
+// rankPost stores the ranking of a v1 post only while its forumVotes still hold the tally it was computed from
+// A vote changing forumVotes meanwhile ranks the post itself, so the last vote always leaves the right ranking
+func (r *postRepository) rankPost(ctx context.Context, post models.ForumPost) error {
+	objectID, _ := primitive.ObjectIDFromHex(post.ID)
+	filter := forumVotesFields.match(bson.M{"_id": objectID}, post.ForumVotes.Tally())
+	_, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"ranking": post.Rank()}})
+	return err
+}
+
+func (r *postRepository) GetDBPosts(ctx context.Context, sort models.PostSort, page models.PageRequest) ([]models.DBForumPost, *models.Cursor, error) {
+	fields := postSortFields(sort.Mode, "metadata.updatedAt", "ranking.score")
+	filter, opt, err := postSortQuery(dbPostFilter, fields, sort, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.DBForumPost{}
+	for cur.Next(ctx) {
+		var post models.DBForumPost
+		if err := cur.Decode(&post); err != nil {
+			log.Printf("Error decoding post: %v", err)
+			continue
+		}
+		res = append(res, post)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, nil, err
+	}
+	if int64(len(res)) <= page.Limit {
+		return res, nil, nil
+	}
+	res = res[:page.Limit]
+	last := res[len(res)-1]
+	return res, &models.Cursor{Keys: last.SortKeys(sort.Mode), ID: last.ID, Sort: sort.Mode}, nil
+}
+
+func (r *postRepository) GetPinnedPosts(ctx context.Context, sort models.PostSort) ([]models.ForumPost, error) {
+	filter, opt := pinnedQuery(legacyPostFilter, sort)
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.ForumPost{}
+	for cur.Next(ctx) {
+		var post models.ForumPost
+		if err := cur.Decode(&post); err != nil {
+			log.Printf("Error decoding post: %v", err)
+			continue
+		}
+		res = append(res, post)
+	}
+	return res, cur.Err()
+}
+
+func (r *postRepository) GetPinnedDBPosts(ctx context.Context, sort models.PostSort) ([]models.DBForumPost, error) {
+	filter, opt := pinnedQuery(dbPostFilter, sort)
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.DBForumPost{}
+	for cur.Next(ctx) {
+		var post models.DBForumPost
+		if err := cur.Decode(&post); err != nil {
+			log.Printf("Error decoding post: %v", err)
+			continue
+		}
+		res = append(res, post)
+	}
+	return res, cur.Err()
+}
+
+func (r *postRepository) PinPost(ctx context.Context, id string, pin models.Pin) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"pin": pin}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *postRepository) UnpinPost(ctx context.Context, id string, updatedBy string, updatedAt int64) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"$set": bson.M{
+		"pin.pinned":             false,
+		"pin.metadata.updatedBy": updatedBy,
+		"pin.metadata.updatedAt": updatedAt,
+	}}
+	res, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *postRepository) InsertDBPost(ctx context.Context, post models.DBForumPost) (string, error) {
+	doc := bson.M{
+		"title":    post.Title,
+		"content":  post.Content,
+		"image":    post.Image,
+		"imageId":  post.ImageID,
+		"metadata": post.Metadata,
+		"userId":   post.UserID,
+		"voteId":   post.VoteID,
+		"sub":      post.Sub,
+		"ranking":  post.Ranking,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *postRepository) ReassignUserPosts(ctx context.Context, userID string, newUserID string) error {
+	for _, key := range []string{"userId", "metadata.createdBy", "metadata.updatedBy", "pin.metadata.createdBy", "pin.metadata.updatedBy"} {
+		filter := bson.M{key: userID}
+		update := bson.M{"$set": bson.M{key: newUserID}}
+		if _, err := r.collection.UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *postRepository) DeleteUserPosts(ctx context.Context, userID string) (postIDs []string, voteIDs []string, err error) {
+	filter := bson.M{"userId": userID}
+	opt := options.Find()
+	opt.SetProjection(bson.M{"_id": 1, "voteId": 1})
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var post models.DBForumPost
+		if err := cur.Decode(&post); err != nil {
+			log.Printf("Error decoding post: %v", err)
+			continue
+		}
+		postIDs = append(postIDs, post.ID)
+		if post.VoteID != "" {
+			voteIDs = append(voteIDs, post.VoteID)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(postIDs) == 0 {
+		return nil, nil, nil
+	}
+	_, err = r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(postIDs)}})
+	return postIDs, voteIDs, err
+}
+
+func (r *postRepository) MoveSubPosts(ctx context.Context, from string, to string) (int, error) {
+	filter := bson.M{"sub": from}
+	if from == "" {
+		filter = bson.M{"sub": bson.M{"$in": bson.A{"", nil}}}
+	}
+	res, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"sub": to}})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.ModifiedCount), nil
+}
+
+func (r *postRepository) GetDBPost(ctx context.Context, id string) (post models.DBForumPost, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": true}}
+	err = r.collection.FindOne(ctx, filter).Decode(&post)
+	return post, translateError(err)
+}
+
+func (r *postRepository) EditPost(ctx context.Context, id string, from models.Revision, edit models.PostEdit) (bool, error) {
+	set := editFields(edit)
+	set["updatedAt"] = edit.UpdatedAt
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": false}}
+	return compareAndEdit(ctx, r.collection, filter, from, true, set)
+}
+
+func (r *postRepository) EditDBPost(ctx context.Context, id string, from models.Revision, edit models.PostEdit) (bool, error) {
+	set := editFields(edit)
+	set["metadata.updatedBy"] = edit.UpdatedBy
+	set["metadata.updatedAt"] = edit.UpdatedAt
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": true}}
+	return compareAndEdit(ctx, r.collection, filter, from, true, set)
+}
+
+func (r *postRepository) BumpDBPostUpdatedAt(ctx context.Context, id string, updatedAt int64) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID, "metadata": bson.M{"$exists": true}}
+	update := bson.M{"$max": bson.M{"metadata.updatedAt": updatedAt}}
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *postRepository) ConvertPostToV2(ctx context.Context, id string, voteID string, metadata models.Metadata, ranking models.Ranking) (bool, error) {
+	set := bson.M{"voteId": voteID, "metadata": metadata, "ranking": ranking}
+	return convertToV2(ctx, r.collection, id, set)
+}
+
+func (r *postRepository) SetPostHidden(ctx context.Context, id string, hidden bool) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"$set": bson.M{"hidden": hidden}}
+	res, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *postRepository) DeletePost(ctx context.Context, id string) (voteID string, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	var post models.DBForumPost
+	err = r.collection.FindOneAndDelete(ctx, filter).Decode(&post)
+	return post.VoteID, translateError(err)
+}
+
+func (r *postRepository) ForEachPost(ctx context.Context, fn func(models.ForumPost) error) error {
+	return forEach(ctx, r.collection, bson.M{"metadata": bson.M{"$exists": false}}, func(cur *mongo.Cursor) error {
+		var post models.ForumPost
+		if err := cur.Decode(&post); err != nil {
+			log.Printf("Error decoding post: %v", err)
+			return nil
+		}
+		return fn(post)
+	})
+}
+
+func (r *postRepository) SetPostVotes(ctx context.Context, id string, stored models.VoteTally, tally models.VoteTally) (bool, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := forumVotesFields.match(bson.M{"_id": objectID}, stored)
+	opt := options.FindOneAndUpdate()
+	opt.SetReturnDocument(options.After)
+	var post models.ForumPost
+	err := r.collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": forumVotesFields.set(tally)}, opt).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, r.rankPost(ctx, post)
+}
+
+func (r *postRepository) ForEachDBPost(ctx context.Context, fn func(models.DBForumPost) error) error {
+	return forEach(ctx, r.collection, bson.M{"metadata": bson.M{"$exists": true}}, func(cur *mongo.Cursor) error {
+		var post models.DBForumPost
+		if err := cur.Decode(&post); err != nil {
+			log.Printf("Error decoding post: %v", err)
+			return nil
+		}
+		return fn(post)
+	})
+}
+
+func (r *postRepository) SetPostRanking(ctx context.Context, id string, ranking models.Ranking) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"ranking": ranking}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type profileRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *profileRepository) GetProfile(ctx context.Context, id string) (profile models.Profile, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	err = r.collection.FindOne(ctx, filter).Decode(&profile)
+	return profile, translateError(err)
+}
+
+func (r *profileRepository) GetProfiles(ctx context.Context, ids []string) (map[string]models.Profile, error) {
+	filter := bson.M{"_id": bson.M{"$in": objectIDs(ids)}}
+	cur, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := map[string]models.Profile{}
+	for cur.Next(ctx) {
+		var profile models.Profile
+		if err := cur.Decode(&profile); err != nil {
+			log.Printf("Error decoding profile: %v", err)
+			continue
+		}
+		res[profile.ID] = profile
+	}
+	return res, cur.Err()
+}
+
+func (r *profileRepository) GetAllProfiles(ctx context.Context) ([]models.Profile, error) {
+	cur, err := r.collection.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.Profile{}
+	for cur.Next(ctx) {
+		var profile models.Profile
+		if err := cur.Decode(&profile); err != nil {
+			log.Printf("Error decoding profile: %v", err)
+			continue
+		}
+		res = append(res, profile)
+	}
+	return res, cur.Err()
+}
+
+func (r *profileRepository) InsertProfile(ctx context.Context, profile models.Profile) (string, error) {
+	doc := bson.M{
+		"name":        profile.Name,
+		"title":       profile.Title,
+		"description": profile.Description,
+		"avatarUrl":   profile.AvatarURL,
+		"role":        profile.Role,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *profileRepository) UpdateProfile(ctx context.Context, id string, update models.ProfileUpdate) (profile models.Profile, err error) {
+	set := bson.M{}
+	fields := map[string]*string{
+		"name":        update.Name,
+		"title":       update.Title,
+		"description": update.Description,
+		"avatarUrl":   update.AvatarURL,
+	}
+	for key, value := range fields {
+		if value != nil {
+			set[key] = *value
+		}
+	}
+	if len(set) == 0 {
+		return r.GetProfile(ctx, id)
+	}
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	opt := options.FindOneAndUpdate()
+	opt.SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, opt).Decode(&profile)
+	return profile, translateError(err)
+}
+
+func (r *profileRepository) DeleteProfile(ctx context.Context, id string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	res, err := r.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *profileRepository) SetProfileRole(ctx context.Context, id string, role string) (profile models.Profile, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	opt := options.FindOneAndUpdate()
+	opt.SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"role": role}}, opt).Decode(&profile)
+	return profile, translateError(err)
+}
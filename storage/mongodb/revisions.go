@@ -0,0 +1,97 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type revisionRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *revisionRepository) InsertRevision(ctx context.Context, revision models.Revision) (string, error) {
+	doc := bson.M{
+		"targetKind": revision.TargetKind,
+		"targetId":   revision.TargetID,
+		"title":      revision.Title,
+		"content":    revision.Content,
+		"action":     revision.Action,
+		"metadata":   revision.Metadata,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *revisionRepository) GetRevisions(ctx context.Context, targetID string) ([]models.Revision, error) {
+	opt := options.Find().SetSort(bson.D{{Key: "metadata.createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	cur, err := r.collection.Find(ctx, bson.M{"targetId": targetID}, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.Revision{}
+	for cur.Next(ctx) {
+		var revision models.Revision
+		if err := cur.Decode(&revision); err != nil {
+			log.Printf("Error decoding revision: %v", err)
+			continue
+		}
+		res = append(res, revision)
+	}
+	return res, cur.Err()
+}
+
+func (r *revisionRepository) DeleteRevisions(ctx context.Context, targetIDs []string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"targetId": bson.M{"$in": targetIDs}})
+	return err
+}
+
+func (r *revisionRepository) ReassignUserRevisions(ctx context.Context, userID string, newUserID string) error {
+	filter := bson.M{"metadata.createdBy": userID}
+	update := bson.M{"$set": bson.M{"metadata.createdBy": newUserID}}
+	_, err := r.collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (r *revisionRepository) DeleteUserRevisions(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"metadata.createdBy": userID})
+	return err
+}
+
+// editFields lists the fields an edit sets on a post or comment
+func editFields(edit models.PostEdit) bson.M {
+	set := bson.M{}
+	if edit.Title != nil {
+		set["title"] = *edit.Title
+	}
+	if edit.Content != nil {
+		set["content"] = *edit.Content
+	}
+	if edit.Delete {
+		set["deleted"] = true
+	}
+	return set
+}
+
+// compareAndEdit sets fields on the post or comment matching filter only while it still holds the title and content of from
+// and is not deleted, comments have no title to compare
+func compareAndEdit(ctx context.Context, collection *mongo.Collection, filter bson.M, from models.Revision, hasTitle bool, set bson.M) (bool, error) {
+	filter["content"] = from.Content
+	filter["deleted"] = bson.M{"$ne": true}
+	if hasTitle {
+		filter["title"] = from.Title
+	}
+	res, err := collection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
@@ -0,0 +1,137 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/config"
+	"gguan/cwgcf_db/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NewStore creates repositories backed by the given database
+func NewStore(db *mongo.Database, collections config.Collections) *models.Store {
+	return &models.Store{
+		Posts:      &postRepository{collection: db.Collection(collections.ForumPosts)},
+		Comments:   &commentRepository{collection: db.Collection(collections.ForumComments)},
+		UserVotes:  &userVoteRepository{collection: db.Collection(collections.ForumUserVotes)},
+		Votes:      &voteRepository{collection: db.Collection(collections.ForumVotes), voteMaps: db.Collection(collections.ForumVoteMap), events: db.Collection(collections.ForumVoteEvents), posts: db.Collection(collections.ForumPosts)},
+		VoteMaps:   &voteMapRepository{collection: db.Collection(collections.ForumVoteMap)},
+		Profiles:   &profileRepository{collection: db.Collection(collections.Profiles)},
+		Photos:     &photoRepository{collection: db.Collection(collections.Album)},
+		Albums:     &albumRepository{collection: db.Collection(collections.Albums), photos: db.Collection(collections.AlbumPhotos)},
+		VoteEvents: &voteEventRepository{collection: db.Collection(collections.ForumVoteEvents)},
+		SubForums:  &subForumRepository{collection: db.Collection(collections.SubForums)},
+		Revisions:  &revisionRepository{collection: db.Collection(collections.ForumRevisions)},
+		Migrations: &migrationRepository{collection: db.Collection(collections.Migrations)},
+	}
+}
+
+// translateError maps driver errors to repository errors
+func translateError(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return models.ErrNotFound
+	}
+	return err
+}
+
+// insertedID returns the hex form of an inserted ObjectID
+func insertedID(res *mongo.InsertOneResult) string {
+	objectID, _ := res.InsertedID.(primitive.ObjectID)
+	return objectID.Hex()
+}
+
+// objectIDs converts hex ids for an $in filter, ids that are not ObjectIDs cannot match and are dropped
+func objectIDs(ids []string) []primitive.ObjectID {
+	res := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+			res = append(res, objectID)
+		}
+	}
+	return res
+}
+
+// forEach calls fn for every document matching filter, fn decodes the current document of cur
+func forEach(ctx context.Context, collection *mongo.Collection, filter interface{}, fn func(cur *mongo.Cursor) error) error {
+	cur, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		if err := fn(cur); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// tallyFields names the fields a models.VoteTally is stored in
+type tallyFields struct {
+	sum  string
+	up   string
+	down string
+}
+
+var (
+	// forumVotesFields hold the tally of v1 posts and comments
+	forumVotesFields = tallyFields{sum: "forumVotes.votesSum", up: "forumVotes.upvotes", down: "forumVotes.downvotes"}
+	// voteFields hold the tally of v2 votes
+	voteFields = tallyFields{sum: "count", up: "upvotes", down: "downvotes"}
+)
+
+// inc returns the $inc document that applies change
+func (f tallyFields) inc(change models.VoteTally) bson.M {
+	return bson.M{f.sum: change.Sum, f.up: change.Up, f.down: change.Down}
+}
+
+// set returns the $set document that stores tally
+func (f tallyFields) set(tally models.VoteTally) bson.M {
+	return bson.M{f.sum: tally.Sum, f.up: tally.Up, f.down: tally.Down}
+}
+
+// match adds the conditions that the document still holds stored to filter
+// Documents written before upvotes and downvotes were kept lack them, a missing field matches a stored 0
+func (f tallyFields) match(filter bson.M, stored models.VoteTally) bson.M {
+	values := map[string]int64{f.sum: stored.Sum, f.up: stored.Up, f.down: stored.Down}
+	for field, value := range values {
+		if value == 0 {
+			filter[field] = bson.M{"$in": bson.A{0, nil}}
+		} else {
+			filter[field] = value
+		}
+	}
+	return filter
+}
+
+// compareAndSetTally sets a tally on a document only while it still holds stored
+func compareAndSetTally(ctx context.Context, collection *mongo.Collection, id string, fields tallyFields, stored models.VoteTally, tally models.VoteTally) (bool, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := fields.match(bson.M{"_id": objectID}, stored)
+	res, err := collection.UpdateOne(ctx, filter, bson.M{"$set": fields.set(tally)})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// duplicateKeyCode is the server error code of writes violating a unique index
+const duplicateKeyCode = 11000
+
+// translateWriteError maps unique index violations to models.ErrConflict
+// Inserts and updates report them as write errors, findAndModify as a command error
+func translateWriteError(err error) error {
+	if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == duplicateKeyCode {
+		return models.ErrConflict
+	}
+	if exception, ok := err.(mongo.WriteException); ok {
+		for _, writeError := range exception.WriteErrors {
+			if writeError.Code == duplicateKeyCode {
+				return models.ErrConflict
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type subForumRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *subForumRepository) GetSubForums(ctx context.Context) ([]models.SubForum, error) {
+	opt := options.Find()
+	opt.SetSort(bson.D{{Key: "slug", Value: 1}})
+	cur, err := r.collection.Find(ctx, bson.D{}, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.SubForum{}
+	for cur.Next(ctx) {
+		var sub models.SubForum
+		if err := cur.Decode(&sub); err != nil {
+			log.Printf("Error decoding sub: %v", err)
+			continue
+		}
+		res = append(res, sub)
+	}
+	return res, cur.Err()
+}
+
+func (r *subForumRepository) GetSubForum(ctx context.Context, slug string) (sub models.SubForum, err error) {
+	err = r.collection.FindOne(ctx, bson.M{"slug": slug}).Decode(&sub)
+	return sub, translateError(err)
+}
+
+func (r *subForumRepository) InsertSubForum(ctx context.Context, sub models.SubForum) (string, error) {
+	doc := bson.M{
+		"slug":         sub.Slug,
+		"name":         sub.Name,
+		"description":  sub.Description,
+		"moderators":   sub.Moderators,
+		"postingRules": sub.PostingRules,
+		"metadata":     sub.Metadata,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", translateWriteError(err)
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *subForumRepository) UpdateSubForum(ctx context.Context, slug string, update models.SubForumUpdate, metadata models.Metadata) (sub models.SubForum, err error) {
+	set := bson.M{
+		"metadata.updatedBy": metadata.UpdatedBy,
+		"metadata.updatedAt": metadata.UpdatedAt,
+	}
+	if update.Name != nil {
+		set["name"] = *update.Name
+	}
+	if update.Description != nil {
+		set["description"] = *update.Description
+	}
+	if update.Moderators != nil {
+		set["moderators"] = *update.Moderators
+	}
+	if update.PostingRules != nil {
+		set["postingRules"] = *update.PostingRules
+	}
+	opt := options.FindOneAndUpdate()
+	opt.SetReturnDocument(options.After)
+	err = r.collection.FindOneAndUpdate(ctx, bson.M{"slug": slug}, bson.M{"$set": set}, opt).Decode(&sub)
+	return sub, translateError(err)
+}
+
+func (r *subForumRepository) DeleteSubForum(ctx context.Context, slug string) error {
+	res, err := r.collection.DeleteOne(ctx, bson.M{"slug": slug})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+func (r *subForumRepository) RemoveSubForumModerator(ctx context.Context, userID string) error {
+	filter := bson.M{"moderators": userID}
+	update := bson.M{"$pull": bson.M{"moderators": userID}}
+	_, err := r.collection.UpdateMany(ctx, filter, update)
+	return err
+}
@@ -0,0 +1,140 @@
+package mongodb
+
+import (
+	"context"
+	"gguan/cwgcf_db/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type voteEventRepository struct {
+	collection *mongo.Collection
+}
+
+// voteEventDoc leaves out _id so mongo generates it
+func voteEventDoc(event models.VoteEvent) bson.M {
+	return bson.M{
+		"targetKind": event.TargetKind,
+		"targetId":   event.TargetID,
+		"userId":     event.UserID,
+		"from":       event.From,
+		"to":         event.To,
+		"createdAt":  event.CreatedAt,
+		"backfilled": event.Backfilled,
+	}
+}
+
+func (r *voteEventRepository) InsertVoteEvent(ctx context.Context, event models.VoteEvent) (string, error) {
+	dbRes, err := r.collection.InsertOne(ctx, voteEventDoc(event))
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *voteEventRepository) GetVoteEvents(ctx context.Context, targetID string, page models.PageRequest) ([]models.VoteEvent, *models.Cursor, error) {
+	filter, opt, err := keysetQuery(bson.M{"targetId": targetID}, []string{"createdAt"}, page)
+	if err != nil {
+		return nil, nil, err
+	}
+	cur, err := r.collection.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.VoteEvent{}
+	for cur.Next(ctx) {
+		var event models.VoteEvent
+		if err := cur.Decode(&event); err != nil {
+			log.Printf("Error decoding vote event: %v", err)
+			continue
+		}
+		res = append(res, event)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, nil, err
+	}
+	if int64(len(res)) <= page.Limit {
+		return res, nil, nil
+	}
+	res = res[:page.Limit]
+	last := res[len(res)-1]
+	return res, &models.Cursor{Keys: []float64{float64(last.CreatedAt)}, ID: last.ID}, nil
+}
+
+func (r *voteEventRepository) GetVoters(ctx context.Context, targetID string) ([]models.VoteEvent, error) {
+	newestFirst := bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"targetId": targetID}}},
+		{{Key: "$sort", Value: newestFirst}},
+		{{Key: "$group", Value: bson.M{"_id": "$userId", "latest": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$latest"}}},
+		{{Key: "$match", Value: bson.M{"to": bson.M{"$ne": models.VoteNone}}}},
+		{{Key: "$sort", Value: newestFirst}},
+	}
+	cur, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []models.VoteEvent{}
+	for cur.Next(ctx) {
+		var event models.VoteEvent
+		if err := cur.Decode(&event); err != nil {
+			log.Printf("Error decoding vote event: %v", err)
+			continue
+		}
+		res = append(res, event)
+	}
+	return res, cur.Err()
+}
+
+func (r *voteEventRepository) HasVoteEvents(ctx context.Context, targetID string, userID string) (bool, error) {
+	err := r.collection.FindOne(ctx, bson.M{"targetId": targetID, "userId": userID}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *voteEventRepository) WindowScores(ctx context.Context, targetIDs []string, since int64) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"targetId": bson.M{"$in": targetIDs}, "createdAt": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$targetId",
+			"score": bson.M{"$sum": bson.M{"$subtract": bson.A{"$to", "$from"}}},
+		}}},
+	}
+	cur, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := map[string]int64{}
+	for cur.Next(ctx) {
+		var score struct {
+			TargetID string `bson:"_id"`
+			Score    int64  `bson:"score"`
+		}
+		if err := cur.Decode(&score); err != nil {
+			log.Printf("Error decoding window score: %v", err)
+			continue
+		}
+		res[score.TargetID] = score.Score
+	}
+	return res, cur.Err()
+}
+
+func (r *voteEventRepository) ReassignUserVoteEvents(ctx context.Context, userID string, newUserID string) error {
+	filter := bson.M{"userId": userID}
+	update := bson.M{"$set": bson.M{"userId": newUserID}}
+	_, err := r.collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (r *voteEventRepository) DeleteUserVoteEvents(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
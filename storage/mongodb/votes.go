@@ -0,0 +1,340 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"gguan/cwgcf_db/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type userVoteRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *userVoteRepository) GetUserVotes(ctx context.Context, userID string) (votes models.ForumUserVotes, err error) {
+	filter := bson.M{"userId": userID}
+	err = r.collection.FindOne(ctx, filter).Decode(&votes)
+	return votes, translateError(err)
+}
+
+func (r *userVoteRepository) SetUserVote(ctx context.Context, userID string, voteID string, voteStatus int) error {
+	filter := bson.M{"userId": userID}
+	update := bson.M{"$set": bson.M{fmt.Sprintf("voteMap.%s.voteStatus", voteID): voteStatus}}
+	opt := options.Update()
+	opt.SetUpsert(true)
+	_, err := r.collection.UpdateOne(ctx, filter, update, opt)
+	return err
+}
+
+func (r *userVoteRepository) ReassignUserVotes(ctx context.Context, userID string, newUserID string) error {
+	filter := bson.M{"userId": userID}
+	update := bson.M{"$set": bson.M{"userId": newUserID}}
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *userVoteRepository) DeleteUserVotes(ctx context.Context, userID string) (votes models.ForumUserVotes, err error) {
+	filter := bson.M{"userId": userID}
+	err = r.collection.FindOneAndDelete(ctx, filter).Decode(&votes)
+	return votes, translateError(err)
+}
+
+func (r *userVoteRepository) ForEachUserVotes(ctx context.Context, fn func(models.ForumUserVotes) error) error {
+	return forEach(ctx, r.collection, bson.D{}, func(cur *mongo.Cursor) error {
+		var votes models.ForumUserVotes
+		if err := cur.Decode(&votes); err != nil {
+			log.Printf("Error decoding user votes: %v", err)
+			return nil
+		}
+		return fn(votes)
+	})
+}
+
+// voteRepository also writes voteMaps, events and the ranking of posts
+// Methods changing a tally run in a transaction, so they need a replica set, standalone servers do not support transactions
+type voteRepository struct {
+	collection *mongo.Collection
+	voteMaps   *mongo.Collection
+	events     *mongo.Collection
+	posts      *mongo.Collection
+}
+
+func (r *voteRepository) InsertVote(ctx context.Context, metadata models.Metadata) (string, error) {
+	doc := bson.M{
+		"count":     0,
+		"upvotes":   0,
+		"downvotes": 0,
+		"metadata":  metadata,
+	}
+	dbRes, err := r.collection.InsertOne(ctx, doc)
+	if err != nil {
+		return "", err
+	}
+	return insertedID(dbRes), nil
+}
+
+func (r *voteRepository) GetVote(ctx context.Context, id string) (vote models.ForumVote, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	err = r.collection.FindOne(ctx, filter).Decode(&vote)
+	return vote, translateError(err)
+}
+
+func (r *voteRepository) GetVotes(ctx context.Context, ids []string) (map[string]models.ForumVote, error) {
+	filter := bson.M{"_id": bson.M{"$in": objectIDs(ids)}}
+	cur, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := map[string]models.ForumVote{}
+	for cur.Next(ctx) {
+		var vote models.ForumVote
+		if err := cur.Decode(&vote); err != nil {
+			log.Printf("Error decoding vote: %v", err)
+			continue
+		}
+		res[vote.ID] = vote
+	}
+	return res, cur.Err()
+}
+
+func (r *voteRepository) IncVote(ctx context.Context, id string, change models.VoteTally, updatedAt int64) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := bson.M{"_id": objectID}
+	update := bson.M{
+		"$inc": voteFields.inc(change),
+		"$set": bson.M{
+			"metadata.updatedAt": updatedAt,
+		},
+	}
+	opt := options.FindOneAndUpdate()
+	opt.SetUpsert(true)
+	opt.SetReturnDocument(options.After)
+	return r.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		var vote models.ForumVote
+		if err := r.collection.FindOneAndUpdate(sc, filter, update, opt).Decode(&vote); err != nil {
+			return err
+		}
+		return r.rankPost(sc, vote)
+	})
+}
+
+func (r *voteRepository) DeleteVotes(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(ids)}})
+	return err
+}
+
+func (r *voteRepository) ForEachVote(ctx context.Context, fn func(models.ForumVote) error) error {
+	return forEach(ctx, r.collection, bson.D{}, func(cur *mongo.Cursor) error {
+		var vote models.ForumVote
+		if err := cur.Decode(&vote); err != nil {
+			log.Printf("Error decoding vote: %v", err)
+			return nil
+		}
+		return fn(vote)
+	})
+}
+
+func (r *voteRepository) SetVoteTally(ctx context.Context, id string, stored models.VoteTally, tally models.VoteTally) (ok bool, err error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	filter := voteFields.match(bson.M{"_id": objectID}, stored)
+	opt := options.FindOneAndUpdate()
+	opt.SetReturnDocument(options.After)
+	err = r.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		var vote models.ForumVote
+		err := r.collection.FindOneAndUpdate(sc, filter, bson.M{"$set": voteFields.set(tally)}, opt).Decode(&vote)
+		if err == mongo.ErrNoDocuments {
+			ok = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ok = true
+		return r.rankPost(sc, vote)
+	})
+	return ok, err
+}
+
+func (r *voteRepository) EnsureVote(ctx context.Context, vote models.ForumVote) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(vote.ID)
+	if err != nil {
+		return false, fmt.Errorf("vote id %s: %v", vote.ID, err)
+	}
+	filter := bson.M{"_id": objectID}
+	set := voteFields.set(vote.Tally())
+	set["metadata"] = vote.Metadata
+	opt := options.Update()
+	opt.SetUpsert(true)
+	res, err := r.collection.UpdateOne(ctx, filter, bson.M{"$setOnInsert": set}, opt)
+	if err != nil {
+		return false, err
+	}
+	return res.UpsertedCount == 1, nil
+}
+
+// maxVoteAttempts bounds how often ApplyVote starts over after losing the race to create the vote map of a user
+const maxVoteAttempts = 3
+
+func (r *voteRepository) ApplyVote(ctx context.Context, userID string, id string, status int, metadata models.Metadata) (vote models.ForumVote, err error) {
+	// The unique index on userId fails the later of two concurrent first votes, which then sees the map of the other
+	for attempt := 1; ; attempt++ {
+		vote, err = r.applyVote(ctx, userID, id, status, metadata)
+		if attempt == maxVoteAttempts || translateWriteError(err) != models.ErrConflict {
+			return vote, err
+		}
+	}
+}
+
+func (r *voteRepository) applyVote(ctx context.Context, userID string, id string, status int, metadata models.Metadata) (vote models.ForumVote, err error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return vote, models.ErrNotFound
+	}
+	err = r.withTransaction(ctx, func(sc mongo.SessionContext) error {
+		var voteMap models.ForumVoteMap
+		err := r.voteMaps.FindOne(sc, bson.M{"userId": userID}).Decode(&voteMap)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		filter := bson.M{"_id": objectID}
+		prev := voteMap.VoteMap[id].VoteStatus
+		if prev == status {
+			return r.collection.FindOne(sc, filter).Decode(&vote)
+		}
+		update := bson.M{
+			"$inc": voteFields.inc(models.TallyChange(prev, status)),
+			"$set": bson.M{"metadata.updatedAt": metadata.UpdatedAt},
+		}
+		opt := options.FindOneAndUpdate()
+		opt.SetReturnDocument(options.After)
+		if err := r.collection.FindOneAndUpdate(sc, filter, update, opt).Decode(&vote); err != nil {
+			return err
+		}
+		if err := r.rankPost(sc, vote); err != nil {
+			return err
+		}
+		entryPrefix := fmt.Sprintf("voteMap.%s.", id)
+		entryUpdate := bson.M{
+			"$set": bson.M{
+				entryPrefix + "voteStatus": status,
+				entryPrefix + "metadata":   metadata,
+			},
+		}
+		mapOpt := options.Update()
+		mapOpt.SetUpsert(true)
+		if _, err := r.voteMaps.UpdateOne(sc, bson.M{"userId": userID}, entryUpdate, mapOpt); err != nil {
+			return err
+		}
+		event := models.VoteEvent{
+			TargetKind: models.CountVote,
+			TargetID:   id,
+			UserID:     userID,
+			From:       prev,
+			To:         status,
+			CreatedAt:  time.Now().Unix(),
+		}
+		_, err = r.events.InsertOne(sc, voteEventDoc(event))
+		return err
+	})
+	if err != nil {
+		return models.ForumVote{}, translateError(err)
+	}
+	vote.VoteStatus = status
+	return vote, nil
+}
+
+// withTransaction runs fn in a transaction, retrying it on transient errors
+func (r *voteRepository) withTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := r.collection.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// rankPost updates the ranking of the v2 post of a vote, votes without a post are left alone
+func (r *voteRepository) rankPost(sc mongo.SessionContext, vote models.ForumVote) error {
+	var post models.DBForumPost
+	err := r.posts.FindOne(sc, bson.M{"voteId": vote.ID}).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	objectID, _ := primitive.ObjectIDFromHex(post.ID)
+	_, err = r.posts.UpdateOne(sc, bson.M{"_id": objectID}, bson.M{"$set": bson.M{"ranking": post.Rank(vote.Tally())}})
+	return err
+}
+
+type voteMapRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *voteMapRepository) GetVoteMap(ctx context.Context, userID string) (voteMap models.ForumVoteMap, err error) {
+	filter := bson.M{"userId": userID}
+	err = r.collection.FindOne(ctx, filter).Decode(&voteMap)
+	return voteMap, translateError(err)
+}
+
+func (r *voteMapRepository) ReassignVoteMap(ctx context.Context, userID string, newUserID string) error {
+	filter := bson.M{"userId": userID}
+	update := bson.M{"$set": bson.M{"userId": newUserID}}
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *voteMapRepository) DeleteVoteMap(ctx context.Context, userID string) (voteMap models.ForumVoteMap, err error) {
+	filter := bson.M{"userId": userID}
+	err = r.collection.FindOneAndDelete(ctx, filter).Decode(&voteMap)
+	return voteMap, translateError(err)
+}
+
+func (r *voteMapRepository) ForEachVoteMap(ctx context.Context, fn func(models.ForumVoteMap) error) error {
+	return forEach(ctx, r.collection, bson.D{}, func(cur *mongo.Cursor) error {
+		var voteMap models.ForumVoteMap
+		if err := cur.Decode(&voteMap); err != nil {
+			log.Printf("Error decoding vote map: %v", err)
+			return nil
+		}
+		return fn(voteMap)
+	})
+}
+
+func (r *voteMapRepository) MergeVoteMap(ctx context.Context, userID string, entries map[string]models.ForumVoteMapEntry) (int, error) {
+	voteMap, err := r.GetVoteMap(ctx, userID)
+	if err != nil && err != models.ErrNotFound {
+		return 0, err
+	}
+	set := bson.M{}
+	for id, entry := range entries {
+		if _, ok := voteMap.VoteMap[id]; !ok {
+			set[fmt.Sprintf("voteMap.%s", id)] = entry
+		}
+	}
+	if len(set) == 0 {
+		return 0, nil
+	}
+	filter := bson.M{"userId": userID}
+	opt := options.Update()
+	opt.SetUpsert(true)
+	if _, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": set}, opt); err != nil {
+		return 0, err
+	}
+	return len(set), nil
+}